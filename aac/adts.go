@@ -0,0 +1,91 @@
+package aac
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ADTSHeaderSize is the size of ADTS header without CRC.
+const ADTSHeaderSize = 7
+
+// ADTSBufferFullnessVBR is adts_buffer_fullness which means variable bit rate.
+const ADTSBufferFullnessVBR = 0x7ff
+
+const maxADTSFrameLength = 1<<13 - 1
+
+// ADTSHeader is adts_fixed_header and adts_variable_header defined at ISO/IEC 13818-7 6.2
+type ADTSHeader struct {
+	// ID is 0 for MPEG-4 and 1 for MPEG-2.
+	ID                     uint8
+	ProtectionAbsent       bool
+	Profile                uint8
+	SamplingFrequencyIndex uint8
+	ChannelConfiguration   uint8
+	// FrameLength is the length of the frame including the header.
+	FrameLength                  uint16
+	BufferFullness               uint16
+	NumberOfRawDataBlocksInFrame uint8
+}
+
+// Marshal returns the binary representation of the header.
+// CRC is not included even if ProtectionAbsent is false.
+func (h *ADTSHeader) Marshal() []byte {
+	protectionAbsent := byte(0)
+	if h.ProtectionAbsent {
+		protectionAbsent = 1
+	}
+	return []byte{
+		0xff,
+		0xf0 | (h.ID&0x1)<<3 | protectionAbsent,
+		(h.Profile&0x3)<<6 | (h.SamplingFrequencyIndex&0xf)<<2 | (h.ChannelConfiguration>>2)&0x1,
+		(h.ChannelConfiguration&0x3)<<6 | byte(h.FrameLength>>11)&0x3,
+		byte(h.FrameLength >> 3),
+		byte(h.FrameLength&0x7)<<5 | byte(h.BufferFullness>>6)&0x1f,
+		byte(h.BufferFullness&0x3f)<<2 | h.NumberOfRawDataBlocksInFrame&0x3,
+	}
+}
+
+// ADTSHeaderSizeWithCRC is the size of ADTS header followed by CRC.
+const ADTSHeaderSizeWithCRC = 9
+
+// ParseADTSHeader parses the ADTS header at the beginning of data.
+func ParseADTSHeader(data []byte) (*ADTSHeader, error) {
+	if len(data) < ADTSHeaderSize {
+		return nil, errors.New("too short ADTS header")
+	}
+	if data[0] != 0xff || data[1]&0xf0 != 0xf0 {
+		return nil, errors.New("ADTS syncword is not found")
+	}
+	h := &ADTSHeader{
+		ID:                           (data[1] >> 3) & 0x1,
+		ProtectionAbsent:             data[1]&0x1 != 0,
+		Profile:                      data[2] >> 6,
+		SamplingFrequencyIndex:       (data[2] >> 2) & 0xf,
+		ChannelConfiguration:         (data[2]&0x1)<<2 | data[3]>>6,
+		FrameLength:                  uint16(data[3]&0x3)<<11 | uint16(data[4])<<3 | uint16(data[5]>>5),
+		BufferFullness:               uint16(data[5]&0x1f)<<6 | uint16(data[6]>>2),
+		NumberOfRawDataBlocksInFrame: data[6] & 0x3,
+	}
+	if int(h.FrameLength) < h.HeaderSize() {
+		return nil, fmt.Errorf("invalid ADTS frame length: %d", h.FrameLength)
+	}
+	return h, nil
+}
+
+// HeaderSize returns the size of the header including CRC.
+func (h *ADTSHeader) HeaderSize() int {
+	if h.ProtectionAbsent {
+		return ADTSHeaderSize
+	}
+	return ADTSHeaderSizeWithCRC
+}
+
+// AudioSpecificConfig returns AudioSpecificConfig which corresponds to the header.
+func (h *ADTSHeader) AudioSpecificConfig() *AudioSpecificConfig {
+	return &AudioSpecificConfig{
+		AudioObjectType:        h.Profile + 1,
+		SamplingFrequencyIndex: h.SamplingFrequencyIndex,
+		SamplingFrequency:      SamplingFrequencyOf(h.SamplingFrequencyIndex),
+		ChannelConfiguration:   h.ChannelConfiguration,
+	}
+}
@@ -0,0 +1,92 @@
+package aac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestADTSHeaderMarshal(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   ADTSHeader
+		expected []byte
+	}{
+		{
+			name: "AAC-LC 44.1kHz stereo",
+			header: ADTSHeader{
+				ProtectionAbsent:       true,
+				Profile:                1,
+				SamplingFrequencyIndex: 4,
+				ChannelConfiguration:   2,
+				FrameLength:            107,
+				BufferFullness:         ADTSBufferFullnessVBR,
+			},
+			expected: []byte{0xff, 0xf1, 0x50, 0x80, 0x0d, 0x7f, 0xfc},
+		},
+		{
+			name: "MPEG-2 with CRC 48kHz 5.1ch",
+			header: ADTSHeader{
+				ID:                           1,
+				Profile:                      1,
+				SamplingFrequencyIndex:       3,
+				ChannelConfiguration:         6,
+				FrameLength:                  0x1fff,
+				BufferFullness:               0x123,
+				NumberOfRawDataBlocksInFrame: 1,
+			},
+			expected: []byte{0xff, 0xf8, 0x4d, 0x83, 0xff, 0xe4, 0x8d},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.header.Marshal())
+		})
+	}
+}
+
+func TestParseADTSHeader(t *testing.T) {
+	h, err := ParseADTSHeader([]byte{0xff, 0xf1, 0x50, 0x80, 0x0d, 0x7f, 0xfc, 0x21})
+	require.NoError(t, err)
+	assert.Equal(t, &ADTSHeader{
+		ProtectionAbsent:       true,
+		Profile:                1,
+		SamplingFrequencyIndex: 4,
+		ChannelConfiguration:   2,
+		FrameLength:            107,
+		BufferFullness:         ADTSBufferFullnessVBR,
+	}, h)
+	assert.Equal(t, ADTSHeaderSize, h.HeaderSize())
+	assert.Equal(t, &AudioSpecificConfig{
+		AudioObjectType:        AudioObjectTypeAACLC,
+		SamplingFrequencyIndex: 4,
+		SamplingFrequency:      44100,
+		ChannelConfiguration:   2,
+	}, h.AudioSpecificConfig())
+
+	h, err = ParseADTSHeader([]byte{0xff, 0xf8, 0x4d, 0x83, 0xff, 0xe4, 0x8d})
+	require.NoError(t, err)
+	assert.Equal(t, &ADTSHeader{
+		ID:                           1,
+		Profile:                      1,
+		SamplingFrequencyIndex:       3,
+		ChannelConfiguration:         6,
+		FrameLength:                  0x1fff,
+		BufferFullness:               0x123,
+		NumberOfRawDataBlocksInFrame: 1,
+	}, h)
+	assert.Equal(t, ADTSHeaderSizeWithCRC, h.HeaderSize())
+
+	// too short
+	_, err = ParseADTSHeader([]byte{0xff, 0xf1, 0x50, 0x80, 0x0d, 0x7f})
+	assert.Error(t, err)
+
+	// no syncword
+	_, err = ParseADTSHeader([]byte{0xff, 0xe1, 0x50, 0x80, 0x0d, 0x7f, 0xfc})
+	assert.Error(t, err)
+
+	// frame length is shorter than header
+	_, err = ParseADTSHeader([]byte{0xff, 0xf1, 0x50, 0x80, 0x00, 0xdf, 0xfc})
+	assert.Error(t, err)
+}
@@ -0,0 +1,211 @@
+package aac
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// Audio object types defined at ISO/IEC 14496-3 Table 1.1
+const (
+	AudioObjectTypeAACMain = 1
+	AudioObjectTypeAACLC   = 2
+	AudioObjectTypeAACSSR  = 3
+	AudioObjectTypeAACLTP  = 4
+	AudioObjectTypeSBR     = 5
+	AudioObjectTypePS      = 29
+)
+
+// SamplingFrequencyIndexExplicit means that the sampling frequency is explicitly coded.
+const SamplingFrequencyIndexExplicit = 0xf
+
+var samplingFrequencies = [...]uint32{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// SamplingFrequencyOf returns the sampling frequency corresponding to samplingFrequencyIndex.
+// It returns 0 for reserved or escape values.
+func SamplingFrequencyOf(samplingFrequencyIndex uint8) uint32 {
+	if int(samplingFrequencyIndex) < len(samplingFrequencies) {
+		return samplingFrequencies[samplingFrequencyIndex]
+	}
+	return 0
+}
+
+// SamplingFrequencyIndexOf returns samplingFrequencyIndex of the sampling frequency.
+// ok is false when the sampling frequency is not listed in the table.
+func SamplingFrequencyIndexOf(samplingFrequency uint32) (index uint8, ok bool) {
+	for i, f := range samplingFrequencies {
+		if f == samplingFrequency {
+			return uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+// AudioSpecificConfig is defined at ISO/IEC 14496-3 1.6.2.1
+// Syntax elements following channelConfiguration, such as GASpecificConfig, are not parsed
+// except the explicit SBR/PS signalling.
+type AudioSpecificConfig struct {
+	// AudioObjectType is the core audio object type.
+	// When SBR or PS is explicitly signalled, it is the type which follows the extension type.
+	AudioObjectType        uint8
+	SamplingFrequencyIndex uint8
+	SamplingFrequency      uint32
+	ChannelConfiguration   uint8
+
+	// ExtensionAudioObjectType is AudioObjectTypeSBR or AudioObjectTypePS
+	// when the extension is explicitly signalled, otherwise 0.
+	ExtensionAudioObjectType        uint8
+	ExtensionSamplingFrequencyIndex uint8
+	ExtensionSamplingFrequency      uint32
+}
+
+// ParseAudioSpecificConfig parses AudioSpecificConfig which is stored in DecoderSpecificInfo of esds.
+func ParseAudioSpecificConfig(data []byte) (*AudioSpecificConfig, error) {
+	r := bitio.NewReader(bytes.NewReader(data))
+	asc := new(AudioSpecificConfig)
+
+	aot, err := readAudioObjectType(r)
+	if err != nil {
+		return nil, err
+	}
+	asc.SamplingFrequencyIndex, asc.SamplingFrequency, err = readSamplingFrequency(r)
+	if err != nil {
+		return nil, err
+	}
+	channelConfiguration, err := bitio.ReadUint(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	asc.ChannelConfiguration = uint8(channelConfiguration)
+
+	if aot == AudioObjectTypeSBR || aot == AudioObjectTypePS {
+		asc.ExtensionAudioObjectType = aot
+		asc.ExtensionSamplingFrequencyIndex, asc.ExtensionSamplingFrequency, err = readSamplingFrequency(r)
+		if err != nil {
+			return nil, err
+		}
+		if aot, err = readAudioObjectType(r); err != nil {
+			return nil, err
+		}
+	}
+	asc.AudioObjectType = aot
+	return asc, nil
+}
+
+func readAudioObjectType(r bitio.Reader) (uint8, error) {
+	aot, err := bitio.ReadUint(r, 5)
+	if err != nil {
+		return 0, err
+	}
+	if aot == 31 {
+		ext, err := bitio.ReadUint(r, 6)
+		if err != nil {
+			return 0, err
+		}
+		aot = 32 + ext
+	}
+	return uint8(aot), nil
+}
+
+func readSamplingFrequency(r bitio.Reader) (uint8, uint32, error) {
+	index, err := bitio.ReadUint(r, 4)
+	if err != nil {
+		return 0, 0, err
+	}
+	if index == SamplingFrequencyIndexExplicit {
+		freq, err := bitio.ReadUint(r, 24)
+		if err != nil {
+			return 0, 0, err
+		}
+		return uint8(index), uint32(freq), nil
+	}
+	if SamplingFrequencyOf(uint8(index)) == 0 {
+		return 0, 0, fmt.Errorf("reserved samplingFrequencyIndex: %d", index)
+	}
+	return uint8(index), SamplingFrequencyOf(uint8(index)), nil
+}
+
+// ADTSHeader returns the ADTS header of a frame which has the specified raw data size.
+func (asc *AudioSpecificConfig) ADTSHeader(rawDataSize int) (*ADTSHeader, error) {
+	if asc.AudioObjectType < AudioObjectTypeAACMain || asc.AudioObjectType > AudioObjectTypeAACLTP {
+		return nil, fmt.Errorf("audio object type %d can not be stored in ADTS", asc.AudioObjectType)
+	}
+	if asc.SamplingFrequencyIndex == SamplingFrequencyIndexExplicit {
+		return nil, errors.New("explicit sampling frequency can not be stored in ADTS")
+	}
+	if rawDataSize+ADTSHeaderSize > maxADTSFrameLength {
+		return nil, fmt.Errorf("too large frame for ADTS: size=%d", rawDataSize)
+	}
+	return &ADTSHeader{
+		ProtectionAbsent:       true,
+		Profile:                asc.AudioObjectType - 1,
+		SamplingFrequencyIndex: asc.SamplingFrequencyIndex,
+		ChannelConfiguration:   asc.ChannelConfiguration,
+		FrameLength:            uint16(rawDataSize + ADTSHeaderSize),
+		BufferFullness:         ADTSBufferFullnessVBR,
+	}, nil
+}
+
+// Marshal returns the binary representation of AudioSpecificConfig.
+// GASpecificConfig with all flags zero is appended for AAC Main, LC, SSR and LTP.
+func (asc *AudioSpecificConfig) Marshal() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 8))
+	w := &ascWriter{w: bitio.NewWriter(buf)}
+	explicitExtension := asc.ExtensionAudioObjectType == AudioObjectTypeSBR ||
+		asc.ExtensionAudioObjectType == AudioObjectTypePS
+	if explicitExtension {
+		w.writeAudioObjectType(asc.ExtensionAudioObjectType)
+	} else {
+		w.writeAudioObjectType(asc.AudioObjectType)
+	}
+	w.writeSamplingFrequency(asc.SamplingFrequencyIndex, asc.SamplingFrequency)
+	w.write(uint64(asc.ChannelConfiguration), 4)
+	if explicitExtension {
+		w.writeSamplingFrequency(asc.ExtensionSamplingFrequencyIndex, asc.ExtensionSamplingFrequency)
+		w.writeAudioObjectType(asc.AudioObjectType)
+	}
+	if asc.AudioObjectType >= AudioObjectTypeAACMain && asc.AudioObjectType <= AudioObjectTypeAACLTP {
+		// frameLengthFlag, dependsOnCoreCoder and extensionFlag
+		w.write(0, 3)
+	}
+	if w.bits%8 != 0 {
+		w.write(0, 8-w.bits%8)
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return buf.Bytes(), nil
+}
+
+type ascWriter struct {
+	w    bitio.Writer
+	bits uint
+	err  error
+}
+
+func (w *ascWriter) write(val uint64, width uint) {
+	if w.err == nil {
+		w.err = bitio.WriteUint(w.w, val, width)
+		w.bits += width
+	}
+}
+
+func (w *ascWriter) writeAudioObjectType(aot uint8) {
+	if aot >= 32 {
+		w.write(31, 5)
+		w.write(uint64(aot-32), 6)
+		return
+	}
+	w.write(uint64(aot), 5)
+}
+
+func (w *ascWriter) writeSamplingFrequency(index uint8, freq uint32) {
+	w.write(uint64(index), 4)
+	if index == SamplingFrequencyIndexExplicit {
+		w.write(uint64(freq), 24)
+	}
+}
@@ -0,0 +1,157 @@
+package aac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAudioSpecificConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected AudioSpecificConfig
+	}{
+		{
+			// AudioSpecificConfig of _examples/sample.mp4
+			name: "AAC-LC",
+			data: []byte{0x12, 0x10, 0x56, 0xe5, 0x00},
+			expected: AudioSpecificConfig{
+				AudioObjectType:        AudioObjectTypeAACLC,
+				SamplingFrequencyIndex: 4,
+				SamplingFrequency:      44100,
+				ChannelConfiguration:   2,
+			},
+		},
+		{
+			name: "HE-AAC explicit signalling",
+			data: []byte{0x2b, 0x11, 0x88},
+			expected: AudioSpecificConfig{
+				AudioObjectType:                 AudioObjectTypeAACLC,
+				SamplingFrequencyIndex:          6,
+				SamplingFrequency:               24000,
+				ChannelConfiguration:            2,
+				ExtensionAudioObjectType:        AudioObjectTypeSBR,
+				ExtensionSamplingFrequencyIndex: 3,
+				ExtensionSamplingFrequency:      48000,
+			},
+		},
+		{
+			name: "escaped object type and explicit frequency",
+			// 11111 001010 1111 000000001011101110000000 0010
+			data: []byte{0xf9, 0x5e, 0x01, 0x77, 0x00, 0x40},
+			expected: AudioSpecificConfig{
+				AudioObjectType:        42,
+				SamplingFrequencyIndex: SamplingFrequencyIndexExplicit,
+				SamplingFrequency:      48000,
+				ChannelConfiguration:   2,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			asc, err := ParseAudioSpecificConfig(tc.data)
+			require.NoError(t, err)
+			assert.Equal(t, &tc.expected, asc)
+		})
+	}
+
+	_, err := ParseAudioSpecificConfig([]byte{0x12})
+	assert.Error(t, err)
+
+	// samplingFrequencyIndex=13 is reserved
+	_, err = ParseAudioSpecificConfig([]byte{0x16, 0x90})
+	assert.Error(t, err)
+}
+
+func TestAudioSpecificConfigADTSHeader(t *testing.T) {
+	asc := &AudioSpecificConfig{
+		AudioObjectType:        AudioObjectTypeAACLC,
+		SamplingFrequencyIndex: 4,
+		SamplingFrequency:      44100,
+		ChannelConfiguration:   2,
+	}
+	h, err := asc.ADTSHeader(100)
+	require.NoError(t, err)
+	assert.Equal(t, &ADTSHeader{
+		ProtectionAbsent:       true,
+		Profile:                1,
+		SamplingFrequencyIndex: 4,
+		ChannelConfiguration:   2,
+		FrameLength:            107,
+		BufferFullness:         ADTSBufferFullnessVBR,
+	}, h)
+
+	_, err = asc.ADTSHeader(8185)
+	assert.Error(t, err)
+
+	_, err = (&AudioSpecificConfig{AudioObjectType: 42, SamplingFrequencyIndex: 3}).ADTSHeader(100)
+	assert.Error(t, err)
+
+	_, err = (&AudioSpecificConfig{AudioObjectType: 2, SamplingFrequencyIndex: SamplingFrequencyIndexExplicit}).ADTSHeader(100)
+	assert.Error(t, err)
+}
+
+func TestAudioSpecificConfigMarshal(t *testing.T) {
+	testCases := []struct {
+		name     string
+		asc      AudioSpecificConfig
+		expected []byte
+	}{
+		{
+			name: "AAC-LC",
+			asc: AudioSpecificConfig{
+				AudioObjectType:        AudioObjectTypeAACLC,
+				SamplingFrequencyIndex: 4,
+				SamplingFrequency:      44100,
+				ChannelConfiguration:   2,
+			},
+			expected: []byte{0x12, 0x10},
+		},
+		{
+			name: "HE-AAC explicit signalling",
+			asc: AudioSpecificConfig{
+				AudioObjectType:                 AudioObjectTypeAACLC,
+				SamplingFrequencyIndex:          6,
+				SamplingFrequency:               24000,
+				ChannelConfiguration:            2,
+				ExtensionAudioObjectType:        AudioObjectTypeSBR,
+				ExtensionSamplingFrequencyIndex: 3,
+				ExtensionSamplingFrequency:      48000,
+			},
+			expected: []byte{0x2b, 0x11, 0x88, 0x00},
+		},
+		{
+			name: "escaped object type and explicit frequency",
+			asc: AudioSpecificConfig{
+				AudioObjectType:        42,
+				SamplingFrequencyIndex: SamplingFrequencyIndexExplicit,
+				SamplingFrequency:      48000,
+				ChannelConfiguration:   2,
+			},
+			expected: []byte{0xf9, 0x5e, 0x01, 0x77, 0x00, 0x40},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := tc.asc.Marshal()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, data)
+			asc, err := ParseAudioSpecificConfig(data)
+			require.NoError(t, err)
+			assert.Equal(t, &tc.asc, asc)
+		})
+	}
+}
+
+func TestSamplingFrequency(t *testing.T) {
+	assert.Equal(t, uint32(96000), SamplingFrequencyOf(0))
+	assert.Equal(t, uint32(7350), SamplingFrequencyOf(12))
+	assert.Equal(t, uint32(0), SamplingFrequencyOf(13))
+	index, ok := SamplingFrequencyIndexOf(48000)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(3), index)
+	_, ok = SamplingFrequencyIndexOf(44000)
+	assert.False(t, ok)
+}
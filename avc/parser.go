@@ -0,0 +1,23 @@
+package avc
+
+import "github.com/abema/go-mp4/bitio"
+
+// parser reads the syntax elements of the parameter sets.
+type parser struct {
+	*bitio.ExpGolombReader
+}
+
+// skipScalingList reads scaling_list() syntax and discards the values.
+func (p *parser) skipScalingList(size int) {
+	lastScale := int32(8)
+	nextScale := int32(8)
+	for j := 0; j < size && p.Err() == nil; j++ {
+		if nextScale != 0 {
+			delta := p.SE()
+			nextScale = (lastScale + delta + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package avc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// PPS is H.264 picture parameter set defined at ISO/IEC 14496-10 7.3.2.2
+// Fields following redundant_pic_cnt_present_flag are not parsed,
+// because they can not be interpreted without the referred SPS.
+type PPS struct {
+	PicParameterSetID                     uint32
+	SeqParameterSetID                     uint32
+	EntropyCodingModeFlag                 bool
+	BottomFieldPicOrderInFramePresentFlag bool
+	NumSliceGroupsMinus1                  uint32
+	SliceGroupMapType                     uint32
+	NumRefIdxL0DefaultActiveMinus1        uint32
+	NumRefIdxL1DefaultActiveMinus1        uint32
+	WeightedPredFlag                      bool
+	WeightedBipredIdc                     uint8
+	PicInitQpMinus26                      int32
+	PicInitQsMinus26                      int32
+	ChromaQpIndexOffset                   int32
+	DeblockingFilterControlPresentFlag    bool
+	ConstrainedIntraPredFlag              bool
+	RedundantPicCntPresentFlag            bool
+}
+
+// ParsePPS parses a PPS NAL unit which begins with the NAL unit header.
+func ParsePPS(nalu []byte) (*PPS, error) {
+	if len(nalu) < 1 {
+		return nil, errors.New("empty nal unit")
+	}
+	if nalu[0]&0x1f != NALUnitTypePPS {
+		return nil, ErrInvalidNALUnitType
+	}
+	return ParsePPSRBSP(bitio.RemoveEmulationPreventionBytes(nalu[1:]))
+}
+
+// ParsePPSRBSP parses pic_parameter_set_rbsp which contains neither
+// the NAL unit header nor emulation prevention bytes.
+func ParsePPSRBSP(rbsp []byte) (*PPS, error) {
+	p := &parser{bitio.NewExpGolombReader(bitio.NewReader(bytes.NewReader(rbsp)))}
+	pps := new(PPS)
+
+	pps.PicParameterSetID = p.UE()
+	pps.SeqParameterSetID = p.UE()
+	pps.EntropyCodingModeFlag = p.Flag()
+	pps.BottomFieldPicOrderInFramePresentFlag = p.Flag()
+	pps.NumSliceGroupsMinus1 = p.UE()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	if pps.NumSliceGroupsMinus1 > 7 {
+		return nil, fmt.Errorf("invalid num_slice_groups_minus1: %d", pps.NumSliceGroupsMinus1)
+	}
+	if pps.NumSliceGroupsMinus1 > 0 {
+		pps.SliceGroupMapType = p.UE()
+		switch pps.SliceGroupMapType {
+		case 0:
+			for i := uint32(0); i <= pps.NumSliceGroupsMinus1; i++ {
+				p.UE() // run_length_minus1
+			}
+		case 2:
+			for i := uint32(0); i < pps.NumSliceGroupsMinus1; i++ {
+				p.UE() // top_left
+				p.UE() // bottom_right
+			}
+		case 3, 4, 5:
+			p.Flag() // slice_group_change_direction_flag
+			p.UE()   // slice_group_change_rate_minus1
+		case 6:
+			picSizeInMapUnitsMinus1 := p.UE()
+			width := uint(0)
+			for (uint32(1) << width) < pps.NumSliceGroupsMinus1+1 {
+				width++
+			}
+			for i := uint32(0); i <= picSizeInMapUnitsMinus1 && p.Err() == nil; i++ {
+				p.U(width) // slice_group_id
+			}
+		}
+	}
+	pps.NumRefIdxL0DefaultActiveMinus1 = p.UE()
+	pps.NumRefIdxL1DefaultActiveMinus1 = p.UE()
+	pps.WeightedPredFlag = p.Flag()
+	pps.WeightedBipredIdc = uint8(p.U(2))
+	pps.PicInitQpMinus26 = p.SE()
+	pps.PicInitQsMinus26 = p.SE()
+	pps.ChromaQpIndexOffset = p.SE()
+	pps.DeblockingFilterControlPresentFlag = p.Flag()
+	pps.ConstrainedIntraPredFlag = p.Flag()
+	pps.RedundantPicCntPresentFlag = p.Flag()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	return pps, nil
+}
@@ -0,0 +1,25 @@
+package avc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePPS(t *testing.T) {
+	// PPS of _examples/sample.mp4
+	pps, err := ParsePPS([]byte{0x68, 0xeb, 0xec, 0xb2, 0x2c})
+	require.NoError(t, err)
+	assert.Equal(t, &PPS{
+		EntropyCodingModeFlag:              true,
+		NumRefIdxL0DefaultActiveMinus1:     2,
+		WeightedPredFlag:                   true,
+		WeightedBipredIdc:                  2,
+		ChromaQpIndexOffset:                -2,
+		DeblockingFilterControlPresentFlag: true,
+	}, pps)
+
+	_, err = ParsePPS([]byte{0x67, 0xeb})
+	assert.Equal(t, ErrInvalidNALUnitType, err)
+}
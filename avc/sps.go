@@ -0,0 +1,429 @@
+package avc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// NAL unit types defined at ISO/IEC 14496-10 Table 7-1
+const (
+	NALUnitTypeNonIDR      = 1
+	NALUnitTypeIDR         = 5
+	NALUnitTypeSEI         = 6
+	NALUnitTypeSPS         = 7
+	NALUnitTypePPS         = 8
+	NALUnitTypeAUD         = 9
+	NALUnitTypeEndOfSeq    = 10
+	NALUnitTypeEndOfStream = 11
+	NALUnitTypeFillerData  = 12
+	NALUnitTypeSPSExt      = 13
+)
+
+const (
+	ProfileBaseline = 66
+	ProfileMain     = 77
+	ProfileExtended = 88
+	ProfileHigh     = 100
+	ProfileHigh10   = 110
+	ProfileHigh422  = 122
+	ProfileHigh444  = 244
+)
+
+// ExtendedSAR is aspect_ratio_idc which means sar_width and sar_height are explicitly coded.
+const ExtendedSAR = 255
+
+var ErrInvalidNALUnitType = errors.New("invalid nal unit type")
+
+// SPS is H.264 sequence parameter set defined at ISO/IEC 14496-10 7.3.2.1.1
+type SPS struct {
+	ProfileIdc                     uint8
+	ConstraintSet0Flag             bool
+	ConstraintSet1Flag             bool
+	ConstraintSet2Flag             bool
+	ConstraintSet3Flag             bool
+	ConstraintSet4Flag             bool
+	ConstraintSet5Flag             bool
+	LevelIdc                       uint8
+	SeqParameterSetID              uint32
+	ChromaFormatIdc                uint32
+	SeparateColourPlaneFlag        bool
+	BitDepthLumaMinus8             uint32
+	BitDepthChromaMinus8           uint32
+	QpprimeYZeroTransformBypass    bool
+	SeqScalingMatrixPresentFlag    bool
+	Log2MaxFrameNumMinus4          uint32
+	PicOrderCntType                uint32
+	Log2MaxPicOrderCntLsbMinus4    uint32
+	DeltaPicOrderAlwaysZeroFlag    bool
+	OffsetForNonRefPic             int32
+	OffsetForTopToBottomField      int32
+	OffsetForRefFrame              []int32
+	MaxNumRefFrames                uint32
+	GapsInFrameNumValueAllowedFlag bool
+	PicWidthInMbsMinus1            uint32
+	PicHeightInMapUnitsMinus1      uint32
+	FrameMbsOnlyFlag               bool
+	MbAdaptiveFrameFieldFlag       bool
+	Direct8x8InferenceFlag         bool
+	FrameCroppingFlag              bool
+	FrameCropLeftOffset            uint32
+	FrameCropRightOffset           uint32
+	FrameCropTopOffset             uint32
+	FrameCropBottomOffset          uint32
+	VUIParametersPresentFlag       bool
+	VUI                            *VUIParameters
+}
+
+// VUIParameters is video usability information defined at ISO/IEC 14496-10 E.1.1
+type VUIParameters struct {
+	AspectRatioInfoPresentFlag         bool
+	AspectRatioIdc                     uint8
+	SarWidth                           uint16
+	SarHeight                          uint16
+	OverscanInfoPresentFlag            bool
+	OverscanAppropriateFlag            bool
+	VideoSignalTypePresentFlag         bool
+	VideoFormat                        uint8
+	VideoFullRangeFlag                 bool
+	ColourDescriptionPresentFlag       bool
+	ColourPrimaries                    uint8
+	TransferCharacteristics            uint8
+	MatrixCoefficients                 uint8
+	ChromaLocInfoPresentFlag           bool
+	ChromaSampleLocTypeTopField        uint32
+	ChromaSampleLocTypeBottomField     uint32
+	TimingInfoPresentFlag              bool
+	NumUnitsInTick                     uint32
+	TimeScale                          uint32
+	FixedFrameRateFlag                 bool
+	NalHRDParametersPresentFlag        bool
+	NalHRDParameters                   *HRDParameters
+	VclHRDParametersPresentFlag        bool
+	VclHRDParameters                   *HRDParameters
+	LowDelayHRDFlag                    bool
+	PicStructPresentFlag               bool
+	BitstreamRestrictionFlag           bool
+	MotionVectorsOverPicBoundariesFlag bool
+	MaxBytesPerPicDenom                uint32
+	MaxBitsPerMbDenom                  uint32
+	Log2MaxMvLengthHorizontal          uint32
+	Log2MaxMvLengthVertical            uint32
+	MaxNumReorderFrames                uint32
+	MaxDecFrameBuffering               uint32
+}
+
+// HRDParameters is hypothetical reference decoder parameters defined at ISO/IEC 14496-10 E.1.2
+type HRDParameters struct {
+	CpbCntMinus1                       uint32
+	BitRateScale                       uint8
+	CpbSizeScale                       uint8
+	BitRateValueMinus1                 []uint32
+	CpbSizeValueMinus1                 []uint32
+	CbrFlag                            []bool
+	InitialCpbRemovalDelayLengthMinus1 uint8
+	CpbRemovalDelayLengthMinus1        uint8
+	DpbOutputDelayLengthMinus1         uint8
+	TimeOffsetLength                   uint8
+}
+
+// sample aspect ratios indicated by aspect_ratio_idc (Table E-1)
+var sarTable = [...][2]uint16{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11},
+	{32, 11}, {80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// ParseSPS parses a SPS NAL unit which begins with the NAL unit header.
+// Emulation prevention bytes are removed by this function.
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 1 {
+		return nil, errors.New("empty nal unit")
+	}
+	if nalu[0]&0x1f != NALUnitTypeSPS {
+		return nil, ErrInvalidNALUnitType
+	}
+	return ParseSPSRBSP(bitio.RemoveEmulationPreventionBytes(nalu[1:]))
+}
+
+// ParseSPSRBSP parses seq_parameter_set_rbsp which contains neither
+// the NAL unit header nor emulation prevention bytes.
+func ParseSPSRBSP(rbsp []byte) (*SPS, error) {
+	p := &parser{bitio.NewExpGolombReader(bitio.NewReader(bytes.NewReader(rbsp)))}
+	sps := new(SPS)
+
+	sps.ProfileIdc = uint8(p.U(8))
+	sps.ConstraintSet0Flag = p.Flag()
+	sps.ConstraintSet1Flag = p.Flag()
+	sps.ConstraintSet2Flag = p.Flag()
+	sps.ConstraintSet3Flag = p.Flag()
+	sps.ConstraintSet4Flag = p.Flag()
+	sps.ConstraintSet5Flag = p.Flag()
+	p.U(2) // reserved_zero_2bits
+	sps.LevelIdc = uint8(p.U(8))
+	sps.SeqParameterSetID = p.UE()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+
+	sps.ChromaFormatIdc = 1
+	if hasChromaInfo(sps.ProfileIdc) {
+		sps.ChromaFormatIdc = p.UE()
+		if sps.ChromaFormatIdc == 3 {
+			sps.SeparateColourPlaneFlag = p.Flag()
+		}
+		sps.BitDepthLumaMinus8 = p.UE()
+		sps.BitDepthChromaMinus8 = p.UE()
+		sps.QpprimeYZeroTransformBypass = p.Flag()
+		sps.SeqScalingMatrixPresentFlag = p.Flag()
+		if sps.SeqScalingMatrixPresentFlag {
+			n := 8
+			if sps.ChromaFormatIdc == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if p.Flag() { // seq_scaling_list_present_flag
+					if i < 6 {
+						p.skipScalingList(16)
+					} else {
+						p.skipScalingList(64)
+					}
+				}
+			}
+		}
+		if p.Err() != nil {
+			return nil, p.Err()
+		}
+		if sps.ChromaFormatIdc > 3 {
+			return nil, fmt.Errorf("invalid chroma_format_idc: %d", sps.ChromaFormatIdc)
+		}
+	}
+
+	sps.Log2MaxFrameNumMinus4 = p.UE()
+	sps.PicOrderCntType = p.UE()
+	switch sps.PicOrderCntType {
+	case 0:
+		sps.Log2MaxPicOrderCntLsbMinus4 = p.UE()
+	case 1:
+		sps.DeltaPicOrderAlwaysZeroFlag = p.Flag()
+		sps.OffsetForNonRefPic = p.SE()
+		sps.OffsetForTopToBottomField = p.SE()
+		num := p.UE()
+		if p.Err() == nil && num > 255 {
+			return nil, fmt.Errorf("invalid num_ref_frames_in_pic_order_cnt_cycle: %d", num)
+		}
+		for i := uint32(0); i < num && p.Err() == nil; i++ {
+			sps.OffsetForRefFrame = append(sps.OffsetForRefFrame, p.SE())
+		}
+	}
+	sps.MaxNumRefFrames = p.UE()
+	sps.GapsInFrameNumValueAllowedFlag = p.Flag()
+	sps.PicWidthInMbsMinus1 = p.UE()
+	sps.PicHeightInMapUnitsMinus1 = p.UE()
+	sps.FrameMbsOnlyFlag = p.Flag()
+	if !sps.FrameMbsOnlyFlag {
+		sps.MbAdaptiveFrameFieldFlag = p.Flag()
+	}
+	sps.Direct8x8InferenceFlag = p.Flag()
+	sps.FrameCroppingFlag = p.Flag()
+	if sps.FrameCroppingFlag {
+		sps.FrameCropLeftOffset = p.UE()
+		sps.FrameCropRightOffset = p.UE()
+		sps.FrameCropTopOffset = p.UE()
+		sps.FrameCropBottomOffset = p.UE()
+	}
+	sps.VUIParametersPresentFlag = p.Flag()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	// the cropped size must be positive
+	cropX, cropY := sps.cropUnit()
+	if uint64(cropX)*(uint64(sps.FrameCropLeftOffset)+uint64(sps.FrameCropRightOffset)) >= uint64(sps.CodedWidth()) {
+		return nil, fmt.Errorf("invalid frame cropping: left=%d, right=%d, codedWidth=%d",
+			sps.FrameCropLeftOffset, sps.FrameCropRightOffset, sps.CodedWidth())
+	}
+	if uint64(cropY)*(uint64(sps.FrameCropTopOffset)+uint64(sps.FrameCropBottomOffset)) >= uint64(sps.CodedHeight()) {
+		return nil, fmt.Errorf("invalid frame cropping: top=%d, bottom=%d, codedHeight=%d",
+			sps.FrameCropTopOffset, sps.FrameCropBottomOffset, sps.CodedHeight())
+	}
+	if sps.VUIParametersPresentFlag {
+		sps.VUI = p.vuiParameters()
+		if p.Err() != nil {
+			return nil, p.Err()
+		}
+	}
+	return sps, nil
+}
+
+func hasChromaInfo(profileIdc uint8) bool {
+	switch profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		return true
+	}
+	return false
+}
+
+func (p *parser) vuiParameters() *VUIParameters {
+	vui := new(VUIParameters)
+	vui.AspectRatioInfoPresentFlag = p.Flag()
+	if vui.AspectRatioInfoPresentFlag {
+		vui.AspectRatioIdc = uint8(p.U(8))
+		if vui.AspectRatioIdc == ExtendedSAR {
+			vui.SarWidth = uint16(p.U(16))
+			vui.SarHeight = uint16(p.U(16))
+		} else if int(vui.AspectRatioIdc) < len(sarTable) {
+			vui.SarWidth = sarTable[vui.AspectRatioIdc][0]
+			vui.SarHeight = sarTable[vui.AspectRatioIdc][1]
+		}
+	}
+	vui.OverscanInfoPresentFlag = p.Flag()
+	if vui.OverscanInfoPresentFlag {
+		vui.OverscanAppropriateFlag = p.Flag()
+	}
+	vui.VideoSignalTypePresentFlag = p.Flag()
+	if vui.VideoSignalTypePresentFlag {
+		vui.VideoFormat = uint8(p.U(3))
+		vui.VideoFullRangeFlag = p.Flag()
+		vui.ColourDescriptionPresentFlag = p.Flag()
+		if vui.ColourDescriptionPresentFlag {
+			vui.ColourPrimaries = uint8(p.U(8))
+			vui.TransferCharacteristics = uint8(p.U(8))
+			vui.MatrixCoefficients = uint8(p.U(8))
+		}
+	}
+	vui.ChromaLocInfoPresentFlag = p.Flag()
+	if vui.ChromaLocInfoPresentFlag {
+		vui.ChromaSampleLocTypeTopField = p.UE()
+		vui.ChromaSampleLocTypeBottomField = p.UE()
+	}
+	vui.TimingInfoPresentFlag = p.Flag()
+	if vui.TimingInfoPresentFlag {
+		vui.NumUnitsInTick = uint32(p.U(32))
+		vui.TimeScale = uint32(p.U(32))
+		vui.FixedFrameRateFlag = p.Flag()
+	}
+	vui.NalHRDParametersPresentFlag = p.Flag()
+	if vui.NalHRDParametersPresentFlag {
+		vui.NalHRDParameters = p.hrdParameters()
+	}
+	vui.VclHRDParametersPresentFlag = p.Flag()
+	if vui.VclHRDParametersPresentFlag {
+		vui.VclHRDParameters = p.hrdParameters()
+	}
+	if vui.NalHRDParametersPresentFlag || vui.VclHRDParametersPresentFlag {
+		vui.LowDelayHRDFlag = p.Flag()
+	}
+	vui.PicStructPresentFlag = p.Flag()
+	vui.BitstreamRestrictionFlag = p.Flag()
+	if vui.BitstreamRestrictionFlag {
+		vui.MotionVectorsOverPicBoundariesFlag = p.Flag()
+		vui.MaxBytesPerPicDenom = p.UE()
+		vui.MaxBitsPerMbDenom = p.UE()
+		vui.Log2MaxMvLengthHorizontal = p.UE()
+		vui.Log2MaxMvLengthVertical = p.UE()
+		vui.MaxNumReorderFrames = p.UE()
+		vui.MaxDecFrameBuffering = p.UE()
+	}
+	return vui
+}
+
+func (p *parser) hrdParameters() *HRDParameters {
+	hrd := new(HRDParameters)
+	hrd.CpbCntMinus1 = p.UE()
+	if p.Err() == nil && hrd.CpbCntMinus1 > 31 {
+		p.SetErr(fmt.Errorf("invalid cpb_cnt_minus1: %d", hrd.CpbCntMinus1))
+		return hrd
+	}
+	hrd.BitRateScale = uint8(p.U(4))
+	hrd.CpbSizeScale = uint8(p.U(4))
+	for i := uint32(0); i <= hrd.CpbCntMinus1 && p.Err() == nil; i++ {
+		hrd.BitRateValueMinus1 = append(hrd.BitRateValueMinus1, p.UE())
+		hrd.CpbSizeValueMinus1 = append(hrd.CpbSizeValueMinus1, p.UE())
+		hrd.CbrFlag = append(hrd.CbrFlag, p.Flag())
+	}
+	hrd.InitialCpbRemovalDelayLengthMinus1 = uint8(p.U(5))
+	hrd.CpbRemovalDelayLengthMinus1 = uint8(p.U(5))
+	hrd.DpbOutputDelayLengthMinus1 = uint8(p.U(5))
+	hrd.TimeOffsetLength = uint8(p.U(5))
+	return hrd
+}
+
+// ChromaArrayType returns ChromaArrayType variable.
+func (sps *SPS) ChromaArrayType() uint32 {
+	if sps.SeparateColourPlaneFlag {
+		return 0
+	}
+	return sps.ChromaFormatIdc
+}
+
+// BitDepthLuma returns bit depth of luma samples.
+func (sps *SPS) BitDepthLuma() uint32 {
+	return sps.BitDepthLumaMinus8 + 8
+}
+
+// BitDepthChroma returns bit depth of chroma samples.
+func (sps *SPS) BitDepthChroma() uint32 {
+	return sps.BitDepthChromaMinus8 + 8
+}
+
+func (sps *SPS) cropUnit() (x, y uint32) {
+	frameMbsOnly := uint32(0)
+	if sps.FrameMbsOnlyFlag {
+		frameMbsOnly = 1
+	}
+	switch sps.ChromaArrayType() {
+	case 0:
+		return 1, 2 - frameMbsOnly
+	case 1:
+		return 2, 2 * (2 - frameMbsOnly)
+	case 2:
+		return 2, 2 - frameMbsOnly
+	default:
+		return 1, 2 - frameMbsOnly
+	}
+}
+
+// CodedWidth returns width of decoded pictures before cropping.
+func (sps *SPS) CodedWidth() uint32 {
+	return (sps.PicWidthInMbsMinus1 + 1) * 16
+}
+
+// CodedHeight returns height of decoded frames before cropping.
+func (sps *SPS) CodedHeight() uint32 {
+	frameHeightInMbs := sps.PicHeightInMapUnitsMinus1 + 1
+	if !sps.FrameMbsOnlyFlag {
+		frameHeightInMbs *= 2
+	}
+	return frameHeightInMbs * 16
+}
+
+// Width returns width of output pictures after frame cropping.
+func (sps *SPS) Width() uint32 {
+	x, _ := sps.cropUnit()
+	return sps.CodedWidth() - x*(sps.FrameCropLeftOffset+sps.FrameCropRightOffset)
+}
+
+// Height returns height of output frames after frame cropping.
+func (sps *SPS) Height() uint32 {
+	_, y := sps.cropUnit()
+	return sps.CodedHeight() - y*(sps.FrameCropTopOffset+sps.FrameCropBottomOffset)
+}
+
+// FrameRate returns frame rate derived from VUI timing information.
+// It returns 0 when timing information is not present.
+func (sps *SPS) FrameRate() float64 {
+	if sps.VUI == nil || !sps.VUI.TimingInfoPresentFlag || sps.VUI.NumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(sps.VUI.TimeScale) / float64(2*sps.VUI.NumUnitsInTick)
+}
+
+// SampleAspectRatio returns sample aspect ratio.
+// It returns (0, 0) when the aspect ratio is unspecified.
+func (sps *SPS) SampleAspectRatio() (width, height uint16) {
+	if sps.VUI == nil || !sps.VUI.AspectRatioInfoPresentFlag {
+		return 0, 0
+	}
+	return sps.VUI.SarWidth, sps.VUI.SarHeight
+}
@@ -0,0 +1,216 @@
+package avc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abema/go-mp4/bitio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSPS(t *testing.T) {
+	// SPS of _examples/sample.mp4
+	sps, err := ParseSPS([]byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0xd9, 0x41, 0x41,
+		0x9f, 0x9f, 0x01, 0x6c, 0x80, 0x00, 0x00, 0x03,
+		0x00, 0x80, 0x00, 0x00, 0x0a, 0x07, 0x8a, 0x14,
+		0xcb,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint8(ProfileHigh), sps.ProfileIdc)
+	assert.Equal(t, uint8(12), sps.LevelIdc)
+	assert.Equal(t, uint32(1), sps.ChromaFormatIdc)
+	assert.Equal(t, uint32(8), sps.BitDepthLuma())
+	assert.Equal(t, uint32(8), sps.BitDepthChroma())
+	assert.Equal(t, uint32(4), sps.MaxNumRefFrames)
+	assert.True(t, sps.FrameMbsOnlyFlag)
+	assert.True(t, sps.FrameCroppingFlag)
+	assert.Equal(t, uint32(6), sps.FrameCropBottomOffset)
+	assert.Equal(t, uint32(320), sps.CodedWidth())
+	assert.Equal(t, uint32(192), sps.CodedHeight())
+	assert.Equal(t, uint32(320), sps.Width())
+	assert.Equal(t, uint32(180), sps.Height())
+	require.NotNil(t, sps.VUI)
+	assert.True(t, sps.VUI.VideoFullRangeFlag)
+	assert.Equal(t, uint32(1), sps.VUI.NumUnitsInTick)
+	assert.Equal(t, uint32(20), sps.VUI.TimeScale)
+	assert.Equal(t, uint32(2), sps.VUI.MaxNumReorderFrames)
+	assert.Equal(t, 10.0, sps.FrameRate())
+	sarW, sarH := sps.SampleAspectRatio()
+	assert.Equal(t, uint16(1), sarW)
+	assert.Equal(t, uint16(1), sarH)
+}
+
+func TestParseSPSInterlaced(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := bitio.NewWriter(buf)
+	u := func(v uint64, width uint) { require.NoError(t, bitio.WriteUint(w, v, width)) }
+	ue := func(v uint64) { require.NoError(t, bitio.WriteUE(w, v)) }
+	se := func(v int64) { require.NoError(t, bitio.WriteSE(w, v)) }
+
+	u(ProfileHigh422, 8) // profile_idc
+	u(0x10, 8)           // constraint_set3_flag
+	u(41, 8)             // level_idc
+	ue(0)                // seq_parameter_set_id
+	ue(2)                // chroma_format_idc
+	ue(2)                // bit_depth_luma_minus8
+	ue(2)                // bit_depth_chroma_minus8
+	u(0, 1)              // qpprime_y_zero_transform_bypass_flag
+	u(1, 1)              // seq_scaling_matrix_present_flag
+	for i := 0; i < 8; i++ {
+		if i == 0 || i == 6 {
+			u(1, 1) // seq_scaling_list_present_flag
+			se(-8)  // delta_scale (useDefaultScalingMatrixFlag)
+		} else {
+			u(0, 1)
+		}
+	}
+	ue(0)   // log2_max_frame_num_minus4
+	ue(1)   // pic_order_cnt_type
+	u(0, 1) // delta_pic_order_always_zero_flag
+	se(-1)  // offset_for_non_ref_pic
+	se(1)   // offset_for_top_to_bottom_field
+	ue(2)   // num_ref_frames_in_pic_order_cnt_cycle
+	se(3)   // offset_for_ref_frame[0]
+	se(-3)  // offset_for_ref_frame[1]
+	ue(2)   // max_num_ref_frames
+	u(0, 1) // gaps_in_frame_num_value_allowed_flag
+	ue(119) // pic_width_in_mbs_minus1
+	ue(33)  // pic_height_in_map_units_minus1
+	u(0, 1) // frame_mbs_only_flag
+	u(1, 1) // mb_adaptive_frame_field_flag
+	u(1, 1) // direct_8x8_inference_flag
+	u(1, 1) // frame_cropping_flag
+	ue(0)   // frame_crop_left_offset
+	ue(0)   // frame_crop_right_offset
+	ue(0)   // frame_crop_top_offset
+	ue(4)   // frame_crop_bottom_offset
+	u(1, 1) // vui_parameters_present_flag
+	u(1, 1) // aspect_ratio_info_present_flag
+	u(ExtendedSAR, 8)
+	u(4, 16)     // sar_width
+	u(3, 16)     // sar_height
+	u(0, 1)      // overscan_info_present_flag
+	u(1, 1)      // video_signal_type_present_flag
+	u(5, 3)      // video_format
+	u(0, 1)      // video_full_range_flag
+	u(1, 1)      // colour_description_present_flag
+	u(9, 8)      // colour_primaries
+	u(16, 8)     // transfer_characteristics
+	u(9, 8)      // matrix_coefficients
+	u(0, 1)      // chroma_loc_info_present_flag
+	u(1, 1)      // timing_info_present_flag
+	u(1001, 32)  // num_units_in_tick
+	u(60000, 32) // time_scale
+	u(1, 1)      // fixed_frame_rate_flag
+	u(1, 1)      // nal_hrd_parameters_present_flag
+	ue(0)        // cpb_cnt_minus1
+	u(4, 4)      // bit_rate_scale
+	u(6, 4)      // cpb_size_scale
+	ue(1000)     // bit_rate_value_minus1
+	ue(2000)     // cpb_size_value_minus1
+	u(1, 1)      // cbr_flag
+	u(23, 5)     // initial_cpb_removal_delay_length_minus1
+	u(23, 5)     // cpb_removal_delay_length_minus1
+	u(23, 5)     // dpb_output_delay_length_minus1
+	u(24, 5)     // time_offset_length
+	u(0, 1)      // vcl_hrd_parameters_present_flag
+	u(0, 1)      // low_delay_hrd_flag
+	u(1, 1)      // pic_struct_present_flag
+	u(0, 1)      // bitstream_restriction_flag
+	u(1, 1)      // rbsp_stop_one_bit
+	for i := 0; i < 7; i++ {
+		u(0, 1)
+	}
+
+	nalu := append([]byte{0x67}, bitio.AddEmulationPreventionBytes(buf.Bytes())...)
+	sps, err := ParseSPS(nalu)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(ProfileHigh422), sps.ProfileIdc)
+	assert.True(t, sps.ConstraintSet3Flag)
+	assert.Equal(t, uint32(2), sps.ChromaFormatIdc)
+	assert.Equal(t, uint32(10), sps.BitDepthLuma())
+	assert.Equal(t, []int32{3, -3}, sps.OffsetForRefFrame)
+	assert.False(t, sps.FrameMbsOnlyFlag)
+	assert.Equal(t, uint32(1920), sps.Width())
+	assert.Equal(t, uint32(1088), sps.CodedHeight())
+	assert.Equal(t, uint32(1080), sps.Height())
+	require.NotNil(t, sps.VUI)
+	sarW, sarH := sps.SampleAspectRatio()
+	assert.Equal(t, uint16(4), sarW)
+	assert.Equal(t, uint16(3), sarH)
+	assert.Equal(t, uint8(9), sps.VUI.ColourPrimaries)
+	assert.Equal(t, uint8(16), sps.VUI.TransferCharacteristics)
+	assert.Equal(t, uint8(9), sps.VUI.MatrixCoefficients)
+	assert.InDelta(t, 29.97, sps.FrameRate(), 0.001)
+	require.NotNil(t, sps.VUI.NalHRDParameters)
+	assert.Equal(t, []uint32{1000}, sps.VUI.NalHRDParameters.BitRateValueMinus1)
+	assert.Equal(t, uint8(24), sps.VUI.NalHRDParameters.TimeOffsetLength)
+	assert.True(t, sps.VUI.PicStructPresentFlag)
+}
+
+func TestParseSPSError(t *testing.T) {
+	_, err := ParseSPS([]byte{})
+	assert.Error(t, err)
+
+	_, err = ParseSPS([]byte{0x68, 0xeb, 0xec, 0xb2, 0x2c})
+	assert.Equal(t, ErrInvalidNALUnitType, err)
+
+	// truncated
+	_, err = ParseSPS([]byte{0x67, 0x64, 0x00, 0x0c, 0xac})
+	assert.Error(t, err)
+}
+
+func TestParseSPSCropError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		crop   [4]uint64 // left, right, top, bottom
+		hasErr bool
+	}{
+		{name: "valid", crop: [4]uint64{1, 1, 0, 4}, hasErr: false},
+		{name: "too large horizontal crop", crop: [4]uint64{80, 80, 0, 0}, hasErr: true},
+		{name: "too large vertical crop", crop: [4]uint64{0, 0, 40, 50}, hasErr: true},
+		{name: "overflow", crop: [4]uint64{0, 0, 0x7fffffff, 0x7fffffff}, hasErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			w := bitio.NewWriter(buf)
+			u := func(v uint64, width uint) { require.NoError(t, bitio.WriteUint(w, v, width)) }
+			ue := func(v uint64) { require.NoError(t, bitio.WriteUE(w, v)) }
+
+			u(ProfileBaseline, 8) // profile_idc
+			u(0, 8)               // constraint flags
+			u(30, 8)              // level_idc
+			ue(0)                 // seq_parameter_set_id
+			ue(0)                 // log2_max_frame_num_minus4
+			ue(2)                 // pic_order_cnt_type
+			ue(1)                 // max_num_ref_frames
+			u(0, 1)               // gaps_in_frame_num_value_allowed_flag
+			ue(19)                // pic_width_in_mbs_minus1
+			ue(9)                 // pic_height_in_map_units_minus1
+			u(1, 1)               // frame_mbs_only_flag
+			u(1, 1)               // direct_8x8_inference_flag
+			u(1, 1)               // frame_cropping_flag
+			for _, c := range tc.crop {
+				ue(c)
+			}
+			u(0, 1) // vui_parameters_present_flag
+			u(1, 1) // rbsp_stop_one_bit
+			for i := 0; i < 7; i++ {
+				u(0, 1)
+			}
+
+			nalu := append([]byte{0x67}, bitio.AddEmulationPreventionBytes(buf.Bytes())...)
+			sps, err := ParseSPS(nalu)
+			if tc.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, uint32(316), sps.Width())
+			assert.Equal(t, uint32(152), sps.Height())
+		})
+	}
+}
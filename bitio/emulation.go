@@ -0,0 +1,41 @@
+package bitio
+
+// RemoveEmulationPreventionBytes converts NAL unit payload (EBSP) to RBSP
+// by removing every emulation_prevention_three_byte which follows two zero bytes.
+func RemoveEmulationPreventionBytes(data []byte) []byte {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for i := 0; i < len(data); i++ {
+		if zeros >= 2 && data[i] == 0x03 {
+			zeros = 0
+			continue
+		}
+		if data[i] == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, data[i])
+	}
+	return rbsp
+}
+
+// AddEmulationPreventionBytes converts RBSP to NAL unit payload (EBSP)
+// by inserting emulation_prevention_three_byte where a start code could be emulated.
+func AddEmulationPreventionBytes(rbsp []byte) []byte {
+	data := make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	zeros := 0
+	for i := 0; i < len(rbsp); i++ {
+		if zeros >= 2 && rbsp[i] <= 0x03 {
+			data = append(data, 0x03)
+			zeros = 0
+		}
+		if rbsp[i] == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		data = append(data, rbsp[i])
+	}
+	return data
+}
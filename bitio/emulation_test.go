@@ -0,0 +1,42 @@
+package bitio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmulationPreventionBytes(t *testing.T) {
+	testCases := []struct {
+		name string
+		rbsp []byte
+		ebsp []byte
+	}{
+		{
+			name: "no emulation",
+			rbsp: []byte{0x00, 0x01, 0x00, 0x04},
+			ebsp: []byte{0x00, 0x01, 0x00, 0x04},
+		},
+		{
+			name: "start code",
+			rbsp: []byte{0x00, 0x00, 0x01, 0x65},
+			ebsp: []byte{0x00, 0x00, 0x03, 0x01, 0x65},
+		},
+		{
+			name: "consecutive zeros",
+			rbsp: []byte{0x00, 0x00, 0x00, 0x00, 0x00},
+			ebsp: []byte{0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00},
+		},
+		{
+			name: "three byte",
+			rbsp: []byte{0x64, 0x00, 0x00, 0x03, 0x00, 0x80},
+			ebsp: []byte{0x64, 0x00, 0x00, 0x03, 0x03, 0x00, 0x80},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.ebsp, AddEmulationPreventionBytes(tc.rbsp))
+			assert.Equal(t, tc.rbsp, RemoveEmulationPreventionBytes(tc.ebsp))
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package bitio
+
+import (
+	"errors"
+	"math"
+)
+
+var ErrExpGolombOverflow = errors.New("exp-golomb code overflows 64 bits")
+
+// ReadUint reads the specified number of bits as an unsigned integer.
+func ReadUint(r Reader, width uint) (uint64, error) {
+	if width > 64 {
+		return 0, errors.New("width must be less than or equal to 64")
+	}
+	var val uint64
+	for i := uint(0); i < width; i++ {
+		bit, err := r.ReadBit()
+		if err != nil {
+			return 0, err
+		}
+		val <<= 1
+		if bit {
+			val |= 1
+		}
+	}
+	return val, nil
+}
+
+// ReadFlag reads 1 bit as a boolean.
+func ReadFlag(r Reader) (bool, error) {
+	return r.ReadBit()
+}
+
+// ReadUE reads an unsigned Exp-Golomb-coded integer, ue(v).
+func ReadUE(r Reader) (uint64, error) {
+	var leadingZeros uint
+	for {
+		bit, err := r.ReadBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit {
+			break
+		}
+		leadingZeros++
+		if leadingZeros > 63 {
+			return 0, ErrExpGolombOverflow
+		}
+	}
+	suffix, err := ReadUint(r, leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+	return (uint64(1)<<leadingZeros - 1) + suffix, nil
+}
+
+// ReadSE reads a signed Exp-Golomb-coded integer, se(v).
+func ReadSE(r Reader) (int64, error) {
+	k, err := ReadUE(r)
+	if err != nil {
+		return 0, err
+	}
+	if k%2 == 1 {
+		return int64((k + 1) / 2), nil
+	}
+	return -int64(k / 2), nil
+}
+
+// WriteUint writes the lower width bits of val.
+func WriteUint(w Writer, val uint64, width uint) error {
+	if width > 64 {
+		return errors.New("width must be less than or equal to 64")
+	}
+	for i := width; i > 0; i-- {
+		if err := w.WriteBit((val>>(i-1))&0x1 != 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFlag writes a boolean as 1 bit.
+func WriteFlag(w Writer, flag bool) error {
+	return w.WriteBit(flag)
+}
+
+// WriteUE writes an unsigned Exp-Golomb-coded integer, ue(v).
+func WriteUE(w Writer, val uint64) error {
+	if val == ^uint64(0) {
+		return ErrExpGolombOverflow
+	}
+	codeNum := val + 1
+	var width uint
+	for v := codeNum; v != 0; v >>= 1 {
+		width++
+	}
+	if err := WriteUint(w, 0, width-1); err != nil {
+		return err
+	}
+	return WriteUint(w, codeNum, width)
+}
+
+// WriteSE writes a signed Exp-Golomb-coded integer, se(v).
+func WriteSE(w Writer, val int64) error {
+	if val > 0 {
+		return WriteUE(w, uint64(val)*2-1)
+	}
+	// the code number of math.MinInt64 is 2^64, which can not be coded
+	if val == math.MinInt64 {
+		return ErrExpGolombOverflow
+	}
+	return WriteUE(w, uint64(-val)*2)
+}
+
+// ExpGolombReader reads the syntax elements such as u(n), ue(v) and se(v), and it holds the first error,
+// so that the syntax elements can be read without checking error each time.
+// The values are zero after an error occurs.
+type ExpGolombReader struct {
+	r   Reader
+	err error
+}
+
+func NewExpGolombReader(r Reader) *ExpGolombReader {
+	return &ExpGolombReader{r: r}
+}
+
+// Err returns the first error.
+func (r *ExpGolombReader) Err() error {
+	return r.err
+}
+
+// SetErr sets the error unless an error has already occurred.
+func (r *ExpGolombReader) SetErr(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// U reads u(n).
+func (r *ExpGolombReader) U(width uint) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var val uint64
+	val, r.err = ReadUint(r.r, width)
+	return val
+}
+
+// Flag reads u(1) as a boolean.
+func (r *ExpGolombReader) Flag() bool {
+	return r.U(1) != 0
+}
+
+// UE reads ue(v), and it fails if the value overflows 32 bits.
+func (r *ExpGolombReader) UE() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	val, err := ReadUE(r.r)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	if val > math.MaxUint32 {
+		r.err = ErrExpGolombOverflow
+		return 0
+	}
+	return uint32(val)
+}
+
+// SE reads se(v), and it fails if the value overflows 32 bits.
+func (r *ExpGolombReader) SE() int32 {
+	if r.err != nil {
+		return 0
+	}
+	val, err := ReadSE(r.r)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	if val > math.MaxInt32 || val < math.MinInt32 {
+		r.err = ErrExpGolombOverflow
+		return 0
+	}
+	return int32(val)
+}
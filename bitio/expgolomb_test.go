@@ -0,0 +1,126 @@
+package bitio
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadUint(t *testing.T) {
+	r := NewReader(bytes.NewReader([]byte{0xb5, 0x63}))
+	val, err := ReadUint(r, 3)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x5), val)
+	val, err = ReadUint(r, 9)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0x156), val)
+	_, err = ReadUint(r, 5)
+	assert.Error(t, err)
+}
+
+func TestExpGolomb(t *testing.T) {
+	testCases := []struct {
+		name string
+		ue   uint64
+		se   int64
+		bits string
+	}{
+		{name: "0", ue: 0, se: 0, bits: "1"},
+		{name: "1", ue: 1, se: 1, bits: "010"},
+		{name: "2", ue: 2, se: -1, bits: "011"},
+		{name: "3", ue: 3, se: 2, bits: "00100"},
+		{name: "4", ue: 4, se: -2, bits: "00101"},
+		{name: "7", ue: 7, se: 4, bits: "0001000"},
+		{name: "254", ue: 254, se: -127, bits: "000000011111111"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// pad to byte boundary with a stop bit
+			bits := tc.bits + "1"
+			for len(bits)%8 != 0 {
+				bits += "0"
+			}
+			data := make([]byte, len(bits)/8)
+			for i, c := range bits {
+				if c == '1' {
+					data[i/8] |= 0x80 >> uint(i%8)
+				}
+			}
+
+			ue, err := ReadUE(NewReader(bytes.NewReader(data)))
+			require.NoError(t, err)
+			assert.Equal(t, tc.ue, ue)
+
+			se, err := ReadSE(NewReader(bytes.NewReader(data)))
+			require.NoError(t, err)
+			assert.Equal(t, tc.se, se)
+
+			buf := bytes.NewBuffer(nil)
+			w := NewWriter(buf)
+			require.NoError(t, WriteUE(w, tc.ue))
+			require.NoError(t, w.WriteBit(true))
+			for i := len(tc.bits) + 1; i%8 != 0; i++ {
+				require.NoError(t, w.WriteBit(false))
+			}
+			assert.Equal(t, data, buf.Bytes())
+
+			buf.Reset()
+			w = NewWriter(buf)
+			require.NoError(t, WriteSE(w, tc.se))
+			require.NoError(t, w.WriteBit(true))
+			for i := len(tc.bits) + 1; i%8 != 0; i++ {
+				require.NoError(t, w.WriteBit(false))
+			}
+			assert.Equal(t, data, buf.Bytes())
+		})
+	}
+}
+
+func TestReadUEOverflow(t *testing.T) {
+	_, err := ReadUE(NewReader(bytes.NewReader(make([]byte, 9))))
+	assert.Equal(t, ErrExpGolombOverflow, err)
+}
+
+func TestWriteSEOverflow(t *testing.T) {
+	assert.Equal(t, ErrExpGolombOverflow, WriteSE(NewWriter(bytes.NewBuffer(nil)), math.MinInt64))
+
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf)
+	require.NoError(t, WriteSE(w, math.MinInt64+1))
+	require.NoError(t, WriteSE(w, math.MaxInt64))
+	// pad 127+127 bits to byte boundary
+	require.NoError(t, WriteUint(w, 0, 2))
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	se, err := ReadSE(r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MinInt64+1), se)
+	se, err = ReadSE(r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), se)
+}
+
+func TestExpGolombReader(t *testing.T) {
+	// u(3)=5, flag=1, ue=3, se=-1
+	r := NewExpGolombReader(NewReader(bytes.NewReader([]byte{0xb2, 0x30})))
+	assert.Equal(t, uint64(5), r.U(3))
+	assert.True(t, r.Flag())
+	assert.Equal(t, uint32(3), r.UE())
+	assert.Equal(t, int32(-1), r.SE())
+	require.NoError(t, r.Err())
+
+	// the first error is held, and the following values are zero
+	assert.Equal(t, uint64(0), r.U(8))
+	err := r.Err()
+	require.Error(t, err)
+	r.SetErr(ErrExpGolombOverflow)
+	assert.Equal(t, err, r.Err())
+	assert.Equal(t, uint32(0), r.UE())
+
+	// ue(v) which overflows 32 bits
+	r = NewExpGolombReader(NewReader(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00})))
+	assert.Equal(t, uint32(0), r.UE())
+	assert.Equal(t, ErrExpGolombOverflow, r.Err())
+}
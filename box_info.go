@@ -44,6 +44,10 @@ type BoxInfo struct {
 	// ExtendToEOF is set true when Box.size is zero. It means that end of box equals to end of file.
 	ExtendToEOF bool
 
+	// Unparsed is set true by ReadBoxStructureLenient when the data is not a valid box.
+	// The data has no header, and Type is zero.
+	Unparsed bool
+
 	// Context would be set by ReadBoxStructure, not ReadBoxInfo.
 	Context
 }
@@ -65,6 +69,23 @@ func WriteBoxInfo(w io.WriteSeeker, bi *BoxInfo) (*BoxInfo, error) {
 		return nil, err
 	}
 
+	data := marshalBoxHeader(bi)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	return &BoxInfo{
+		Offset:      uint64(offset),
+		Size:        bi.Size - bi.HeaderSize + uint64(len(data)),
+		HeaderSize:  uint64(len(data)),
+		Type:        bi.Type,
+		ExtendToEOF: bi.ExtendToEOF,
+	}, nil
+}
+
+// marshalBoxHeader returns the size and the type fields of the box.
+// The large header is used when bi.HeaderSize is LargeHeaderSize or bi.Size does not fit in 32 bits.
+func marshalBoxHeader(bi *BoxInfo) []byte {
 	var data []byte
 	if bi.ExtendToEOF {
 		data = make([]byte, SmallHeaderSize)
@@ -80,18 +101,7 @@ func WriteBoxInfo(w io.WriteSeeker, bi *BoxInfo) (*BoxInfo, error) {
 	data[5] = bi.Type[1]
 	data[6] = bi.Type[2]
 	data[7] = bi.Type[3]
-
-	if _, err := w.Write(data); err != nil {
-		return nil, err
-	}
-
-	return &BoxInfo{
-		Offset:      uint64(offset),
-		Size:        bi.Size - bi.HeaderSize + uint64(len(data)),
-		HeaderSize:  uint64(len(data)),
-		Type:        bi.Type,
-		ExtendToEOF: bi.ExtendToEOF,
-	}, nil
+	return data
 }
 
 // ReadBoxInfo reads common fields which are defined as "Box" class member at ISO/IEC 14496-12.
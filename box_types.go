@@ -680,6 +680,168 @@ func (hdlr *Hdlr) OnReadName(r bitio.ReadSeeker, leftBits uint64, ctx Context) (
 	return leftBits, true, nil
 }
 
+/*************************** iloc ****************************/
+
+func BoxTypeIloc() BoxType { return StrToBoxType("iloc") }
+
+func init() {
+	AddBoxDef(&Iloc{}, 0, 1, 2)
+}
+
+const (
+	IlocConstructionMethodFileOffset = 0
+	IlocConstructionMethodIdatOffset = 1
+	IlocConstructionMethodItemOffset = 2
+)
+
+// Iloc is ISOBMFF iloc box type
+type Iloc struct {
+	FullBox        `mp4:"0,extend"`
+	OffsetSize     uint8      `mp4:"1,size=4"`
+	LengthSize     uint8      `mp4:"2,size=4"`
+	BaseOffsetSize uint8      `mp4:"3,size=4"`
+	IndexSize      uint8      `mp4:"4,size=4"` // reserved in version 0
+	ItemCount      uint32     `mp4:"5,size=dynamic"`
+	Items          []IlocItem `mp4:"6,len=dynamic"`
+}
+
+type IlocItem struct {
+	ItemID             uint32       `mp4:"0,size=dynamic"`
+	ConstructionMethod uint8        `mp4:"1,size=4,nver=0"`
+	DataReferenceIndex uint16       `mp4:"2,size=16"`
+	BaseOffset         uint64       `mp4:"3,size=dynamic"`
+	ExtentCount        uint16       `mp4:"4,size=16"`
+	Extents            []IlocExtent `mp4:"5"`
+}
+
+type IlocExtent struct {
+	ExtentIndex  uint64 `mp4:"0,size=dynamic,opt=dynamic"`
+	ExtentOffset uint64 `mp4:"1,size=dynamic"`
+	ExtentLength uint64 `mp4:"2,size=dynamic"`
+}
+
+// GetType returns the BoxType
+func (*Iloc) GetType() BoxType {
+	return BoxTypeIloc()
+}
+
+// GetFieldSize returns size of dynamic field
+func (iloc *Iloc) GetFieldSize(name string, ctx Context) uint {
+	switch name {
+	case "ItemCount", "ItemID":
+		if iloc.GetVersion() < 2 {
+			return 16
+		}
+		return 32
+	case "BaseOffset":
+		return uint(iloc.BaseOffsetSize) * 8
+	case "ExtentIndex":
+		return uint(iloc.IndexSize) * 8
+	case "ExtentOffset":
+		return uint(iloc.OffsetSize) * 8
+	case "ExtentLength":
+		return uint(iloc.LengthSize) * 8
+	}
+	panic(fmt.Errorf("invalid name of dynamic-size field: boxType=iloc fieldName=%s", name))
+}
+
+// GetFieldLength returns length of dynamic field
+func (iloc *Iloc) GetFieldLength(name string, ctx Context) uint {
+	switch name {
+	case "Items":
+		return uint(iloc.ItemCount)
+	}
+	panic(fmt.Errorf("invalid name of dynamic-length field: boxType=iloc fieldName=%s", name))
+}
+
+func (iloc *Iloc) IsOptFieldEnabled(name string, ctx Context) bool {
+	switch name {
+	case "ExtentIndex":
+		return iloc.GetVersion() != 0 && iloc.IndexSize != 0
+	}
+	return false
+}
+
+// OnReadField reads the items, because the number of the extents is different for each item.
+func (iloc *Iloc) OnReadField(name string, r bitio.ReadSeeker, leftBits uint64, ctx Context) (rbits uint64, override bool, err error) {
+	if name != "Items" {
+		return 0, false, nil
+	}
+	read := func(width uint) uint64 {
+		if err != nil {
+			return 0
+		}
+		if rbits+uint64(width) > leftBits {
+			err = errors.New("iloc box has too short data for items")
+			return 0
+		}
+		var v uint64
+		v, err = bitio.ReadUint(r, width)
+		rbits += uint64(width)
+		return v
+	}
+	iloc.Items = make([]IlocItem, 0)
+	for i := uint32(0); i < iloc.ItemCount && err == nil; i++ {
+		var item IlocItem
+		item.ItemID = uint32(read(iloc.GetFieldSize("ItemID", ctx)))
+		if iloc.GetVersion() != 0 {
+			read(12)
+			item.ConstructionMethod = uint8(read(4))
+		}
+		item.DataReferenceIndex = uint16(read(16))
+		item.BaseOffset = read(iloc.GetFieldSize("BaseOffset", ctx))
+		item.ExtentCount = uint16(read(16))
+		for j := 0; j < int(item.ExtentCount) && err == nil; j++ {
+			var e IlocExtent
+			if iloc.IsOptFieldEnabled("ExtentIndex", ctx) {
+				e.ExtentIndex = read(iloc.GetFieldSize("ExtentIndex", ctx))
+			}
+			e.ExtentOffset = read(iloc.GetFieldSize("ExtentOffset", ctx))
+			e.ExtentLength = read(iloc.GetFieldSize("ExtentLength", ctx))
+			item.Extents = append(item.Extents, e)
+		}
+		iloc.Items = append(iloc.Items, item)
+	}
+	return rbits, true, err
+}
+
+// OnWriteField writes the items, because the number of the extents is different for each item.
+func (iloc *Iloc) OnWriteField(name string, w bitio.Writer, ctx Context) (wbits uint64, override bool, err error) {
+	if name != "Items" {
+		return 0, false, nil
+	}
+	if uint32(len(iloc.Items)) < iloc.ItemCount {
+		return 0, false, fmt.Errorf("the slice has too few elements: required=%d actual=%d", iloc.ItemCount, len(iloc.Items))
+	}
+	write := func(v uint64, width uint) {
+		if err == nil {
+			err = bitio.WriteUint(w, v, width)
+			wbits += uint64(width)
+		}
+	}
+	for _, item := range iloc.Items[:iloc.ItemCount] {
+		if int(item.ExtentCount) > len(item.Extents) {
+			return 0, false, fmt.Errorf("the slice has too few elements: required=%d actual=%d", item.ExtentCount, len(item.Extents))
+		}
+		write(uint64(item.ItemID), iloc.GetFieldSize("ItemID", ctx))
+		if iloc.GetVersion() != 0 {
+			write(0, 12)
+			write(uint64(item.ConstructionMethod), 4)
+		}
+		write(uint64(item.DataReferenceIndex), 16)
+		write(item.BaseOffset, iloc.GetFieldSize("BaseOffset", ctx))
+		write(uint64(item.ExtentCount), 16)
+		for _, e := range item.Extents[:item.ExtentCount] {
+			if iloc.IsOptFieldEnabled("ExtentIndex", ctx) {
+				write(e.ExtentIndex, iloc.GetFieldSize("ExtentIndex", ctx))
+			}
+			write(e.ExtentOffset, iloc.GetFieldSize("ExtentOffset", ctx))
+			write(e.ExtentLength, iloc.GetFieldSize("ExtentLength", ctx))
+		}
+	}
+	return wbits, true, err
+}
+
 /*************************** ilst ****************************/
 
 func BoxTypeIlst() BoxType { return StrToBoxType("ilst") }
@@ -1274,10 +1436,14 @@ func (*Saiz) GetType() BoxType {
 
 func init() {
 	AddAnyTypeBoxDef(&VisualSampleEntry{}, StrToBoxType("avc1"))
+	AddAnyTypeBoxDef(&VisualSampleEntry{}, StrToBoxType("avc3"))
 	AddAnyTypeBoxDef(&VisualSampleEntry{}, StrToBoxType("encv"))
 	AddAnyTypeBoxDef(&AudioSampleEntry{}, StrToBoxType("mp4a"))
 	AddAnyTypeBoxDef(&AudioSampleEntry{}, StrToBoxType("enca"))
+	AddAnyTypeBoxDef(&VisualSampleEntry{}, StrToBoxType("hvc1"))
+	AddAnyTypeBoxDef(&VisualSampleEntry{}, StrToBoxType("hev1"))
 	AddAnyTypeBoxDef(&AVCDecoderConfiguration{}, StrToBoxType("avcC"))
+	AddAnyTypeBoxDef(&HEVCDecoderConfiguration{}, StrToBoxType("hvcC"))
 	AddAnyTypeBoxDef(&PixelAspectRatioBox{}, StrToBoxType("pasp"))
 }
 
@@ -1437,6 +1603,21 @@ func (avcc *AVCDecoderConfiguration) OnWriteField(name string, w bitio.Writer, c
 	return 0, false, nil
 }
 
+// ParameterSets returns SPS, PPS and SPS extension NAL units in this order.
+func (avcc *AVCDecoderConfiguration) ParameterSets() [][]byte {
+	nalus := make([][]byte, 0, len(avcc.SequenceParameterSets)+len(avcc.PictureParameterSets)+len(avcc.SequenceParameterSetsExt))
+	for _, ps := range avcc.SequenceParameterSets {
+		nalus = append(nalus, ps.NALUnit)
+	}
+	for _, ps := range avcc.PictureParameterSets {
+		nalus = append(nalus, ps.NALUnit)
+	}
+	for _, ps := range avcc.SequenceParameterSetsExt {
+		nalus = append(nalus, ps.NALUnit)
+	}
+	return nalus
+}
+
 type AVCParameterSet struct {
 	BaseCustomFieldObject
 	Length  uint16 `mp4:"0,size=16"`
@@ -1451,6 +1632,84 @@ func (s *AVCParameterSet) GetFieldLength(name string, ctx Context) uint {
 	return 0
 }
 
+type HEVCDecoderConfiguration struct {
+	AnyTypeBox
+	ConfigurationVersion        uint8           `mp4:"0,size=8"`
+	GeneralProfileSpace         uint8           `mp4:"1,size=2"`
+	GeneralTierFlag             bool            `mp4:"2,size=1"`
+	GeneralProfileIdc           uint8           `mp4:"3,size=5"`
+	GeneralProfileCompatibility uint32          `mp4:"4,size=32,hex"`
+	GeneralConstraintIndicator  [6]uint8        `mp4:"5,size=8,hex"`
+	GeneralLevelIdc             uint8           `mp4:"6,size=8"`
+	Reserved                    uint8           `mp4:"7,size=4,const=15"`
+	MinSpatialSegmentationIdc   uint16          `mp4:"8,size=12"`
+	Reserved2                   uint8           `mp4:"9,size=6,const=63"`
+	ParallelismType             uint8           `mp4:"10,size=2"`
+	Reserved3                   uint8           `mp4:"11,size=6,const=63"`
+	ChromaFormatIdc             uint8           `mp4:"12,size=2"`
+	Reserved4                   uint8           `mp4:"13,size=5,const=31"`
+	BitDepthLumaMinus8          uint8           `mp4:"14,size=3"`
+	Reserved5                   uint8           `mp4:"15,size=5,const=31"`
+	BitDepthChromaMinus8        uint8           `mp4:"16,size=3"`
+	AvgFrameRate                uint16          `mp4:"17,size=16"`
+	ConstantFrameRate           uint8           `mp4:"18,size=2"`
+	NumTemporalLayers           uint8           `mp4:"19,size=3"`
+	TemporalIdNested            uint8           `mp4:"20,size=1"`
+	LengthSizeMinusOne          uint8           `mp4:"21,size=2"`
+	NumOfNaluArrays             uint8           `mp4:"22,size=8"`
+	NaluArrays                  []HEVCNaluArray `mp4:"23,len=dynamic"`
+}
+
+func (hvcc *HEVCDecoderConfiguration) GetFieldLength(name string, ctx Context) uint {
+	switch name {
+	case "NaluArrays":
+		return uint(hvcc.NumOfNaluArrays)
+	}
+	return 0
+}
+
+// ParameterSets returns all NAL units of NaluArrays in the order of appearance.
+func (hvcc *HEVCDecoderConfiguration) ParameterSets() [][]byte {
+	nalus := make([][]byte, 0, len(hvcc.NaluArrays))
+	for _, array := range hvcc.NaluArrays {
+		for _, nalu := range array.Nalus {
+			nalus = append(nalus, nalu.NALUnit)
+		}
+	}
+	return nalus
+}
+
+type HEVCNaluArray struct {
+	BaseCustomFieldObject
+	Completeness bool       `mp4:"0,size=1"`
+	Reserved     bool       `mp4:"1,size=1,const=0"`
+	NaluType     uint8      `mp4:"2,size=6"`
+	NumNalus     uint16     `mp4:"3,size=16"`
+	Nalus        []HEVCNalu `mp4:"4,len=dynamic"`
+}
+
+func (a *HEVCNaluArray) GetFieldLength(name string, ctx Context) uint {
+	switch name {
+	case "Nalus":
+		return uint(a.NumNalus)
+	}
+	return 0
+}
+
+type HEVCNalu struct {
+	BaseCustomFieldObject
+	Length  uint16 `mp4:"0,size=16"`
+	NALUnit []byte `mp4:"1,size=8,len=dynamic"`
+}
+
+func (s *HEVCNalu) GetFieldLength(name string, ctx Context) uint {
+	switch name {
+	case "NALUnit":
+		return uint(s.Length)
+	}
+	return 0
+}
+
 type PixelAspectRatioBox struct {
 	AnyTypeBox
 	HSpacing uint32 `mp4:"0,size=32"`
@@ -1549,6 +1808,32 @@ func (*Sdtp) GetType() BoxType {
 	return BoxTypeSdtp()
 }
 
+/*************************** senc ****************************/
+
+func BoxTypeSenc() BoxType { return StrToBoxType("senc") }
+
+func init() {
+	AddBoxDef(&Senc{}, 0)
+}
+
+const (
+	SencUseSubsampleEncryption = 0x000002
+)
+
+// Senc is ISO/IEC 23001-7 senc box type.
+// The format of SampleData depends on the per-sample IV size in tenc box,
+// so that it has the sample auxiliary information of all samples as it is.
+type Senc struct {
+	FullBox     `mp4:"0,extend"`
+	SampleCount uint32 `mp4:"1,size=32"`
+	SampleData  []byte `mp4:"2,size=8"`
+}
+
+// GetType returns the BoxType
+func (*Senc) GetType() BoxType {
+	return BoxTypeSenc()
+}
+
 /*************************** sgpd ****************************/
 
 func BoxTypeSgpd() BoxType { return StrToBoxType("sgpd") }
@@ -2338,6 +2623,15 @@ type Trun struct {
 	Entries          []TrunEntry `mp4:"4,len=dynamic,size=dynamic"`
 }
 
+const (
+	TrunDataOffsetPresent                  = 0x000001
+	TrunFirstSampleFlagsPresent            = 0x000004
+	TrunSampleDurationPresent              = 0x000100
+	TrunSampleSizePresent                  = 0x000200
+	TrunSampleFlagsPresent                 = 0x000400
+	TrunSampleCompositionTimeOffsetPresent = 0x000800
+)
+
 type TrunEntry struct {
 	SampleDuration                uint32 `mp4:"0,size=32,opt=0x000100"`
 	SampleSize                    uint32 `mp4:"1,size=32,opt=0x000200"`
@@ -547,6 +547,89 @@ func TestBoxTypes(t *testing.T) {
 			},
 			str: `Version=0 Flags=0x000000 PreDefined=305419896 HandlerType="abem" Name="Abema"`,
 		},
+		{
+			name: "iloc: version 0",
+			src: &Iloc{
+				FullBox: FullBox{
+					Version: 0,
+					Flags:   [3]byte{0x00, 0x00, 0x00},
+				},
+				OffsetSize:     4,
+				LengthSize:     4,
+				BaseOffsetSize: 0,
+				ItemCount:      2,
+				Items: []IlocItem{
+					{ItemID: 1, ExtentCount: 1, Extents: []IlocExtent{{ExtentOffset: 0x100, ExtentLength: 0x200}}},
+					{ItemID: 2, ExtentCount: 1, Extents: []IlocExtent{{ExtentOffset: 0x300, ExtentLength: 0x10}}},
+				},
+			},
+			dst: &Iloc{},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x00, // flags
+				0x44, 0x00, // offset size, length size, base offset size, reserved
+				0x00, 0x02, // item count
+				0x00, 0x01, // item ID
+				0x00, 0x00, // data reference index
+				0x00, 0x01, // extent count
+				0x00, 0x00, 0x01, 0x00, // extent offset
+				0x00, 0x00, 0x02, 0x00, // extent length
+				0x00, 0x02, // item ID
+				0x00, 0x00, // data reference index
+				0x00, 0x01, // extent count
+				0x00, 0x00, 0x03, 0x00, // extent offset
+				0x00, 0x00, 0x00, 0x10, // extent length
+			},
+			str: `Version=0 Flags=0x000000 OffsetSize=0x4 LengthSize=0x4 BaseOffsetSize=0x0 IndexSize=0x0 ItemCount=2 Items=[` +
+				`{ItemID=1 DataReferenceIndex=0 BaseOffset=0 ExtentCount=1 Extents=[{ExtentOffset=256 ExtentLength=512}]}, ` +
+				`{ItemID=2 DataReferenceIndex=0 BaseOffset=0 ExtentCount=1 Extents=[{ExtentOffset=768 ExtentLength=16}]}]`,
+		},
+		{
+			name: "iloc: version 2",
+			src: &Iloc{
+				FullBox: FullBox{
+					Version: 2,
+					Flags:   [3]byte{0x00, 0x00, 0x00},
+				},
+				OffsetSize:     8,
+				LengthSize:     4,
+				BaseOffsetSize: 4,
+				IndexSize:      4,
+				ItemCount:      1,
+				Items: []IlocItem{{
+					ItemID:             0x12345678,
+					ConstructionMethod: IlocConstructionMethodIdatOffset,
+					DataReferenceIndex: 0,
+					BaseOffset:         0x1000,
+					ExtentCount:        2,
+					Extents: []IlocExtent{
+						{ExtentIndex: 1, ExtentOffset: 0x0123456789abcdef, ExtentLength: 0x20},
+						{ExtentIndex: 2, ExtentOffset: 0x30, ExtentLength: 0x40},
+					},
+				}},
+			},
+			dst: &Iloc{},
+			bin: []byte{
+				2,                // version
+				0x00, 0x00, 0x00, // flags
+				0x84, 0x44, // offset size, length size, base offset size, index size
+				0x00, 0x00, 0x00, 0x01, // item count
+				0x12, 0x34, 0x56, 0x78, // item ID
+				0x00, 0x01, // reserved, construction method
+				0x00, 0x00, // data reference index
+				0x00, 0x00, 0x10, 0x00, // base offset
+				0x00, 0x02, // extent count
+				0x00, 0x00, 0x00, 0x01, // extent index
+				0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, // extent offset
+				0x00, 0x00, 0x00, 0x20, // extent length
+				0x00, 0x00, 0x00, 0x02, // extent index
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x30, // extent offset
+				0x00, 0x00, 0x00, 0x40, // extent length
+			},
+			str: `Version=2 Flags=0x000000 OffsetSize=0x8 LengthSize=0x4 BaseOffsetSize=0x4 IndexSize=0x4 ItemCount=1 Items=[` +
+				`{ItemID=305419896 ConstructionMethod=0x1 DataReferenceIndex=0 BaseOffset=4096 ExtentCount=2 Extents=[` +
+				`{ExtentIndex=1 ExtentOffset=81985529216486895 ExtentLength=32}, {ExtentIndex=2 ExtentOffset=48 ExtentLength=64}]}]`,
+		},
 		{
 			name: "ilst",
 			src:  &Ilst{},
@@ -1608,6 +1691,101 @@ func TestBoxTypes(t *testing.T) {
 				`{Length=2 NALUnit=[0x12, 0x34]}, ` +
 				`{Length=3 NALUnit=[0x12, 0x34, 0x56]}]`,
 		},
+		{
+			name: "HEVCDecoderConfiguration",
+			src: &HEVCDecoderConfiguration{
+				AnyTypeBox:                  AnyTypeBox{Type: StrToBoxType("hvcC")},
+				ConfigurationVersion:        1,
+				GeneralProfileSpace:         0,
+				GeneralTierFlag:             true,
+				GeneralProfileIdc:           2,
+				GeneralProfileCompatibility: 0x20000000,
+				GeneralConstraintIndicator:  [6]uint8{0xb0, 0x00, 0x00, 0x00, 0x00, 0x00},
+				GeneralLevelIdc:             153,
+				Reserved:                    0xf,
+				MinSpatialSegmentationIdc:   0x123,
+				Reserved2:                   0x3f,
+				ParallelismType:             1,
+				Reserved3:                   0x3f,
+				ChromaFormatIdc:             1,
+				Reserved4:                   0x1f,
+				BitDepthLumaMinus8:          2,
+				Reserved5:                   0x1f,
+				BitDepthChromaMinus8:        2,
+				AvgFrameRate:                0x1234,
+				ConstantFrameRate:           1,
+				NumTemporalLayers:           2,
+				TemporalIdNested:            1,
+				LengthSizeMinusOne:          3,
+				NumOfNaluArrays:             2,
+				NaluArrays: []HEVCNaluArray{
+					{
+						Completeness: true,
+						NaluType:     32,
+						NumNalus:     1,
+						Nalus: []HEVCNalu{
+							{Length: 2, NALUnit: []byte{0x40, 0x01}},
+						},
+					},
+					{
+						Completeness: false,
+						NaluType:     33,
+						NumNalus:     2,
+						Nalus: []HEVCNalu{
+							{Length: 2, NALUnit: []byte{0x42, 0x01}},
+							{Length: 3, NALUnit: []byte{0x42, 0x01, 0x01}},
+						},
+					},
+				},
+			},
+			dst: &HEVCDecoderConfiguration{AnyTypeBox: AnyTypeBox{Type: StrToBoxType("hvcC")}},
+			bin: []byte{
+				0x01,                   // configuration version
+				0x22,                   // profile space, tier flag, profile idc
+				0x20, 0x00, 0x00, 0x00, // profile compatibility
+				0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, // constraint indicator
+				0x99,       // level idc
+				0xf1, 0x23, // reserved, min spatial segmentation idc
+				0xfd,       // reserved, parallelism type
+				0xfd,       // reserved, chroma format idc
+				0xfa,       // reserved, bit depth luma minus 8
+				0xfa,       // reserved, bit depth chroma minus 8
+				0x12, 0x34, // avg frame rate
+				0x57,       // constant frame rate, num temporal layers, temporal id nested, length size minus one
+				0x02,       // num of nalu arrays
+				0xa0,       // completeness, reserved, nalu type
+				0x00, 0x01, // num nalus
+				0x00, 0x02, // length
+				0x40, 0x01, // nal unit
+				0x21,       // completeness, reserved, nalu type
+				0x00, 0x02, // num nalus
+				0x00, 0x02, // length
+				0x42, 0x01, // nal unit
+				0x00, 0x03, // length
+				0x42, 0x01, 0x01, // nal unit
+			},
+			str: `ConfigurationVersion=0x1 ` +
+				`GeneralProfileSpace=0x0 ` +
+				`GeneralTierFlag=true ` +
+				`GeneralProfileIdc=0x2 ` +
+				`GeneralProfileCompatibility=0x20000000 ` +
+				`GeneralConstraintIndicator=[0xb0, 0x0, 0x0, 0x0, 0x0, 0x0] ` +
+				`GeneralLevelIdc=0x99 ` +
+				`MinSpatialSegmentationIdc=291 ` +
+				`ParallelismType=0x1 ` +
+				`ChromaFormatIdc=0x1 ` +
+				`BitDepthLumaMinus8=0x2 ` +
+				`BitDepthChromaMinus8=0x2 ` +
+				`AvgFrameRate=4660 ` +
+				`ConstantFrameRate=0x1 ` +
+				`NumTemporalLayers=0x2 ` +
+				`TemporalIdNested=0x1 ` +
+				`LengthSizeMinusOne=0x3 ` +
+				`NumOfNaluArrays=0x2 ` +
+				`NaluArrays=[` +
+				`{Completeness=true NaluType=0x20 NumNalus=1 Nalus=[{Length=2 NALUnit=[0x40, 0x1]}]}, ` +
+				`{Completeness=false NaluType=0x21 NumNalus=2 Nalus=[{Length=2 NALUnit=[0x42, 0x1]}, {Length=3 NALUnit=[0x42, 0x1, 0x1]}]}]`,
+		},
 		{
 			name: "PixelAspectRatioBox",
 			src: &PixelAspectRatioBox{
@@ -1765,6 +1943,31 @@ func TestBoxTypes(t *testing.T) {
 				`{IsLeading=0x0 SampleDependsOn=0x1 SampleIsDependedOn=0x2 SampleHasRedundancy=0x3}, ` +
 				`{IsLeading=0x3 SampleDependsOn=0x2 SampleIsDependedOn=0x1 SampleHasRedundancy=0x0}]`,
 		},
+		{
+			name: "senc",
+			src: &Senc{
+				FullBox: FullBox{
+					Version: 0,
+					Flags:   [3]byte{0x00, 0x00, 0x02},
+				},
+				SampleCount: 1,
+				SampleData: []byte{
+					0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, // IV
+					0x00, 0x01, // subsample count
+					0x00, 0x10, 0x00, 0x00, 0x01, 0x00, // clear bytes, protected bytes
+				},
+			},
+			dst: &Senc{},
+			bin: []byte{
+				0,                // version
+				0x00, 0x00, 0x02, // flags
+				0x00, 0x00, 0x00, 0x01, // sample count
+				0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, // IV
+				0x00, 0x01, // subsample count
+				0x00, 0x10, 0x00, 0x00, 0x01, 0x00, // clear bytes, protected bytes
+			},
+			str: `Version=0 Flags=0x000002 SampleCount=1 SampleData=[0x1, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x0, 0x1, 0x0, 0x10, 0x0, 0x0, 0x1, 0x0]`,
+		},
 		{
 			name: "sgpd: version 1 roll",
 			src: &Sgpd{
@@ -3230,6 +3433,24 @@ func TestAvcCInconsistentError(t *testing.T) {
 	assert.Equal(t, "each values of Profile and HighProfileFieldsEnabled are inconsistent", err.Error())
 }
 
+func TestDecoderConfigurationParameterSets(t *testing.T) {
+	avcc := &AVCDecoderConfiguration{
+		SequenceParameterSets:    []AVCParameterSet{{Length: 2, NALUnit: []byte{0x67, 0x01}}},
+		PictureParameterSets:     []AVCParameterSet{{Length: 2, NALUnit: []byte{0x68, 0x02}}, {Length: 2, NALUnit: []byte{0x68, 0x03}}},
+		SequenceParameterSetsExt: []AVCParameterSet{{Length: 2, NALUnit: []byte{0x6d, 0x04}}},
+	}
+	assert.Equal(t, [][]byte{{0x67, 0x01}, {0x68, 0x02}, {0x68, 0x03}, {0x6d, 0x04}}, avcc.ParameterSets())
+
+	hvcc := &HEVCDecoderConfiguration{
+		NaluArrays: []HEVCNaluArray{
+			{NaluType: 32, Nalus: []HEVCNalu{{Length: 2, NALUnit: []byte{0x40, 0x01}}}},
+			{NaluType: 33, Nalus: []HEVCNalu{{Length: 2, NALUnit: []byte{0x42, 0x01}}}},
+			{NaluType: 34, Nalus: []HEVCNalu{{Length: 2, NALUnit: []byte{0x44, 0x01}}, {Length: 2, NALUnit: []byte{0x44, 0x02}}}},
+		},
+	}
+	assert.Equal(t, [][]byte{{0x40, 0x01}, {0x42, 0x01}, {0x44, 0x01}, {0x44, 0x02}}, hvcc.ParameterSets())
+}
+
 func TestFixedPoint(t *testing.T) {
 	mvhd := Mvhd{Rate: 0x4d2b000}
 	assert.Equal(t, float64(1234.6875), mvhd.GetRate())
@@ -0,0 +1,726 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+)
+
+// Concat concatenates MP4 files in order.
+// The inputs have to be all progressive or all fragmented, and have the same tracks
+// which are identified by track IDs and have the same handler types and timescales.
+//
+// The decoding times of each input are rebased so that all tracks of the input begin
+// at the end of the previous input, and the durations are recomputed.
+// When the sample entries of a track differ between the inputs, stsd box lists all of them
+// and the samples refer to them by the sample description index.
+// The edit list of the first input is extended to the end of the media, and the edit lists of the other inputs are ignored.
+//
+// Progressive inputs are written as a progressive MP4 file which has the rebuilt sample tables and a single mdat box.
+// For fragmented inputs, the fragments are copied with renumbered sequence numbers of mfhd boxes
+// and rebased decoding times of tfdt boxes, and sidx, ssix, styp and mfra boxes are dropped.
+func Concat(inputs []io.ReadSeeker, w io.WriteSeeker) error {
+	if len(inputs) == 0 {
+		return errors.New("no inputs")
+	}
+	c := &concatenator{}
+	for _, r := range inputs {
+		if err := c.read(r); err != nil {
+			return err
+		}
+	}
+	if err := c.merge(); err != nil {
+		return err
+	}
+	if c.base.fragmented {
+		return c.writeFragmented(w)
+	}
+	return c.base.write(c.inputs[0].r, w)
+}
+
+type concatenator struct {
+	inputs         []*concatInput
+	base           *progressiveBuilder
+	sequenceNumber uint32
+}
+
+type concatInput struct {
+	r      io.ReadSeeker
+	b      *progressiveBuilder
+	tracks map[uint32]*concatTrack
+
+	// next is the index of the sample of each track which the next track fragment begins with.
+	next map[uint32]int
+}
+
+type concatTrack struct {
+	track       *progressiveTrack
+	trak        *BoxInfo
+	handlerType [4]byte
+	entries     [][]byte
+
+	// indices are the sample description indices in the output which correspond to the sample entries.
+	indices []uint32
+
+	// shift is added to the decoding times of the samples.
+	shift int64
+}
+
+// read reads the tracks and the samples of an input.
+func (c *concatenator) read(r io.ReadSeeker) error {
+	b, err := readProgressiveInit(r)
+	if err != nil {
+		return err
+	}
+	if b.fragmented {
+		if err := b.readSegment(r); err != nil {
+			return err
+		}
+	}
+	if c.base == nil {
+		c.base = b
+	} else if b.fragmented != c.base.fragmented {
+		return errors.New("progressive and fragmented inputs can not be concatenated")
+	}
+
+	// readProgressiveInit returns the tracks in the order of trak boxes
+	traks, err := ExtractBox(r, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak()})
+	if err != nil {
+		return err
+	}
+	in := &concatInput{
+		r:      r,
+		b:      b,
+		tracks: make(map[uint32]*concatTrack, len(traks)),
+		next:   make(map[uint32]int, len(traks)),
+	}
+	for i, trak := range traks {
+		ct := &concatTrack{track: b.tracks[i], trak: trak}
+		bs, err := ExtractBoxWithPayload(r, trak, BoxPath{BoxTypeMdia(), BoxTypeHdlr()})
+		if err != nil {
+			return err
+		}
+		for _, bi := range bs {
+			ct.handlerType = bi.Payload.(*Hdlr).HandlerType
+		}
+		entries, err := ExtractBox(r, trak, sampleEntryPath(BoxTypeAny()))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			data := make([]byte, entry.Size)
+			if _, err := entry.SeekToStart(r); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			ct.entries = append(ct.entries, data)
+		}
+		in.tracks[ct.track.trackID] = ct
+	}
+	c.inputs = append(c.inputs, in)
+	return nil
+}
+
+// merge appends the samples of the inputs to the tracks of the first input.
+func (c *concatenator) merge() error {
+	for _, in := range c.inputs {
+		if len(in.tracks) != len(c.base.tracks) {
+			return errors.New("inputs have different tracks")
+		}
+	}
+	for _, track := range c.base.tracks {
+		if err := c.mergeSampleEntries(track); err != nil {
+			return err
+		}
+	}
+	c.computeShifts()
+
+	for _, track := range c.base.tracks {
+		var samples []Sample
+		for i, in := range c.inputs {
+			ct := in.tracks[track.trackID]
+			begin := len(samples)
+			for _, s := range ct.track.samples {
+				s.DecodeTime = uint64(int64(s.DecodeTime) + ct.shift)
+				index, err := ct.sampleDescriptionIndex(s.SampleDescriptionIndex)
+				if err != nil {
+					return err
+				}
+				s.SampleDescriptionIndex = index
+				samples = append(samples, s)
+			}
+			if i == 0 || c.base.fragmented {
+				// the fragments are copied as they are
+				continue
+			}
+			track.samples = samples
+			c.base.addChunks(track, in.r, begin)
+			track.appendSampleGroups(ct.track, begin)
+			if err := track.appendAuxInfo(ct.track, begin); err != nil {
+				return err
+			}
+		}
+		track.samples = samples
+		if err := track.computeDurations(); err != nil {
+			return err
+		}
+		if err := c.base.extendEdit(track); err != nil {
+			return err
+		}
+	}
+	c.base.buildTracks()
+	return nil
+}
+
+// mergeSampleEntries checks the compatibility of the track between the inputs,
+// and lists the distinct sample entries of the track.
+func (c *concatenator) mergeSampleEntries(track *progressiveTrack) error {
+	base := c.inputs[0].tracks[track.trackID]
+	entries := base.entries
+	for _, in := range c.inputs {
+		ct := in.tracks[track.trackID]
+		if ct == nil {
+			return fmt.Errorf("track is not found: trackID=%d", track.trackID)
+		}
+		if ct.handlerType != base.handlerType {
+			return fmt.Errorf("inconsistent handler type: trackID=%d", track.trackID)
+		}
+		if ct.track.timescale != track.timescale {
+			return fmt.Errorf("inconsistent timescale: trackID=%d", track.trackID)
+		}
+		for _, sgpd := range ct.track.sgpds {
+			var found bool
+			for _, s := range base.track.sgpds {
+				found = found || reflect.DeepEqual(s, sgpd)
+			}
+			if !found {
+				return fmt.Errorf("inconsistent sample group description: trackID=%d groupingType=%s",
+					track.trackID, BoxType(sgpd.GroupingType))
+			}
+		}
+
+		ct.indices = make([]uint32, len(ct.entries))
+		for i, entry := range ct.entries {
+			for j := range entries {
+				if string(entries[j]) == string(entry) {
+					ct.indices[i] = uint32(j + 1)
+					break
+				}
+			}
+			if ct.indices[i] == 0 {
+				entries = append(entries, entry)
+				ct.indices[i] = uint32(len(entries))
+			}
+		}
+	}
+	if len(entries) != len(base.entries) {
+		track.sampleEntries = entries
+	}
+	return nil
+}
+
+// sampleDescriptionIndex returns the sample description index in the output.
+func (ct *concatTrack) sampleDescriptionIndex(index uint32) (uint32, error) {
+	if index == 0 {
+		index = 1
+	}
+	if int(index) > len(ct.indices) {
+		return 0, fmt.Errorf("invalid sample description index: trackID=%d index=%d", ct.track.trackID, index)
+	}
+	return ct.indices[index-1], nil
+}
+
+// computeShifts decides the offsets which are added to the decoding times of the inputs.
+// All tracks of an input begin at the end of the previous input, keeping the differences between their start times.
+func (c *concatenator) computeShifts() {
+	movieTimescale := c.base.movieTimescale
+	ends := make(map[uint32]uint64)
+	var clipStart uint64
+	for _, in := range c.inputs {
+		var minStart uint64
+		var found bool
+		for _, ct := range in.tracks {
+			if len(ct.track.samples) == 0 {
+				continue
+			}
+			start := scaleUint(ct.track.samples[0].DecodeTime, ct.track.timescale, movieTimescale)
+			if !found || start < minStart {
+				minStart = start
+				found = true
+			}
+		}
+
+		clipEnd := clipStart
+		for trackID, ct := range in.tracks {
+			samples := ct.track.samples
+			if len(samples) == 0 {
+				continue
+			}
+			timescale := ct.track.timescale
+			first := samples[0].DecodeTime
+			start := scaleUint(clipStart, movieTimescale, timescale)
+			if offset := scaleUint(minStart, movieTimescale, timescale); first > offset {
+				start += first - offset
+			}
+			// samples never overlap the previous input
+			if start < ends[trackID] {
+				start = ends[trackID]
+			}
+			ct.shift = int64(start) - int64(first)
+
+			last := samples[len(samples)-1]
+			ends[trackID] = uint64(int64(last.DecodeTime+uint64(last.Duration)) + ct.shift)
+			if end := scaleUint(ends[trackID], timescale, movieTimescale); end > clipEnd {
+				clipEnd = end
+			}
+		}
+		clipStart = clipEnd
+	}
+}
+
+// appendSampleGroups appends the sample groups of the samples which are appended from other at begin.
+func (track *progressiveTrack) appendSampleGroups(other *progressiveTrack, begin int) {
+	for _, src := range other.sbgps {
+		var sbgp *Sbgp
+		for _, s := range track.sbgps {
+			if s.GroupingType == src.GroupingType && s.GroupingTypeParameter == src.GroupingTypeParameter {
+				sbgp = s
+			}
+		}
+		if sbgp == nil {
+			sbgp = &Sbgp{
+				FullBox:               src.FullBox,
+				GroupingType:          src.GroupingType,
+				GroupingTypeParameter: src.GroupingTypeParameter,
+			}
+			track.sbgps = append(track.sbgps, sbgp)
+		}
+
+		// the preceding samples which are not described belong to no group
+		var sampleCount int
+		for _, e := range sbgp.Entries {
+			sampleCount += int(e.SampleCount)
+		}
+		if sampleCount < begin {
+			sbgp.Entries = append(sbgp.Entries, SbgpEntry{SampleCount: uint32(begin - sampleCount)})
+		}
+		sbgp.Entries = append(sbgp.Entries, src.Entries...)
+		sbgp.EntryCount = uint32(len(sbgp.Entries))
+	}
+}
+
+// appendAuxInfo appends the auxiliary information of the samples which are appended from other at begin.
+func (track *progressiveTrack) appendAuxInfo(other *progressiveTrack, begin int) error {
+	if other.auxInfo == nil {
+		return nil
+	}
+	if track.auxInfo == nil {
+		track.auxInfo = &progressiveAuxInfo{saiz: other.auxInfo.saiz}
+	} else if !sameAuxInfoType(track.auxInfo.saiz, other.auxInfo.saiz) {
+		return fmt.Errorf("inconsistent auxiliary information type: trackID=%d", track.trackID)
+	}
+	for len(track.auxInfo.sizes) < begin {
+		track.auxInfo.sizes = append(track.auxInfo.sizes, 0)
+	}
+	track.auxInfo.sizes = append(track.auxInfo.sizes, other.auxInfo.sizes...)
+	track.auxInfo.data = append(track.auxInfo.data, other.auxInfo.data...)
+	return nil
+}
+
+// extendEdit extends the media edit of the track to the end of the media.
+// Segment duration 0 of fragmented files, which means the entire duration of the media, is kept.
+func (b *progressiveBuilder) extendEdit(track *progressiveTrack) error {
+	_, edit, err := track.splitEdits()
+	if err != nil || edit == nil || edit.SegmentDurationV1 == 0 {
+		return err
+	}
+	if mediaTime := uint64(edit.MediaTimeV1); mediaTime < track.mediaDuration {
+		edit.SegmentDurationV1 = scaleUint(track.mediaDuration-mediaTime, track.timescale, b.movieTimescale)
+	}
+	return nil
+}
+
+// fragmentDuration returns the duration of the concatenated fragments in the movie timescale.
+func (c *concatenator) fragmentDuration() uint64 {
+	var duration uint64
+	for _, track := range c.base.tracks {
+		mediaDuration := scaleUint(track.mediaDuration, track.timescale, c.base.movieTimescale)
+		d := mediaDuration
+		if delay, edit, err := track.splitEdits(); err == nil && edit != nil {
+			d = delay + edit.SegmentDurationV1
+			if edit.SegmentDurationV1 == 0 {
+				d = delay + mediaDuration - scaleUint(uint64(edit.MediaTimeV1), track.timescale, c.base.movieTimescale)
+			}
+		}
+		if d > duration {
+			duration = d
+		}
+	}
+	return duration
+}
+
+// writeFragmented writes the init segment of the first input and the fragments of all inputs.
+func (c *concatenator) writeFragmented(w io.WriteSeeker) error {
+	pw := NewWriter(w)
+	for i, in := range c.inputs {
+		var boxes []*BoxInfo
+		if _, err := ReadBoxStructure(in.r, func(h *ReadHandle) (interface{}, error) {
+			bi := h.BoxInfo
+			boxes = append(boxes, &bi)
+			return nil, nil
+		}); err != nil {
+			return err
+		}
+
+		// the boxes which precede moov box are written only for the first input
+		moovFound := i == 0
+		for _, bi := range boxes {
+			switch bi.Type {
+			case BoxTypeStyp(), BoxTypeSidx(), StrToBoxType("ssix"), BoxTypeMfra():
+				continue
+			case BoxTypeMoov():
+				moovFound = true
+				if i == 0 {
+					if err := c.writeInitMoov(pw, in, bi); err != nil {
+						return err
+					}
+				}
+				continue
+			case BoxTypeMoof():
+				if err := c.writeMoof(pw, in, bi); err != nil {
+					return err
+				}
+				continue
+			}
+			if moovFound {
+				if err := pw.CopyBox(in.r, bi); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeInitMoov copies moov box of the first input with the merged sample entries,
+// the extended edit lists and the duration of the fragments.
+func (c *concatenator) writeInitMoov(w *Writer, in *concatInput, moov *BoxInfo) error {
+	var track *progressiveTrack
+	_, err := ReadBoxStructureFromInternal(in.r, moov, func(h *ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeMvex(), BoxTypeEdts(),
+			BoxTypeStsd(), BoxTypeElst(), BoxTypeMehd():
+		default:
+			return nil, w.CopyBox(in.r, &h.BoxInfo)
+		}
+		if h.BoxInfo.Type == BoxTypeTrak() {
+			for _, ct := range in.tracks {
+				if ct.trak.Offset == h.BoxInfo.Offset {
+					track = ct.track
+				}
+			}
+		}
+
+		if _, err := w.StartBox(&h.BoxInfo); err != nil {
+			return nil, err
+		}
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		switch b := box.(type) {
+		case *Stsd:
+			if track.sampleEntries != nil {
+				if err := writeSampleEntries(w, b, h.BoxInfo.Context, track.sampleEntries); err != nil {
+					return nil, err
+				}
+				_, err := w.EndBox()
+				return nil, err
+			}
+		case *Elst:
+			box = newElst(track.edits)
+		case *Mehd:
+			duration := c.fragmentDuration()
+			if duration > math.MaxUint32 {
+				b.SetVersion(1)
+			}
+			b.FragmentDurationV0 = uint32(duration)
+			b.FragmentDurationV1 = duration
+		}
+		if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+			return nil, err
+		}
+		if _, err := h.Expand(); err != nil {
+			return nil, err
+		}
+		_, err = w.EndBox()
+		return nil, err
+	})
+	return err
+}
+
+// concatTraf is a traf box of the output.
+type concatTraf struct {
+	tfhd       *Tfhd
+	tfdt       *Tfdt
+	insertTfdt bool
+
+	// base is the base data offset relative to moof box, which is valid when relative is true.
+	// Otherwise, the data offsets are relative to the end of the data of the previous track fragment.
+	base     int64
+	relative bool
+}
+
+// concatResize is a change of the size of a box in moof box.
+type concatResize struct {
+	end   int64
+	delta int64
+}
+
+// writeMoof writes moof box which has the renumbered sequence number and the rebased decoding times.
+// The default values of trex box of the input are written into tfhd boxes when they differ from the first input.
+// Data offsets are adjusted when tfhd and tfdt boxes grow.
+func (c *concatenator) writeMoof(w *Writer, in *concatInput, moof *BoxInfo) error {
+	trafs, resizes, err := c.planTrafs(in, moof)
+	if err != nil {
+		return err
+	}
+	// position maps an offset relative to moof box in the input to the output
+	position := func(offset int64) int64 {
+		p := offset
+		for _, r := range resizes {
+			if r.end <= offset {
+				p += r.delta
+			}
+		}
+		return p
+	}
+	moofOffset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	_, err = ReadBoxStructureFromInternal(in.r, moof, func(h *ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case BoxTypeMoof(), BoxTypeTraf(), BoxTypeMfhd(), BoxTypeTfhd(), BoxTypeTrun(), BoxTypeSaio():
+		case BoxTypeTfdt():
+			return nil, writeMuxBox(w, &muxBox{box: trafs[index].tfdt})
+		default:
+			return nil, w.CopyBox(in.r, &h.BoxInfo)
+		}
+		if h.BoxInfo.Type == BoxTypeTraf() {
+			index++
+		}
+
+		if _, err := w.StartBox(&h.BoxInfo); err != nil {
+			return nil, err
+		}
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		var traf *concatTraf
+		if index >= 0 {
+			traf = trafs[index]
+		}
+		switch b := box.(type) {
+		case *Mfhd:
+			c.sequenceNumber++
+			b.SequenceNumber = c.sequenceNumber
+		case *Tfhd:
+			if traf.tfhd.GetFlags()&TfhdBaseDataOffsetPresent != 0 {
+				traf.tfhd.BaseDataOffset = uint64(moofOffset + position(traf.base))
+			}
+			box = traf.tfhd
+		case *Trun:
+			if traf.relative && b.GetFlags()&TrunDataOffsetPresent != 0 {
+				b.DataOffset = int32(position(traf.base+int64(b.DataOffset)) - position(traf.base))
+			}
+		case *Saio:
+			if !traf.relative {
+				break
+			}
+			for i := range b.OffsetV0 {
+				b.OffsetV0[i] = uint32(position(traf.base+int64(b.OffsetV0[i])) - position(traf.base))
+			}
+			for i := range b.OffsetV1 {
+				b.OffsetV1[i] = uint64(position(traf.base+int64(b.OffsetV1[i])) - position(traf.base))
+			}
+		}
+		if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+			return nil, err
+		}
+		if _, err := h.Expand(); err != nil {
+			return nil, err
+		}
+		if _, err := w.EndBox(); err != nil {
+			return nil, err
+		}
+		if h.BoxInfo.Type == BoxTypeTfhd() && traf.insertTfdt {
+			return nil, writeMuxBox(w, &muxBox{box: traf.tfdt})
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// planTrafs returns tfhd and tfdt boxes of each traf box in moof box, and the changes of their sizes.
+func (c *concatenator) planTrafs(in *concatInput, moof *BoxInfo) ([]*concatTraf, []concatResize, error) {
+	bs, err := ExtractBoxesWithPayload(in.r, moof, []BoxPath{
+		{BoxTypeTraf()},
+		{BoxTypeTraf(), BoxTypeTfhd()},
+		{BoxTypeTraf(), BoxTypeTfdt()},
+		{BoxTypeTraf(), BoxTypeTrun()},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	type source struct {
+		tfhd        *BoxInfoWithPayload
+		tfdt        *BoxInfoWithPayload
+		sampleCount int
+	}
+	var sources []*source
+	for _, bi := range bs {
+		if bi.Info.Type == BoxTypeTraf() {
+			sources = append(sources, &source{})
+			continue
+		}
+		if len(sources) == 0 {
+			continue
+		}
+		src := sources[len(sources)-1]
+		switch box := bi.Payload.(type) {
+		case *Tfhd:
+			src.tfhd = bi
+		case *Tfdt:
+			src.tfdt = bi
+		case *Trun:
+			src.sampleCount += int(box.SampleCount)
+		}
+	}
+
+	trafs := make([]*concatTraf, 0, len(sources))
+	var resizes []concatResize
+	for i, src := range sources {
+		if src.tfhd == nil {
+			return nil, nil, errors.New("tfhd box is not found")
+		}
+		orig := src.tfhd.Payload.(*Tfhd)
+		ct := in.tracks[orig.TrackID]
+		if ct == nil {
+			return nil, nil, fmt.Errorf("track is not found: trackID=%d", orig.TrackID)
+		}
+		tfhd, err := c.tfhd(ct, orig)
+		if err != nil {
+			return nil, nil, err
+		}
+		traf := &concatTraf{tfhd: tfhd}
+		flags := orig.GetFlags()
+		switch {
+		case flags&TfhdBaseDataOffsetPresent != 0:
+			traf.base = int64(orig.BaseDataOffset) - int64(moof.Offset)
+			traf.relative = true
+		case flags&TfhdDefaultBaseIsMoof != 0 || i == 0:
+			traf.relative = true
+		}
+
+		// decoding time of the first sample of the track fragment
+		samples := ct.track.samples
+		next := in.next[orig.TrackID]
+		in.next[orig.TrackID] += src.sampleCount
+		var decodeTime uint64
+		if next < len(samples) {
+			decodeTime = samples[next].DecodeTime
+		} else if len(samples) != 0 {
+			last := samples[len(samples)-1]
+			decodeTime = last.DecodeTime + uint64(last.Duration)
+		}
+		decodeTime = uint64(int64(decodeTime) + ct.shift)
+
+		traf.tfdt = &Tfdt{}
+		if src.tfdt != nil {
+			traf.tfdt.FullBox = src.tfdt.Payload.(*Tfdt).FullBox
+		} else {
+			traf.tfdt.SetVersion(1)
+			traf.insertTfdt = true
+		}
+		if decodeTime > math.MaxUint32 {
+			traf.tfdt.SetVersion(1)
+		}
+		traf.tfdt.BaseMediaDecodeTimeV0 = uint32(decodeTime)
+		traf.tfdt.BaseMediaDecodeTimeV1 = decodeTime
+		trafs = append(trafs, traf)
+
+		tfhdSize, err := boxSize(tfhd)
+		if err != nil {
+			return nil, nil, err
+		}
+		tfdtSize, err := boxSize(traf.tfdt)
+		if err != nil {
+			return nil, nil, err
+		}
+		delta := int64(tfhdSize) - int64(src.tfhd.Info.Size)
+		if traf.insertTfdt {
+			delta += int64(tfdtSize)
+		}
+		resizes = append(resizes, concatResize{
+			end:   int64(src.tfhd.Info.Offset+src.tfhd.Info.Size) - int64(moof.Offset),
+			delta: delta,
+		})
+		if src.tfdt != nil {
+			resizes = append(resizes, concatResize{
+				end:   int64(src.tfdt.Info.Offset+src.tfdt.Info.Size) - int64(moof.Offset),
+				delta: int64(tfdtSize) - int64(src.tfdt.Info.Size),
+			})
+		}
+	}
+	return trafs, resizes, nil
+}
+
+// tfhd returns tfhd box which refers to the sample description in the output,
+// and which has the default values of trex box of the input when they differ from the first input.
+func (c *concatenator) tfhd(ct *concatTrack, orig *Tfhd) (*Tfhd, error) {
+	tfhd := *orig
+	trex := ct.track.trex
+	baseTrex := c.inputs[0].tracks[orig.TrackID].track.trex
+	flags := orig.GetFlags()
+
+	index := orig.SampleDescriptionIndex
+	if flags&TfhdSampleDescriptionIndexPresent == 0 {
+		index = trex.DefaultSampleDescriptionIndex
+	}
+	index, err := ct.sampleDescriptionIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	if flags&TfhdSampleDescriptionIndexPresent != 0 || index != baseTrex.DefaultSampleDescriptionIndex {
+		tfhd.AddFlag(TfhdSampleDescriptionIndexPresent)
+		tfhd.SampleDescriptionIndex = index
+	}
+	if flags&TfhdDefaultSampleDurationPresent == 0 && trex.DefaultSampleDuration != baseTrex.DefaultSampleDuration {
+		tfhd.AddFlag(TfhdDefaultSampleDurationPresent)
+		tfhd.DefaultSampleDuration = trex.DefaultSampleDuration
+	}
+	if flags&TfhdDefaultSampleSizePresent == 0 && trex.DefaultSampleSize != baseTrex.DefaultSampleSize {
+		tfhd.AddFlag(TfhdDefaultSampleSizePresent)
+		tfhd.DefaultSampleSize = trex.DefaultSampleSize
+	}
+	if flags&TfhdDefaultSampleFlagsPresent == 0 && trex.DefaultSampleFlags != baseTrex.DefaultSampleFlags {
+		tfhd.AddFlag(TfhdDefaultSampleFlagsPresent)
+		tfhd.DefaultSampleFlags = trex.DefaultSampleFlags
+	}
+	return &tfhd, nil
+}
+
+// boxSize returns the size of the box which has the small header.
+func boxSize(box IImmutableBox) (uint64, error) {
+	n, err := Marshal(ioutil.Discard, box, Context{})
+	return SmallHeaderSize + n, err
+}
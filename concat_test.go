@@ -0,0 +1,185 @@
+package mp4
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// renameCompressor returns a copy of the input whose avc1 box has another compressor name.
+func renameCompressor(t *testing.T, fs billy.Filesystem, r io.ReadSeeker, name string) billy.File {
+	_, err := r.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	bs, err := ExtractBox(r, nil, append(BoxPath{BoxTypeMoov(), BoxTypeTrak()}, sampleEntryPath(StrToBoxType("avc1"))...))
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	// compressorname follows the 8-byte header and the 34-byte fields of VisualSampleEntry
+	offset := bs[0].Offset + 42
+	data[offset] = byte(len(name))
+	copy(data[offset+1:], name)
+
+	f, err := fs.Create("renamed.mp4")
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	return f
+}
+
+func TestConcat(t *testing.T) {
+	fs := memfs.New()
+	progressive := createProgressiveSample(t)
+	defer progressive.Close()
+	clip, err := fs.Create("clip.mp4")
+	require.NoError(t, err)
+	defer clip.Close()
+	require.NoError(t, Cut(progressive, clip, 400*time.Millisecond, 700*time.Millisecond))
+	renamed := renameCompressor(t, fs, clip, "renamed")
+	defer renamed.Close()
+
+	fragment := func(name string, r io.ReadSeeker) billy.File {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		require.NoError(t, Fragment(r, f, &FragmentOptions{FragmentDuration: 200 * time.Millisecond, WriteSidx: true}))
+		return f
+	}
+	fragmented := fragment("fragmented.mp4", progressive)
+	defer fragmented.Close()
+	fragmentedClip := fragment("fragmented_clip.mp4", clip)
+	defer fragmentedClip.Close()
+	fragmentedRenamed := fragment("fragmented_renamed.mp4", renamed)
+	defer fragmentedRenamed.Close()
+
+	// the second input begins at 1209ms, which is the end of the audio track of the first input
+	videoStart := uint64(108810)
+	audioStart := uint64(53343)
+
+	for _, tc := range []struct {
+		name          string
+		inputs        []io.ReadSeeker
+		fragmented    bool
+		entries       int
+		videoEdits    []ElstEntry
+		movieDuration uint32
+		fragments     int
+	}{
+		{
+			name:          "progressive",
+			inputs:        []io.ReadSeeker{progressive, clip},
+			entries:       1,
+			videoEdits:    []ElstEntry{{SegmentDurationV0: 200, MediaTimeV0: -1, MediaRateInteger: 1}, {SegmentDurationV0: 1509, MediaTimeV0: 18000, MediaRateInteger: 1}},
+			movieDuration: 1709,
+		},
+		{
+			name:          "progressive with different sample entries",
+			inputs:        []io.ReadSeeker{progressive, renamed},
+			entries:       2,
+			videoEdits:    []ElstEntry{{SegmentDurationV0: 200, MediaTimeV0: -1, MediaRateInteger: 1}, {SegmentDurationV0: 1509, MediaTimeV0: 18000, MediaRateInteger: 1}},
+			movieDuration: 1709,
+		},
+		{
+			name:       "fragmented",
+			inputs:     []io.ReadSeeker{fragmented, fragmentedClip},
+			fragmented: true,
+			entries:    1,
+			fragments:  6,
+		},
+		{
+			name:       "fragmented with different sample entries",
+			inputs:     []io.ReadSeeker{fragmented, fragmentedRenamed},
+			fragmented: true,
+			entries:    2,
+			fragments:  6,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := memfs.New().Create("concat.mp4")
+			require.NoError(t, err)
+			defer f.Close()
+			require.NoError(t, Concat(tc.inputs, f))
+
+			for trackID, start := range map[uint32]uint64{1: videoStart, 2: audioStart} {
+				first, err := ReadSamples(tc.inputs[0], trackID)
+				require.NoError(t, err)
+				second, err := ReadSamples(tc.inputs[1], trackID)
+				require.NoError(t, err)
+				samples, err := ReadSamples(f, trackID)
+				require.NoError(t, err)
+				require.Len(t, samples, len(first)+len(second))
+				for i, s := range samples {
+					var expected Sample
+					input := tc.inputs[0]
+					if i < len(first) {
+						expected = first[i]
+					} else {
+						expected, input = second[i-len(first)], tc.inputs[1]
+						expected.DecodeTime += start
+						if trackID == 1 && tc.entries == 2 {
+							expected.SampleDescriptionIndex = 2
+						}
+					}
+					assert.Equal(t, expected.Size, s.Size)
+					assert.Equal(t, expected.DecodeTime, s.DecodeTime)
+					assert.Equal(t, expected.CompositionTimeOffset, s.CompositionTimeOffset)
+					assert.Equal(t, expected.IsSync, s.IsSync)
+					assert.Equal(t, expected.SampleDescriptionIndex, s.SampleDescriptionIndex)
+					assert.Equal(t, readSampleData(t, input, expected), readSampleData(t, f, s))
+				}
+			}
+
+			bs, err := ExtractBoxesWithPayload(f, nil, []BoxPath{
+				{BoxTypeMoov(), BoxTypeMvhd()},
+				{BoxTypeMoov(), BoxTypeTrak(), BoxTypeEdts(), BoxTypeElst()},
+				{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd()},
+				{BoxTypeMoof(), BoxTypeMfhd()},
+				{BoxTypeSidx()},
+			})
+			require.NoError(t, err)
+			var stsds []*Stsd
+			var mfhds []*Mfhd
+			var elsts []*Elst
+			var sidxs int
+			for _, b := range bs {
+				switch box := b.Payload.(type) {
+				case *Mvhd:
+					if !tc.fragmented {
+						assert.Equal(t, tc.movieDuration, box.DurationV0)
+					}
+				case *Elst:
+					elsts = append(elsts, box)
+				case *Stsd:
+					stsds = append(stsds, box)
+				case *Mfhd:
+					mfhds = append(mfhds, box)
+				case *Sidx:
+					sidxs++
+				}
+			}
+			require.Len(t, stsds, 2)
+			assert.Equal(t, uint32(tc.entries), stsds[0].EntryCount)
+			assert.Equal(t, uint32(1), stsds[1].EntryCount)
+			if !tc.fragmented {
+				require.Len(t, elsts, 1)
+				assert.Equal(t, tc.videoEdits, elsts[0].Entries)
+			}
+			require.Len(t, mfhds, tc.fragments)
+			for i, mfhd := range mfhds {
+				assert.Equal(t, uint32(i+1), mfhd.SequenceNumber)
+			}
+			assert.Zero(t, sidxs)
+		})
+	}
+
+	f, err := memfs.New().Create("concat.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, Concat(nil, f))
+	assert.Error(t, Concat([]io.ReadSeeker{progressive, fragmented}, f))
+}
@@ -0,0 +1,180 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// opusPreroll is the recommended pre-roll of Opus defined at RFC 7845 4.6.
+const opusPreroll = 80 * time.Millisecond
+
+// Cut writes the presentation between from and to of the input as a progressive MP4 file.
+// Both progressive and fragmented inputs are accepted. When to is 0, the rest of the presentation is written.
+//
+// Each track begins at the last sync sample before from, and only the referred samples are copied.
+// Audio tracks also keep the pre-roll samples which are required by the decoder,
+// that is the roll distance of the roll sample group, a frame of AAC, or 80ms of Opus.
+// The edit list of each track is rewritten so that the presentation begins exactly at from,
+// which also keeps the encoder delay, such as AAC priming samples and Opus pre-skip, being skipped.
+func Cut(r io.ReadSeeker, w io.WriteSeeker, from, to time.Duration) error {
+	if from < 0 || (to != 0 && to <= from) {
+		return fmt.Errorf("invalid range: from=%s to=%s", from, to)
+	}
+
+	b, err := readProgressiveInit(r)
+	if err != nil {
+		return err
+	}
+	if b.fragmented {
+		b.ftyp = progressiveFtyp(b.ftyp)
+		if err := b.readSegment(r); err != nil {
+			return err
+		}
+	}
+	for _, track := range b.tracks {
+		if err := track.computeDurations(); err != nil {
+			return err
+		}
+	}
+	if b.fragmented {
+		b.alignTracks()
+	}
+
+	fromTime := scaleDuration(from, b.movieTimescale)
+	toTime := scaleDuration(to, b.movieTimescale)
+	for _, track := range b.tracks {
+		if err := b.cut(track, fromTime, toTime); err != nil {
+			return err
+		}
+		if err := track.computeDurations(); err != nil {
+			return err
+		}
+	}
+	b.buildTracks()
+	if b.movieDuration == 0 {
+		return errors.New("no samples in the range")
+	}
+	return b.write(r, w)
+}
+
+// cut trims the samples and rewrites the edit list of the track.
+// from and to are in the movie timescale, and to is 0 for the end of the presentation.
+func (b *progressiveBuilder) cut(track *progressiveTrack, from, to uint64) error {
+	delay, edit, err := track.splitEdits()
+	if err != nil {
+		return err
+	}
+	var mediaTime uint64
+	duration := scaleUint(track.mediaDuration, track.timescale, b.movieTimescale)
+	if edit != nil {
+		mediaTime = uint64(edit.MediaTimeV1)
+		duration = edit.SegmentDurationV1
+	}
+
+	// presentation of the track in the range
+	start := from
+	if start < delay {
+		start = delay
+	}
+	end := delay + duration
+	if to != 0 && to < end {
+		end = to
+	}
+	track.edits = nil
+	if len(track.samples) == 0 || start >= end {
+		b.trim(track, 0, 0)
+		return nil
+	}
+
+	// composition times relative to the first sample
+	base := track.samples[0].DecodeTime
+	mediaStart := mediaTime + scaleUint(start-delay, b.movieTimescale, track.timescale)
+	mediaEnd := mediaTime + scaleUint(end-delay, b.movieTimescale, track.timescale)
+	pts := func(i int) int64 {
+		return int64(track.samples[i].DecodeTime-base) + track.samples[i].CompositionTimeOffset
+	}
+
+	first := 0
+	last := -1
+	for i := range track.samples {
+		if track.samples[i].IsSync && pts(i) <= int64(mediaStart) {
+			first = i
+		}
+		if pts(i) < int64(mediaEnd) {
+			last = i
+		}
+	}
+	first = track.prerollStart(first)
+	if last < first {
+		b.trim(track, 0, 0)
+		return nil
+	}
+	firstDecodeTime := track.samples[first].DecodeTime - base
+	b.trim(track, first, last+1)
+
+	if start > from {
+		track.edits = append(track.edits, ElstEntry{
+			SegmentDurationV1: start - from,
+			MediaTimeV1:       -1,
+			MediaRateInteger:  1,
+		})
+	}
+	track.edits = append(track.edits, ElstEntry{
+		SegmentDurationV1: end - start,
+		MediaTimeV1:       int64(mediaStart - firstDecodeTime),
+		MediaRateInteger:  1,
+	})
+	return nil
+}
+
+// prerollStart returns the index of the sample from which decoding begins to output the specified sample.
+func (track *progressiveTrack) prerollStart(index int) int {
+	// negative roll distances of roll sample groups and positive ones of prol sample groups mean pre-roll
+	var roll int
+	for _, sgpd := range track.sgpds {
+		sign := 1
+		switch sgpd.GroupingType {
+		case [4]byte{'r', 'o', 'l', 'l'}:
+			sign = -1
+		case [4]byte{'p', 'r', 'o', 'l'}:
+		default:
+			continue
+		}
+		distances := sgpd.RollDistances
+		for _, d := range sgpd.RollDistancesL {
+			distances = append(distances, d.RollDistance)
+		}
+		for _, d := range distances {
+			if sign*int(d) > roll {
+				roll = sign * int(d)
+			}
+		}
+	}
+	if roll == 0 && track.sampleEntryType == StrToBoxType("mp4a") {
+		// an AAC frame overlaps the previous frame
+		roll = 1
+	}
+	index -= roll
+	if index < 0 {
+		index = 0
+	}
+
+	if track.sampleEntryType == StrToBoxType("Opus") {
+		preroll := scaleDuration(opusPreroll, track.timescale)
+		decodeTime := track.samples[index].DecodeTime
+		for index > 0 && decodeTime-track.samples[index].DecodeTime < preroll {
+			index--
+		}
+	}
+	return index
+}
+
+// scaleDuration converts d into the timescale.
+// The seconds and the remainder are converted separately, so that long durations do not overflow.
+func scaleDuration(d time.Duration, timescale uint32) uint64 {
+	sec := uint64(d / time.Second)
+	rem := uint64(d % time.Second)
+	return sec*uint64(timescale) + rem*uint64(timescale)/uint64(time.Second)
+}
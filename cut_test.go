@@ -0,0 +1,147 @@
+package mp4
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func TestCut(t *testing.T) {
+	progressive := createProgressiveSample(t)
+	defer progressive.Close()
+	fragmented, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer fragmented.Close()
+	require.NoError(t, Fragment(progressive, fragmented, &FragmentOptions{FragmentDuration: 200 * time.Millisecond}))
+
+	videoSamples, err := ReadSamples(progressive, 1)
+	require.NoError(t, err)
+	audioSamples, err := ReadSamples(progressive, 2)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		from          time.Duration
+		to            time.Duration
+		videoSamples  []Sample
+		audioSamples  []Sample
+		videoEdits    []ElstEntry
+		audioEdits    []ElstEntry
+		movieDuration uint32
+	}{
+		{
+			// the video track begins at the sync sample at 200ms,
+			// and the audio track has a pre-roll frame before 400ms
+			name:         "middle",
+			from:         400 * time.Millisecond,
+			to:           700 * time.Millisecond,
+			videoSamples: videoSamples[0:5],
+			audioSamples: audioSamples[8:23],
+			videoEdits: []ElstEntry{
+				{SegmentDurationV0: 300, MediaTimeV0: 36000, MediaRateInteger: 1},
+			},
+			audioEdits: []ElstEntry{
+				{SegmentDurationV0: 300, MediaTimeV0: 17640 - 16479, MediaRateInteger: 1},
+			},
+			movieDuration: 300,
+		},
+		{
+			// the empty edit of the video track is kept
+			name:         "head",
+			from:         100 * time.Millisecond,
+			to:           300 * time.Millisecond,
+			videoSamples: videoSamples[0:1],
+			audioSamples: audioSamples[0:5],
+			videoEdits: []ElstEntry{
+				{SegmentDurationV0: 100, MediaTimeV0: -1, MediaRateInteger: 1},
+				{SegmentDurationV0: 100, MediaTimeV0: 18000, MediaRateInteger: 1},
+			},
+			audioEdits: []ElstEntry{
+				{SegmentDurationV0: 200, MediaTimeV0: 4410, MediaRateInteger: 1},
+			},
+			movieDuration: 200,
+		},
+		{
+			// the video track begins at the sync sample at 700ms
+			name:         "tail",
+			from:         800 * time.Millisecond,
+			videoSamples: videoSamples[5:8],
+			audioSamples: audioSamples[25:],
+			videoEdits: []ElstEntry{
+				{SegmentDurationV0: 200, MediaTimeV0: 72000 - 45000, MediaRateInteger: 1},
+			},
+			audioEdits: []ElstEntry{
+				{SegmentDurationV0: 409, MediaTimeV0: 35280 - 33887, MediaRateInteger: 1},
+			},
+			movieDuration: 409,
+		},
+	}
+
+	for _, input := range []struct {
+		name string
+		r    io.ReadSeeker
+	}{
+		{name: "progressive", r: progressive},
+		{name: "fragmented", r: fragmented},
+	} {
+		for _, tc := range testCases {
+			t.Run(input.name+"/"+tc.name, func(t *testing.T) {
+				f, err := memfs.New().Create("cut.mp4")
+				require.NoError(t, err)
+				defer f.Close()
+				require.NoError(t, Cut(input.r, f, tc.from, tc.to))
+
+				for trackID, expected := range map[uint32][]Sample{1: tc.videoSamples, 2: tc.audioSamples} {
+					samples, err := ReadSamples(f, trackID)
+					require.NoError(t, err)
+					require.Len(t, samples, len(expected))
+					for i := range samples {
+						assert.Equal(t, expected[i].Size, samples[i].Size)
+						assert.Equal(t, expected[i].DecodeTime-expected[0].DecodeTime, samples[i].DecodeTime)
+						assert.Equal(t, expected[i].CompositionTimeOffset, samples[i].CompositionTimeOffset)
+						assert.Equal(t, expected[i].IsSync, samples[i].IsSync)
+						assert.Equal(t, readSampleData(t, progressive, expected[i]), readSampleData(t, f, samples[i]))
+					}
+				}
+
+				bs, err := ExtractBoxesWithPayload(f, nil, []BoxPath{
+					{BoxTypeMoov(), BoxTypeMvhd()},
+					{BoxTypeMoov(), BoxTypeTrak(), BoxTypeEdts(), BoxTypeElst()},
+				})
+				require.NoError(t, err)
+				require.Len(t, bs, 3)
+				assert.Equal(t, tc.movieDuration, bs[0].Payload.(*Mvhd).DurationV0)
+				assert.Equal(t, tc.videoEdits, bs[1].Payload.(*Elst).Entries)
+				assert.Equal(t, tc.audioEdits, bs[2].Payload.(*Elst).Entries)
+
+				// unreferenced samples are dropped
+				mdats, err := ExtractBox(f, nil, BoxPath{BoxTypeMdat()})
+				require.NoError(t, err)
+				require.Len(t, mdats, 1)
+				var size uint64
+				for _, s := range append(append([]Sample{}, tc.videoSamples...), tc.audioSamples...) {
+					size += uint64(s.Size)
+				}
+				assert.Equal(t, size, mdats[0].Size-mdats[0].HeaderSize)
+			})
+		}
+	}
+
+	f, err := memfs.New().Create("cut.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, Cut(progressive, f, 500*time.Millisecond, 400*time.Millisecond))
+	assert.Error(t, Cut(progressive, f, 10*time.Second, 0))
+}
+
+func TestScaleDuration(t *testing.T) {
+	assert.Equal(t, uint64(45000), scaleDuration(500*time.Millisecond, 90000))
+	assert.Equal(t, uint64(3528), scaleDuration(80*time.Millisecond, 44100))
+	// uint64(d)*timescale overflows
+	assert.Equal(t, uint64(1000*3600*90000), scaleDuration(1000*time.Hour, 90000))
+	assert.Equal(t, uint64(1000*3600)*0xffffffff, scaleDuration(1000*time.Hour, 0xffffffff))
+}
@@ -0,0 +1,114 @@
+package mp4
+
+import (
+	"errors"
+	"io"
+)
+
+// Defragment converts a fragmented MP4 file into a progressive MP4 file.
+// See DefragmentSegments for details.
+func Defragment(r io.ReadSeeker, w io.WriteSeeker) error {
+	return DefragmentSegments(r, []io.ReadSeeker{r}, w)
+}
+
+// DefragmentSegments converts an init segment and media segments into a progressive MP4 file
+// which consists of ftyp, mdat and moov.
+// Each media segment has moof/mdat pairs, and the init segment itself can be passed as a media segment.
+//
+// The sample tables are rebuilt from trun boxes, and the decoding time of each track is rebased to begin at 0.
+// An empty edit is inserted into the tracks which begin later than the other tracks.
+// The other boxes in moov, such as edts, udta, pssh and sample entries with sinf, are carried over,
+// and the sample auxiliary information referred by saiz and saio boxes is moved into mdat.
+// Sample groups defined by traf boxes are not carried over.
+func DefragmentSegments(init io.ReadSeeker, segments []io.ReadSeeker, w io.WriteSeeker) error {
+	b, err := readProgressiveInit(init)
+	if err != nil {
+		return err
+	}
+	if !b.fragmented {
+		return errors.New("input is not fragmented")
+	}
+	b.ftyp = progressiveFtyp(b.ftyp)
+	for _, segment := range segments {
+		if err := b.readSegment(segment); err != nil {
+			return err
+		}
+	}
+	for _, track := range b.tracks {
+		if err := track.computeDurations(); err != nil {
+			return err
+		}
+	}
+	b.alignTracks()
+	b.buildTracks()
+	return b.write(init, w)
+}
+
+// progressiveFtyp returns ftyp box which has isom major brand instead of the brands for fragmented files.
+func progressiveFtyp(ftyp *Ftyp) *Ftyp {
+	brands := []CompatibleBrandElem{}
+	if ftyp != nil {
+		brands = ftyp.CompatibleBrands
+	}
+	ftyp = &Ftyp{
+		MajorBrand:       [4]byte{'i', 's', 'o', 'm'},
+		MinorVersion:     0x200,
+		CompatibleBrands: []CompatibleBrandElem{{CompatibleBrand: [4]byte{'i', 's', 'o', 'm'}}},
+	}
+	for _, b := range brands {
+		switch b.CompatibleBrand {
+		case [4]byte{'c', 'm', 'f', 'c'}, [4]byte{'c', 'm', 'f', '2'}, [4]byte{'d', 'a', 's', 'h'},
+			[4]byte{'m', 's', 'd', 'h'}, [4]byte{'m', 's', 'i', 'x'}:
+			continue
+		}
+		ftyp.AddCompatibleBrand(b.CompatibleBrand)
+	}
+	return ftyp
+}
+
+// alignTracks completes the edit lists of the fragmented input,
+// and inserts an empty edit into the tracks which begin later than the other tracks.
+func (b *progressiveBuilder) alignTracks() {
+	// presentation of each track begins at the first decoding time in the movie timescale
+	var movieStart uint64
+	starts := make([]uint64, len(b.tracks))
+	var found bool
+	for i, track := range b.tracks {
+		if len(track.samples) == 0 {
+			continue
+		}
+		starts[i] = scaleUint(track.samples[0].DecodeTime, track.timescale, b.movieTimescale)
+		if !found || starts[i] < movieStart {
+			movieStart = starts[i]
+			found = true
+		}
+	}
+
+	for i, track := range b.tracks {
+		for j, e := range track.edits {
+			// segment duration 0 of fragmented files means the entire duration of the media
+			if e.SegmentDurationV1 == 0 && e.MediaTimeV1 >= 0 && uint64(e.MediaTimeV1) < track.mediaDuration {
+				track.edits[j].SegmentDurationV1 = scaleUint(track.mediaDuration-uint64(e.MediaTimeV1), track.timescale, b.movieTimescale)
+			}
+		}
+		if len(track.samples) == 0 || starts[i] == movieStart {
+			continue
+		}
+		delay := starts[i] - movieStart
+		if len(track.edits) == 0 {
+			track.edits = []ElstEntry{{
+				SegmentDurationV1: scaleUint(track.mediaDuration, track.timescale, b.movieTimescale),
+				MediaRateInteger:  1,
+			}}
+		}
+		if track.edits[0].MediaTimeV1 == -1 {
+			track.edits[0].SegmentDurationV1 += delay
+		} else {
+			track.edits = append([]ElstEntry{{
+				SegmentDurationV1: delay,
+				MediaTimeV1:       -1,
+				MediaRateInteger:  1,
+			}}, track.edits...)
+		}
+	}
+}
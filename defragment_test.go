@@ -0,0 +1,234 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func TestDefragment(t *testing.T) {
+	src := createProgressiveSample(t)
+	defer src.Close()
+
+	fs := memfs.New()
+	fragmented, err := fs.Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer fragmented.Close()
+	require.NoError(t, Fragment(src, fragmented, &FragmentOptions{
+		FragmentDuration: 200 * time.Millisecond,
+		WriteStyp:        true,
+		WriteSidx:        true,
+		WriteMfra:        true,
+	}))
+
+	// split into an init segment and media segments
+	moofs, err := ExtractBox(fragmented, nil, BoxPath{BoxTypeMoof()})
+	require.NoError(t, err)
+	require.Len(t, moofs, 4)
+	end, err := fragmented.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	copyRange := func(name string, begin, end uint64) billy.File {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = fragmented.Seek(int64(begin), io.SeekStart)
+		require.NoError(t, err)
+		_, err = io.CopyN(f, fragmented, int64(end-begin))
+		require.NoError(t, err)
+		return f
+	}
+	init := copyRange("init.mp4", 0, moofs[0].Offset)
+	defer init.Close()
+	var segments []io.ReadSeeker
+	for i, moof := range moofs {
+		segmentEnd := uint64(end)
+		if i+1 < len(moofs) {
+			segmentEnd = moofs[i+1].Offset
+		}
+		segment := copyRange(fmt.Sprintf("segment%d.m4s", i+1), moof.Offset, segmentEnd)
+		defer segment.Close()
+		segments = append(segments, segment)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		defragment func(w io.WriteSeeker) error
+	}{
+		{
+			name:       "single file",
+			defragment: func(w io.WriteSeeker) error { return Defragment(fragmented, w) },
+		},
+		{
+			name:       "segments",
+			defragment: func(w io.WriteSeeker) error { return DefragmentSegments(init, segments, w) },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := memfs.New().Create("defragmented.mp4")
+			require.NoError(t, err)
+			defer f.Close()
+			require.NoError(t, tc.defragment(f))
+
+			// samples are preserved
+			for _, trackID := range []uint32{1, 2} {
+				expected, err := ReadSamples(src, trackID)
+				require.NoError(t, err)
+				samples, err := ReadSamples(f, trackID)
+				require.NoError(t, err)
+				require.Len(t, samples, len(expected))
+				for i := range samples {
+					assert.Equal(t, expected[i].Size, samples[i].Size)
+					assert.Equal(t, expected[i].DecodeTime, samples[i].DecodeTime)
+					assert.Equal(t, expected[i].Duration, samples[i].Duration)
+					assert.Equal(t, expected[i].CompositionTimeOffset, samples[i].CompositionTimeOffset)
+					assert.Equal(t, expected[i].IsSync, samples[i].IsSync)
+					assert.Equal(t, readSampleData(t, src, expected[i]), readSampleData(t, f, samples[i]))
+				}
+			}
+
+			// boxes
+			fragmentBoxes, err := ExtractBoxes(f, nil, []BoxPath{{BoxTypeMoov(), BoxTypeMvex()}, {BoxTypeMoof()}})
+			require.NoError(t, err)
+			assert.Empty(t, fragmentBoxes)
+			paths := []BoxPath{
+				{BoxTypeFtyp()},
+				{BoxTypeMoov(), BoxTypeMvhd()},
+				{BoxTypeMoov(), BoxTypeTrak(), BoxTypeTkhd()},
+				{BoxTypeMoov(), BoxTypeTrak(), BoxTypeEdts(), BoxTypeElst()},
+				{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMdhd()},
+			}
+			expected, err := ExtractBoxesWithPayload(src, nil, paths)
+			require.NoError(t, err)
+			bs, err := ExtractBoxesWithPayload(f, nil, paths)
+			require.NoError(t, err)
+			require.Len(t, bs, len(expected))
+			ftyp := bs[0].Payload.(*Ftyp)
+			assert.Equal(t, [4]byte{'i', 's', 'o', 'm'}, ftyp.MajorBrand)
+			assert.True(t, ftyp.HasCompatibleBrand([4]byte{'a', 'v', 'c', '1'}))
+			for i := 1; i < len(bs); i++ {
+				assert.Equal(t, expected[i].Info.Type, bs[i].Info.Type)
+				assert.Equal(t, expected[i].Payload, bs[i].Payload)
+			}
+		})
+	}
+
+	// progressive input
+	f, err := memfs.New().Create("defragmented.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, Defragment(src, f))
+}
+
+func TestDefragmentAuxInfo(t *testing.T) {
+	sampleData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}
+	auxData := []byte{0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28}
+	url := &Url{}
+	url.SetFlags(UrlSelfContained)
+	init := &muxBox{box: &Moov{}, children: []*muxBox{
+		{box: &Mvhd{Timescale: 1000, NextTrackID: 2}},
+		{box: &Trak{}, children: []*muxBox{
+			{box: &Tkhd{TrackID: 1}},
+			{box: &Mdia{}, children: []*muxBox{
+				{box: &Mdhd{Timescale: 48000}},
+				{box: &Hdlr{HandlerType: [4]byte{'s', 'o', 'u', 'n'}}},
+				{box: &Minf{}, children: []*muxBox{
+					{box: &Dinf{}, children: []*muxBox{
+						{box: &Dref{EntryCount: 1}, children: []*muxBox{{box: url}}},
+					}},
+					{box: &Stbl{}, children: []*muxBox{
+						{box: &Stsd{}},
+						{box: &Stts{}},
+						{box: &Stsc{}},
+						{box: &Stsz{}},
+						{box: &Stco{}},
+					}},
+				}},
+			}},
+		}},
+		{box: &Mvex{}, children: []*muxBox{
+			{box: &Trex{TrackID: 1, DefaultSampleDescriptionIndex: 1, DefaultSampleDuration: 1024}},
+		}},
+		{box: &Pssh{SystemID: [16]byte{0x10, 0x77, 0xef, 0xec}}},
+	}}
+
+	// fragment which has 2 samples and 8 bytes of auxiliary information for each sample
+	buildMoof := func(moofSize uint32) *muxBox {
+		tfhd := &Tfhd{TrackID: 1}
+		tfhd.SetFlags(TfhdDefaultBaseIsMoof)
+		trun := &Trun{
+			SampleCount: 2,
+			DataOffset:  int32(moofSize + 8),
+			Entries:     []TrunEntry{{SampleSize: 3}, {SampleSize: 4}},
+		}
+		trun.SetFlags(TrunDataOffsetPresent | TrunSampleSizePresent)
+		saiz := &Saiz{DefaultSampleInfoSize: 8, SampleCount: 2}
+		saio := &Saio{EntryCount: 1, OffsetV0: []uint32{moofSize + 8 + uint32(len(sampleData))}}
+		return &muxBox{box: &Moof{}, children: []*muxBox{
+			{box: &Mfhd{SequenceNumber: 1}},
+			{box: &Traf{}, children: []*muxBox{
+				{box: tfhd},
+				{box: &Tfdt{BaseMediaDecodeTimeV0: 2048}},
+				{box: trun},
+				{box: saiz},
+				{box: saio},
+			}},
+		}}
+	}
+	scratch, err := memfs.New().Create("scratch.mp4")
+	require.NoError(t, err)
+	defer scratch.Close()
+	require.NoError(t, writeMuxBox(NewWriter(scratch), buildMoof(0)))
+	moofSize, err := scratch.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+
+	src, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+	w := NewWriter(src)
+	require.NoError(t, writeMuxBox(w, init))
+	require.NoError(t, writeMuxBox(w, buildMoof(uint32(moofSize))))
+	_, err = WriteBoxInfo(w, &BoxInfo{Type: BoxTypeMdat(), Size: SmallHeaderSize + uint64(len(sampleData)+len(auxData))})
+	require.NoError(t, err)
+	_, err = w.Write(append(append([]byte{}, sampleData...), auxData...))
+	require.NoError(t, err)
+
+	f, err := memfs.New().Create("defragmented.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, Defragment(src, f))
+
+	samples, err := ReadSamples(f, 1)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, uint64(0), samples[0].DecodeTime)
+	assert.Equal(t, uint64(1024), samples[1].DecodeTime)
+	assert.Equal(t, sampleData[:3], readSampleData(t, f, samples[0]))
+	assert.Equal(t, sampleData[3:], readSampleData(t, f, samples[1]))
+
+	bs, err := ExtractBoxesWithPayload(f, nil, []BoxPath{
+		{BoxTypeMoov(), BoxTypePssh()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMdhd()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSaiz()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSaio()},
+	})
+	require.NoError(t, err)
+	require.Len(t, bs, 4)
+	assert.Equal(t, [16]byte{0x10, 0x77, 0xef, 0xec}, bs[3].Payload.(*Pssh).SystemID)
+	assert.Equal(t, uint32(2048), bs[0].Payload.(*Mdhd).DurationV0)
+	saiz := bs[1].Payload.(*Saiz)
+	assert.Equal(t, uint8(8), saiz.DefaultSampleInfoSize)
+	assert.Equal(t, uint32(2), saiz.SampleCount)
+	saio := bs[2].Payload.(*Saio)
+	require.Equal(t, uint32(1), saio.EntryCount)
+	data := make([]byte, len(auxData))
+	_, err = f.Seek(int64(saio.OffsetV0[0]), io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(f, data)
+	require.NoError(t, err)
+	assert.Equal(t, auxData, data)
+}
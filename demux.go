@@ -0,0 +1,207 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/abema/go-mp4/aac"
+	"github.com/abema/go-mp4/nalu"
+)
+
+// object type indications defined at ISO/IEC 14496-1 Table 5
+const (
+	objectTypeMPEG4Audio   = 0x40
+	objectTypeMPEG2AACMain = 0x66
+	objectTypeMPEG2AACLC   = 0x67
+	objectTypeMPEG2AACSSR  = 0x68
+)
+
+// ExtractElementaryStream writes the samples of the specified track to w as an elementary stream.
+// AVC and HEVC are written as Annex B byte stream with parameter sets before every key frame,
+// AAC is written with ADTS headers which are built from AudioSpecificConfig,
+// and the other codecs, such as Opus and AC-3, are written as they are.
+func ExtractElementaryStream(r io.ReadSeeker, trackID uint32, w io.Writer) error {
+	trak, err := findTrak(r, trackID)
+	if err != nil {
+		return err
+	}
+
+	writers, err := readSampleEntryWriters(r, trak)
+	if err != nil {
+		return err
+	}
+
+	samples, err := ReadSamples(r, trackID)
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+	for _, sample := range samples {
+		index := sample.SampleDescriptionIndex
+		if index == 0 {
+			index = 1
+		}
+		if int(index) > len(writers) {
+			return fmt.Errorf("sample description not found: index=%d", index)
+		}
+
+		if uint32(cap(buf)) < sample.Size {
+			buf = make([]byte, sample.Size)
+		}
+		buf = buf[:sample.Size]
+		if _, err := r.Seek(int64(sample.Offset), io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+
+		if err := writers[index-1].writeSample(w, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sampleWriter interface {
+	writeSample(w io.Writer, data []byte) error
+}
+
+type rawSampleWriter struct{}
+
+func (rawSampleWriter) writeSample(w io.Writer, data []byte) error {
+	_, err := w.Write(data)
+	return err
+}
+
+type annexBSampleWriter struct {
+	converter nalu.AnnexBConverter
+	buf       []byte
+}
+
+func (sw *annexBSampleWriter) writeSample(w io.Writer, data []byte) error {
+	buf, err := sw.converter.Convert(sw.buf[:0], data)
+	if err != nil {
+		return err
+	}
+	sw.buf = buf
+	_, err = w.Write(buf)
+	return err
+}
+
+type adtsSampleWriter struct {
+	asc *aac.AudioSpecificConfig
+}
+
+func (sw *adtsSampleWriter) writeSample(w io.Writer, data []byte) error {
+	header, err := sw.asc.ADTSHeader(len(data))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(header.Marshal()); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readSampleEntryWriters(r io.ReadSeeker, trak *BoxInfo) ([]sampleWriter, error) {
+	paths := []BoxPath{sampleEntryPath(BoxTypeAny())}
+	for _, t := range []string{"avc1", "avc3", "encv"} {
+		paths = append(paths, sampleEntryPath(StrToBoxType(t), StrToBoxType("avcC")))
+	}
+	for _, t := range []string{"hvc1", "hev1", "encv"} {
+		paths = append(paths, sampleEntryPath(StrToBoxType(t), StrToBoxType("hvcC")))
+	}
+	for _, t := range []string{"mp4a", "enca"} {
+		paths = append(paths, sampleEntryPath(StrToBoxType(t), BoxTypeEsds()))
+		paths = append(paths, sampleEntryPath(StrToBoxType(t), BoxTypeWave(), BoxTypeEsds()))
+	}
+	bs, err := ExtractBoxes(r, trak, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	writers := make([]sampleWriter, 0, 1)
+	var entry *BoxInfo
+	for _, bi := range bs {
+		if entry == nil || bi.Offset >= entry.Offset+entry.Size {
+			// sample entry
+			entry = bi
+			writers = append(writers, rawSampleWriter{})
+			continue
+		}
+
+		// a terminator box of QuickTime, which has type 0x00000000, matches any path
+		if bi.Type != StrToBoxType("avcC") && bi.Type != StrToBoxType("hvcC") && bi.Type != BoxTypeEsds() {
+			continue
+		}
+
+		if _, err := bi.SeekToPayload(r); err != nil {
+			return nil, err
+		}
+		box, _, err := UnmarshalAny(r, bi.Type, bi.Size-bi.HeaderSize, bi.Context)
+		if err != nil {
+			return nil, err
+		}
+		switch config := box.(type) {
+		case *AVCDecoderConfiguration:
+			writers[len(writers)-1] = &annexBSampleWriter{converter: nalu.AnnexBConverter{
+				Codec:         nalu.CodecAVC,
+				LengthSize:    int(config.LengthSizeMinusOne) + 1,
+				ParameterSets: config.ParameterSets(),
+			}}
+		case *HEVCDecoderConfiguration:
+			writers[len(writers)-1] = &annexBSampleWriter{converter: nalu.AnnexBConverter{
+				Codec:         nalu.CodecHEVC,
+				LengthSize:    int(config.LengthSizeMinusOne) + 1,
+				ParameterSets: config.ParameterSets(),
+			}}
+		case *Esds:
+			asc, err := audioSpecificConfigOf(config)
+			if err != nil {
+				return nil, err
+			}
+			if asc != nil {
+				writers[len(writers)-1] = &adtsSampleWriter{asc: asc}
+			}
+		}
+	}
+	return writers, nil
+}
+
+// sampleEntryPath returns the path from trak to the descendant of stsd.
+func sampleEntryPath(types ...BoxType) BoxPath {
+	return append(BoxPath{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd()}, types...)
+}
+
+// audioSpecificConfigOf returns AudioSpecificConfig in esds.
+// It returns nil if the stream is not AAC.
+func audioSpecificConfigOf(esds *Esds) (*aac.AudioSpecificConfig, error) {
+	var objectType byte
+	for _, d := range esds.Descriptors {
+		switch d.Tag {
+		case DecoderConfigDescrTag:
+			objectType = d.DecoderConfigDescriptor.ObjectTypeIndication
+		case DecSpecificInfoTag:
+			switch objectType {
+			case objectTypeMPEG4Audio:
+				return aac.ParseAudioSpecificConfig(d.Data)
+			case objectTypeMPEG2AACMain, objectTypeMPEG2AACLC, objectTypeMPEG2AACSSR:
+				asc, err := aac.ParseAudioSpecificConfig(d.Data)
+				if err != nil {
+					return nil, err
+				}
+				asc.AudioObjectType = objectType - objectTypeMPEG2AACMain + aac.AudioObjectTypeAACMain
+				return asc, nil
+			}
+			return nil, nil
+		}
+	}
+	if objectType == objectTypeMPEG4Audio {
+		return nil, errors.New("DecoderSpecificInfo is not found in esds")
+	}
+	return nil, nil
+}
@@ -0,0 +1,62 @@
+package mp4
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/abema/go-mp4/nalu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractElementaryStream(t *testing.T) {
+	testCases := []struct {
+		name string
+		file string
+	}{
+		{name: "progressive", file: "./_examples/sample.mp4"},
+		{name: "fragmented", file: "./_examples/sample_fragmented.mp4"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Open(tc.file)
+			require.NoError(t, err)
+			defer f.Close()
+
+			// AVC
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, ExtractElementaryStream(f, 1, buf))
+			nalus, err := nalu.SplitAnnexB(buf.Bytes())
+			require.NoError(t, err)
+			if nalu.CodecAVC.IsAUD(nalu.CodecAVC.Type(nalus[0])) {
+				nalus = nalus[1:]
+			}
+			require.True(t, len(nalus) > 2)
+			assert.Equal(t, "SPS", nalu.CodecAVC.TypeName(nalu.CodecAVC.Type(nalus[0])))
+			assert.Equal(t, "PPS", nalu.CodecAVC.TypeName(nalu.CodecAVC.Type(nalus[1])))
+			var slices int
+			for _, n := range nalus {
+				if nalu.CodecAVC.IsVCL(nalu.CodecAVC.Type(n)) {
+					slices++
+				}
+			}
+			assert.Equal(t, 10, slices)
+
+			// AAC
+			samples, err := ReadSamples(f, 2)
+			require.NoError(t, err)
+			buf = bytes.NewBuffer(nil)
+			require.NoError(t, ExtractElementaryStream(f, 2, buf))
+			data := buf.Bytes()
+			for _, sample := range samples {
+				require.True(t, len(data) >= 7)
+				assert.Equal(t, []byte{0xff, 0xf1, 0x50, 0x80}, data[:4])
+				frameLength := int(data[3]&0x3)<<11 | int(data[4])<<3 | int(data[5])>>5
+				assert.Equal(t, int(sample.Size)+7, frameLength)
+				data = data[frameLength:]
+			}
+			assert.Empty(t, data)
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/orcaman/writerseeker"
+)
+
+// FastStartOptions is the options of FastStart.
+type FastStartOptions struct {
+	// RemoveFree removes top-level free and skip boxes.
+	RemoveFree bool
+
+	// Padding is the size of free box which is written after moov box.
+	// It leaves room to grow moov box in place later. No free box is written when it is 0.
+	Padding uint32
+}
+
+// FastStart moves moov box to the front of the file, right after ftyp box, for progressive playback.
+// The other top-level boxes are copied in the original order without being loaded into memory.
+// Chunk offsets of stco and co64 boxes and offsets of saio boxes in moov box are shifted,
+// and stco box is upgraded to co64 box when a shifted offset exceeds 32 bits.
+func FastStart(r io.ReadSeeker, w io.Writer, opts *FastStartOptions) error {
+	if opts == nil {
+		opts = &FastStartOptions{}
+	}
+	if opts.Padding != 0 && opts.Padding < SmallHeaderSize {
+		return fmt.Errorf("too small padding: padding=%d", opts.Padding)
+	}
+
+	var boxes []*BoxInfo
+	var moov *BoxInfo
+	if _, err := ReadBoxStructure(r, func(h *ReadHandle) (interface{}, error) {
+		bi := h.BoxInfo
+		switch bi.Type {
+		case BoxTypeMoov():
+			moov = &bi
+		case BoxTypeMoof():
+			return nil, errors.New("fragmented input is not supported")
+		case BoxTypeFree(), BoxTypeSkip():
+			if opts.RemoveFree {
+				return nil, nil
+			}
+		}
+		boxes = append(boxes, &bi)
+		return nil, nil
+	}); err != nil {
+		return err
+	}
+	if moov == nil {
+		return errors.New("moov box is not found")
+	}
+
+	fs := &fastStarter{r: r, boxes: boxes, moov: moov, padding: opts.Padding}
+	data, err := fs.buildMoov()
+	if err != nil {
+		return err
+	}
+
+	for _, bi := range fs.order {
+		switch bi {
+		case moov:
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		case fs.free:
+			free := make([]byte, bi.Size)
+			binary.BigEndian.PutUint32(free, uint32(bi.Size))
+			copy(free[4:], bi.Type[:])
+			if _, err := w.Write(free); err != nil {
+				return err
+			}
+		default:
+			if _, err := bi.SeekToStart(r); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, r, int64(bi.Size)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type fastStarter struct {
+	r        io.ReadSeeker
+	boxes    []*BoxInfo
+	moov     *BoxInfo
+	padding  uint32
+	free     *BoxInfo
+	order    []*BoxInfo
+	offsets  map[*BoxInfo]uint64
+	upgrades map[uint64]bool
+}
+
+// buildMoov decides the layout and returns moov box which has the shifted offsets.
+// The layout depends on the size of moov box, and the size of moov box depends on the layout
+// because stco boxes are upgraded to co64 boxes; they are repeatedly built until both are stable.
+func (fs *fastStarter) buildMoov() ([]byte, error) {
+	fs.upgrades = make(map[uint64]bool)
+	moovSize := fs.moov.Size
+	for {
+		fs.layout(moovSize)
+		data, overflows, err := fs.rewriteMoov()
+		if err != nil {
+			return nil, err
+		}
+		for _, offset := range overflows {
+			fs.upgrades[offset] = true
+		}
+		if len(overflows) == 0 && uint64(len(data)) == moovSize {
+			return data, nil
+		}
+		moovSize = uint64(len(data))
+	}
+}
+
+// layout decides the order and the offsets of the top-level boxes in the output.
+func (fs *fastStarter) layout(moovSize uint64) {
+	fs.order = make([]*BoxInfo, 0, len(fs.boxes)+1)
+	var moovIndex int
+	for i, bi := range fs.boxes {
+		if bi.Type == BoxTypeFtyp() && moovIndex == 0 {
+			moovIndex = i + 1
+		}
+	}
+	for i, bi := range fs.boxes {
+		if i == moovIndex {
+			fs.order = append(fs.order, fs.moov)
+			if fs.padding != 0 {
+				fs.free = &BoxInfo{Type: BoxTypeFree(), Size: uint64(fs.padding), HeaderSize: SmallHeaderSize}
+				fs.order = append(fs.order, fs.free)
+			}
+		}
+		if bi != fs.moov {
+			fs.order = append(fs.order, bi)
+		}
+	}
+	if moovIndex == len(fs.boxes) {
+		// ftyp is the last box except moov
+		fs.order = append(fs.order, fs.moov)
+	}
+
+	fs.offsets = make(map[*BoxInfo]uint64, len(fs.order))
+	var offset uint64
+	for _, bi := range fs.order {
+		fs.offsets[bi] = offset
+		if bi == fs.moov {
+			offset += moovSize
+		} else {
+			offset += bi.Size
+		}
+	}
+}
+
+// shift returns the offset in the output which corresponds to the offset in the input.
+func (fs *fastStarter) shift(offset uint64) (uint64, error) {
+	for _, bi := range fs.boxes {
+		if bi != fs.moov && offset >= bi.Offset && offset < bi.Offset+bi.Size {
+			return offset - bi.Offset + fs.offsets[bi], nil
+		}
+	}
+	return 0, fmt.Errorf("offset out of range: offset=%d", offset)
+}
+
+// rewriteMoov returns moov box which has the shifted offsets.
+// It also returns the input offsets of stco and saio boxes which have to be upgraded to 64 bits.
+func (fs *fastStarter) rewriteMoov() ([]byte, []uint64, error) {
+	ws := &writerseeker.WriterSeeker{}
+	w := NewWriter(ws)
+	var overflows []uint64
+	if _, err := ReadBoxStructureFromInternal(fs.r, fs.moov, func(h *ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(),
+			BoxTypeStco(), BoxTypeCo64(), BoxTypeSaio():
+		default:
+			// the other boxes are copied as they are
+			return nil, w.CopyBox(fs.r, &h.BoxInfo)
+		}
+
+		bi := &h.BoxInfo
+		if h.BoxInfo.Type == BoxTypeMoov() {
+			// the size of moov box which extends to the end of file is written
+			bi = &BoxInfo{Type: BoxTypeMoov()}
+		}
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		switch b := box.(type) {
+		case *Stco:
+			var large bool
+			offsets := make([]uint64, len(b.ChunkOffset))
+			for i, offset := range b.ChunkOffset {
+				if offsets[i], err = fs.shift(uint64(offset)); err != nil {
+					return nil, err
+				}
+				if offsets[i] > math.MaxUint32 {
+					large = true
+				}
+			}
+			if fs.upgrades[h.BoxInfo.Offset] {
+				box = &Co64{EntryCount: b.EntryCount, ChunkOffset: offsets}
+				bi = &BoxInfo{Type: BoxTypeCo64()}
+				break
+			}
+			if large {
+				overflows = append(overflows, h.BoxInfo.Offset)
+			}
+			for i := range offsets {
+				b.ChunkOffset[i] = uint32(offsets[i])
+			}
+		case *Co64:
+			for i, offset := range b.ChunkOffset {
+				if b.ChunkOffset[i], err = fs.shift(offset); err != nil {
+					return nil, err
+				}
+			}
+		case *Saio:
+			if len(h.Path) < 2 || h.Path[len(h.Path)-2] != BoxTypeStbl() {
+				break
+			}
+			offsets := make([]uint64, b.EntryCount)
+			var large bool
+			for i := range offsets {
+				if b.GetVersion() == 0 {
+					offsets[i], err = fs.shift(uint64(b.OffsetV0[i]))
+				} else {
+					offsets[i], err = fs.shift(b.OffsetV1[i])
+				}
+				if err != nil {
+					return nil, err
+				}
+				if offsets[i] > math.MaxUint32 {
+					large = true
+				}
+			}
+			if b.GetVersion() == 0 && large && !fs.upgrades[h.BoxInfo.Offset] {
+				overflows = append(overflows, h.BoxInfo.Offset)
+			}
+			if b.GetVersion() != 0 || fs.upgrades[h.BoxInfo.Offset] {
+				b.SetVersion(1)
+				b.OffsetV0 = nil
+				b.OffsetV1 = offsets
+			} else {
+				for i := range offsets {
+					b.OffsetV0[i] = uint32(offsets[i])
+				}
+			}
+		}
+
+		if _, err := w.StartBox(bi); err != nil {
+			return nil, err
+		}
+		if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+			return nil, err
+		}
+		if _, err := h.Expand(); err != nil {
+			return nil, err
+		}
+		_, err = w.EndBox()
+		return nil, err
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := ioutil.ReadAll(ws.Reader())
+	return data, overflows, err
+}
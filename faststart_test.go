@@ -0,0 +1,127 @@
+package mp4
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func TestFastStart(t *testing.T) {
+	src, err := os.Open("./_examples/sample.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+
+	for _, tc := range []struct {
+		name     string
+		opts     *FastStartOptions
+		expected []BoxType
+	}{
+		{
+			name:     "default",
+			expected: []BoxType{BoxTypeFtyp(), BoxTypeMoov(), BoxTypeFree(), BoxTypeMdat()},
+		},
+		{
+			name:     "remove free",
+			opts:     &FastStartOptions{RemoveFree: true},
+			expected: []BoxType{BoxTypeFtyp(), BoxTypeMoov(), BoxTypeMdat()},
+		},
+		{
+			name:     "padding",
+			opts:     &FastStartOptions{RemoveFree: true, Padding: 1024},
+			expected: []BoxType{BoxTypeFtyp(), BoxTypeMoov(), BoxTypeFree(), BoxTypeMdat()},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := memfs.New().Create("faststart.mp4")
+			require.NoError(t, err)
+			defer f.Close()
+			require.NoError(t, FastStart(src, f, tc.opts))
+
+			var types []BoxType
+			_, err = ReadBoxStructure(f, func(h *ReadHandle) (interface{}, error) {
+				types = append(types, h.BoxInfo.Type)
+				if h.BoxInfo.Type == BoxTypeFree() && tc.opts != nil {
+					assert.Equal(t, uint64(tc.opts.Padding), h.BoxInfo.Size)
+				}
+				return nil, nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, types)
+
+			for _, trackID := range []uint32{1, 2} {
+				expected, err := ReadSamples(src, trackID)
+				require.NoError(t, err)
+				samples, err := ReadSamples(f, trackID)
+				require.NoError(t, err)
+				require.Len(t, samples, len(expected))
+				for i := range samples {
+					assert.Equal(t, expected[i].Size, samples[i].Size)
+					assert.Equal(t, expected[i].DecodeTime, samples[i].DecodeTime)
+					assert.Equal(t, readSampleData(t, src, expected[i]), readSampleData(t, f, samples[i]))
+				}
+			}
+		})
+	}
+
+	// too small padding
+	f, err := memfs.New().Create("faststart.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, FastStart(src, f, &FastStartOptions{Padding: 4}))
+}
+
+func TestFastStartUpgradeToCo64(t *testing.T) {
+	r, err := memfs.New().Create("input.mp4")
+	require.NoError(t, err)
+	defer r.Close()
+	w := NewWriter(r)
+	require.NoError(t, writeMuxBox(w, &muxBox{box: &Ftyp{MajorBrand: [4]byte{'i', 's', 'o', 'm'}}}))
+	stbl := func(offset uint32) *muxBox {
+		return &muxBox{box: &Trak{}, children: []*muxBox{
+			{box: &Mdia{}, children: []*muxBox{
+				{box: &Minf{}, children: []*muxBox{
+					{box: &Stbl{}, children: []*muxBox{
+						{box: &Stco{EntryCount: 1, ChunkOffset: []uint32{offset}}},
+					}},
+				}},
+			}},
+		}}
+	}
+	require.NoError(t, writeMuxBox(w, &muxBox{box: &Moov{}, children: []*muxBox{
+		stbl(math.MaxUint32 - 10),
+		stbl(200),
+	}}))
+	bs, err := ExtractBoxes(r, nil, []BoxPath{{BoxTypeFtyp()}, {BoxTypeMoov()}})
+	require.NoError(t, err)
+	require.Len(t, bs, 2)
+
+	// a large mdat box which precedes moov box
+	fs := &fastStarter{
+		r:     r,
+		boxes: []*BoxInfo{bs[0], {Offset: 100, Size: math.MaxUint32, HeaderSize: SmallHeaderSize, Type: BoxTypeMdat()}, bs[1]},
+		moov:  bs[1],
+	}
+	data, err := fs.buildMoov()
+	require.NoError(t, err)
+	// the first stco box is upgraded to co64 box
+	assert.Equal(t, bs[1].Size+4, uint64(len(data)))
+
+	out, err := memfs.New().Create("moov.mp4")
+	require.NoError(t, err)
+	defer out.Close()
+	_, err = out.Write(data)
+	require.NoError(t, err)
+	boxes, err := ExtractBoxesWithPayload(out, nil, []BoxPath{
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStco()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeCo64()},
+	})
+	require.NoError(t, err)
+	require.Len(t, boxes, 2)
+	shift := bs[0].Size + uint64(len(data)) - 100
+	assert.Equal(t, []uint64{math.MaxUint32 - 10 + shift}, boxes[0].Payload.(*Co64).ChunkOffset)
+	assert.Equal(t, []uint32{200 + uint32(shift)}, boxes[1].Payload.(*Stco).ChunkOffset)
+}
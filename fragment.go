@@ -0,0 +1,754 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+// DefaultFragmentDuration is used when FragmentOptions.FragmentDuration is 0.
+const DefaultFragmentDuration = 2 * time.Second
+
+// FragmentOptions is the options of Fragment.
+type FragmentOptions struct {
+	// FragmentDuration is the minimum duration of each fragment.
+	// A new fragment begins at the first sync sample of the reference track after the duration,
+	// where the reference track is the first video track, or the first track when there is no video track.
+	FragmentDuration time.Duration
+
+	// WriteStyp writes styp box at the beginning of each fragment.
+	WriteStyp bool
+
+	// WriteSidx writes sidx box which indexes all fragments of the reference track.
+	WriteSidx bool
+
+	// WriteMfra writes mfra box which has tfra box for each track at the end of the file.
+	WriteMfra bool
+}
+
+type fragmentTrack struct {
+	trackID   uint32
+	timescale uint32
+	isVideo   bool
+	samples   []Sample
+	sdtp      []SdtpSampleElem
+	sbgps     []*Sbgp
+
+	// auxInfo is the sample auxiliary information for the encryption, and auxInfoOffsets is the offset of each sample in it.
+	auxInfo        *progressiveAuxInfo
+	auxInfoOffsets []int
+	ivSize         uint8
+
+	// fragmentStarts is the index of the first sample of each fragment.
+	fragmentStarts []int
+
+	hasCompositionTimeOffset         bool
+	hasNegativeCompositionTimeOffset bool
+}
+
+// fragmentSamples returns the range of the samples in k-th fragment.
+func (track *fragmentTrack) fragmentSamples(k int) (begin, end int) {
+	begin = track.fragmentStarts[k]
+	end = len(track.samples)
+	if k+1 < len(track.fragmentStarts) {
+		end = track.fragmentStarts[k+1]
+	}
+	return begin, end
+}
+
+// Fragment converts a progressive MP4 file into a fragmented MP4 file.
+// The output consists of an init segment which has mvex box and empty sample tables,
+// and moof/mdat pairs each of which contains one traf box per track.
+// The sample auxiliary information of the encrypted tracks is written into saiz, saio and senc boxes of each traf box.
+func Fragment(r io.ReadSeeker, w io.WriteSeeker, opts *FragmentOptions) error {
+	if opts == nil {
+		opts = &FragmentOptions{}
+	}
+	fragmentDuration := opts.FragmentDuration
+	if fragmentDuration == 0 {
+		fragmentDuration = DefaultFragmentDuration
+	}
+
+	tracks, err := readFragmentTracks(r)
+	if err != nil {
+		return err
+	}
+	ref := planFragments(tracks, fragmentDuration)
+	if len(ref.samples) == 0 {
+		return fmt.Errorf("no samples: trackID=%d", ref.trackID)
+	}
+
+	fw := NewWriter(w)
+	if err := writeFragmentedInitSegment(r, fw, tracks); err != nil {
+		return err
+	}
+
+	numFragments := len(ref.fragmentStarts)
+	var sidxOffset int64
+	var sidx *Sidx
+	if opts.WriteSidx {
+		if numFragments > math.MaxUint16 {
+			return fmt.Errorf("too many fragments for sidx: %d", numFragments)
+		}
+		if sidxOffset, err = fw.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+		begin, end := ref.fragmentSamples(0)
+		ept := earliestPresentationTime(ref.samples[begin:end])
+		sidx = &Sidx{
+			ReferenceID:                ref.trackID,
+			Timescale:                  ref.timescale,
+			EarliestPresentationTimeV0: uint32(ept),
+			EarliestPresentationTimeV1: ept,
+			ReferenceCount:             uint16(numFragments),
+			References:                 make([]SidxReference, numFragments),
+		}
+		if ept > math.MaxUint32 {
+			sidx.SetVersion(1)
+		}
+		if err := writeMuxBox(fw, &muxBox{box: sidx}); err != nil {
+			return err
+		}
+	}
+
+	tfras := make([]*Tfra, len(tracks))
+	for i, track := range tracks {
+		tfras[i] = &Tfra{TrackID: track.trackID}
+	}
+
+	var buf []byte
+	for k := 0; k < numFragments; k++ {
+		fragmentOffset, err := fw.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		if opts.WriteStyp {
+			styp := &Styp{
+				MajorBrand: [4]byte{'m', 's', 'd', 'h'},
+				CompatibleBrands: []CompatibleBrandElem{
+					{CompatibleBrand: [4]byte{'m', 's', 'd', 'h'}},
+					{CompatibleBrand: [4]byte{'m', 's', 'i', 'x'}},
+				},
+			}
+			if err := writeMuxBox(fw, &muxBox{box: styp}); err != nil {
+				return err
+			}
+		}
+
+		moofOffset, err := fw.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		moof, truns, mdatSize, err := buildMoof(tracks, k)
+		if err != nil {
+			return err
+		}
+		if err := writeMuxBox(fw, moof); err != nil {
+			return err
+		}
+		moofEnd, err := fw.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		// rewrite data offsets which are relative to moof
+		mdatHeaderSize := uint64(SmallHeaderSize)
+		if mdatSize+SmallHeaderSize > math.MaxUint32 {
+			mdatHeaderSize = LargeHeaderSize
+		}
+		dataOffset := uint64(moofEnd-moofOffset) + mdatHeaderSize
+		for _, trun := range truns {
+			if dataOffset > math.MaxInt32 {
+				return errors.New("too large moof box")
+			}
+			trun.DataOffset = int32(dataOffset)
+			for _, e := range trun.Entries {
+				dataOffset += uint64(e.SampleSize)
+			}
+		}
+		if _, err := fw.Seek(moofOffset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := writeMuxBox(fw, moof); err != nil {
+			return err
+		}
+
+		// mdat
+		if _, err := WriteBoxInfo(fw, &BoxInfo{
+			Type:       BoxTypeMdat(),
+			Size:       mdatHeaderSize + mdatSize,
+			HeaderSize: mdatHeaderSize,
+		}); err != nil {
+			return err
+		}
+		var trafNumber uint32
+		for i, track := range tracks {
+			begin, end := track.fragmentSamples(k)
+			if begin == end {
+				continue
+			}
+			trafNumber++
+			for j := begin; j < end; j++ {
+				s := track.samples[j]
+				if uint32(cap(buf)) < s.Size {
+					buf = make([]byte, s.Size)
+				}
+				buf = buf[:s.Size]
+				if _, err := r.Seek(int64(s.Offset), io.SeekStart); err != nil {
+					return err
+				}
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return err
+				}
+				if _, err := fw.Write(buf); err != nil {
+					return err
+				}
+			}
+
+			// random access point of the fragment
+			for j := begin; j < end; j++ {
+				s := track.samples[j]
+				if !s.IsSync {
+					continue
+				}
+				tfras[i].Entries = append(tfras[i].Entries, TfraEntry{
+					TimeV0:       uint32(int64(s.DecodeTime) + s.CompositionTimeOffset),
+					MoofOffsetV0: uint32(moofOffset),
+					TimeV1:       uint64(int64(s.DecodeTime) + s.CompositionTimeOffset),
+					MoofOffsetV1: uint64(moofOffset),
+					TrafNumber:   trafNumber,
+					TrunNumber:   1,
+					SampleNumber: uint32(j - begin + 1),
+				})
+				break
+			}
+		}
+
+		if sidx != nil {
+			fragmentEnd, err := fw.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			begin, end := ref.fragmentSamples(k)
+			if sidx.References[k], err = sidxReference(ref.samples[begin:end], uint64(fragmentEnd-fragmentOffset)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sidx != nil {
+		end, err := fw.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Seek(sidxOffset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := writeMuxBox(fw, &muxBox{box: sidx}); err != nil {
+			return err
+		}
+		if _, err := fw.Seek(end, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	if opts.WriteMfra {
+		if err := writeMfra(fw, tfras); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFragmentTracks(r io.ReadSeeker) ([]*fragmentTrack, error) {
+	bs, err := ExtractBox(r, nil, BoxPath{BoxTypeMoov(), BoxTypeMvex()})
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) != 0 {
+		return nil, errors.New("input is already fragmented")
+	}
+
+	traks, err := ExtractBox(r, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak()})
+	if err != nil {
+		return nil, err
+	}
+	if len(traks) == 0 {
+		return nil, errors.New("trak box is not found")
+	}
+
+	tracks := make([]*fragmentTrack, 0, len(traks))
+	for _, trak := range traks {
+		bs, err := ExtractBoxesWithPayload(r, trak, []BoxPath{
+			{BoxTypeTkhd()},
+			{BoxTypeMdia(), BoxTypeMdhd()},
+			{BoxTypeMdia(), BoxTypeHdlr()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSdtp()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSbgp()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSaiz()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSaio()},
+			sampleEntryPath(BoxTypeAny(), BoxTypeSinf(), BoxTypeSchi(), BoxTypeTenc()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		track := new(fragmentTrack)
+		var saiz *Saiz
+		var saio *Saio
+		var tenc *Tenc
+		for _, b := range bs {
+			switch box := b.Payload.(type) {
+			case *Tkhd:
+				track.trackID = box.TrackID
+			case *Mdhd:
+				track.timescale = box.Timescale
+			case *Hdlr:
+				track.isVideo = box.HandlerType == [4]byte{'v', 'i', 'd', 'e'}
+			case *Sdtp:
+				track.sdtp = box.Samples
+			case *Sbgp:
+				track.sbgps = append(track.sbgps, box)
+			case *Saiz:
+				saiz = box
+			case *Saio:
+				saio = box
+			case *Tenc:
+				tenc = box
+			}
+		}
+		if track.timescale == 0 {
+			return nil, fmt.Errorf("invalid timescale: trackID=%d", track.trackID)
+		}
+		if track.samples, err = readSampleTable(r, trak); err != nil {
+			return nil, err
+		}
+		if saiz != nil {
+			// the auxiliary information is written into senc box of each traf box
+			if tenc == nil {
+				return nil, fmt.Errorf("unsupported sample auxiliary information without encryption: trackID=%d", track.trackID)
+			}
+			if track.auxInfo, err = readSampleTableAuxInfo(r, track.trackID, len(track.samples), saiz, saio); err != nil {
+				return nil, err
+			}
+			track.ivSize = tenc.DefaultPerSampleIVSize
+			track.auxInfoOffsets = make([]int, len(track.auxInfo.sizes)+1)
+			for i, size := range track.auxInfo.sizes {
+				track.auxInfoOffsets[i+1] = track.auxInfoOffsets[i] + int(size)
+			}
+		}
+		for _, s := range track.samples {
+			if s.CompositionTimeOffset != 0 {
+				track.hasCompositionTimeOffset = true
+			}
+			if s.CompositionTimeOffset < 0 {
+				track.hasNegativeCompositionTimeOffset = true
+			}
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// planFragments decides the first sample of each fragment and returns the reference track.
+func planFragments(tracks []*fragmentTrack, fragmentDuration time.Duration) *fragmentTrack {
+	ref := tracks[0]
+	for _, track := range tracks {
+		if track.isVideo {
+			ref = track
+			break
+		}
+	}
+
+	// boundaries in the timescale of the reference track
+	var boundaries []uint64
+	minDuration := uint64(fragmentDuration) * uint64(ref.timescale) / uint64(time.Second)
+	for i, s := range ref.samples {
+		if i == 0 || (s.IsSync && s.DecodeTime-boundaries[len(boundaries)-1] >= minDuration) {
+			boundaries = append(boundaries, s.DecodeTime)
+			ref.fragmentStarts = append(ref.fragmentStarts, i)
+		}
+	}
+	for _, track := range tracks {
+		if track == ref {
+			continue
+		}
+		track.fragmentStarts = make([]int, len(boundaries))
+		var i int
+		for k := range boundaries {
+			if k == 0 {
+				continue
+			}
+			for i < len(track.samples) &&
+				track.samples[i].DecodeTime*uint64(ref.timescale) < boundaries[k]*uint64(track.timescale) {
+				i++
+			}
+			track.fragmentStarts[k] = i
+		}
+	}
+	return ref
+}
+
+func writeFragmentedInitSegment(r io.ReadSeeker, w *Writer, tracks []*fragmentTrack) error {
+	var movieDuration uint64
+	_, err := ReadBoxStructure(r, func(h *ReadHandle) (interface{}, error) {
+		if len(h.Path) >= 2 && h.Path[len(h.Path)-2] == BoxTypeStbl() {
+			switch h.BoxInfo.Type {
+			case BoxTypeStsd(), BoxTypeSgpd():
+			case BoxTypeStts():
+				return nil, writeMuxBox(w, &muxBox{box: &Stts{}})
+			case BoxTypeStsc():
+				return nil, writeMuxBox(w, &muxBox{box: &Stsc{}})
+			case BoxTypeStsz():
+				return nil, writeMuxBox(w, &muxBox{box: &Stsz{}})
+			case BoxTypeStco(), BoxTypeCo64():
+				return nil, writeMuxBox(w, &muxBox{box: &Stco{}})
+			default:
+				// the other sample tables, such as stss, ctts, sbgp, saiz and saio, are removed,
+				// and sbgp, saiz and saio boxes are written into each traf box
+				return nil, nil
+			}
+		}
+
+		switch h.BoxInfo.Type {
+		case BoxTypeFtyp():
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			ftyp := box.(*Ftyp)
+			brands := ftyp.CompatibleBrands
+			ftyp.MajorBrand = [4]byte{'i', 's', 'o', '6'}
+			ftyp.MinorVersion = 0
+			ftyp.CompatibleBrands = []CompatibleBrandElem{{CompatibleBrand: ftyp.MajorBrand}}
+			for _, b := range brands {
+				ftyp.AddCompatibleBrand(b.CompatibleBrand)
+			}
+			return nil, writeMuxBox(w, &muxBox{box: ftyp})
+		case BoxTypeMoov():
+		default:
+			if len(h.Path) == 1 {
+				// mdat and the other top-level boxes are not copied
+				return nil, nil
+			}
+		}
+
+		if !h.BoxInfo.IsSupportedType() {
+			return nil, w.CopyBox(r, &h.BoxInfo)
+		}
+
+		if _, err := w.StartBox(&h.BoxInfo); err != nil {
+			return nil, err
+		}
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		switch box := box.(type) {
+		case *Mvhd:
+			if box.GetVersion() == 0 {
+				movieDuration = uint64(box.DurationV0)
+			} else {
+				movieDuration = box.DurationV1
+			}
+			box.DurationV0 = 0
+			box.DurationV1 = 0
+		case *Tkhd:
+			box.DurationV0 = 0
+			box.DurationV1 = 0
+		case *Mdhd:
+			box.DurationV0 = 0
+			box.DurationV1 = 0
+		}
+		if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+			return nil, err
+		}
+		if _, err := h.Expand(); err != nil {
+			return nil, err
+		}
+
+		if h.BoxInfo.Type == BoxTypeMoov() {
+			mehd := &Mehd{}
+			if movieDuration > math.MaxUint32 {
+				mehd.SetVersion(1)
+				mehd.FragmentDurationV1 = movieDuration
+			} else {
+				mehd.FragmentDurationV0 = uint32(movieDuration)
+			}
+			mvex := &muxBox{box: &Mvex{}, children: []*muxBox{{box: mehd}}}
+			for _, track := range tracks {
+				mvex.children = append(mvex.children, &muxBox{box: &Trex{
+					TrackID:                       track.trackID,
+					DefaultSampleDescriptionIndex: 1,
+				}})
+			}
+			if err := writeMuxBox(w, mvex); err != nil {
+				return nil, err
+			}
+		}
+		_, err = w.EndBox()
+		return nil, err
+	})
+	return err
+}
+
+// buildMoof returns moof box of k-th fragment, trun boxes in the moof and the size of the sample data.
+// Data offsets of trun boxes are not set.
+func buildMoof(tracks []*fragmentTrack, k int) (*muxBox, []*Trun, uint64, error) {
+	moof := &muxBox{box: &Moof{}, children: []*muxBox{
+		{box: &Mfhd{SequenceNumber: uint32(k + 1)}},
+	}}
+	var truns []*Trun
+	var saios []*Saio
+	var sencs []*muxBox
+	var mdatSize uint64
+	for _, track := range tracks {
+		begin, end := track.fragmentSamples(k)
+		if begin == end {
+			continue
+		}
+
+		index := track.samples[begin].SampleDescriptionIndex
+		for i := begin; i < end; i++ {
+			if track.samples[i].SampleDescriptionIndex != index {
+				return nil, nil, 0, fmt.Errorf("sample description changes within a fragment: trackID=%d", track.trackID)
+			}
+		}
+
+		tfhd := &Tfhd{TrackID: track.trackID}
+		tfhd.SetFlags(TfhdDefaultBaseIsMoof)
+		if index > 1 {
+			tfhd.AddFlag(TfhdSampleDescriptionIndexPresent)
+			tfhd.SampleDescriptionIndex = index
+		}
+
+		tfdt := &Tfdt{}
+		tfdt.SetVersion(1)
+		tfdt.BaseMediaDecodeTimeV1 = track.samples[begin].DecodeTime
+
+		trun := &Trun{SampleCount: uint32(end - begin)}
+		trun.SetFlags(TrunDataOffsetPresent | TrunSampleDurationPresent | TrunSampleSizePresent | TrunSampleFlagsPresent)
+		if track.hasCompositionTimeOffset {
+			trun.AddFlag(TrunSampleCompositionTimeOffsetPresent)
+		}
+		if track.hasNegativeCompositionTimeOffset {
+			trun.SetVersion(1)
+		}
+		trun.Entries = make([]TrunEntry, 0, end-begin)
+		for i := begin; i < end; i++ {
+			s := track.samples[i]
+			trun.Entries = append(trun.Entries, TrunEntry{
+				SampleDuration:                s.Duration,
+				SampleSize:                    s.Size,
+				SampleFlags:                   track.sampleFlags(i),
+				SampleCompositionTimeOffsetV0: uint32(s.CompositionTimeOffset),
+				SampleCompositionTimeOffsetV1: int32(s.CompositionTimeOffset),
+			})
+			mdatSize += uint64(s.Size)
+		}
+		truns = append(truns, trun)
+
+		traf := &muxBox{box: &Traf{}, children: []*muxBox{
+			{box: tfhd},
+			{box: tfdt},
+			{box: trun},
+		}}
+		if track.auxInfo != nil {
+			saiz, saio, senc, err := track.fragmentAuxInfo(begin, end)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			traf.children = append(traf.children, &muxBox{box: saiz}, &muxBox{box: saio}, senc)
+			saios = append(saios, saio)
+			sencs = append(sencs, senc)
+		}
+		// the group description indices refer to sgpd box in stbl box which is kept in the init segment
+		for _, sbgp := range track.sbgps {
+			if trimmed := trimSbgp(sbgp, begin, end); len(trimmed.Entries) != 0 {
+				traf.children = append(traf.children, &muxBox{box: trimmed})
+			}
+		}
+		moof.children = append(moof.children, traf)
+	}
+
+	// saio boxes refer to the sample data in senc boxes, and the offsets are relative to moof
+	for i, saio := range saios {
+		offset, err := muxBoxOffset(moof, sencs[i])
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		// the sample data follows the full box header and the sample count
+		offset += SmallHeaderSize + 8
+		if offset > math.MaxUint32 {
+			return nil, nil, 0, errors.New("too large moof box")
+		}
+		saio.OffsetV0 = []uint32{uint32(offset)}
+	}
+	return moof, truns, mdatSize, nil
+}
+
+// fragmentAuxInfo returns saiz, saio and senc boxes of the auxiliary information of the samples in the range.
+// The offset of saio box is set by buildMoof.
+func (track *fragmentTrack) fragmentAuxInfo(begin, end int) (*Saiz, *Saio, *muxBox, error) {
+	sizes := track.auxInfo.sizes[begin:end]
+	senc := &Senc{
+		SampleCount: uint32(end - begin),
+		SampleData:  track.auxInfo.data[track.auxInfoOffsets[begin]:track.auxInfoOffsets[end]],
+	}
+	// the auxiliary information has subsamples after the IV when it is larger than the IV
+	subsample := len(sizes) != 0 && sizes[0] > track.ivSize
+	for _, size := range sizes {
+		if (size > track.ivSize) != subsample {
+			return nil, nil, nil, fmt.Errorf("inconsistent use of subsample encryption: trackID=%d", track.trackID)
+		}
+	}
+	if subsample {
+		senc.SetFlags(SencUseSubsampleEncryption)
+	}
+
+	src := track.auxInfo.saiz
+	saio := &Saio{
+		FullBox:              src.FullBox,
+		AuxInfoType:          src.AuxInfoType,
+		AuxInfoTypeParameter: src.AuxInfoTypeParameter,
+		EntryCount:           1,
+		OffsetV0:             []uint32{0},
+	}
+	saio.SetVersion(0)
+	return newSaiz(src, sizes), saio, &muxBox{box: senc}, nil
+}
+
+// muxBoxOffset returns the offset of the box from the beginning of b when b is written by writeMuxBox.
+func muxBoxOffset(b, target *muxBox) (uint64, error) {
+	offset, found, err := muxBoxLayout(b, target)
+	if err != nil {
+		return 0, err
+	} else if !found {
+		return 0, errors.New("box is not found")
+	}
+	return offset, nil
+}
+
+// muxBoxLayout returns the offset of target if b has it, or the size of b.
+func muxBoxLayout(b, target *muxBox) (uint64, bool, error) {
+	if b == target {
+		return 0, true, nil
+	}
+	n, err := Marshal(ioutil.Discard, b.box, Context{})
+	if err != nil {
+		return 0, false, err
+	}
+	offset := SmallHeaderSize + n
+	for _, c := range b.children {
+		size, found, err := muxBoxLayout(c, target)
+		if err != nil {
+			return 0, false, err
+		}
+		offset += size
+		if found {
+			return offset, true, nil
+		}
+	}
+	return offset, false, nil
+}
+
+// sampleFlags returns sample flags defined at ISO/IEC 14496-12 8.8.3.1.
+// Dependency information comes from sdtp box, and sample_depends_on is derived from
+// the sync sample table when sdtp box does not have it.
+func (track *fragmentTrack) sampleFlags(i int) uint32 {
+	var flags uint32
+	var dependsOn uint8
+	if i < len(track.sdtp) {
+		e := track.sdtp[i]
+		flags |= uint32(e.IsLeading&0x3)<<26 |
+			uint32(e.SampleIsDependedOn&0x3)<<22 |
+			uint32(e.SampleHasRedundancy&0x3)<<20
+		dependsOn = e.SampleDependsOn & 0x3
+	}
+	if dependsOn == 0 {
+		if track.samples[i].IsSync {
+			dependsOn = 2 // does not depend on others
+		} else {
+			dependsOn = 1 // depends on others
+		}
+	}
+	flags |= uint32(dependsOn) << 24
+	if !track.samples[i].IsSync {
+		flags |= SampleFlagsIsNonSyncSample
+	}
+	return flags
+}
+
+func earliestPresentationTime(samples []Sample) uint64 {
+	var ept int64
+	for i, s := range samples {
+		if t := int64(s.DecodeTime) + s.CompositionTimeOffset; i == 0 || t < ept {
+			ept = t
+		}
+	}
+	if ept < 0 {
+		return 0
+	}
+	return uint64(ept)
+}
+
+func writeMfra(w *Writer, tfras []*Tfra) error {
+	if _, err := w.StartBox(&BoxInfo{Type: BoxTypeMfra()}); err != nil {
+		return err
+	}
+	for _, tfra := range tfras {
+		var maxTime, maxOffset uint64
+		var maxTraf, maxSample uint32
+		for _, e := range tfra.Entries {
+			if e.TimeV1 > maxTime {
+				maxTime = e.TimeV1
+			}
+			if e.MoofOffsetV1 > maxOffset {
+				maxOffset = e.MoofOffsetV1
+			}
+			if e.TrafNumber > maxTraf {
+				maxTraf = e.TrafNumber
+			}
+			if e.SampleNumber > maxSample {
+				maxSample = e.SampleNumber
+			}
+		}
+		if maxTime > math.MaxUint32 || maxOffset > math.MaxUint32 {
+			tfra.SetVersion(1)
+		}
+		tfra.LengthSizeOfTrafNum = lengthSizeOf(maxTraf)
+		tfra.LengthSizeOfSampleNum = lengthSizeOf(maxSample)
+		tfra.NumberOfEntry = uint32(len(tfra.Entries))
+		if err := writeMuxBox(w, &muxBox{box: tfra}); err != nil {
+			return err
+		}
+	}
+	offset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	mfra := w.biStack[len(w.biStack)-1].bi
+	mfro := &Mfro{Size: uint32(uint64(offset) - mfra.Offset + 16)}
+	if err := writeMuxBox(w, &muxBox{box: mfro}); err != nil {
+		return err
+	}
+	_, err = w.EndBox()
+	return err
+}
+
+// lengthSizeOf returns the value of length_size_of_xxx_num fields of tfra for n.
+func lengthSizeOf(n uint32) byte {
+	switch {
+	case n <= math.MaxUint8:
+		return 0
+	case n <= math.MaxUint16:
+		return 1
+	case n <= 1<<24-1:
+		return 2
+	default:
+		return 3
+	}
+}
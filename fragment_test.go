@@ -0,0 +1,406 @@
+package mp4
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/abema/go-mp4/nalu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// createProgressiveSample muxes the samples of _examples/sample_fragmented.mp4 into a progressive MP4 file.
+func createProgressiveSample(t *testing.T) billy.File {
+	src, err := os.Open("./_examples/sample_fragmented.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+
+	bs, err := ExtractBoxesWithPayload(src, nil, []BoxPath{
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(),
+			StrToBoxType("avc1"), StrToBoxType("avcC")},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(),
+			StrToBoxType("mp4a"), BoxTypeEsds()},
+	})
+	require.NoError(t, err)
+	require.Len(t, bs, 2)
+	parameterSets := bs[0].Payload.(*AVCDecoderConfiguration).ParameterSets()
+	asc, err := audioSpecificConfigOf(bs[1].Payload.(*Esds))
+	require.NoError(t, err)
+
+	f, err := memfs.New().Create("progressive.mp4")
+	require.NoError(t, err)
+	muxer, err := NewMuxer(f)
+	require.NoError(t, err)
+	videoTrackID, err := muxer.AddAVCTrack(90000, parameterSets[0], parameterSets[1])
+	require.NoError(t, err)
+	audioTrackID, err := muxer.AddAACTrack(44100, asc)
+	require.NoError(t, err)
+
+	videoSamples, err := ReadSamples(src, 1)
+	require.NoError(t, err)
+	audioSamples, err := ReadSamples(src, 2)
+	require.NoError(t, err)
+	var v, a int
+	for v < len(videoSamples) || a < len(audioSamples) {
+		if a >= len(audioSamples) || (v < len(videoSamples) &&
+			videoSamples[v].DecodeTime*44100 <= audioSamples[a].DecodeTime*90000) {
+			s := videoSamples[v]
+			data, err := nalu.ToAnnexB(readSampleData(t, src, s), 4)
+			require.NoError(t, err)
+			dts := int64(s.DecodeTime)
+			require.NoError(t, muxer.WriteSample(videoTrackID, data, dts, dts+s.CompositionTimeOffset, s.IsSync))
+			v++
+		} else {
+			s := audioSamples[a]
+			dts := int64(s.DecodeTime)
+			require.NoError(t, muxer.WriteSample(audioTrackID, readSampleData(t, src, s), dts, dts, true))
+			a++
+		}
+	}
+	require.NoError(t, muxer.Close())
+	return f
+}
+
+func TestFragment(t *testing.T) {
+	src := createProgressiveSample(t)
+	defer src.Close()
+
+	f, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, Fragment(src, f, &FragmentOptions{
+		FragmentDuration: 200 * time.Millisecond,
+		WriteStyp:        true,
+		WriteSidx:        true,
+		WriteMfra:        true,
+	}))
+
+	// samples are preserved
+	for _, trackID := range []uint32{1, 2} {
+		expected, err := ReadSamples(src, trackID)
+		require.NoError(t, err)
+		samples, err := ReadSamples(f, trackID)
+		require.NoError(t, err)
+		require.Len(t, samples, len(expected))
+		for i := range samples {
+			assert.Equal(t, expected[i].Size, samples[i].Size)
+			assert.Equal(t, expected[i].DecodeTime, samples[i].DecodeTime)
+			assert.Equal(t, expected[i].Duration, samples[i].Duration)
+			assert.Equal(t, expected[i].CompositionTimeOffset, samples[i].CompositionTimeOffset)
+			assert.Equal(t, expected[i].IsSync, samples[i].IsSync)
+			assert.Equal(t, readSampleData(t, src, expected[i]), readSampleData(t, f, samples[i]))
+		}
+	}
+
+	// init segment
+	bs, err := ExtractBoxesWithPayload(f, nil, []BoxPath{
+		{BoxTypeFtyp()},
+		{BoxTypeMoov(), BoxTypeMvex(), BoxTypeMehd()},
+		{BoxTypeMoov(), BoxTypeMvex(), BoxTypeTrex()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsz()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStss()},
+	})
+	require.NoError(t, err)
+	require.Len(t, bs, 6)
+	ftyp := bs[0].Payload.(*Ftyp)
+	assert.Equal(t, [4]byte{'i', 's', 'o', '6'}, ftyp.MajorBrand)
+	assert.True(t, ftyp.HasCompatibleBrand([4]byte{'a', 'v', 'c', '1'}))
+	// stss is removed and mvex is appended to the end of moov
+	assert.Equal(t, uint32(0), bs[1].Payload.(*Stsz).SampleCount)
+	assert.Equal(t, uint32(0), bs[2].Payload.(*Stsz).SampleCount)
+	assert.NotZero(t, bs[3].Payload.(*Mehd).FragmentDurationV0)
+	assert.Equal(t, uint32(1), bs[4].Payload.(*Trex).TrackID)
+	assert.Equal(t, uint32(2), bs[5].Payload.(*Trex).TrackID)
+
+	// fragments
+	bs, err = ExtractBoxesWithPayload(f, nil, []BoxPath{
+		{BoxTypeSidx()},
+		{BoxTypeStyp()},
+		{BoxTypeMoof(), BoxTypeMfhd()},
+		{BoxTypeMoof(), BoxTypeTraf(), BoxTypeTfhd()},
+		{BoxTypeMoof(), BoxTypeTraf(), BoxTypeTrun()},
+		{BoxTypeMfra(), BoxTypeTfra()},
+		{BoxTypeMfra(), BoxTypeMfro()},
+	})
+	require.NoError(t, err)
+	var sidx *Sidx
+	var mfhds []*Mfhd
+	var tfras []*Tfra
+	var mfro *Mfro
+	var stypCount int
+	var videoTruns []*Trun
+	var tfhd *Tfhd
+	for _, b := range bs {
+		switch box := b.Payload.(type) {
+		case *Sidx:
+			sidx = box
+		case *Styp:
+			stypCount++
+		case *Mfhd:
+			mfhds = append(mfhds, box)
+		case *Tfhd:
+			tfhd = box
+			assert.Equal(t, uint32(TfhdDefaultBaseIsMoof), box.GetFlags())
+		case *Trun:
+			if tfhd.TrackID == 1 {
+				videoTruns = append(videoTruns, box)
+			}
+		case *Tfra:
+			tfras = append(tfras, box)
+		case *Mfro:
+			mfro = box
+		}
+	}
+	require.Len(t, mfhds, 4)
+	for i, mfhd := range mfhds {
+		assert.Equal(t, uint32(i+1), mfhd.SequenceNumber)
+	}
+	assert.Equal(t, 4, stypCount)
+
+	// each fragment begins with a sync sample
+	require.Len(t, videoTruns, 4)
+	for _, trun := range videoTruns {
+		assert.Zero(t, trun.Entries[0].SampleFlags&SampleFlagsIsNonSyncSample)
+		assert.Equal(t, uint32(0x02000000), trun.Entries[0].SampleFlags)
+		assert.Equal(t, uint32(0x01010000), trun.Entries[1].SampleFlags)
+	}
+
+	require.NotNil(t, sidx)
+	assert.Equal(t, uint32(1), sidx.ReferenceID)
+	assert.Equal(t, uint32(90000), sidx.Timescale)
+	require.Equal(t, uint16(4), sidx.ReferenceCount)
+	moofs, err := ExtractBox(f, nil, BoxPath{BoxTypeMoof()})
+	require.NoError(t, err)
+	require.Len(t, moofs, 4)
+	sidxs, err := ExtractBox(f, nil, BoxPath{BoxTypeSidx()})
+	require.NoError(t, err)
+	offset := sidxs[0].Offset + sidxs[0].Size
+	for i, ref := range sidx.References {
+		assert.True(t, ref.StartsWithSAP)
+		assert.Equal(t, uint32(1), ref.SAPType)
+		// a subsegment consists of styp, moof and mdat
+		assert.Equal(t, moofs[i].Offset-24, offset)
+		offset += uint64(ref.ReferencedSize)
+	}
+
+	require.Len(t, tfras, 2)
+	require.Len(t, tfras[0].Entries, 4)
+	for i, e := range tfras[0].Entries {
+		assert.Equal(t, uint32(moofs[i].Offset), e.MoofOffsetV0)
+		assert.Equal(t, uint32(1), e.TrafNumber)
+		assert.Equal(t, uint32(1), e.SampleNumber)
+	}
+	mfras, err := ExtractBox(f, nil, BoxPath{BoxTypeMfra()})
+	require.NoError(t, err)
+	require.NotNil(t, mfro)
+	assert.Equal(t, uint32(mfras[0].Size), mfro.Size)
+
+	// fragmented input
+	fragmented, err := os.Open("./_examples/sample_fragmented.mp4")
+	require.NoError(t, err)
+	defer fragmented.Close()
+	assert.Error(t, Fragment(fragmented, f, nil))
+}
+
+func TestFragmentSampleGroups(t *testing.T) {
+	src, err := os.Open("./_examples/sample.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+	f, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, Fragment(src, f, &FragmentOptions{FragmentDuration: 200 * time.Millisecond}))
+
+	stbl := BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl()}
+	bs, err := ExtractBoxWithPayload(src, nil, append(stbl, BoxTypeSbgp()))
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	expected := bs[0].Payload.(*Sbgp)
+
+	// sgpd is kept in stbl, and sbgp is moved from stbl into each traf of the audio track
+	bs, err = ExtractBoxWithPayload(f, nil, append(stbl, BoxTypeSgpd()))
+	require.NoError(t, err)
+	assert.Len(t, bs, 1)
+	bs, err = ExtractBoxWithPayload(f, nil, append(stbl, BoxTypeSbgp()))
+	require.NoError(t, err)
+	assert.Empty(t, bs)
+	bs, err = ExtractBoxWithPayload(f, nil, BoxPath{BoxTypeMoof(), BoxTypeTraf(), BoxTypeSbgp()})
+	require.NoError(t, err)
+	require.NotEmpty(t, bs)
+
+	// the group description index of each sample is preserved
+	groupIndices := func(sbgps ...*Sbgp) []uint32 {
+		var indices []uint32
+		for _, sbgp := range sbgps {
+			assert.Equal(t, expected.GroupingType, sbgp.GroupingType)
+			for _, e := range sbgp.Entries {
+				for i := uint32(0); i < e.SampleCount; i++ {
+					indices = append(indices, e.GroupDescriptionIndex)
+				}
+			}
+		}
+		return indices
+	}
+	var sbgps []*Sbgp
+	for _, b := range bs {
+		sbgps = append(sbgps, b.Payload.(*Sbgp))
+	}
+	assert.Equal(t, groupIndices(expected), groupIndices(sbgps...))
+}
+
+func TestBuildMoofSampleGroups(t *testing.T) {
+	track := &fragmentTrack{
+		trackID:        1,
+		timescale:      1000,
+		samples:        make([]Sample, 5),
+		fragmentStarts: []int{0, 2},
+		sbgps: []*Sbgp{{
+			GroupingType: 0x726f6c6c, // roll
+			EntryCount:   3,
+			Entries: []SbgpEntry{
+				{SampleCount: 1, GroupDescriptionIndex: 1},
+				{SampleCount: 2, GroupDescriptionIndex: 0},
+				{SampleCount: 2, GroupDescriptionIndex: 2},
+			},
+		}},
+	}
+	for k, expected := range [][]SbgpEntry{
+		{{SampleCount: 1, GroupDescriptionIndex: 1}, {SampleCount: 1, GroupDescriptionIndex: 0}},
+		{{SampleCount: 1, GroupDescriptionIndex: 0}, {SampleCount: 2, GroupDescriptionIndex: 2}},
+	} {
+		moof, _, _, err := buildMoof([]*fragmentTrack{track}, k)
+		require.NoError(t, err)
+		traf := moof.children[1]
+		require.Len(t, traf.children, 4)
+		sbgp := traf.children[3].box.(*Sbgp)
+		assert.Equal(t, uint32(0x726f6c6c), sbgp.GroupingType)
+		assert.Equal(t, uint32(len(expected)), sbgp.EntryCount)
+		assert.Equal(t, expected, sbgp.Entries)
+	}
+}
+
+func TestFragmentEncrypted(t *testing.T) {
+	// 4 samples which have 8 bytes IV and subsamples
+	sampleData := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12}
+	sampleSizes := []uint32{3, 4, 5, 6}
+	var auxData []byte
+	auxSizes := []uint8{16, 22, 16, 22}
+	for i, size := range auxSizes {
+		iv := []byte{byte(i), 0, 0, 0, 0, 0, 0, 1}
+		subsamples := []byte{0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02}
+		if size == 22 {
+			subsamples = []byte{0x00, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}
+		}
+		auxData = append(append(auxData, iv...), subsamples...)
+	}
+
+	src, err := memfs.New().Create("encrypted.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+	w := NewWriter(src)
+	require.NoError(t, writeMuxBox(w, &muxBox{box: &Ftyp{MajorBrand: [4]byte{'i', 's', 'o', 'm'}}}))
+	mdatOffset, err := w.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+	_, err = WriteBoxInfo(w, &BoxInfo{Type: BoxTypeMdat(), Size: SmallHeaderSize + uint64(len(sampleData)+len(auxData))})
+	require.NoError(t, err)
+	_, err = w.Write(append(append([]byte{}, sampleData...), auxData...))
+	require.NoError(t, err)
+
+	url := &Url{}
+	url.SetFlags(UrlSelfContained)
+	enca := &AudioSampleEntry{
+		SampleEntry:  SampleEntry{AnyTypeBox: AnyTypeBox{Type: StrToBoxType("enca")}, DataReferenceIndex: 1},
+		ChannelCount: 2,
+		SampleSize:   16,
+		SampleRate:   48000 << 16,
+	}
+	tenc := &Tenc{DefaultIsProtected: 1, DefaultPerSampleIVSize: 8, DefaultKID: [16]byte{0x01, 0x23}}
+	dataOffset := uint32(mdatOffset) + SmallHeaderSize
+	require.NoError(t, writeMuxBox(w, &muxBox{box: &Moov{}, children: []*muxBox{
+		{box: &Mvhd{Timescale: 1000, NextTrackID: 2}},
+		{box: &Trak{}, children: []*muxBox{
+			{box: &Tkhd{TrackID: 1}},
+			{box: &Mdia{}, children: []*muxBox{
+				{box: &Mdhd{Timescale: 48000}},
+				{box: &Hdlr{HandlerType: [4]byte{'s', 'o', 'u', 'n'}}},
+				{box: &Minf{}, children: []*muxBox{
+					{box: &Dinf{}, children: []*muxBox{
+						{box: &Dref{EntryCount: 1}, children: []*muxBox{{box: url}}},
+					}},
+					{box: &Stbl{}, children: []*muxBox{
+						{box: &Stsd{EntryCount: 1}, children: []*muxBox{
+							{box: enca, children: []*muxBox{
+								{box: &Sinf{}, children: []*muxBox{
+									{box: &Frma{DataFormat: [4]byte{'m', 'p', '4', 'a'}}},
+									{box: &Schm{SchemeType: [4]byte{'c', 'e', 'n', 'c'}, SchemeVersion: 0x00010000}},
+									{box: &Schi{}, children: []*muxBox{{box: tenc}}},
+								}},
+							}},
+						}},
+						{box: &Stts{EntryCount: 1, Entries: []SttsEntry{{SampleCount: 4, SampleDelta: 1024}}}},
+						{box: &Stsc{EntryCount: 1, Entries: []StscEntry{{FirstChunk: 1, SamplesPerChunk: 4, SampleDescriptionIndex: 1}}}},
+						{box: &Stsz{SampleCount: 4, EntrySize: sampleSizes}},
+						{box: &Stco{EntryCount: 1, ChunkOffset: []uint32{dataOffset}}},
+						{box: &Saiz{SampleCount: 4, SampleInfoSize: auxSizes}},
+						{box: &Saio{EntryCount: 1, OffsetV0: []uint32{dataOffset + uint32(len(sampleData))}}},
+					}},
+				}},
+			}},
+		}},
+	}}))
+
+	f, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, Fragment(src, f, &FragmentOptions{FragmentDuration: 40 * time.Millisecond}))
+
+	// each traf has the auxiliary information of its samples in senc box
+	moofs, err := ExtractBox(f, nil, BoxPath{BoxTypeMoof()})
+	require.NoError(t, err)
+	require.Len(t, moofs, 2)
+	for k, moof := range moofs {
+		bs, err := ExtractBoxesWithPayload(f, moof, []BoxPath{
+			{BoxTypeTraf(), BoxTypeSaiz()},
+			{BoxTypeTraf(), BoxTypeSaio()},
+			{BoxTypeTraf(), BoxTypeSenc()},
+		})
+		require.NoError(t, err)
+		require.Len(t, bs, 3)
+		expected := auxData[38*k : 38*(k+1)]
+		saiz := bs[0].Payload.(*Saiz)
+		assert.Equal(t, uint32(2), saiz.SampleCount)
+		assert.Equal(t, auxSizes[2*k:2*(k+1)], saiz.SampleInfoSize)
+		senc := bs[2].Payload.(*Senc)
+		assert.Equal(t, uint32(SencUseSubsampleEncryption), senc.GetFlags())
+		assert.Equal(t, uint32(2), senc.SampleCount)
+		assert.Equal(t, expected, senc.SampleData)
+		saio := bs[1].Payload.(*Saio)
+		require.Equal(t, uint32(1), saio.EntryCount)
+		data := make([]byte, len(expected))
+		_, err = f.Seek(int64(moof.Offset)+int64(saio.OffsetV0[0]), io.SeekStart)
+		require.NoError(t, err)
+		_, err = io.ReadFull(f, data)
+		require.NoError(t, err)
+		assert.Equal(t, expected, data)
+	}
+
+	// the auxiliary information is restored by Defragment
+	d, err := memfs.New().Create("defragmented.mp4")
+	require.NoError(t, err)
+	defer d.Close()
+	require.NoError(t, Defragment(f, d))
+	assertSamples(t, src, d, 1)
+	bs, err := ExtractBoxWithPayload(d, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSaio()})
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	data := make([]byte, len(auxData))
+	_, err = d.Seek(int64(bs[0].Payload.(*Saio).OffsetV0[0]), io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(d, data)
+	require.NoError(t, err)
+	assert.Equal(t, auxData, data)
+}
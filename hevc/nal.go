@@ -0,0 +1,68 @@
+package hevc
+
+import (
+	"errors"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// NAL unit types defined at ISO/IEC 23008-2 Table 7-1
+const (
+	NALUnitTypeBLAWLP       = 16
+	NALUnitTypeBLAWRADL     = 17
+	NALUnitTypeBLANLP       = 18
+	NALUnitTypeIDRWRADL     = 19
+	NALUnitTypeIDRNLP       = 20
+	NALUnitTypeCRA          = 21
+	NALUnitTypeVPS          = 32
+	NALUnitTypeSPS          = 33
+	NALUnitTypePPS          = 34
+	NALUnitTypeAUD          = 35
+	NALUnitTypeEOS          = 36
+	NALUnitTypeEOB          = 37
+	NALUnitTypeFD           = 38
+	NALUnitTypePrefixSEI    = 39
+	NALUnitTypeSuffixSEI    = 40
+	NALUnitHeaderSize       = 2
+	nalUnitTypeReservedIRAP = 23
+)
+
+var (
+	ErrInvalidNALUnitType = errors.New("invalid nal unit type")
+	ErrShortNALUnit       = errors.New("nal unit is too short")
+)
+
+// NALUnitHeader is nal_unit_header defined at ISO/IEC 23008-2 7.3.1.2
+type NALUnitHeader struct {
+	Type              uint8
+	LayerID           uint8
+	TemporalIDPlusOne uint8
+}
+
+// ParseNALUnitHeader parses the first 2 bytes of the NAL unit.
+func ParseNALUnitHeader(nalu []byte) (NALUnitHeader, error) {
+	if len(nalu) < NALUnitHeaderSize {
+		return NALUnitHeader{}, ErrShortNALUnit
+	}
+	return NALUnitHeader{
+		Type:              (nalu[0] >> 1) & 0x3f,
+		LayerID:           (nalu[0]&0x01)<<5 | nalu[1]>>3,
+		TemporalIDPlusOne: nalu[1] & 0x07,
+	}, nil
+}
+
+// IsIRAP returns whether the NAL unit type is an intra random access point picture.
+func IsIRAP(nalUnitType uint8) bool {
+	return nalUnitType >= NALUnitTypeBLAWLP && nalUnitType <= nalUnitTypeReservedIRAP
+}
+
+func rbspOf(nalu []byte, nalUnitType uint8) ([]byte, error) {
+	h, err := ParseNALUnitHeader(nalu)
+	if err != nil {
+		return nil, err
+	}
+	if h.Type != nalUnitType {
+		return nil, ErrInvalidNALUnitType
+	}
+	return bitio.RemoveEmulationPreventionBytes(nalu[NALUnitHeaderSize:]), nil
+}
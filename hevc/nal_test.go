@@ -0,0 +1,41 @@
+package hevc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNALUnitHeader(t *testing.T) {
+	testCases := []struct {
+		name     string
+		nalu     []byte
+		expected NALUnitHeader
+	}{
+		{name: "VPS", nalu: []byte{0x40, 0x01}, expected: NALUnitHeader{Type: NALUnitTypeVPS, TemporalIDPlusOne: 1}},
+		{name: "IDR_W_RADL", nalu: []byte{0x26, 0x01, 0xaf}, expected: NALUnitHeader{Type: NALUnitTypeIDRWRADL, TemporalIDPlusOne: 1}},
+		{name: "layer", nalu: []byte{0x03, 0xfa}, expected: NALUnitHeader{Type: 1, LayerID: 0x3f, TemporalIDPlusOne: 2}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := ParseNALUnitHeader(tc.nalu)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, h)
+		})
+	}
+
+	_, err := ParseNALUnitHeader([]byte{0x40})
+	assert.Equal(t, ErrShortNALUnit, err)
+}
+
+func TestIsIRAP(t *testing.T) {
+	assert.False(t, IsIRAP(1))
+	assert.False(t, IsIRAP(15))
+	assert.True(t, IsIRAP(NALUnitTypeBLAWLP))
+	assert.True(t, IsIRAP(NALUnitTypeIDRNLP))
+	assert.True(t, IsIRAP(NALUnitTypeCRA))
+	assert.True(t, IsIRAP(23))
+	assert.False(t, IsIRAP(24))
+	assert.False(t, IsIRAP(NALUnitTypeVPS))
+}
@@ -0,0 +1,42 @@
+package hevc
+
+import (
+	"fmt"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// parser reads the syntax elements of the parameter sets.
+type parser struct {
+	*bitio.ExpGolombReader
+}
+
+// skipScalingListData reads scaling_list_data() syntax and discards the values.
+func (p *parser) skipScalingListData() {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if !p.Flag() { // scaling_list_pred_mode_flag
+				p.UE() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefNum := 1 << (4 + (uint(sizeID) << 1))
+			if coefNum > 64 {
+				coefNum = 64
+			}
+			if sizeID > 1 {
+				p.SE() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < coefNum && p.Err() == nil; i++ {
+				p.SE() // scaling_list_delta_coef
+			}
+		}
+	}
+}
+
+func errOutOfRange(name string, val uint32) error {
+	return fmt.Errorf("%s is out of range: %d", name, val)
+}
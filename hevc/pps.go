@@ -0,0 +1,109 @@
+package hevc
+
+import (
+	"bytes"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// PPS is H.265 picture parameter set defined at ISO/IEC 23008-2 7.3.2.3
+// Syntax elements following tile information are not parsed.
+type PPS struct {
+	PPSPicParameterSetID               uint32
+	PPSSeqParameterSetID               uint32
+	DependentSliceSegmentsEnabledFlag  bool
+	OutputFlagPresentFlag              bool
+	NumExtraSliceHeaderBits            uint8
+	SignDataHidingEnabledFlag          bool
+	CabacInitPresentFlag               bool
+	NumRefIdxL0DefaultActiveMinus1     uint32
+	NumRefIdxL1DefaultActiveMinus1     uint32
+	InitQpMinus26                      int32
+	ConstrainedIntraPredFlag           bool
+	TransformSkipEnabledFlag           bool
+	CuQpDeltaEnabledFlag               bool
+	DiffCuQpDeltaDepth                 uint32
+	PPSCbQpOffset                      int32
+	PPSCrQpOffset                      int32
+	PPSSliceChromaQpOffsetsPresentFlag bool
+	WeightedPredFlag                   bool
+	WeightedBipredFlag                 bool
+	TransquantBypassEnabledFlag        bool
+	TilesEnabledFlag                   bool
+	EntropyCodingSyncEnabledFlag       bool
+	NumTileColumnsMinus1               uint32
+	NumTileRowsMinus1                  uint32
+	UniformSpacingFlag                 bool
+	ColumnWidthMinus1                  []uint32
+	RowHeightMinus1                    []uint32
+	LoopFilterAcrossTilesEnabledFlag   bool
+}
+
+// ParsePPS parses a PPS NAL unit which begins with the NAL unit header.
+// Emulation prevention bytes are removed by this function.
+func ParsePPS(nalu []byte) (*PPS, error) {
+	rbsp, err := rbspOf(nalu, NALUnitTypePPS)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePPSRBSP(rbsp)
+}
+
+// ParsePPSRBSP parses pic_parameter_set_rbsp which contains neither
+// the NAL unit header nor emulation prevention bytes.
+func ParsePPSRBSP(rbsp []byte) (*PPS, error) {
+	p := &parser{bitio.NewExpGolombReader(bitio.NewReader(bytes.NewReader(rbsp)))}
+	pps := new(PPS)
+
+	pps.PPSPicParameterSetID = p.UE()
+	pps.PPSSeqParameterSetID = p.UE()
+	pps.DependentSliceSegmentsEnabledFlag = p.Flag()
+	pps.OutputFlagPresentFlag = p.Flag()
+	pps.NumExtraSliceHeaderBits = uint8(p.U(3))
+	pps.SignDataHidingEnabledFlag = p.Flag()
+	pps.CabacInitPresentFlag = p.Flag()
+	pps.NumRefIdxL0DefaultActiveMinus1 = p.UE()
+	pps.NumRefIdxL1DefaultActiveMinus1 = p.UE()
+	pps.InitQpMinus26 = p.SE()
+	pps.ConstrainedIntraPredFlag = p.Flag()
+	pps.TransformSkipEnabledFlag = p.Flag()
+	pps.CuQpDeltaEnabledFlag = p.Flag()
+	if pps.CuQpDeltaEnabledFlag {
+		pps.DiffCuQpDeltaDepth = p.UE()
+	}
+	pps.PPSCbQpOffset = p.SE()
+	pps.PPSCrQpOffset = p.SE()
+	pps.PPSSliceChromaQpOffsetsPresentFlag = p.Flag()
+	pps.WeightedPredFlag = p.Flag()
+	pps.WeightedBipredFlag = p.Flag()
+	pps.TransquantBypassEnabledFlag = p.Flag()
+	pps.TilesEnabledFlag = p.Flag()
+	pps.EntropyCodingSyncEnabledFlag = p.Flag()
+	if pps.TilesEnabledFlag {
+		pps.NumTileColumnsMinus1 = p.UE()
+		pps.NumTileRowsMinus1 = p.UE()
+		if p.Err() != nil {
+			return nil, p.Err()
+		}
+		if pps.NumTileColumnsMinus1 > 19 {
+			return nil, errOutOfRange("num_tile_columns_minus1", pps.NumTileColumnsMinus1)
+		}
+		if pps.NumTileRowsMinus1 > 21 {
+			return nil, errOutOfRange("num_tile_rows_minus1", pps.NumTileRowsMinus1)
+		}
+		pps.UniformSpacingFlag = p.Flag()
+		if !pps.UniformSpacingFlag {
+			for i := uint32(0); i < pps.NumTileColumnsMinus1; i++ {
+				pps.ColumnWidthMinus1 = append(pps.ColumnWidthMinus1, p.UE())
+			}
+			for i := uint32(0); i < pps.NumTileRowsMinus1; i++ {
+				pps.RowHeightMinus1 = append(pps.RowHeightMinus1, p.UE())
+			}
+		}
+		pps.LoopFilterAcrossTilesEnabledFlag = p.Flag()
+	}
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	return pps, nil
+}
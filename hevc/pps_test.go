@@ -0,0 +1,65 @@
+package hevc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abema/go-mp4/bitio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePPS(t *testing.T) {
+	pps, err := ParsePPS([]byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40})
+	require.NoError(t, err)
+	assert.Equal(t, &PPS{
+		SignDataHidingEnabledFlag:    true,
+		CuQpDeltaEnabledFlag:         true,
+		DiffCuQpDeltaDepth:           1,
+		WeightedPredFlag:             true,
+		EntropyCodingSyncEnabledFlag: true,
+	}, pps)
+
+	_, err = ParsePPS([]byte{0x42, 0x01, 0xc1})
+	assert.Equal(t, ErrInvalidNALUnitType, err)
+}
+
+func TestParsePPSTiles(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := &spsWriter{t: t, w: bitio.NewWriter(buf)}
+	w.ue(1)   // pps_pic_parameter_set_id
+	w.ue(0)   // pps_seq_parameter_set_id
+	w.u(0, 7) // dependent_slice_segments_enabled_flag ... cabac_init_present_flag
+	w.ue(0)   // num_ref_idx_l0_default_active_minus1
+	w.ue(0)   // num_ref_idx_l1_default_active_minus1
+	w.se(-4)  // init_qp_minus26
+	w.u(0, 3) // constrained_intra_pred_flag ... cu_qp_delta_enabled_flag
+	w.se(-1)  // pps_cb_qp_offset
+	w.se(2)   // pps_cr_qp_offset
+	w.u(0, 4) // pps_slice_chroma_qp_offsets_present_flag ... transquant_bypass_enabled_flag
+	w.u(1, 1) // tiles_enabled_flag
+	w.u(0, 1) // entropy_coding_sync_enabled_flag
+	w.ue(2)   // num_tile_columns_minus1
+	w.ue(1)   // num_tile_rows_minus1
+	w.u(0, 1) // uniform_spacing_flag
+	w.ue(4)   // column_width_minus1[0]
+	w.ue(5)   // column_width_minus1[1]
+	w.ue(3)   // row_height_minus1[0]
+	w.u(1, 1) // loop_filter_across_tiles_enabled_flag
+	w.u(1, 1) // rbsp_stop_one_bit
+	w.u(0, 7)
+	pps, err := ParsePPSRBSP(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, &PPS{
+		PPSPicParameterSetID:             1,
+		InitQpMinus26:                    -4,
+		PPSCbQpOffset:                    -1,
+		PPSCrQpOffset:                    2,
+		TilesEnabledFlag:                 true,
+		NumTileColumnsMinus1:             2,
+		NumTileRowsMinus1:                1,
+		ColumnWidthMinus1:                []uint32{4, 5},
+		RowHeightMinus1:                  []uint32{3},
+		LoopFilterAcrossTilesEnabledFlag: true,
+	}, pps)
+}
@@ -0,0 +1,113 @@
+package hevc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// general_profile_idc values defined at ISO/IEC 23008-2 Annex A
+const (
+	ProfileMain                = 1
+	ProfileMain10              = 2
+	ProfileMainStillPicture    = 3
+	ProfileRangeExtensions     = 4
+	ProfileHighThroughput      = 5
+	ProfileScreenContentCoding = 9
+)
+
+// ProfileTierLevel is profile_tier_level() defined at ISO/IEC 23008-2 7.3.3
+// Sub-layer information is not kept.
+type ProfileTierLevel struct {
+	GeneralProfileSpace              uint8
+	GeneralTierFlag                  bool
+	GeneralProfileIdc                uint8
+	GeneralProfileCompatibilityFlags uint32
+	// GeneralConstraintIndicatorFlags holds 48 bits from general_progressive_source_flag
+	// in the same layout as HEVCDecoderConfigurationRecord.
+	GeneralConstraintIndicatorFlags uint64
+	GeneralLevelIdc                 uint8
+	SubLayerProfilePresentFlag      []bool
+	SubLayerLevelPresentFlag        []bool
+}
+
+func (p *parser) profileTierLevel(profilePresentFlag bool, maxNumSubLayersMinus1 uint8) *ProfileTierLevel {
+	ptl := new(ProfileTierLevel)
+	if profilePresentFlag {
+		ptl.GeneralProfileSpace = uint8(p.U(2))
+		ptl.GeneralTierFlag = p.Flag()
+		ptl.GeneralProfileIdc = uint8(p.U(5))
+		ptl.GeneralProfileCompatibilityFlags = uint32(p.U(32))
+		ptl.GeneralConstraintIndicatorFlags = p.U(48)
+	}
+	ptl.GeneralLevelIdc = uint8(p.U(8))
+	for i := uint8(0); i < maxNumSubLayersMinus1; i++ {
+		ptl.SubLayerProfilePresentFlag = append(ptl.SubLayerProfilePresentFlag, p.Flag())
+		ptl.SubLayerLevelPresentFlag = append(ptl.SubLayerLevelPresentFlag, p.Flag())
+	}
+	if maxNumSubLayersMinus1 > 0 {
+		for i := maxNumSubLayersMinus1; i < 8; i++ {
+			p.U(2) // reserved_zero_2bits
+		}
+	}
+	for i := uint8(0); i < maxNumSubLayersMinus1; i++ {
+		if ptl.SubLayerProfilePresentFlag[i] {
+			p.U(32) // sub_layer_profile_space ... sub_layer_profile_compatibility_flag[0..23]
+			p.U(56) // sub_layer_profile_compatibility_flag[24..31] ... sub_layer_inbld_flag
+		}
+		if ptl.SubLayerLevelPresentFlag[i] {
+			p.U(8) // sub_layer_level_idc
+		}
+	}
+	return ptl
+}
+
+// IsCompatibleWith returns whether general_profile_compatibility_flag[profileIdc] is set.
+func (ptl *ProfileTierLevel) IsCompatibleWith(profileIdc uint8) bool {
+	if profileIdc >= 32 {
+		return false
+	}
+	return ptl.GeneralProfileCompatibilityFlags&(1<<(31-profileIdc)) != 0
+}
+
+// Codecs returns the codecs parameter defined at ISO/IEC 14496-15 Annex E.3,
+// such as "hvc1.2.4.L153.B0".
+// sampleEntryType is the four character code of the sample entry, e.g. "hvc1" or "hev1".
+func (ptl *ProfileTierLevel) Codecs(sampleEntryType string) string {
+	var sb strings.Builder
+	sb.WriteString(sampleEntryType)
+	sb.WriteString(".")
+	if ptl.GeneralProfileSpace > 0 && ptl.GeneralProfileSpace <= 3 {
+		sb.WriteByte('A' + ptl.GeneralProfileSpace - 1)
+	}
+	fmt.Fprintf(&sb, "%d", ptl.GeneralProfileIdc)
+
+	// general_profile_compatibility_flags in reverse bit order
+	var compat uint32
+	for i := uint(0); i < 32; i++ {
+		if ptl.GeneralProfileCompatibilityFlags&(1<<i) != 0 {
+			compat |= 1 << (31 - i)
+		}
+	}
+	fmt.Fprintf(&sb, ".%X", compat)
+
+	if ptl.GeneralTierFlag {
+		sb.WriteString(".H")
+	} else {
+		sb.WriteString(".L")
+	}
+	fmt.Fprintf(&sb, "%d", ptl.GeneralLevelIdc)
+
+	// constraint bytes with trailing zero bytes omitted
+	constraints := make([]byte, 6)
+	for i := range constraints {
+		constraints[i] = byte(ptl.GeneralConstraintIndicatorFlags >> (40 - 8*uint(i)))
+	}
+	n := len(constraints)
+	for n > 0 && constraints[n-1] == 0 {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, ".%X", constraints[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,77 @@
+package hevc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileTierLevelCodecs(t *testing.T) {
+	testCases := []struct {
+		name            string
+		sampleEntryType string
+		ptl             ProfileTierLevel
+		expected        string
+	}{
+		{
+			name:            "main",
+			sampleEntryType: "hvc1",
+			ptl: ProfileTierLevel{
+				GeneralProfileIdc:                ProfileMain,
+				GeneralProfileCompatibilityFlags: 0x60000000,
+				GeneralConstraintIndicatorFlags:  0x900000000000,
+				GeneralLevelIdc:                  93,
+			},
+			expected: "hvc1.1.6.L93.90",
+		},
+		{
+			name:            "main10 high tier",
+			sampleEntryType: "hev1",
+			ptl: ProfileTierLevel{
+				GeneralTierFlag:                  true,
+				GeneralProfileIdc:                ProfileMain10,
+				GeneralProfileCompatibilityFlags: 0x20000000,
+				GeneralConstraintIndicatorFlags:  0xb00000000000,
+				GeneralLevelIdc:                  153,
+			},
+			expected: "hev1.2.4.H153.B0",
+		},
+		{
+			name:            "profile space and no constraints",
+			sampleEntryType: "hvc1",
+			ptl: ProfileTierLevel{
+				GeneralProfileSpace:              1,
+				GeneralProfileIdc:                ProfileRangeExtensions,
+				GeneralProfileCompatibilityFlags: 0x08000000,
+				GeneralConstraintIndicatorFlags:  0x000000000000,
+				GeneralLevelIdc:                  120,
+			},
+			expected: "hvc1.A4.10.L120",
+		},
+		{
+			name:            "trailing zeros",
+			sampleEntryType: "hvc1",
+			ptl: ProfileTierLevel{
+				GeneralProfileIdc:                ProfileMain,
+				GeneralProfileCompatibilityFlags: 0x40000000,
+				GeneralConstraintIndicatorFlags:  0x900001000000,
+				GeneralLevelIdc:                  120,
+			},
+			expected: "hvc1.1.2.L120.90.0.1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.ptl.Codecs(tc.sampleEntryType))
+		})
+	}
+}
+
+func TestProfileTierLevelIsCompatibleWith(t *testing.T) {
+	ptl := &ProfileTierLevel{GeneralProfileCompatibilityFlags: 0x60000000}
+	assert.False(t, ptl.IsCompatibleWith(0))
+	assert.True(t, ptl.IsCompatibleWith(ProfileMain))
+	assert.True(t, ptl.IsCompatibleWith(ProfileMain10))
+	assert.False(t, ptl.IsCompatibleWith(ProfileMainStillPicture))
+	assert.False(t, ptl.IsCompatibleWith(32))
+}
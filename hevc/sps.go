@@ -0,0 +1,581 @@
+package hevc
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// ExtendedSAR is aspect_ratio_idc which means sar_width and sar_height are explicitly coded.
+const ExtendedSAR = 255
+
+// colour description values defined at ISO/IEC 23091-2 (ITU-T H.273)
+const (
+	ColourPrimariesBT709         = 1
+	ColourPrimariesBT2020        = 9
+	TransferCharacteristicsBT709 = 1
+	TransferCharacteristicsPQ    = 16 // SMPTE ST 2084
+	TransferCharacteristicsHLG   = 18 // ARIB STD-B67
+	MatrixCoefficientsBT709      = 1
+	MatrixCoefficientsBT2020NCL  = 9
+	MatrixCoefficientsBT2020CL   = 10
+	ColourDescriptionUnspecified = 2
+	maxNumShortTermRefPicSets    = 64
+	maxNumLongTermRefPicsSPS     = 32
+	maxNumDeltaPocs              = 32
+)
+
+// SPS is H.265 sequence parameter set defined at ISO/IEC 23008-2 7.3.2.2
+// Syntax elements following vui_parameters are not parsed.
+type SPS struct {
+	SPSVideoParameterSetID               uint8
+	SPSMaxSubLayersMinus1                uint8
+	SPSTemporalIDNestingFlag             bool
+	ProfileTierLevel                     *ProfileTierLevel
+	SPSSeqParameterSetID                 uint32
+	ChromaFormatIdc                      uint32
+	SeparateColourPlaneFlag              bool
+	PicWidthInLumaSamples                uint32
+	PicHeightInLumaSamples               uint32
+	ConformanceWindowFlag                bool
+	ConfWinLeftOffset                    uint32
+	ConfWinRightOffset                   uint32
+	ConfWinTopOffset                     uint32
+	ConfWinBottomOffset                  uint32
+	BitDepthLumaMinus8                   uint32
+	BitDepthChromaMinus8                 uint32
+	Log2MaxPicOrderCntLsbMinus4          uint32
+	SPSSubLayerOrderingInfoPresentFlag   bool
+	SPSMaxDecPicBufferingMinus1          []uint32
+	SPSMaxNumReorderPics                 []uint32
+	SPSMaxLatencyIncreasePlus1           []uint32
+	Log2MinLumaCodingBlockSizeMinus3     uint32
+	Log2DiffMaxMinLumaCodingBlockSize    uint32
+	Log2MinLumaTransformBlockSizeMinus2  uint32
+	Log2DiffMaxMinLumaTransformBlockSize uint32
+	MaxTransformHierarchyDepthInter      uint32
+	MaxTransformHierarchyDepthIntra      uint32
+	ScalingListEnabledFlag               bool
+	SPSScalingListDataPresentFlag        bool
+	AmpEnabledFlag                       bool
+	SampleAdaptiveOffsetEnabledFlag      bool
+	PCMEnabledFlag                       bool
+	NumShortTermRefPicSets               uint32
+	LongTermRefPicsPresentFlag           bool
+	NumLongTermRefPicsSPS                uint32
+	SPSTemporalMVPEnabledFlag            bool
+	StrongIntraSmoothingEnabledFlag      bool
+	VUIParametersPresentFlag             bool
+	VUI                                  *VUIParameters
+}
+
+// VUIParameters is video usability information defined at ISO/IEC 23008-2 E.2.1
+type VUIParameters struct {
+	AspectRatioInfoPresentFlag         bool
+	AspectRatioIdc                     uint8
+	SarWidth                           uint16
+	SarHeight                          uint16
+	OverscanInfoPresentFlag            bool
+	OverscanAppropriateFlag            bool
+	VideoSignalTypePresentFlag         bool
+	VideoFormat                        uint8
+	VideoFullRangeFlag                 bool
+	ColourDescriptionPresentFlag       bool
+	ColourPrimaries                    uint8
+	TransferCharacteristics            uint8
+	MatrixCoeffs                       uint8
+	ChromaLocInfoPresentFlag           bool
+	ChromaSampleLocTypeTopField        uint32
+	ChromaSampleLocTypeBottomField     uint32
+	NeutralChromaIndicationFlag        bool
+	FieldSeqFlag                       bool
+	FrameFieldInfoPresentFlag          bool
+	DefaultDisplayWindowFlag           bool
+	DefDispWinLeftOffset               uint32
+	DefDispWinRightOffset              uint32
+	DefDispWinTopOffset                uint32
+	DefDispWinBottomOffset             uint32
+	VUITimingInfoPresentFlag           bool
+	VUINumUnitsInTick                  uint32
+	VUITimeScale                       uint32
+	VUIPocProportionalToTimingFlag     bool
+	VUINumTicksPocDiffOneMinus1        uint32
+	VUIHRDParametersPresentFlag        bool
+	BitstreamRestrictionFlag           bool
+	TilesFixedStructureFlag            bool
+	MotionVectorsOverPicBoundariesFlag bool
+	RestrictedRefPicListsFlag          bool
+	MinSpatialSegmentationIdc          uint32
+	MaxBytesPerPicDenom                uint32
+	MaxBitsPerMinCuDenom               uint32
+	Log2MaxMvLengthHorizontal          uint32
+	Log2MaxMvLengthVertical            uint32
+}
+
+// sample aspect ratios indicated by aspect_ratio_idc (Table E-1)
+var sarTable = [...][2]uint16{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11},
+	{32, 11}, {80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// ParseSPS parses a SPS NAL unit which begins with the NAL unit header.
+// Emulation prevention bytes are removed by this function.
+func ParseSPS(nalu []byte) (*SPS, error) {
+	rbsp, err := rbspOf(nalu, NALUnitTypeSPS)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSPSRBSP(rbsp)
+}
+
+// ParseSPSRBSP parses seq_parameter_set_rbsp which contains neither
+// the NAL unit header nor emulation prevention bytes.
+func ParseSPSRBSP(rbsp []byte) (*SPS, error) {
+	p := &parser{bitio.NewExpGolombReader(bitio.NewReader(bytes.NewReader(rbsp)))}
+	sps := new(SPS)
+
+	sps.SPSVideoParameterSetID = uint8(p.U(4))
+	sps.SPSMaxSubLayersMinus1 = uint8(p.U(3))
+	sps.SPSTemporalIDNestingFlag = p.Flag()
+	sps.ProfileTierLevel = p.profileTierLevel(true, sps.SPSMaxSubLayersMinus1)
+	sps.SPSSeqParameterSetID = p.UE()
+	sps.ChromaFormatIdc = p.UE()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	if sps.ChromaFormatIdc > 3 {
+		return nil, errOutOfRange("chroma_format_idc", sps.ChromaFormatIdc)
+	}
+	if sps.ChromaFormatIdc == 3 {
+		sps.SeparateColourPlaneFlag = p.Flag()
+	}
+	sps.PicWidthInLumaSamples = p.UE()
+	sps.PicHeightInLumaSamples = p.UE()
+	sps.ConformanceWindowFlag = p.Flag()
+	if sps.ConformanceWindowFlag {
+		sps.ConfWinLeftOffset = p.UE()
+		sps.ConfWinRightOffset = p.UE()
+		sps.ConfWinTopOffset = p.UE()
+		sps.ConfWinBottomOffset = p.UE()
+	}
+	sps.BitDepthLumaMinus8 = p.UE()
+	sps.BitDepthChromaMinus8 = p.UE()
+	sps.Log2MaxPicOrderCntLsbMinus4 = p.UE()
+	sps.SPSSubLayerOrderingInfoPresentFlag = p.Flag()
+	sps.SPSMaxDecPicBufferingMinus1 = make([]uint32, sps.SPSMaxSubLayersMinus1+1)
+	sps.SPSMaxNumReorderPics = make([]uint32, sps.SPSMaxSubLayersMinus1+1)
+	sps.SPSMaxLatencyIncreasePlus1 = make([]uint32, sps.SPSMaxSubLayersMinus1+1)
+	first := firstSubLayer(sps.SPSSubLayerOrderingInfoPresentFlag, sps.SPSMaxSubLayersMinus1)
+	for i := first; i <= sps.SPSMaxSubLayersMinus1; i++ {
+		sps.SPSMaxDecPicBufferingMinus1[i] = p.UE()
+		sps.SPSMaxNumReorderPics[i] = p.UE()
+		sps.SPSMaxLatencyIncreasePlus1[i] = p.UE()
+	}
+	for i := uint8(0); i < first; i++ {
+		sps.SPSMaxDecPicBufferingMinus1[i] = sps.SPSMaxDecPicBufferingMinus1[sps.SPSMaxSubLayersMinus1]
+		sps.SPSMaxNumReorderPics[i] = sps.SPSMaxNumReorderPics[sps.SPSMaxSubLayersMinus1]
+		sps.SPSMaxLatencyIncreasePlus1[i] = sps.SPSMaxLatencyIncreasePlus1[sps.SPSMaxSubLayersMinus1]
+	}
+	sps.Log2MinLumaCodingBlockSizeMinus3 = p.UE()
+	sps.Log2DiffMaxMinLumaCodingBlockSize = p.UE()
+	sps.Log2MinLumaTransformBlockSizeMinus2 = p.UE()
+	sps.Log2DiffMaxMinLumaTransformBlockSize = p.UE()
+	sps.MaxTransformHierarchyDepthInter = p.UE()
+	sps.MaxTransformHierarchyDepthIntra = p.UE()
+	sps.ScalingListEnabledFlag = p.Flag()
+	if sps.ScalingListEnabledFlag {
+		sps.SPSScalingListDataPresentFlag = p.Flag()
+		if sps.SPSScalingListDataPresentFlag {
+			p.skipScalingListData()
+		}
+	}
+	sps.AmpEnabledFlag = p.Flag()
+	sps.SampleAdaptiveOffsetEnabledFlag = p.Flag()
+	sps.PCMEnabledFlag = p.Flag()
+	if sps.PCMEnabledFlag {
+		p.U(4)   // pcm_sample_bit_depth_luma_minus1
+		p.U(4)   // pcm_sample_bit_depth_chroma_minus1
+		p.UE()   // log2_min_pcm_luma_coding_block_size_minus3
+		p.UE()   // log2_diff_max_min_pcm_luma_coding_block_size
+		p.Flag() // pcm_loop_filter_disabled_flag
+	}
+	sps.NumShortTermRefPicSets = p.UE()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	if sps.NumShortTermRefPicSets > maxNumShortTermRefPicSets {
+		return nil, errOutOfRange("num_short_term_ref_pic_sets", sps.NumShortTermRefPicSets)
+	}
+	rpss := make([]stRefPicSet, 0, sps.NumShortTermRefPicSets)
+	for i := uint32(0); i < sps.NumShortTermRefPicSets && p.Err() == nil; i++ {
+		rpss = append(rpss, p.stRefPicSet(rpss))
+	}
+	sps.LongTermRefPicsPresentFlag = p.Flag()
+	if sps.LongTermRefPicsPresentFlag {
+		sps.NumLongTermRefPicsSPS = p.UE()
+		if p.Err() == nil && sps.NumLongTermRefPicsSPS > maxNumLongTermRefPicsSPS {
+			return nil, errOutOfRange("num_long_term_ref_pics_sps", sps.NumLongTermRefPicsSPS)
+		}
+		for i := uint32(0); i < sps.NumLongTermRefPicsSPS && p.Err() == nil; i++ {
+			p.U(uint(sps.Log2MaxPicOrderCntLsbMinus4) + 4) // lt_ref_pic_poc_lsb_sps
+			p.Flag()                                       // used_by_curr_pic_lt_sps_flag
+		}
+	}
+	sps.SPSTemporalMVPEnabledFlag = p.Flag()
+	sps.StrongIntraSmoothingEnabledFlag = p.Flag()
+	sps.VUIParametersPresentFlag = p.Flag()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	if sps.VUIParametersPresentFlag {
+		sps.VUI = p.vuiParameters(sps.SPSMaxSubLayersMinus1)
+		if p.Err() != nil {
+			return nil, p.Err()
+		}
+	}
+	return sps, nil
+}
+
+// stRefPicSet holds the variables derived from st_ref_pic_set(),
+// which are referred by following inter-predicted sets.
+type stRefPicSet struct {
+	deltaPocS0 []int32
+	deltaPocS1 []int32
+}
+
+func (p *parser) stRefPicSet(prev []stRefPicSet) stRefPicSet {
+	var rps stRefPicSet
+	idx := len(prev)
+	interRefPicSetPredictionFlag := false
+	if idx != 0 {
+		interRefPicSetPredictionFlag = p.Flag()
+	}
+
+	if interRefPicSetPredictionFlag {
+		// delta_idx_minus1 is present only in slice headers
+		ref := prev[idx-1]
+		deltaRpsSign := p.Flag()
+		absDeltaRpsMinus1 := p.UE()
+		deltaRps := int32(absDeltaRpsMinus1) + 1
+		if deltaRpsSign {
+			deltaRps = -deltaRps
+		}
+		numDeltaPocs := len(ref.deltaPocS0) + len(ref.deltaPocS1)
+		useDelta := make([]bool, numDeltaPocs+1)
+		for j := 0; j <= numDeltaPocs; j++ {
+			usedByCurrPicFlag := p.Flag()
+			useDelta[j] = true
+			if !usedByCurrPicFlag {
+				useDelta[j] = p.Flag()
+			}
+		}
+		if p.Err() != nil {
+			return rps
+		}
+
+		// (7-61)
+		numNeg := len(ref.deltaPocS0)
+		for j := len(ref.deltaPocS1) - 1; j >= 0; j-- {
+			dPoc := ref.deltaPocS1[j] + deltaRps
+			if dPoc < 0 && useDelta[numNeg+j] {
+				rps.deltaPocS0 = append(rps.deltaPocS0, dPoc)
+			}
+		}
+		if deltaRps < 0 && useDelta[numDeltaPocs] {
+			rps.deltaPocS0 = append(rps.deltaPocS0, deltaRps)
+		}
+		for j := 0; j < numNeg; j++ {
+			dPoc := ref.deltaPocS0[j] + deltaRps
+			if dPoc < 0 && useDelta[j] {
+				rps.deltaPocS0 = append(rps.deltaPocS0, dPoc)
+			}
+		}
+
+		// (7-62)
+		for j := numNeg - 1; j >= 0; j-- {
+			dPoc := ref.deltaPocS0[j] + deltaRps
+			if dPoc > 0 && useDelta[j] {
+				rps.deltaPocS1 = append(rps.deltaPocS1, dPoc)
+			}
+		}
+		if deltaRps > 0 && useDelta[numDeltaPocs] {
+			rps.deltaPocS1 = append(rps.deltaPocS1, deltaRps)
+		}
+		for j := 0; j < len(ref.deltaPocS1); j++ {
+			dPoc := ref.deltaPocS1[j] + deltaRps
+			if dPoc > 0 && useDelta[numNeg+j] {
+				rps.deltaPocS1 = append(rps.deltaPocS1, dPoc)
+			}
+		}
+		return rps
+	}
+
+	numNegativePics := p.UE()
+	numPositivePics := p.UE()
+	if p.Err() != nil {
+		return rps
+	}
+	if numNegativePics+numPositivePics > maxNumDeltaPocs {
+		p.SetErr(errOutOfRange("num_negative_pics + num_positive_pics", numNegativePics+numPositivePics))
+		return rps
+	}
+	var poc int32
+	for i := uint32(0); i < numNegativePics; i++ {
+		poc -= int32(p.UE()) + 1 // delta_poc_s0_minus1
+		p.Flag()                 // used_by_curr_pic_s0_flag
+		rps.deltaPocS0 = append(rps.deltaPocS0, poc)
+	}
+	poc = 0
+	for i := uint32(0); i < numPositivePics; i++ {
+		poc += int32(p.UE()) + 1 // delta_poc_s1_minus1
+		p.Flag()                 // used_by_curr_pic_s1_flag
+		rps.deltaPocS1 = append(rps.deltaPocS1, poc)
+	}
+	return rps
+}
+
+func (p *parser) vuiParameters(maxSubLayersMinus1 uint8) *VUIParameters {
+	vui := new(VUIParameters)
+	vui.AspectRatioInfoPresentFlag = p.Flag()
+	if vui.AspectRatioInfoPresentFlag {
+		vui.AspectRatioIdc = uint8(p.U(8))
+		if vui.AspectRatioIdc == ExtendedSAR {
+			vui.SarWidth = uint16(p.U(16))
+			vui.SarHeight = uint16(p.U(16))
+		} else if int(vui.AspectRatioIdc) < len(sarTable) {
+			vui.SarWidth = sarTable[vui.AspectRatioIdc][0]
+			vui.SarHeight = sarTable[vui.AspectRatioIdc][1]
+		}
+	}
+	vui.OverscanInfoPresentFlag = p.Flag()
+	if vui.OverscanInfoPresentFlag {
+		vui.OverscanAppropriateFlag = p.Flag()
+	}
+	vui.VideoFormat = 5
+	vui.ColourPrimaries = ColourDescriptionUnspecified
+	vui.TransferCharacteristics = ColourDescriptionUnspecified
+	vui.MatrixCoeffs = ColourDescriptionUnspecified
+	vui.VideoSignalTypePresentFlag = p.Flag()
+	if vui.VideoSignalTypePresentFlag {
+		vui.VideoFormat = uint8(p.U(3))
+		vui.VideoFullRangeFlag = p.Flag()
+		vui.ColourDescriptionPresentFlag = p.Flag()
+		if vui.ColourDescriptionPresentFlag {
+			vui.ColourPrimaries = uint8(p.U(8))
+			vui.TransferCharacteristics = uint8(p.U(8))
+			vui.MatrixCoeffs = uint8(p.U(8))
+		}
+	}
+	vui.ChromaLocInfoPresentFlag = p.Flag()
+	if vui.ChromaLocInfoPresentFlag {
+		vui.ChromaSampleLocTypeTopField = p.UE()
+		vui.ChromaSampleLocTypeBottomField = p.UE()
+	}
+	vui.NeutralChromaIndicationFlag = p.Flag()
+	vui.FieldSeqFlag = p.Flag()
+	vui.FrameFieldInfoPresentFlag = p.Flag()
+	vui.DefaultDisplayWindowFlag = p.Flag()
+	if vui.DefaultDisplayWindowFlag {
+		vui.DefDispWinLeftOffset = p.UE()
+		vui.DefDispWinRightOffset = p.UE()
+		vui.DefDispWinTopOffset = p.UE()
+		vui.DefDispWinBottomOffset = p.UE()
+	}
+	vui.VUITimingInfoPresentFlag = p.Flag()
+	if vui.VUITimingInfoPresentFlag {
+		vui.VUINumUnitsInTick = uint32(p.U(32))
+		vui.VUITimeScale = uint32(p.U(32))
+		vui.VUIPocProportionalToTimingFlag = p.Flag()
+		if vui.VUIPocProportionalToTimingFlag {
+			vui.VUINumTicksPocDiffOneMinus1 = p.UE()
+		}
+		vui.VUIHRDParametersPresentFlag = p.Flag()
+		if vui.VUIHRDParametersPresentFlag {
+			p.skipHRDParameters(true, maxSubLayersMinus1)
+		}
+	}
+	vui.BitstreamRestrictionFlag = p.Flag()
+	if vui.BitstreamRestrictionFlag {
+		vui.TilesFixedStructureFlag = p.Flag()
+		vui.MotionVectorsOverPicBoundariesFlag = p.Flag()
+		vui.RestrictedRefPicListsFlag = p.Flag()
+		vui.MinSpatialSegmentationIdc = p.UE()
+		vui.MaxBytesPerPicDenom = p.UE()
+		vui.MaxBitsPerMinCuDenom = p.UE()
+		vui.Log2MaxMvLengthHorizontal = p.UE()
+		vui.Log2MaxMvLengthVertical = p.UE()
+	}
+	return vui
+}
+
+// skipHRDParameters reads hrd_parameters() syntax defined at E.2.2 and discards the values.
+func (p *parser) skipHRDParameters(commonInfPresentFlag bool, maxNumSubLayersMinus1 uint8) {
+	var nalHRDParametersPresentFlag, vclHRDParametersPresentFlag, subPicHRDParamsPresentFlag bool
+	if commonInfPresentFlag {
+		nalHRDParametersPresentFlag = p.Flag()
+		vclHRDParametersPresentFlag = p.Flag()
+		if nalHRDParametersPresentFlag || vclHRDParametersPresentFlag {
+			subPicHRDParamsPresentFlag = p.Flag()
+			if subPicHRDParamsPresentFlag {
+				p.U(8) // tick_divisor_minus2
+				p.U(5) // du_cpb_removal_delay_increment_length_minus1
+				p.U(1) // sub_pic_cpb_params_in_pic_timing_sei_flag
+				p.U(5) // dpb_output_delay_du_length_minus1
+			}
+			p.U(4) // bit_rate_scale
+			p.U(4) // cpb_size_scale
+			if subPicHRDParamsPresentFlag {
+				p.U(4) // cpb_size_du_scale
+			}
+			p.U(5) // initial_cpb_removal_delay_length_minus1
+			p.U(5) // au_cpb_removal_delay_length_minus1
+			p.U(5) // dpb_output_delay_length_minus1
+		}
+	}
+	for i := uint8(0); i <= maxNumSubLayersMinus1 && p.Err() == nil; i++ {
+		fixedPicRateGeneralFlag := p.Flag()
+		fixedPicRateWithinCVSFlag := true
+		if !fixedPicRateGeneralFlag {
+			fixedPicRateWithinCVSFlag = p.Flag()
+		}
+		lowDelayHRDFlag := false
+		if fixedPicRateWithinCVSFlag {
+			p.UE() // elemental_duration_in_tc_minus1
+		} else {
+			lowDelayHRDFlag = p.Flag()
+		}
+		cpbCntMinus1 := uint32(0)
+		if !lowDelayHRDFlag {
+			cpbCntMinus1 = p.UE()
+			if p.Err() == nil && cpbCntMinus1 > 31 {
+				p.SetErr(errOutOfRange("cpb_cnt_minus1", cpbCntMinus1))
+				return
+			}
+		}
+		for n := 0; n < 2; n++ {
+			if (n == 0 && !nalHRDParametersPresentFlag) || (n == 1 && !vclHRDParametersPresentFlag) {
+				continue
+			}
+			// sub_layer_hrd_parameters()
+			for k := uint32(0); k <= cpbCntMinus1 && p.Err() == nil; k++ {
+				p.UE() // bit_rate_value_minus1
+				p.UE() // cpb_size_value_minus1
+				if subPicHRDParamsPresentFlag {
+					p.UE() // cpb_size_du_value_minus1
+					p.UE() // bit_rate_du_value_minus1
+				}
+				p.Flag() // cbr_flag
+			}
+		}
+	}
+}
+
+// BitDepthLuma returns bit depth of luma samples.
+func (sps *SPS) BitDepthLuma() uint32 {
+	return sps.BitDepthLumaMinus8 + 8
+}
+
+// BitDepthChroma returns bit depth of chroma samples.
+func (sps *SPS) BitDepthChroma() uint32 {
+	return sps.BitDepthChromaMinus8 + 8
+}
+
+// ChromaArrayType returns ChromaArrayType variable.
+func (sps *SPS) ChromaArrayType() uint32 {
+	if sps.SeparateColourPlaneFlag {
+		return 0
+	}
+	return sps.ChromaFormatIdc
+}
+
+func (sps *SPS) subWidthHeightC() (x, y uint32) {
+	switch sps.ChromaArrayType() {
+	case 1:
+		return 2, 2
+	case 2:
+		return 2, 1
+	default:
+		return 1, 1
+	}
+}
+
+// Width returns width of output pictures cropped by the conformance window.
+func (sps *SPS) Width() uint32 {
+	x, _ := sps.subWidthHeightC()
+	return sps.PicWidthInLumaSamples - x*(sps.ConfWinLeftOffset+sps.ConfWinRightOffset)
+}
+
+// Height returns height of output pictures cropped by the conformance window.
+func (sps *SPS) Height() uint32 {
+	_, y := sps.subWidthHeightC()
+	return sps.PicHeightInLumaSamples - y*(sps.ConfWinTopOffset+sps.ConfWinBottomOffset)
+}
+
+// MaxDecPicBuffering returns the maximum required size of the decoded picture buffer
+// for the highest temporal sub-layer, which is sps_max_dec_pic_buffering_minus1 + 1.
+func (sps *SPS) MaxDecPicBuffering() uint32 {
+	return sps.SPSMaxDecPicBufferingMinus1[sps.SPSMaxSubLayersMinus1] + 1
+}
+
+// FrameRate returns frame rate derived from VUI timing information.
+// It returns 0 when timing information is not present.
+func (sps *SPS) FrameRate() float64 {
+	if sps.VUI == nil || !sps.VUI.VUITimingInfoPresentFlag || sps.VUI.VUINumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(sps.VUI.VUITimeScale) / float64(sps.VUI.VUINumUnitsInTick)
+}
+
+// TransferCharacteristics returns transfer_characteristics of VUI.
+// It returns ColourDescriptionUnspecified when colour description is not present.
+func (sps *SPS) TransferCharacteristics() uint8 {
+	if sps.VUI == nil {
+		return ColourDescriptionUnspecified
+	}
+	return sps.VUI.TransferCharacteristics
+}
+
+// IsPQ returns whether the stream signals SMPTE ST 2084 (PQ) transfer characteristics.
+func (sps *SPS) IsPQ() bool {
+	return sps.TransferCharacteristics() == TransferCharacteristicsPQ
+}
+
+// IsHLG returns whether the stream signals ARIB STD-B67 (HLG) transfer characteristics.
+func (sps *SPS) IsHLG() bool {
+	return sps.TransferCharacteristics() == TransferCharacteristicsHLG
+}
+
+// CheckProfileConstraints checks whether the chroma format and the bit depths
+// conform to general_profile_idc (or the compatible profile) declared in profile_tier_level.
+// Only Main, Main 10 and Main Still Picture profiles are checked.
+func (sps *SPS) CheckProfileConstraints() error {
+	ptl := sps.ProfileTierLevel
+	profile := ptl.GeneralProfileIdc
+	if profile == 0 {
+		for _, p := range []uint8{ProfileMain, ProfileMain10, ProfileMainStillPicture} {
+			if ptl.IsCompatibleWith(p) {
+				profile = p
+				break
+			}
+		}
+	}
+
+	var maxBitDepth uint32
+	switch profile {
+	case ProfileMain, ProfileMainStillPicture:
+		maxBitDepth = 8
+	case ProfileMain10:
+		maxBitDepth = 10
+	default:
+		return nil
+	}
+	if sps.ChromaFormatIdc != 1 {
+		return fmt.Errorf("chroma_format_idc must be 1 for general_profile_idc %d: actual=%d", profile, sps.ChromaFormatIdc)
+	}
+	if sps.BitDepthLuma() > maxBitDepth || sps.BitDepthChroma() > maxBitDepth {
+		return fmt.Errorf("bit depth must be less than or equal to %d for general_profile_idc %d: luma=%d chroma=%d",
+			maxBitDepth, profile, sps.BitDepthLuma(), sps.BitDepthChroma())
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package hevc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abema/go-mp4/bitio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSPS(t *testing.T) {
+	sps, err := ParseSPS([]byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+		0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16,
+		0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70,
+		0x80, 0x00, 0x01, 0xf4, 0x80, 0x00, 0x3a, 0x98,
+		0x04,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint8(ProfileMain), sps.ProfileTierLevel.GeneralProfileIdc)
+	assert.Equal(t, uint8(93), sps.ProfileTierLevel.GeneralLevelIdc)
+	assert.Equal(t, uint32(1), sps.ChromaFormatIdc)
+	assert.Equal(t, uint32(1280), sps.Width())
+	assert.Equal(t, uint32(720), sps.Height())
+	assert.Equal(t, uint32(8), sps.BitDepthLuma())
+	assert.Equal(t, uint32(8), sps.BitDepthChroma())
+	assert.Equal(t, uint32(5), sps.MaxDecPicBuffering())
+	assert.Equal(t, []uint32{2}, sps.SPSMaxNumReorderPics)
+	require.NotNil(t, sps.VUI)
+	assert.InDelta(t, 29.97, sps.FrameRate(), 0.001)
+	assert.Equal(t, uint8(ColourDescriptionUnspecified), sps.TransferCharacteristics())
+	assert.False(t, sps.IsPQ())
+	assert.False(t, sps.IsHLG())
+	assert.NoError(t, sps.CheckProfileConstraints())
+}
+
+type spsWriter struct {
+	t *testing.T
+	w bitio.Writer
+}
+
+func (w *spsWriter) u(v uint64, width uint) { require.NoError(w.t, bitio.WriteUint(w.w, v, width)) }
+func (w *spsWriter) ue(v uint64)            { require.NoError(w.t, bitio.WriteUE(w.w, v)) }
+func (w *spsWriter) se(v int64)             { require.NoError(w.t, bitio.WriteSE(w.w, v)) }
+
+func buildHDRSPS(t *testing.T, transfer uint64, bitDepthMinus8 uint64) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := &spsWriter{t: t, w: bitio.NewWriter(buf)}
+
+	w.u(0, 4)               // sps_video_parameter_set_id
+	w.u(1, 3)               // sps_max_sub_layers_minus1
+	w.u(1, 1)               // sps_temporal_id_nesting_flag
+	w.u(0, 2)               // general_profile_space
+	w.u(1, 1)               // general_tier_flag
+	w.u(ProfileMain10, 5)   // general_profile_idc
+	w.u(0x20000000, 32)     // general_profile_compatibility_flag
+	w.u(0xb00000000000, 48) // general constraint flags
+	w.u(153, 8)             // general_level_idc
+	w.u(1, 1)               // sub_layer_profile_present_flag[0]
+	w.u(1, 1)               // sub_layer_level_present_flag[0]
+	for i := 1; i < 8; i++ {
+		w.u(0, 2) // reserved_zero_2bits
+	}
+	w.u(0, 32)  // sub_layer profile (first half)
+	w.u(0, 56)  // sub_layer profile (second half)
+	w.u(120, 8) // sub_layer_level_idc[0]
+	w.ue(0)     // sps_seq_parameter_set_id
+	w.ue(1)     // chroma_format_idc
+	w.ue(3840)  // pic_width_in_luma_samples
+	w.ue(2176)  // pic_height_in_luma_samples
+	w.u(1, 1)   // conformance_window_flag
+	w.ue(0)     // conf_win_left_offset
+	w.ue(0)     // conf_win_right_offset
+	w.ue(0)     // conf_win_top_offset
+	w.ue(8)     // conf_win_bottom_offset
+	w.ue(bitDepthMinus8)
+	w.ue(bitDepthMinus8)
+	w.ue(4)   // log2_max_pic_order_cnt_lsb_minus4
+	w.u(0, 1) // sps_sub_layer_ordering_info_present_flag
+	w.ue(5)   // sps_max_dec_pic_buffering_minus1
+	w.ue(3)   // sps_max_num_reorder_pics
+	w.ue(0)   // sps_max_latency_increase_plus1
+	w.ue(0)   // log2_min_luma_coding_block_size_minus3
+	w.ue(3)   // log2_diff_max_min_luma_coding_block_size
+	w.ue(0)   // log2_min_luma_transform_block_size_minus2
+	w.ue(3)   // log2_diff_max_min_luma_transform_block_size
+	w.ue(1)   // max_transform_hierarchy_depth_inter
+	w.ue(1)   // max_transform_hierarchy_depth_intra
+	w.u(1, 1) // scaling_list_enabled_flag
+	w.u(1, 1) // sps_scaling_list_data_present_flag
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if sizeID == 2 && matrixID == 1 {
+				w.u(1, 1) // scaling_list_pred_mode_flag
+				w.se(1)   // scaling_list_dc_coef_minus8
+				for i := 0; i < 64; i++ {
+					w.se(0) // scaling_list_delta_coef
+				}
+			} else {
+				w.u(0, 1) // scaling_list_pred_mode_flag
+				w.ue(0)   // scaling_list_pred_matrix_id_delta
+			}
+		}
+	}
+	w.u(1, 1) // amp_enabled_flag
+	w.u(1, 1) // sample_adaptive_offset_enabled_flag
+	w.u(1, 1) // pcm_enabled_flag
+	w.u(7, 4) // pcm_sample_bit_depth_luma_minus1
+	w.u(7, 4) // pcm_sample_bit_depth_chroma_minus1
+	w.ue(0)   // log2_min_pcm_luma_coding_block_size_minus3
+	w.ue(1)   // log2_diff_max_min_pcm_luma_coding_block_size
+	w.u(0, 1) // pcm_loop_filter_disabled_flag
+	w.ue(3)   // num_short_term_ref_pic_sets
+	// st_ref_pic_set(0)
+	w.ue(2)   // num_negative_pics
+	w.ue(1)   // num_positive_pics
+	w.ue(0)   // delta_poc_s0_minus1
+	w.u(1, 1) // used_by_curr_pic_s0_flag
+	w.ue(1)   // delta_poc_s0_minus1
+	w.u(1, 1) // used_by_curr_pic_s0_flag
+	w.ue(0)   // delta_poc_s1_minus1
+	w.u(1, 1) // used_by_curr_pic_s1_flag
+	// st_ref_pic_set(1): predicted from set 0, NumDeltaPocs=3
+	w.u(1, 1) // inter_ref_pic_set_prediction_flag
+	w.u(1, 1) // delta_rps_sign
+	w.ue(0)   // abs_delta_rps_minus1
+	for j := 0; j <= 3; j++ {
+		w.u(1, 1) // used_by_curr_pic_flag
+	}
+	// st_ref_pic_set(2): predicted from set 1, NumDeltaPocs=3 (dPoc=0 is dropped)
+	w.u(1, 1) // inter_ref_pic_set_prediction_flag
+	w.u(0, 1) // delta_rps_sign
+	w.ue(1)   // abs_delta_rps_minus1
+	for j := 0; j <= 3; j++ {
+		w.u(0, 1) // used_by_curr_pic_flag
+		w.u(1, 1) // use_delta_flag
+	}
+	w.u(1, 1) // long_term_ref_pics_present_flag
+	w.ue(1)   // num_long_term_ref_pics_sps
+	w.u(5, 8) // lt_ref_pic_poc_lsb_sps
+	w.u(1, 1) // used_by_curr_pic_lt_sps_flag
+	w.u(1, 1) // sps_temporal_mvp_enabled_flag
+	w.u(0, 1) // strong_intra_smoothing_enabled_flag
+	w.u(1, 1) // vui_parameters_present_flag
+	w.u(0, 1) // aspect_ratio_info_present_flag
+	w.u(0, 1) // overscan_info_present_flag
+	w.u(1, 1) // video_signal_type_present_flag
+	w.u(5, 3) // video_format
+	w.u(0, 1) // video_full_range_flag
+	w.u(1, 1) // colour_description_present_flag
+	w.u(ColourPrimariesBT2020, 8)
+	w.u(transfer, 8)
+	w.u(MatrixCoefficientsBT2020NCL, 8)
+	w.u(1, 1)   // chroma_loc_info_present_flag
+	w.ue(2)     // chroma_sample_loc_type_top_field
+	w.ue(2)     // chroma_sample_loc_type_bottom_field
+	w.u(0, 1)   // neutral_chroma_indication_flag
+	w.u(0, 1)   // field_seq_flag
+	w.u(0, 1)   // frame_field_info_present_flag
+	w.u(0, 1)   // default_display_window_flag
+	w.u(1, 1)   // vui_timing_info_present_flag
+	w.u(1, 32)  // vui_num_units_in_tick
+	w.u(60, 32) // vui_time_scale
+	w.u(0, 1)   // vui_poc_proportional_to_timing_flag
+	w.u(1, 1)   // vui_hrd_parameters_present_flag
+	w.u(1, 1)   // nal_hrd_parameters_present_flag
+	w.u(0, 1)   // vcl_hrd_parameters_present_flag
+	w.u(0, 1)   // sub_pic_hrd_params_present_flag
+	w.u(0, 4)   // bit_rate_scale
+	w.u(0, 4)   // cpb_size_scale
+	w.u(23, 5)  // initial_cpb_removal_delay_length_minus1
+	w.u(23, 5)  // au_cpb_removal_delay_length_minus1
+	w.u(23, 5)  // dpb_output_delay_length_minus1
+	for i := 0; i < 2; i++ {
+		w.u(0, 1) // fixed_pic_rate_general_flag
+		w.u(0, 1) // fixed_pic_rate_within_cvs_flag
+		w.u(0, 1) // low_delay_hrd_flag
+		w.ue(1)   // cpb_cnt_minus1
+		for k := 0; k < 2; k++ {
+			w.ue(1000) // bit_rate_value_minus1
+			w.ue(2000) // cpb_size_value_minus1
+			w.u(0, 1)  // cbr_flag
+		}
+	}
+	w.u(1, 1) // bitstream_restriction_flag
+	w.u(0, 1) // tiles_fixed_structure_flag
+	w.u(1, 1) // motion_vectors_over_pic_boundaries_flag
+	w.u(1, 1) // restricted_ref_pic_lists_flag
+	w.ue(0)   // min_spatial_segmentation_idc
+	w.ue(2)   // max_bytes_per_pic_denom
+	w.ue(1)   // max_bits_per_min_cu_denom
+	w.ue(15)  // log2_max_mv_length_horizontal
+	w.ue(15)  // log2_max_mv_length_vertical
+	w.u(0, 1) // sps_extension_present_flag
+	w.u(1, 1) // rbsp_stop_one_bit
+	for {
+		if _, err := w.w.Write(nil); err == nil {
+			break
+		}
+		w.u(0, 1)
+	}
+
+	return append([]byte{0x42, 0x01}, bitio.AddEmulationPreventionBytes(buf.Bytes())...)
+}
+
+func TestParseSPSHDR(t *testing.T) {
+	testCases := []struct {
+		name     string
+		transfer uint64
+		pq       bool
+		hlg      bool
+	}{
+		{name: "PQ", transfer: TransferCharacteristicsPQ, pq: true},
+		{name: "HLG", transfer: TransferCharacteristicsHLG, hlg: true},
+		{name: "SDR", transfer: TransferCharacteristicsBT709},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sps, err := ParseSPS(buildHDRSPS(t, tc.transfer, 2))
+			require.NoError(t, err)
+			ptl := sps.ProfileTierLevel
+			assert.Equal(t, uint8(ProfileMain10), ptl.GeneralProfileIdc)
+			assert.True(t, ptl.GeneralTierFlag)
+			assert.Equal(t, uint8(153), ptl.GeneralLevelIdc)
+			assert.Equal(t, []bool{true}, ptl.SubLayerProfilePresentFlag)
+			assert.Equal(t, uint32(3840), sps.Width())
+			assert.Equal(t, uint32(2160), sps.Height())
+			assert.Equal(t, uint32(10), sps.BitDepthLuma())
+			assert.Equal(t, uint32(10), sps.BitDepthChroma())
+			assert.Equal(t, []uint32{5, 5}, sps.SPSMaxDecPicBufferingMinus1)
+			assert.Equal(t, uint32(6), sps.MaxDecPicBuffering())
+			assert.Equal(t, uint32(3), sps.NumShortTermRefPicSets)
+			assert.Equal(t, uint32(1), sps.NumLongTermRefPicsSPS)
+			assert.True(t, sps.SPSTemporalMVPEnabledFlag)
+			require.NotNil(t, sps.VUI)
+			assert.Equal(t, uint8(ColourPrimariesBT2020), sps.VUI.ColourPrimaries)
+			assert.Equal(t, uint8(tc.transfer), sps.VUI.TransferCharacteristics)
+			assert.Equal(t, uint8(MatrixCoefficientsBT2020NCL), sps.VUI.MatrixCoeffs)
+			assert.Equal(t, 60.0, sps.FrameRate())
+			assert.True(t, sps.VUI.VUIHRDParametersPresentFlag)
+			assert.True(t, sps.VUI.BitstreamRestrictionFlag)
+			assert.Equal(t, uint32(15), sps.VUI.Log2MaxMvLengthVertical)
+			assert.Equal(t, tc.pq, sps.IsPQ())
+			assert.Equal(t, tc.hlg, sps.IsHLG())
+			assert.NoError(t, sps.CheckProfileConstraints())
+		})
+	}
+}
+
+func TestCheckProfileConstraints(t *testing.T) {
+	sps, err := ParseSPS(buildHDRSPS(t, TransferCharacteristicsPQ, 4))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(12), sps.BitDepthLuma())
+	assert.Error(t, sps.CheckProfileConstraints())
+
+	sps.BitDepthLumaMinus8 = 2
+	sps.BitDepthChromaMinus8 = 2
+	sps.ChromaFormatIdc = 2
+	assert.Error(t, sps.CheckProfileConstraints())
+
+	sps.ChromaFormatIdc = 1
+	assert.NoError(t, sps.CheckProfileConstraints())
+
+	sps.ProfileTierLevel.GeneralProfileIdc = ProfileMain
+	assert.Error(t, sps.CheckProfileConstraints())
+
+	sps.ProfileTierLevel.GeneralProfileIdc = ProfileRangeExtensions
+	assert.NoError(t, sps.CheckProfileConstraints())
+}
+
+func TestParseSPSError(t *testing.T) {
+	_, err := ParseSPS([]byte{0x42})
+	assert.Equal(t, ErrShortNALUnit, err)
+
+	_, err = ParseSPS([]byte{0x40, 0x01, 0x0c})
+	assert.Equal(t, ErrInvalidNALUnitType, err)
+
+	// truncated
+	_, err = ParseSPS([]byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03})
+	assert.Error(t, err)
+}
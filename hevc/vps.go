@@ -0,0 +1,105 @@
+package hevc
+
+import (
+	"bytes"
+
+	"github.com/abema/go-mp4/bitio"
+)
+
+// VPS is H.265 video parameter set defined at ISO/IEC 23008-2 7.3.2.1
+// Syntax elements following vps_num_hrd_parameters are not parsed.
+type VPS struct {
+	VPSVideoParameterSetID             uint8
+	VPSBaseLayerInternalFlag           bool
+	VPSBaseLayerAvailableFlag          bool
+	VPSMaxLayersMinus1                 uint8
+	VPSMaxSubLayersMinus1              uint8
+	VPSTemporalIDNestingFlag           bool
+	ProfileTierLevel                   *ProfileTierLevel
+	VPSSubLayerOrderingInfoPresentFlag bool
+	VPSMaxDecPicBufferingMinus1        []uint32
+	VPSMaxNumReorderPics               []uint32
+	VPSMaxLatencyIncreasePlus1         []uint32
+	VPSMaxLayerID                      uint8
+	VPSNumLayerSetsMinus1              uint32
+	VPSTimingInfoPresentFlag           bool
+	VPSNumUnitsInTick                  uint32
+	VPSTimeScale                       uint32
+	VPSPocProportionalToTimingFlag     bool
+	VPSNumTicksPocDiffOneMinus1        uint32
+	VPSNumHRDParameters                uint32
+}
+
+// ParseVPS parses a VPS NAL unit which begins with the NAL unit header.
+// Emulation prevention bytes are removed by this function.
+func ParseVPS(nalu []byte) (*VPS, error) {
+	rbsp, err := rbspOf(nalu, NALUnitTypeVPS)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVPSRBSP(rbsp)
+}
+
+// ParseVPSRBSP parses video_parameter_set_rbsp which contains neither
+// the NAL unit header nor emulation prevention bytes.
+func ParseVPSRBSP(rbsp []byte) (*VPS, error) {
+	p := &parser{bitio.NewExpGolombReader(bitio.NewReader(bytes.NewReader(rbsp)))}
+	vps := new(VPS)
+
+	vps.VPSVideoParameterSetID = uint8(p.U(4))
+	vps.VPSBaseLayerInternalFlag = p.Flag()
+	vps.VPSBaseLayerAvailableFlag = p.Flag()
+	vps.VPSMaxLayersMinus1 = uint8(p.U(6))
+	vps.VPSMaxSubLayersMinus1 = uint8(p.U(3))
+	vps.VPSTemporalIDNestingFlag = p.Flag()
+	p.U(16) // vps_reserved_0xffff_16bits
+	vps.ProfileTierLevel = p.profileTierLevel(true, vps.VPSMaxSubLayersMinus1)
+	vps.VPSSubLayerOrderingInfoPresentFlag = p.Flag()
+	vps.VPSMaxDecPicBufferingMinus1 = make([]uint32, vps.VPSMaxSubLayersMinus1+1)
+	vps.VPSMaxNumReorderPics = make([]uint32, vps.VPSMaxSubLayersMinus1+1)
+	vps.VPSMaxLatencyIncreasePlus1 = make([]uint32, vps.VPSMaxSubLayersMinus1+1)
+	for i := firstSubLayer(vps.VPSSubLayerOrderingInfoPresentFlag, vps.VPSMaxSubLayersMinus1); i <= vps.VPSMaxSubLayersMinus1; i++ {
+		vps.VPSMaxDecPicBufferingMinus1[i] = p.UE()
+		vps.VPSMaxNumReorderPics[i] = p.UE()
+		vps.VPSMaxLatencyIncreasePlus1[i] = p.UE()
+	}
+	for i := uint8(0); i < firstSubLayer(vps.VPSSubLayerOrderingInfoPresentFlag, vps.VPSMaxSubLayersMinus1); i++ {
+		vps.VPSMaxDecPicBufferingMinus1[i] = vps.VPSMaxDecPicBufferingMinus1[vps.VPSMaxSubLayersMinus1]
+		vps.VPSMaxNumReorderPics[i] = vps.VPSMaxNumReorderPics[vps.VPSMaxSubLayersMinus1]
+		vps.VPSMaxLatencyIncreasePlus1[i] = vps.VPSMaxLatencyIncreasePlus1[vps.VPSMaxSubLayersMinus1]
+	}
+	vps.VPSMaxLayerID = uint8(p.U(6))
+	vps.VPSNumLayerSetsMinus1 = p.UE()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	if vps.VPSNumLayerSetsMinus1 > 1023 {
+		return nil, errOutOfRange("vps_num_layer_sets_minus1", vps.VPSNumLayerSetsMinus1)
+	}
+	for i := uint32(1); i <= vps.VPSNumLayerSetsMinus1; i++ {
+		p.U(uint(vps.VPSMaxLayerID) + 1) // layer_id_included_flag
+	}
+	vps.VPSTimingInfoPresentFlag = p.Flag()
+	if vps.VPSTimingInfoPresentFlag {
+		vps.VPSNumUnitsInTick = uint32(p.U(32))
+		vps.VPSTimeScale = uint32(p.U(32))
+		vps.VPSPocProportionalToTimingFlag = p.Flag()
+		if vps.VPSPocProportionalToTimingFlag {
+			vps.VPSNumTicksPocDiffOneMinus1 = p.UE()
+		}
+		vps.VPSNumHRDParameters = p.UE()
+	}
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	return vps, nil
+}
+
+// firstSubLayer returns the first index of sub-layer ordering info.
+// When sub_layer_ordering_info_present_flag is 0, only the highest sub-layer is signalled.
+func firstSubLayer(subLayerOrderingInfoPresentFlag bool, maxSubLayersMinus1 uint8) uint8 {
+	if subLayerOrderingInfoPresentFlag {
+		return 0
+	}
+	return maxSubLayersMinus1
+}
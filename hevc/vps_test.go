@@ -0,0 +1,35 @@
+package hevc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVPS(t *testing.T) {
+	vps, err := ParseVPS([]byte{
+		0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60,
+		0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x03, 0x00, 0x5d, 0x95, 0x98, 0x09,
+	})
+	require.NoError(t, err)
+	assert.True(t, vps.VPSBaseLayerInternalFlag)
+	assert.True(t, vps.VPSBaseLayerAvailableFlag)
+	assert.Equal(t, uint8(0), vps.VPSMaxSubLayersMinus1)
+	assert.True(t, vps.VPSTemporalIDNestingFlag)
+	assert.Equal(t, &ProfileTierLevel{
+		GeneralProfileIdc:                ProfileMain,
+		GeneralProfileCompatibilityFlags: 0x60000000,
+		GeneralConstraintIndicatorFlags:  0x900000000000,
+		GeneralLevelIdc:                  93,
+	}, vps.ProfileTierLevel)
+	assert.True(t, vps.VPSSubLayerOrderingInfoPresentFlag)
+	assert.Equal(t, []uint32{4}, vps.VPSMaxDecPicBufferingMinus1)
+	assert.Equal(t, []uint32{2}, vps.VPSMaxNumReorderPics)
+	assert.Equal(t, []uint32{5}, vps.VPSMaxLatencyIncreasePlus1)
+	assert.False(t, vps.VPSTimingInfoPresentFlag)
+
+	_, err = ParseVPS([]byte{0x42, 0x01, 0x0c})
+	assert.Equal(t, ErrInvalidNALUnitType, err)
+}
@@ -0,0 +1,197 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseError is a problem which is found by ReadBoxStructureLenient.
+type ParseError struct {
+	// Offset is the offset of the box or the data where the problem is found.
+	Offset uint64
+
+	// Path is the path of the box which has the problem.
+	// It is the path of the parent when the box header is invalid.
+	Path BoxPath
+
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	types := make([]string, 0, len(e.Path))
+	for _, t := range e.Path {
+		types = append(types, t.String())
+	}
+	return fmt.Sprintf("offset=%d, path=/%s: %v", e.Offset, strings.Join(types, "/"), e.Err)
+}
+
+// ParseReport has the problems which are found by ReadBoxStructureLenient in the order of the file.
+type ParseReport struct {
+	Errors []*ParseError
+}
+
+func (report *ParseReport) add(offset uint64, path BoxPath, err error) {
+	report.Errors = append(report.Errors, &ParseError{Offset: offset, Path: path, Err: err})
+}
+
+// ReadBoxStructureLenient reads the box structure like ReadBoxStructure,
+// but it records the problems into report and keeps going instead of failing.
+//
+// When the box size is invalid or the data is too short for the box header,
+// the data is skipped to the next box of the supported type found by scanning, or to the end of the parent.
+// The skipped data is passed to the handler as BoxInfo.Unparsed is true, and it has no header and no payload.
+// When the handler returns an error, the box is skipped and the next box is read.
+// The returned error is only for the failure of reading r.
+func ReadBoxStructureLenient(r io.ReadSeeker, report *ParseReport, handler ReadHandler, params ...interface{}) ([]interface{}, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readBoxStructure(&lenientReader{ReadSeeker: r}, 0, true, nil, Context{}, handler, params, report)
+}
+
+// lenientReader keeps the error of the underlying reader,
+// so that the failure of reading r is distinguished from the problems of the data.
+type lenientReader struct {
+	io.ReadSeeker
+	err error
+}
+
+func (r *lenientReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.setErr(err)
+	return n, err
+}
+
+func (r *lenientReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.ReadSeeker.Seek(offset, whence)
+	r.setErr(err)
+	return n, err
+}
+
+func (r *lenientReader) setErr(err error) {
+	if r.err == nil && err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		r.err = err
+	}
+}
+
+// readError returns the error of reading r if any.
+func readError(r io.ReadSeeker) error {
+	if lr, ok := r.(*lenientReader); ok {
+		return lr.err
+	}
+	return nil
+}
+
+func readBoxStructureLenient(r io.ReadSeeker, totalSize uint64, isRoot bool, path BoxPath, ctx Context, handler ReadHandler, params []interface{}, report *ParseReport) ([]interface{}, error) {
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if isRoot {
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		totalSize = uint64(end - offset)
+	}
+
+	vals := make([]interface{}, 0, 8)
+	start := uint64(offset)
+	end := start + totalSize
+	for start < end {
+		if _, err := r.Seek(int64(start), io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		bi, err := readBoxInfoLenient(r, end-start)
+		if err != nil {
+			if rerr := readError(r); rerr != nil {
+				return nil, rerr
+			}
+			report.add(start, path, err)
+			next, err := resyncBoxStructure(r, start+1, end, ctx)
+			if err != nil {
+				return nil, err
+			}
+			unparsed := &BoxInfo{Offset: start, Size: next - start, Context: ctx, Unparsed: true}
+			if val, err := readBoxStructureFromInternal(r, unparsed, path, handler, params, report); err != nil {
+				if rerr := readError(r); rerr != nil {
+					return nil, rerr
+				}
+				report.add(start, path, err)
+			} else {
+				vals = append(vals, val)
+			}
+			start = next
+			continue
+		}
+
+		bi.Context = ctx
+		if val, err := readBoxStructureFromInternal(r, bi, path, handler, params, report); err != nil {
+			if rerr := readError(r); rerr != nil {
+				return nil, rerr
+			}
+			report.add(bi.Offset, childPath(path, bi), err)
+		} else {
+			vals = append(vals, val)
+		}
+		start += bi.Size
+
+		if bi.IsQuickTimeCompatible {
+			ctx.IsQuickTimeCompatible = true
+		}
+	}
+
+	if _, err := r.Seek(int64(end), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// readBoxInfoLenient reads the box header, and it fails if the box does not fit in the remaining size.
+func readBoxInfoLenient(r io.ReadSeeker, remaining uint64) (*BoxInfo, error) {
+	if remaining < SmallHeaderSize {
+		return nil, fmt.Errorf("too short data for box header: size=%d", remaining)
+	}
+	bi, err := ReadBoxInfo(r)
+	if err != nil {
+		return nil, err
+	}
+	if bi.Size < bi.HeaderSize {
+		return nil, fmt.Errorf("too small box size: type=%s, size=%d", bi.Type.String(), bi.Size)
+	}
+	if bi.Size > remaining {
+		return nil, fmt.Errorf("too large box size: type=%s, size=%d, actualBufSize=%d", bi.Type.String(), bi.Size, remaining)
+	}
+	return bi, nil
+}
+
+// resyncBoxStructure returns the offset of the first box header which has the supported type and the valid size
+// between start and end, or end if it is not found.
+func resyncBoxStructure(r io.ReadSeeker, start, end uint64, ctx Context) (uint64, error) {
+	const chunkSize = 64 * 1024
+	buf := make([]byte, chunkSize+SmallHeaderSize-1)
+	for offset := start; offset+SmallHeaderSize <= end; offset += chunkSize {
+		if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
+			return 0, err
+		}
+		size := uint64(len(buf))
+		if end-offset < size {
+			size = end - offset
+		}
+		n, err := io.ReadFull(r, buf[:size])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		for i := 0; i < chunkSize && i+SmallHeaderSize <= n; i++ {
+			boxSize := uint64(binary.BigEndian.Uint32(buf[i:]))
+			boxType := BoxType{buf[i+4], buf[i+5], buf[i+6], buf[i+7]}
+			if boxSize >= SmallHeaderSize && offset+uint64(i)+boxSize <= end && boxType.IsSupported(ctx) {
+				return offset + uint64(i), nil
+			}
+		}
+	}
+	return end, nil
+}
@@ -0,0 +1,182 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingReader fails reading the data after limit.
+type failingReader struct {
+	*bytes.Reader
+	limit int64
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	offset, _ := r.Seek(0, io.SeekCurrent)
+	if offset+int64(len(p)) > r.limit {
+		return 0, errors.New("read error")
+	}
+	return r.Reader.Read(p)
+}
+
+type lenientBox struct {
+	path     string
+	offset   uint64
+	size     uint64
+	unparsed bool
+}
+
+func readLenientBoxes(t *testing.T, data []byte, fail BoxType) ([]lenientBox, *ParseReport) {
+	var boxes []lenientBox
+	report := &ParseReport{}
+	_, err := ReadBoxStructureLenient(bytes.NewReader(data), report, func(h *ReadHandle) (interface{}, error) {
+		var path string
+		for _, t := range h.Path {
+			path += "/" + t.String()
+		}
+		boxes = append(boxes, lenientBox{path: path, offset: h.BoxInfo.Offset, size: h.BoxInfo.Size, unparsed: h.BoxInfo.Unparsed})
+		if h.BoxInfo.Unparsed {
+			buf := bytes.NewBuffer(nil)
+			n, err := h.ReadData(buf)
+			require.NoError(t, err)
+			assert.Equal(t, h.BoxInfo.Size, n)
+			assert.Equal(t, data[h.BoxInfo.Offset:h.BoxInfo.Offset+h.BoxInfo.Size], buf.Bytes())
+			return nil, nil
+		}
+		if h.BoxInfo.Type == fail {
+			return nil, errors.New("handler error")
+		}
+		if h.BoxInfo.IsSupportedType() && h.BoxInfo.Type != BoxTypeMdat() {
+			return h.Expand()
+		}
+		return nil, nil
+	})
+	require.NoError(t, err)
+	return boxes, report
+}
+
+func TestReadBoxStructureLenient(t *testing.T) {
+	data, err := ioutil.ReadFile("./_examples/sample.mp4")
+	require.NoError(t, err)
+	expected, report := readLenientBoxes(t, data, BoxType{})
+	require.Empty(t, report.Errors)
+
+	// the boxes are the same as ReadBoxStructure
+	var boxes []lenientBox
+	_, err = ReadBoxStructure(bytes.NewReader(data), func(h *ReadHandle) (interface{}, error) {
+		var path string
+		for _, t := range h.Path {
+			path += "/" + t.String()
+		}
+		boxes = append(boxes, lenientBox{path: path, offset: h.BoxInfo.Offset, size: h.BoxInfo.Size})
+		if h.BoxInfo.IsSupportedType() && h.BoxInfo.Type != BoxTypeMdat() {
+			return h.Expand()
+		}
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, boxes, expected)
+
+	indexOf := func(path string) int {
+		for i, b := range expected {
+			if b.path == path {
+				return i
+			}
+		}
+		require.Fail(t, "box not found", path)
+		return -1
+	}
+
+	t.Run("too large box size", func(t *testing.T) {
+		// tkhd of the first track is broken, and edts is found by scanning
+		tkhd := expected[indexOf("/moov/trak/tkhd")]
+		broken := append([]byte{}, data...)
+		binary.BigEndian.PutUint32(broken[tkhd.offset:], 0xffffffff)
+		boxes, report := readLenientBoxes(t, broken, BoxType{})
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, tkhd.offset, report.Errors[0].Offset)
+		assert.Equal(t, BoxPath{BoxTypeMoov(), BoxTypeTrak()}, report.Errors[0].Path)
+		assert.Contains(t, report.Errors[0].Error(), "too large box size")
+
+		i := indexOf("/moov/trak/tkhd")
+		assert.Equal(t, expected[:i], boxes[:i])
+		assert.Equal(t, lenientBox{path: "/moov/trak/0x00000000", offset: tkhd.offset, size: tkhd.size, unparsed: true}, boxes[i])
+		assert.Equal(t, expected[i+1:], boxes[i+1:])
+	})
+
+	t.Run("too small box size", func(t *testing.T) {
+		// avc1 is broken, and avcC in it is found by scanning
+		entry := expected[indexOf("/moov/trak/mdia/minf/stbl/stsd/avc1")]
+		avcC := expected[indexOf("/moov/trak/mdia/minf/stbl/stsd/avc1/avcC")]
+		broken := append([]byte{}, data...)
+		binary.BigEndian.PutUint32(broken[entry.offset:], 4)
+		boxes, report := readLenientBoxes(t, broken, BoxType{})
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, entry.offset, report.Errors[0].Offset)
+		assert.Contains(t, report.Errors[0].Error(), "too small box size")
+		assert.Contains(t, boxes, lenientBox{path: "/moov/trak/mdia/minf/stbl/stsd/0x00000000", offset: entry.offset, size: avcC.offset - entry.offset, unparsed: true})
+		assert.Contains(t, boxes, lenientBox{path: "/moov/trak/mdia/minf/stbl/stsd/avcC", offset: avcC.offset, size: avcC.size})
+		assert.Contains(t, boxes, expected[indexOf("/moov/trak/mdia/minf/stbl/stts")])
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		for _, garbage := range [][]byte{
+			{0x00, 0x01, 0x02},
+			bytes.Repeat([]byte{0xff}, 20),
+		} {
+			boxes, report := readLenientBoxes(t, append(append([]byte{}, data...), garbage...), BoxType{})
+			require.Len(t, report.Errors, 1)
+			assert.Equal(t, uint64(len(data)), report.Errors[0].Offset)
+			assert.Empty(t, report.Errors[0].Path)
+			assert.Equal(t, expected, boxes[:len(expected)])
+			assert.Equal(t, []lenientBox{{path: "/0x00000000", offset: uint64(len(data)), size: uint64(len(garbage)), unparsed: true}}, boxes[len(expected):])
+		}
+	})
+
+	t.Run("truncated file", func(t *testing.T) {
+		moov := expected[indexOf("/moov")]
+		boxes, report := readLenientBoxes(t, data[:moov.offset+moov.size/2], BoxType{})
+		require.NotEmpty(t, report.Errors)
+		assert.Equal(t, moov.offset, report.Errors[0].Offset)
+		assert.Empty(t, report.Errors[0].Path)
+		assert.Contains(t, report.Errors[0].Error(), "too large box size: type=moov")
+		assert.Equal(t, expected[:indexOf("/moov")], boxes[:indexOf("/moov")])
+		// mvhd is found by scanning
+		assert.Equal(t, lenientBox{path: "/0x00000000", offset: moov.offset, size: 8, unparsed: true}, boxes[indexOf("/moov")])
+		assert.Equal(t, "/mvhd", boxes[indexOf("/moov")+1].path)
+	})
+
+	t.Run("handler error", func(t *testing.T) {
+		boxes, report := readLenientBoxes(t, data, BoxTypeTkhd())
+		// the walk continues after the boxes which fail
+		require.Len(t, report.Errors, 2)
+		for i, offset := range []uint64{6566, 7309} {
+			assert.Equal(t, offset, report.Errors[i].Offset)
+			assert.Equal(t, BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeTkhd()}, report.Errors[i].Path)
+			assert.Equal(t, "handler error", report.Errors[i].Err.Error())
+		}
+		assert.Equal(t, expected, boxes)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		// the failure of reading r is returned instead of being reported
+		moov := expected[indexOf("/moov")]
+		report := &ParseReport{}
+		_, err := ReadBoxStructureLenient(&failingReader{Reader: bytes.NewReader(data), limit: int64(moov.offset + moov.size/2)}, report, func(h *ReadHandle) (interface{}, error) {
+			if h.BoxInfo.IsSupportedType() && h.BoxInfo.Type != BoxTypeMdat() {
+				return h.Expand()
+			}
+			return nil, nil
+		})
+		require.Error(t, err)
+		assert.Equal(t, "read error", err.Error())
+		assert.Empty(t, report.Errors)
+	})
+}
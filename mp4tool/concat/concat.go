@@ -0,0 +1,50 @@
+package concat
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("concat", flag.ExitOnError)
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool concat [OPTIONS] INPUT.mp4 [INPUT.mp4 ...] OUTPUT.mp4\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPaths := flagSet.Args()[:len(flagSet.Args())-1]
+	outputPath := flagSet.Args()[len(flagSet.Args())-1]
+
+	if err := concatFiles(inputPaths, outputPath); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func concatFiles(inputPaths []string, outputPath string) error {
+	var inputs []io.ReadSeeker
+	for _, path := range inputPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		inputs = append(inputs, bufseekio.NewReadSeeker(f, 128*1024, 4))
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	return mp4.Concat(inputs, outputFile)
+}
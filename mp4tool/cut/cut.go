@@ -0,0 +1,59 @@
+package cut
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("cut", flag.ExitOnError)
+	from := flagSet.Float64("from", 0, "start time in seconds")
+	to := flagSet.Float64("to", 0, "end time in seconds (0 means the end of the input)")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool cut [OPTIONS] INPUT.mp4 OUTPUT.mp4\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPath := flagSet.Args()[0]
+	outputPath := flagSet.Args()[1]
+
+	if err := cutFile(inputPath, outputPath, toDuration(*from), toDuration(*to)); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func toDuration(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
+
+func cutFile(inputPath, outputPath string, from, to time.Duration) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	r := bufseekio.NewReadSeeker(inputFile, 128*1024, 4)
+	if err := mp4.Cut(r, outputFile, from, to); err != nil {
+		// the output is not left when the range is invalid or the input is broken
+		outputFile.Close()
+		os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
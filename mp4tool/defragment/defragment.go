@@ -0,0 +1,66 @@
+package defragment
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("defragment", flag.ExitOnError)
+	initPath := flagSet.String("init", "", "init segment; the other inputs are treated as media segments")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool defragment [OPTIONS] INPUT.mp4 OUTPUT.mp4\n")
+		fmt.Printf("       mp4tool defragment [OPTIONS] -init INIT.mp4 SEGMENT.m4s [SEGMENT.m4s ...] OUTPUT.mp4\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPaths := flagSet.Args()[:len(flagSet.Args())-1]
+	outputPath := flagSet.Args()[len(flagSet.Args())-1]
+
+	if err := defragmentFiles(*initPath, inputPaths, outputPath); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func defragmentFiles(initPath string, inputPaths []string, outputPath string) error {
+	if initPath == "" && len(inputPaths) != 1 {
+		return fmt.Errorf("-init option is required for multiple inputs")
+	}
+
+	var segments []io.ReadSeeker
+	for _, path := range inputPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		segments = append(segments, bufseekio.NewReadSeeker(f, 128*1024, 4))
+	}
+
+	init := segments[0]
+	if initPath != "" {
+		f, err := os.Open(initPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		init = bufseekio.NewReadSeeker(f, 128*1024, 4)
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	return mp4.DefragmentSegments(init, segments, outputFile)
+}
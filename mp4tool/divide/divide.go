@@ -1,13 +1,20 @@
 package divide
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/abema/go-mp4"
+	"github.com/google/uuid"
+	"github.com/sunfish-shogi/bufseekio"
 )
 
 const (
@@ -16,7 +23,9 @@ const (
 	encVideoDirName  = "video_enc"
 	encAudioDirName  = "audio_enc"
 	initMP4FileName  = "init.mp4"
+	mediaFileName    = "media.mp4"
 	playlistFileName = "playlist.m3u8"
+	masterFileName   = "master.m3u8"
 )
 
 func segmentFileName(i int) string {
@@ -24,21 +33,38 @@ func segmentFileName(i int) string {
 }
 
 func Main(args []string) {
-	if len(os.Args) < 2 {
-		fmt.Printf("USAGE: mp4tool divide INPUT.mp4 OUTPUT_DIR\n")
+	flagSet := flag.NewFlagSet("divide", flag.ExitOnError)
+	byteRange := flagSet.Bool("byterange", false, "write one file for each track whose segments are referred by byte ranges")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool alpha divide [OPTIONS] INPUT.mp4 OUTPUT_DIR\n")
+		flagSet.PrintDefaults()
 		return
 	}
 
-	if err := divide(args[0], args[1]); err != nil {
+	if err := divide(flagSet.Args()[0], flagSet.Args()[1], *byteRange); err != nil {
 		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
 }
 
-type childInfo map[uint32]uint64
-
 type segment struct {
 	duration float64
+
+	// decodeTime and mediaDuration are in the track timescale.
+	decodeTime    uint64
+	mediaDuration uint64
+
+	// offset and size are the byte range of the segment in the media file of the track,
+	// which consists of moof box, the following mdat box, and the preceding styp, emsg and prft boxes.
+	offset uint64
+	size   uint64
+
+	moofOffset uint64
+
+	// dependsOn is sample_depends_on of sdtp box for each sample, and nil when sdtp box is absent.
+	dependsOn []uint8
 }
 
 type trackType int
@@ -57,22 +83,39 @@ type track struct {
 	bandwidth   uint64
 	height      uint16
 	width       uint16
+	codecs      string
+	sampleRate  uint32
+	channels    uint16
+	frameRate   float64
+	encryption  *mp4.EncryptionInfo
+	sampleCount uint64
 	segments    []segment
 	outputDir   string
-	initFile    *os.File
-	segmentFile *os.File
+
+	// mediaFile is the file which has the initialization segment and all media segments,
+	// and is empty when each segment is written to its own file.
+	mediaFile string
+
+	// initSize is the size of the initialization segment at the beginning of the media file.
+	initSize uint64
+
+	iframes         []iframe
+	iframeBandwidth uint64
 }
 
-func divide(inputFilePath, outputDir string) error {
+func divide(inputFilePath, outputDir string, byteRange bool) error {
 	inputFile, err := os.Open(inputFilePath)
 	if err != nil {
 		return err
 	}
 	defer inputFile.Close()
+	r := bufseekio.NewReadSeeker(inputFile, 128*1024, 4)
 
 	// generate track map
 	tracks := make(map[uint32]*track, 4)
-	bis, err := mp4.ExtractBox(inputFile, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak()})
+	dirNames := make(map[uint32]string, 4)
+	dirCounts := make(map[string]int, 4)
+	bis, err := mp4.ExtractBox(r, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak()})
 	if err != nil {
 		return err
 	}
@@ -80,7 +123,7 @@ func divide(inputFilePath, outputDir string) error {
 		t := new(track)
 
 		// get trackID from Tkhd box
-		bs, err := mp4.ExtractBoxWithPayload(inputFile, bi, mp4.BoxPath{mp4.BoxTypeTkhd()})
+		bs, err := mp4.ExtractBoxWithPayload(r, bi, mp4.BoxPath{mp4.BoxTypeTkhd()})
 		if err != nil {
 			return err
 		} else if len(bs) != 1 {
@@ -90,7 +133,7 @@ func divide(inputFilePath, outputDir string) error {
 		t.id = tkhd.TrackID
 
 		// get timescale from Mdhd box
-		bs, err = mp4.ExtractBoxWithPayload(inputFile, bi, mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMdhd()})
+		bs, err = mp4.ExtractBoxWithPayload(r, bi, mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMdhd()})
 		if err != nil {
 			return err
 		} else if len(bs) != 1 {
@@ -99,312 +142,462 @@ func divide(inputFilePath, outputDir string) error {
 		mdhd := bs[0].Payload.(*mp4.Mdhd)
 		t.timescale = mdhd.Timescale
 
-		bs, err = mp4.ExtractBoxWithPayload(inputFile, bi, mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.StrToBoxType("avc1")})
+		entry, err := findSampleEntry(r, bi)
 		if err != nil {
 			return err
 		}
-		if len(bs) != 0 {
-			avc1 := bs[0].Payload.(*mp4.VisualSampleEntry)
-			t.trackType = trackVideo
-			t.height = avc1.Height
-			t.width = avc1.Width
-			t.outputDir = path.Join(outputDir, videoDirName)
-			tracks[t.id] = t
+		if entry == nil {
+			fmt.Printf("WARN: unsupported track type: trackID=%d\n", t.id)
 			continue
 		}
-
-		bis, err = mp4.ExtractBox(inputFile, bi, mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.StrToBoxType("mp4a")})
-		if err != nil {
-			return err
+		t.trackType = entry.trackType
+		if visual, ok := entry.payload.(*mp4.VisualSampleEntry); ok {
+			t.height = visual.Height
+			t.width = visual.Width
 		}
-		if len(bis) != 0 {
-			t.trackType = trackAudio
-			t.outputDir = path.Join(outputDir, audioDirName)
-			tracks[t.id] = t
-			continue
+		dirNames[t.id] = entry.dirName
+		dirCounts[entry.dirName]++
+		tracks[t.id] = t
+	}
+	for _, t := range tracks {
+		// the track ID is added only when there are several tracks of the same type
+		if dirName := dirNames[t.id]; dirCounts[dirName] > 1 {
+			t.outputDir = path.Join(outputDir, fmt.Sprintf("%s_%d", dirName, t.id))
+		} else {
+			t.outputDir = path.Join(outputDir, dirName)
 		}
+	}
 
-		bs, err = mp4.ExtractBoxWithPayload(inputFile, bi, mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.StrToBoxType("encv")})
-		if err != nil {
-			return err
-		}
-		if len(bs) != 0 {
-			encv := bs[0].Payload.(*mp4.VisualSampleEntry)
-			t.trackType = trackEncVideo
-			t.height = encv.Height
-			t.width = encv.Width
-			t.outputDir = path.Join(outputDir, encVideoDirName)
-			tracks[t.id] = t
+	// get codecs and encryption parameters
+	info, err := mp4.ProbeFra(r)
+	if err != nil {
+		return err
+	}
+	for _, ti := range info.Tracks {
+		t, exists := tracks[ti.TrackID]
+		if !exists {
 			continue
 		}
-
-		bis, err = mp4.ExtractBox(inputFile, bi, mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), mp4.StrToBoxType("enca")})
-		if err != nil {
-			return err
-		}
-		if len(bis) != 0 {
-			t.trackType = trackEncAudio
-			t.outputDir = path.Join(outputDir, encAudioDirName)
-			tracks[t.id] = t
-			continue
+		if ti.AVC != nil {
+			t.codecs = ti.AVC.Codecs
+			t.frameRate = ti.AVC.FrameRate
+		} else if ti.HEVC != nil {
+			t.codecs = ti.HEVC.Codecs
+			t.frameRate = ti.HEVC.FrameRate
+		} else if ti.AAC != nil {
+			t.codecs = ti.AAC.Codecs
+			t.sampleRate = ti.AAC.SampleRate
+			t.channels = ti.AAC.ChannelCount
 		}
-
-		fmt.Printf("WARN: unsupported track type: trackID=%d\n", t.id)
+		t.encryption = ti.Encryption
 	}
 
-	for _, t := range tracks {
-		os.MkdirAll(t.outputDir, 0777)
+	psshs, err := readPsshs(r)
+	if err != nil {
+		return err
+	}
 
-		if t.initFile, err = os.Create(path.Join(t.outputDir, initMP4FileName)); err != nil {
+	for _, t := range sortTracks(tracks) {
+		if err := divideTrack(r, t, byteRange); err != nil {
 			return err
 		}
-		defer t.initFile.Close()
+	}
 
-		if t.segmentFile, err = os.Create(path.Join(t.outputDir, segmentFileName(0))); err != nil {
-			return err
-		}
-		defer func(t *track) { t.segmentFile.Close() }(t)
-	}
-
-	currTrackID := uint32(math.MaxUint32)
-	if _, err = mp4.ReadBoxStructure(inputFile, func(h *mp4.ReadHandle) (interface{}, error) {
-		// initialization segment
-		if h.BoxInfo.Type == mp4.BoxTypeMoov() ||
-			h.BoxInfo.Type == mp4.BoxTypeFtyp() ||
-			h.BoxInfo.Type == mp4.BoxTypePssh() ||
-			h.BoxInfo.Type == mp4.BoxTypeMvhd() ||
-			h.BoxInfo.Type == mp4.BoxTypeTrak() ||
-			h.BoxInfo.Type == mp4.BoxTypeMvex() ||
-			h.BoxInfo.Type == mp4.BoxTypeUdta() {
-
-			var writeAll bool
-			var trackID uint32
-			if h.BoxInfo.Type == mp4.BoxTypeTrak() {
-				// get trackID from Tkhd box
-				bs, err := mp4.ExtractBoxWithPayload(inputFile, &h.BoxInfo, mp4.BoxPath{mp4.BoxTypeTkhd()})
-				if err != nil {
-					return nil, err
-				} else if len(bs) != 1 {
-					return nil, fmt.Errorf("trak box must have one tkhd box: tkhd=%d", len(bs))
-				}
-				tkhd := bs[0].Payload.(*mp4.Tkhd)
-				trackID = tkhd.TrackID
+	if err := outputMasterPlaylist(path.Join(outputDir, masterFileName), tracks); err != nil {
+		return err
+	}
 
-			} else {
-				writeAll = true
-			}
+	if err := outputMPD(path.Join(outputDir, mpdFileName), tracks, psshs, byteRange); err != nil {
+		return err
+	}
 
-			offsetMap := make(map[uint32]int64, len(tracks))
-			biMap := make(map[uint32]*mp4.BoxInfo, len(tracks))
-			for _, t := range tracks {
-				if writeAll || t.id == trackID {
-					if offsetMap[t.id], err = t.initFile.Seek(0, io.SeekEnd); err != nil {
-						return nil, err
-					}
-					if biMap[t.id], err = mp4.WriteBoxInfo(t.initFile, &h.BoxInfo); err != nil {
-						return nil, err
-					}
-					biMap[t.id].Size = biMap[t.id].HeaderSize
-				}
+	for _, t := range tracks {
+		if err := outputMediaPlaylist(path.Join(t.outputDir, playlistFileName), t, psshs); err != nil {
+			return err
+		}
+		if len(t.iframes) != 0 {
+			if err := outputIFramePlaylist(path.Join(t.outputDir, iframesFileName), t, psshs); err != nil {
+				return err
 			}
+		}
+	}
 
-			if h.BoxInfo.Type == mp4.BoxTypeMoov() {
-				vals, err := h.Expand()
-				if err != nil {
-					return nil, err
-				}
-				for _, val := range vals {
-					ci := val.(childInfo)
-					for _, t := range tracks {
-						// already writeAll is true in Moov box
-						biMap[t.id].Size += ci[t.id]
-					}
-				}
+	return nil
+}
 
-			} else {
-				// copy all data of payload
-				for _, t := range tracks {
-					if writeAll || t.id == trackID {
-						n, err := h.ReadData(t.initFile)
-						if err != nil {
-							return nil, err
-						}
-						biMap[t.id].Size += uint64(n)
-					}
-				}
-			}
+var sampleEntryTypes = []struct {
+	boxType   mp4.BoxType
+	trackType trackType
+	dirName   string
+}{
+	{mp4.StrToBoxType("avc1"), trackVideo, videoDirName},
+	{mp4.StrToBoxType("hvc1"), trackVideo, videoDirName},
+	{mp4.StrToBoxType("hev1"), trackVideo, videoDirName},
+	{mp4.StrToBoxType("mp4a"), trackAudio, audioDirName},
+	{mp4.StrToBoxType("encv"), trackEncVideo, encVideoDirName},
+	{mp4.StrToBoxType("enca"), trackEncAudio, encAudioDirName},
+}
 
-			// rewrite headers
-			for _, t := range tracks {
-				if writeAll || t.id == trackID {
-					if _, err = t.initFile.Seek(offsetMap[t.id], io.SeekStart); err != nil {
-						return nil, err
-					}
-					if biMap[t.id], err = mp4.WriteBoxInfo(t.initFile, biMap[t.id]); err != nil {
-						return nil, err
-					}
-				}
-			}
+type sampleEntry struct {
+	trackType trackType
+	dirName   string
+	payload   mp4.IBox
+}
 
-			ci := make(childInfo, 0)
-			for id, bi := range biMap {
-				ci[id] = bi.Size
-			}
-			return ci, nil
+// findSampleEntry returns the first sample entry of the supported type in the trak box, or nil if there is none.
+func findSampleEntry(r io.ReadSeeker, trak *mp4.BoxInfo) (*sampleEntry, error) {
+	for _, e := range sampleEntryTypes {
+		bs, err := mp4.ExtractBoxWithPayload(r, trak, mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), mp4.BoxTypeStsd(), e.boxType})
+		if err != nil {
+			return nil, err
 		}
+		if len(bs) != 0 {
+			return &sampleEntry{trackType: e.trackType, dirName: e.dirName, payload: bs[0].Payload}, nil
+		}
+	}
+	return nil, nil
+}
 
-		// media segment
-		if h.BoxInfo.Type == mp4.BoxTypeMoof() ||
-			h.BoxInfo.Type == mp4.BoxTypeMdat() {
-
-			if h.BoxInfo.Type == mp4.BoxTypeMoof() {
-				// extract Tfdt-box
-				bs, err := mp4.ExtractBoxWithPayload(inputFile, &h.BoxInfo, mp4.BoxPath{mp4.BoxTypeTraf(), mp4.BoxTypeTfhd()})
-				if err != nil {
-					return nil, err
-				} else if len(bs) != 1 {
-					return nil, fmt.Errorf("trak box must have one tkhd box: tkhd=%d", len(bs))
-				}
-				tfhd := bs[0].Payload.(*mp4.Tfhd)
-
-				currTrackID = tfhd.TrackID
-				if _, exists := tracks[currTrackID]; !exists {
-					return nil, nil
-				}
+// divideTrack writes the initialization segment and the media segments of the track.
+// The input is remuxed to have only the track at first,
+// so that moof boxes which have traf boxes of several tracks are split into each track.
+func divideTrack(r io.ReadSeeker, t *track, byteRange bool) error {
+	if err := os.MkdirAll(t.outputDir, 0777); err != nil {
+		return err
+	}
 
-				var defaultSampleDuration uint32
-				if tfhd.CheckFlag(0x000008) {
-					defaultSampleDuration = tfhd.DefaultSampleDuration
-				}
+	var file *os.File
+	var err error
+	if byteRange {
+		t.mediaFile = mediaFileName
+		if file, err = os.Create(path.Join(t.outputDir, mediaFileName)); err != nil {
+			return err
+		}
+	} else {
+		if file, err = ioutil.TempFile("", "mp4tool-divide-*.mp4"); err != nil {
+			return err
+		}
+		defer os.Remove(file.Name())
+	}
+	defer file.Close()
 
-				// extract Trun-box
-				bs, err = mp4.ExtractBoxWithPayload(inputFile, &h.BoxInfo, mp4.BoxPath{mp4.BoxTypeTraf(), mp4.BoxTypeTrun()})
-				if err != nil {
-					return nil, err
-				}
-				trun := bs[0].Payload.(*mp4.Trun)
-
-				var duration uint32
-				for i := range trun.Entries {
-					if trun.CheckFlag(0x000100) {
-						duration += trun.Entries[i].SampleDuration
-					} else {
-						duration += defaultSampleDuration
-					}
-				}
+	if err := mp4.Remux(r, file, &mp4.RemuxOptions{TrackIDs: []uint32{t.id}}); err != nil {
+		return err
+	}
+	if err := t.readSegments(file); err != nil {
+		return err
+	}
+	if t.trackType == trackVideo || t.trackType == trackEncVideo {
+		if err := t.readIFrames(file); err != nil {
+			return err
+		}
+	}
+	if byteRange {
+		return nil
+	}
 
-				// close last segment file and create next
-				t := tracks[currTrackID]
-				t.segmentFile.Close()
-				if t.segmentFile, err = os.Create(path.Join(t.outputDir, segmentFileName(len(t.segments)))); err != nil {
-					return nil, err
-				}
-				t.segments = append(t.segments, segment{
-					duration: float64(duration) / float64(t.timescale),
-				})
+	if err := copyRange(file, path.Join(t.outputDir, initMP4FileName), 0, t.initSize); err != nil {
+		return err
+	}
+	for i, s := range t.segments {
+		if err := copyRange(file, path.Join(t.outputDir, segmentFileName(i)), s.offset, s.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			} else { // Mdat box
-				if _, exists := tracks[currTrackID]; !exists {
-					return nil, nil
-				}
+// readSegments reads the segments from the file which has only the track.
+func (t *track) readSegments(r io.ReadSeeker) error {
+	var defaultSampleDuration uint32
+	bs, err := mp4.ExtractBoxWithPayload(r, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeMvex(), mp4.BoxTypeTrex()})
+	if err != nil {
+		return err
+	}
+	if len(bs) != 0 {
+		defaultSampleDuration = bs[0].Payload.(*mp4.Trex).DefaultSampleDuration
+	}
 
-				t := tracks[currTrackID]
-				bandwidth := uint64(float64(h.BoxInfo.Size) * 8 / t.segments[len(t.segments)-1].duration)
-				if bandwidth > t.bandwidth {
-					t.bandwidth = bandwidth
-				}
+	prefix := uint64(math.MaxUint64)
+	if _, err := mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case mp4.BoxTypeStyp(), mp4.BoxTypeEmsg(), mp4.StrToBoxType("prft"):
+			if prefix == math.MaxUint64 {
+				prefix = h.BoxInfo.Offset
 			}
 
-			t := tracks[currTrackID]
-			if _, err := mp4.WriteBoxInfo(t.segmentFile, &h.BoxInfo); err != nil {
+		case mp4.BoxTypeMoof():
+			s, err := t.readMoof(r, &h.BoxInfo, defaultSampleDuration)
+			if err != nil {
 				return nil, err
 			}
-			if _, err := h.ReadData(t.segmentFile); err != nil {
-				return nil, err
+			if prefix != math.MaxUint64 {
+				s.size += s.offset - prefix
+				s.offset = prefix
+				prefix = math.MaxUint64
 			}
+			if len(t.segments) == 0 {
+				t.initSize = s.offset
+			}
+			t.segments = append(t.segments, s)
 
-			return nil, nil
-		}
-
-		// skip
-		if h.BoxInfo.Type == mp4.BoxTypeMfra() {
-			return nil, nil
+		case mp4.BoxTypeMdat():
+			if len(t.segments) != 0 {
+				s := &t.segments[len(t.segments)-1]
+				s.size += h.BoxInfo.Size
+			}
 		}
-
-		return nil, fmt.Errorf("unexpected box type: %s", h.BoxInfo.Type.String())
+		return nil, nil
 	}); err != nil {
 		return err
 	}
+	if len(t.segments) == 0 {
+		return fmt.Errorf("no fragments: trackID=%d", t.id)
+	}
 
-	trackTypeMap := make(map[trackType]*track, len(tracks))
-	for _, t := range tracks {
-		trackTypeMap[t.trackType] = t
+	for _, s := range t.segments {
+		if s.duration == 0 {
+			continue
+		}
+		if bandwidth := uint64(float64(s.size) * 8 / s.duration); bandwidth > t.bandwidth {
+			t.bandwidth = bandwidth
+		}
 	}
+	return nil
+}
 
-	if err := outputMasterPlaylist(path.Join(outputDir, playlistFileName), trackTypeMap); err != nil {
-		return err
+func (t *track) readMoof(r io.ReadSeeker, moof *mp4.BoxInfo, defaultSampleDuration uint32) (segment, error) {
+	s := segment{offset: moof.Offset, size: moof.Size, moofOffset: moof.Offset}
+	if len(t.segments) != 0 {
+		last := t.segments[len(t.segments)-1]
+		s.decodeTime = last.decodeTime + last.mediaDuration
 	}
 
-	for _, t := range tracks {
-		if err := outputMediaPlaylist(path.Join(t.outputDir, playlistFileName), t.segments); err != nil {
-			return err
+	bs, err := mp4.ExtractBoxesWithPayload(r, moof, []mp4.BoxPath{
+		{mp4.BoxTypeTraf(), mp4.BoxTypeTfhd()},
+		{mp4.BoxTypeTraf(), mp4.BoxTypeTfdt()},
+		{mp4.BoxTypeTraf(), mp4.BoxTypeTrun()},
+		{mp4.BoxTypeTraf(), mp4.BoxTypeSdtp()},
+	})
+	if err != nil {
+		return s, err
+	}
+	for _, b := range bs {
+		switch box := b.Payload.(type) {
+		case *mp4.Tfhd:
+			if box.CheckFlag(0x000008) {
+				defaultSampleDuration = box.DefaultSampleDuration
+			}
+		case *mp4.Tfdt:
+			if box.GetVersion() == 0 {
+				s.decodeTime = uint64(box.BaseMediaDecodeTimeV0)
+			} else {
+				s.decodeTime = box.BaseMediaDecodeTimeV1
+			}
+		case *mp4.Trun:
+			for i := range box.Entries {
+				if box.CheckFlag(0x000100) {
+					s.mediaDuration += uint64(box.Entries[i].SampleDuration)
+				} else {
+					s.mediaDuration += uint64(defaultSampleDuration)
+				}
+			}
+			t.sampleCount += uint64(len(box.Entries))
+		case *mp4.Sdtp:
+			for _, e := range box.Samples {
+				s.dependsOn = append(s.dependsOn, e.SampleDependsOn)
+			}
 		}
 	}
-
-	return nil
+	s.duration = float64(s.mediaDuration) / float64(t.timescale)
+	return s, nil
 }
 
-func outputMasterPlaylist(filePath string, trackTypeMap map[trackType]*track) error {
+// copyRange writes the range of the input to the file.
+func copyRange(r io.ReadSeeker, filePath string, offset, size uint64) error {
 	file, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	var adir string
-	var vdir string
-	var vt *track
+	if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.CopyN(file, r, int64(size))
+	return err
+}
+
+// readPsshs returns the pssh boxes in moov box.
+func readPsshs(r io.ReadSeeker) ([]pssh, error) {
+	bs, err := mp4.ExtractBoxWithPayload(r, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypePssh()})
+	if err != nil {
+		return nil, err
+	}
+	psshs := make([]pssh, 0, len(bs))
+	for _, b := range bs {
+		if _, err := b.Info.SeekToStart(r); err != nil {
+			return nil, err
+		}
+		data := make([]byte, b.Info.Size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		psshs = append(psshs, pssh{
+			systemID: b.Payload.(*mp4.Pssh).SystemID,
+			data:     data,
+		})
+	}
+	return psshs, nil
+}
 
-	if _, exists := trackTypeMap[trackAudio]; exists {
-		adir = audioDirName
-	} else if _, exists := trackTypeMap[trackEncAudio]; exists {
-		adir = encAudioDirName
+func outputMasterPlaylist(filePath string, tracks map[uint32]*track) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	if t, exists := trackTypeMap[trackVideo]; exists {
-		vdir = videoDirName
-		vt = t
-	} else if t, exists := trackTypeMap[trackEncVideo]; exists {
-		vdir = encVideoDirName
-		vt = t
+	var videos, audios []*track
+	for _, t := range sortTracks(tracks) {
+		switch t.trackType {
+		case trackVideo, trackEncVideo:
+			videos = append(videos, t)
+		default:
+			audios = append(audios, t)
+		}
 	}
 
 	file.WriteString("#EXTM3U\n")
-	if adir != "" {
-		file.WriteString("#EXT-X-MEDIA:TYPE=AUDIO,URI=\"" + adir + "/" + playlistFileName + "\",GROUP-ID=\"audio\",NAME=\"audio\",AUTOSELECT=YES,CHANNELS=\"2\"\n")
+	file.WriteString("#EXT-X-VERSION:7\n")
+	file.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	// all audio tracks are the renditions of one group which every video variant refers to
+	var audioCodecs []string
+	var audioBandwidth, audioAverageBandwidth uint64
+	for i, t := range audios {
+		if len(videos) == 0 {
+			break // the audio playlists are the variants
+		}
+		dflt := "NO"
+		if i == 0 {
+			dflt = "YES"
+		}
+		name := fmt.Sprintf("audio%d", t.id)
+		if _, err := fmt.Fprintf(file, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"%s\",DEFAULT=%s,AUTOSELECT=YES,CHANNELS=\"%d\",URI=\"%s\"\n",
+			name, dflt, t.channels, mediaPlaylistURI(t)); err != nil {
+			return err
+		}
+		audioCodecs = appendCodecs(audioCodecs, t.codecs)
+		if t.bandwidth > audioBandwidth {
+			audioBandwidth = t.bandwidth
+		}
+		if b := averageBandwidth(t); b > audioAverageBandwidth {
+			audioAverageBandwidth = b
+		}
 	}
-	if vdir != "" {
-		_, err = fmt.Fprintf(file, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"avc1.64001f,mp4a.40.2\",RESOLUTION=%dx%d", // FIXME: hard coding
-			vt.bandwidth, vt.width, vt.height)
-		if err != nil {
+
+	for _, t := range videos {
+		codecs := appendCodecs(nil, t.codecs)
+		for _, c := range audioCodecs {
+			codecs = appendCodecs(codecs, c)
+		}
+		// BANDWIDTH of a variant includes the rendition which is played together
+		if _, err := fmt.Fprintf(file, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\",RESOLUTION=%dx%d",
+			t.bandwidth+audioBandwidth, averageBandwidth(t)+audioAverageBandwidth, strings.Join(codecs, ","), t.width, t.height); err != nil {
 			return err
 		}
-		if adir != "" {
+		if frameRate := frameRateOf(t); frameRate != 0 {
+			if _, err := fmt.Fprintf(file, ",FRAME-RATE=%.3f", frameRate); err != nil {
+				return err
+			}
+		}
+		if len(audios) != 0 {
 			file.WriteString(",AUDIO=\"audio\"")
 		}
 		file.WriteString("\n")
-		file.WriteString(vdir + "/" + playlistFileName + "\n")
+		file.WriteString(mediaPlaylistURI(t) + "\n")
+	}
+
+	for _, t := range videos {
+		if len(t.iframes) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(file, "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,CODECS=\"%s\",RESOLUTION=%dx%d,URI=\"%s\"\n",
+			t.iframeBandwidth, t.codecs, t.width, t.height, path.Base(t.outputDir)+"/"+iframesFileName); err != nil {
+			return err
+		}
+	}
+
+	// audio only
+	if len(videos) == 0 {
+		for _, t := range audios {
+			if _, err := fmt.Fprintf(file, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\"\n",
+				t.bandwidth, averageBandwidth(t), t.codecs); err != nil {
+				return err
+			}
+			file.WriteString(mediaPlaylistURI(t) + "\n")
+		}
 	}
 	return nil
 }
 
-func outputMediaPlaylist(filePath string, segments []segment) error {
+func mediaPlaylistURI(t *track) string {
+	return path.Base(t.outputDir) + "/" + playlistFileName
+}
+
+func appendCodecs(codecs []string, c string) []string {
+	if c == "" {
+		return codecs
+	}
+	for _, e := range codecs {
+		if e == c {
+			return codecs
+		}
+	}
+	return append(codecs, c)
+}
+
+func totalDuration(t *track) float64 {
+	var d float64
+	for i := range t.segments {
+		d += t.segments[i].duration
+	}
+	return d
+}
+
+func averageBandwidth(t *track) uint64 {
+	d := totalDuration(t)
+	if d == 0 {
+		return 0
+	}
+	var size uint64
+	for _, s := range t.segments {
+		size += s.size
+	}
+	return uint64(float64(size) * 8 / d)
+}
+
+// frameRateOf returns the frame rate declared by VUI, or the average frame rate of the samples.
+func frameRateOf(t *track) float64 {
+	if t.frameRate != 0 {
+		return t.frameRate
+	}
+	d := totalDuration(t)
+	if d == 0 {
+		return 0
+	}
+	return float64(t.sampleCount) / d
+}
+
+func outputMediaPlaylist(filePath string, t *track, psshs []pssh) error {
 	file, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	segments := t.segments
 	var maxDur float64
 	for i := range segments {
 		if segments[i].duration > maxDur {
@@ -418,15 +611,51 @@ func outputMediaPlaylist(filePath string, segments []segment) error {
 		return err
 	}
 	file.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
-	file.WriteString("#EXT-X-MAP:URI=\"" + initMP4FileName + "\"\n")
+	if t.encryption != nil {
+		for _, key := range keyTags(t.encryption, psshs) {
+			file.WriteString(key + "\n")
+		}
+	}
+	if t.mediaFile != "" {
+		if _, err := fmt.Fprintf(file, "#EXT-X-MAP:URI=\"%s\",BYTERANGE=\"%d@0\"\n", t.mediaFile, t.initSize); err != nil {
+			return err
+		}
+	} else {
+		file.WriteString("#EXT-X-MAP:URI=\"" + initMP4FileName + "\"\n")
+	}
 	for i := range segments {
 		if _, err := fmt.Fprintf(file, "#EXTINF:%f,\n", segments[i].duration); err != nil {
 			return err
 		}
-		if _, err := fmt.Fprintf(file, "%s\n", segmentFileName(i)); err != nil {
+		if t.mediaFile != "" {
+			if _, err := fmt.Fprintf(file, "#EXT-X-BYTERANGE:%d@%d\n%s\n", segments[i].size, segments[i].offset, t.mediaFile); err != nil {
+				return err
+			}
+		} else if _, err := fmt.Fprintf(file, "%s\n", segmentFileName(i)); err != nil {
 			return err
 		}
 	}
 	file.WriteString("#EXT-X-ENDLIST\n")
 	return nil
 }
+
+// keyTags returns EXT-X-KEY tags of the encrypted track.
+// Each pssh box is embedded as a data URI with the system ID as KEYFORMAT.
+// When no pssh box exists, the key is referred by the skd URI of FairPlay Streaming.
+func keyTags(enc *mp4.EncryptionInfo, psshs []pssh) []string {
+	method := "SAMPLE-AES"
+	if enc.Scheme == "cenc" || enc.Scheme == "cens" {
+		method = "SAMPLE-AES-CTR"
+	}
+	keyID := hex.EncodeToString(enc.DefaultKID[:])
+	if len(psshs) == 0 {
+		return []string{fmt.Sprintf("#EXT-X-KEY:METHOD=%s,URI=\"skd://%s\",KEYFORMAT=\"com.apple.streamingkeydelivery\",KEYFORMATVERSIONS=\"1\"",
+			method, keyID)}
+	}
+	tags := make([]string, 0, len(psshs))
+	for _, p := range psshs {
+		tags = append(tags, fmt.Sprintf("#EXT-X-KEY:METHOD=%s,URI=\"data:text/plain;base64,%s\",KEYID=0x%s,KEYFORMAT=\"urn:uuid:%s\",KEYFORMATVERSIONS=\"1\"",
+			method, base64.StdEncoding.EncodeToString(p.data), keyID, uuid.UUID(p.systemID).String()))
+	}
+	return tags
+}
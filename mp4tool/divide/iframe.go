@@ -0,0 +1,134 @@
+package divide
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/abema/go-mp4"
+)
+
+const iframesFileName = "iframes.m3u8"
+
+// sdtp sample_depends_on values defined at ISO/IEC 14496-12 8.6.4.3
+const (
+	dependsOnOthers   = 1
+	dependsOnNoOthers = 2
+)
+
+// iframe is a sync sample which is addressed by the byte range from moof box to the end of the sample.
+type iframe struct {
+	segment    int
+	offset     uint64
+	size       uint64
+	decodeTime uint64
+}
+
+// readIFrames reads the sync samples from the file which has only the track.
+// A sample is an I-frame when its sample flags mark it as a sync sample
+// and sdtp box, if exists, does not declare it depends on other samples.
+func (t *track) readIFrames(r io.ReadSeeker) error {
+	samples, err := mp4.ReadSamples(r, t.id)
+	if err != nil {
+		return err
+	}
+
+	var i int
+	for s := range t.segments {
+		seg := &t.segments[s]
+		var n int
+		for ; i < len(samples) && samples[i].Offset < seg.offset+seg.size; i++ {
+			sample := &samples[i]
+			isIFrame := sample.IsSync
+			if n < len(seg.dependsOn) {
+				switch seg.dependsOn[n] {
+				case dependsOnOthers:
+					isIFrame = false
+				case dependsOnNoOthers:
+					isIFrame = true
+				}
+			}
+			n++
+			if !isIFrame {
+				continue
+			}
+			t.iframes = append(t.iframes, iframe{
+				segment:    s,
+				offset:     seg.moofOffset,
+				size:       sample.Offset + uint64(sample.Size) - seg.moofOffset,
+				decodeTime: sample.DecodeTime,
+			})
+		}
+	}
+
+	for j := range t.iframes {
+		d := t.iframeDuration(j)
+		if d == 0 {
+			continue
+		}
+		if bandwidth := uint64(float64(t.iframes[j].size) * 8 / d); bandwidth > t.iframeBandwidth {
+			t.iframeBandwidth = bandwidth
+		}
+	}
+	return nil
+}
+
+// iframeDuration returns the duration in seconds until the next I-frame or the end of the track.
+func (t *track) iframeDuration(i int) float64 {
+	var end uint64
+	if i+1 < len(t.iframes) {
+		end = t.iframes[i+1].decodeTime
+	} else {
+		last := t.segments[len(t.segments)-1]
+		end = last.decodeTime + last.mediaDuration
+	}
+	return float64(end-t.iframes[i].decodeTime) / float64(t.timescale)
+}
+
+func outputIFramePlaylist(filePath string, t *track, psshs []pssh) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var maxDur float64
+	for i := range t.iframes {
+		if d := t.iframeDuration(i); d > maxDur {
+			maxDur = d
+		}
+	}
+
+	file.WriteString("#EXTM3U\n")
+	file.WriteString("#EXT-X-VERSION:7\n")
+	if _, err := fmt.Fprintf(file, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(maxDur))); err != nil {
+		return err
+	}
+	file.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	file.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+	if t.encryption != nil {
+		for _, key := range keyTags(t.encryption, psshs) {
+			file.WriteString(key + "\n")
+		}
+	}
+	if t.mediaFile != "" {
+		if _, err := fmt.Fprintf(file, "#EXT-X-MAP:URI=\"%s\",BYTERANGE=\"%d@0\"\n", t.mediaFile, t.initSize); err != nil {
+			return err
+		}
+	} else {
+		file.WriteString("#EXT-X-MAP:URI=\"" + initMP4FileName + "\"\n")
+	}
+	for i, f := range t.iframes {
+		// the byte range is relative to the segment file unless all segments are in the media file
+		uri, offset := t.mediaFile, f.offset
+		if uri == "" {
+			uri, offset = segmentFileName(f.segment), f.offset-t.segments[f.segment].offset
+		}
+		if _, err := fmt.Fprintf(file, "#EXTINF:%f,\n#EXT-X-BYTERANGE:%d@%d\n%s\n", t.iframeDuration(i), f.size, offset, uri); err != nil {
+			return err
+		}
+	}
+	file.WriteString("#EXT-X-ENDLIST\n")
+	return nil
+}
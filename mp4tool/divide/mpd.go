@@ -0,0 +1,268 @@
+package divide
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+const (
+	mpdFileName = "manifest.mpd"
+
+	mp4ProtectionScheme = "urn:mpeg:dash:mp4protection:2011"
+)
+
+type mpd struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	XMLNS                     string   `xml:"xmlns,attr"`
+	XMLNSCenc                 string   `xml:"xmlns:cenc,attr"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	Period                    mpdPeriod
+}
+
+type mpdPeriod struct {
+	XMLName        xml.Name `xml:"Period"`
+	ID             string   `xml:"id,attr"`
+	Start          string   `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet
+}
+
+type mpdAdaptationSet struct {
+	XMLName            xml.Name `xml:"AdaptationSet"`
+	ContentType        string   `xml:"contentType,attr"`
+	MimeType           string   `xml:"mimeType,attr"`
+	SegmentAlignment   bool     `xml:"segmentAlignment,attr"`
+	StartWithSAP       int      `xml:"startWithSAP,attr"`
+	ContentProtections []mpdContentProtection
+	Representations    []mpdRepresentation
+}
+
+type mpdContentProtection struct {
+	XMLName     xml.Name `xml:"ContentProtection"`
+	SchemeIDURI string   `xml:"schemeIdUri,attr"`
+	Value       string   `xml:"value,attr,omitempty"`
+	DefaultKID  string   `xml:"cenc:default_KID,attr,omitempty"`
+	Pssh        string   `xml:"cenc:pssh,omitempty"`
+}
+
+type mpdRepresentation struct {
+	XMLName           xml.Name `xml:"Representation"`
+	ID                string   `xml:"id,attr"`
+	Bandwidth         uint64   `xml:"bandwidth,attr"`
+	Codecs            string   `xml:"codecs,attr,omitempty"`
+	Width             uint16   `xml:"width,attr,omitempty"`
+	Height            uint16   `xml:"height,attr,omitempty"`
+	AudioSamplingRate uint32   `xml:"audioSamplingRate,attr,omitempty"`
+	SegmentTemplate   *mpdSegmentTemplate
+	SegmentList       *mpdSegmentList
+}
+
+type mpdSegmentTemplate struct {
+	XMLName         xml.Name `xml:"SegmentTemplate"`
+	Timescale       uint32   `xml:"timescale,attr"`
+	Initialization  string   `xml:"initialization,attr"`
+	Media           string   `xml:"media,attr"`
+	StartNumber     int      `xml:"startNumber,attr"`
+	SegmentTimeline mpdSegmentTimeline
+}
+
+type mpdSegmentList struct {
+	XMLName         xml.Name `xml:"SegmentList"`
+	Timescale       uint32   `xml:"timescale,attr"`
+	Initialization  mpdURL   `xml:"Initialization"`
+	SegmentTimeline mpdSegmentTimeline
+	SegmentURLs     []mpdURL `xml:"SegmentURL"`
+}
+
+// mpdURL is URLType for Initialization and SegmentURL.
+type mpdURL struct {
+	SourceURL  string `xml:"sourceURL,attr,omitempty"`
+	Range      string `xml:"range,attr,omitempty"`
+	Media      string `xml:"media,attr,omitempty"`
+	MediaRange string `xml:"mediaRange,attr,omitempty"`
+}
+
+type mpdSegmentTimeline struct {
+	XMLName  xml.Name `xml:"SegmentTimeline"`
+	Segments []mpdS
+}
+
+type mpdS struct {
+	XMLName  xml.Name `xml:"S"`
+	Time     uint64   `xml:"t,attr"`
+	Duration uint64   `xml:"d,attr"`
+	Repeat   int      `xml:"r,attr,omitempty"`
+}
+
+// pssh is a pssh box in moov box.
+type pssh struct {
+	systemID [16]byte
+	data     []byte
+}
+
+func outputMPD(filePath string, tracks map[uint32]*track, psshs []pssh, useByteRange bool) error {
+	sorted := sortTracks(tracks)
+
+	// segments referred by byte ranges are addressed by SegmentList, and by SegmentTemplate otherwise
+	profiles := "urn:mpeg:dash:profile:isoff-live:2011"
+	if useByteRange {
+		profiles = "urn:mpeg:dash:profile:isoff-on-demand:2011"
+	}
+
+	m := mpd{
+		XMLNS:         "urn:mpeg:dash:schema:mpd:2011",
+		XMLNSCenc:     "urn:mpeg:cenc:2013",
+		Profiles:      profiles,
+		Type:          "static",
+		MinBufferTime: "PT2S",
+		Period:        mpdPeriod{ID: "0", Start: "PT0S"},
+	}
+
+	var duration float64
+	for i, t := range sorted {
+		if d := totalDuration(t); d > duration {
+			duration = d
+		}
+
+		// one adaptation set per track type
+		if i == 0 || sorted[i-1].trackType != t.trackType {
+			set := mpdAdaptationSet{
+				ContentType:      contentTypeOf(t.trackType),
+				MimeType:         contentTypeOf(t.trackType) + "/mp4",
+				SegmentAlignment: true,
+				StartWithSAP:     1,
+			}
+			if t.encryption != nil {
+				set.ContentProtections = contentProtectionsOf(t, psshs)
+			}
+			m.Period.AdaptationSets = append(m.Period.AdaptationSets, set)
+		}
+		set := &m.Period.AdaptationSets[len(m.Period.AdaptationSets)-1]
+		set.Representations = append(set.Representations, representationOf(t))
+	}
+	m.MediaPresentationDuration = fmt.Sprintf("PT%.3fS", duration)
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(file)
+	enc.Indent("", "  ")
+	if err := enc.Encode(&m); err != nil {
+		return err
+	}
+	_, err = file.WriteString("\n")
+	return err
+}
+
+// sortTracks returns the tracks in the order of the track type and the track ID.
+func sortTracks(tracks map[uint32]*track) []*track {
+	sorted := make([]*track, 0, len(tracks))
+	for _, t := range tracks {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].trackType != sorted[j].trackType {
+			return sorted[i].trackType < sorted[j].trackType
+		}
+		return sorted[i].id < sorted[j].id
+	})
+	return sorted
+}
+
+func contentTypeOf(tt trackType) string {
+	switch tt {
+	case trackVideo, trackEncVideo:
+		return "video"
+	default:
+		return "audio"
+	}
+}
+
+func contentProtectionsOf(t *track, psshs []pssh) []mpdContentProtection {
+	cps := []mpdContentProtection{{
+		SchemeIDURI: mp4ProtectionScheme,
+		Value:       t.encryption.Scheme,
+		DefaultKID:  uuid.UUID(t.encryption.DefaultKID).String(),
+	}}
+	for _, p := range psshs {
+		cps = append(cps, mpdContentProtection{
+			SchemeIDURI: "urn:uuid:" + uuid.UUID(p.systemID).String(),
+			Pssh:        base64.StdEncoding.EncodeToString(p.data),
+		})
+	}
+	return cps
+}
+
+func representationOf(t *track) mpdRepresentation {
+	dir := path.Base(t.outputDir)
+	rep := mpdRepresentation{
+		ID:        fmt.Sprintf("%d", t.id),
+		Bandwidth: t.bandwidth,
+		Codecs:    t.codecs,
+	}
+	switch t.trackType {
+	case trackVideo, trackEncVideo:
+		rep.Width = t.width
+		rep.Height = t.height
+	default:
+		rep.AudioSamplingRate = t.sampleRate
+	}
+
+	// consecutive segments of the same duration are merged by the repeat count
+	var timeline mpdSegmentTimeline
+	var next uint64
+	for i, seg := range t.segments {
+		if n := len(timeline.Segments); i != 0 && seg.decodeTime == next &&
+			seg.mediaDuration == timeline.Segments[n-1].Duration {
+			timeline.Segments[n-1].Repeat++
+		} else {
+			timeline.Segments = append(timeline.Segments, mpdS{
+				Time:     seg.decodeTime,
+				Duration: seg.mediaDuration,
+			})
+		}
+		next = seg.decodeTime + seg.mediaDuration
+	}
+
+	if t.mediaFile != "" {
+		media := dir + "/" + t.mediaFile
+		list := &mpdSegmentList{
+			Timescale:       t.timescale,
+			Initialization:  mpdURL{SourceURL: media, Range: byteRange(0, t.initSize)},
+			SegmentTimeline: timeline,
+		}
+		for _, seg := range t.segments {
+			list.SegmentURLs = append(list.SegmentURLs, mpdURL{Media: media, MediaRange: byteRange(seg.offset, seg.size)})
+		}
+		rep.SegmentList = list
+		return rep
+	}
+
+	rep.SegmentTemplate = &mpdSegmentTemplate{
+		Timescale:       t.timescale,
+		Initialization:  dir + "/" + initMP4FileName,
+		Media:           dir + "/$Number$.mp4",
+		StartNumber:     0,
+		SegmentTimeline: timeline,
+	}
+	return rep
+}
+
+// byteRange returns the byte range in the form of RFC 7233, such as "0-499".
+func byteRange(offset, size uint64) string {
+	return fmt.Sprintf("%d-%d", offset, offset+size-1)
+}
@@ -2,6 +2,7 @@ package dump
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -36,10 +37,13 @@ func Main(args []string) {
 	free := flagSet.Bool("free", false, "Deprecated: use \"-full free,styp\"")
 	offset := flagSet.Bool("offset", false, "Show offset of box")
 	hex := flagSet.Bool("hex", false, "Use hex for size and offset")
+	query := flagSet.String("q", "", "Show only boxes which match the query and their children\nFor example: -q \"moov/trak[tkhd.TrackID=1]//stsd\"")
+	lenient := flagSet.Bool("lenient", false, "Keep going on broken boxes and show errors and unparsed data inline")
 	flagSet.Parse(args)
 
 	if len(flagSet.Args()) < 1 {
 		fmt.Printf("USAGE: mp4tool dump [OPTIONS] INPUT.mp4\n")
+		fmt.Printf("       specify \"-\" as INPUT.mp4 to read from stdin\n")
 		return
 	}
 
@@ -62,6 +66,8 @@ func Main(args []string) {
 		showAll: *showAll,
 		offset:  *offset,
 		hex:     *hex,
+		query:   *query,
+		lenient: *lenient,
 	}
 	err := m.dumpFile(fpath)
 	if err != nil {
@@ -75,9 +81,32 @@ type mp4dump struct {
 	showAll bool
 	offset  bool
 	hex     bool
+	query   string
+	lenient bool
+
+	// report has the errors found in the lenient mode, and printed is the number of the errors which are printed.
+	report  *mp4.ParseReport
+	printed int
+
+	// matches are the boxes which match the query,
+	// and baseDepth is the depth of the matched box which is being dumped.
+	matches   []*mp4.BoxInfo
+	inMatch   bool
+	baseDepth int
 }
 
 func (m *mp4dump) dumpFile(fpath string) error {
+	if fpath == "-" {
+		if m.query != "" {
+			return errors.New("query is not supported for stdin")
+		}
+		if m.lenient {
+			return errors.New("lenient mode is not supported for stdin")
+		}
+		_, err := mp4.ReadBoxStructureStream(os.Stdin, m.handle)
+		return err
+	}
+
 	file, err := os.Open(fpath)
 	if err != nil {
 		return err
@@ -88,103 +117,170 @@ func (m *mp4dump) dumpFile(fpath string) error {
 }
 
 func (m *mp4dump) dump(r io.ReadSeeker) error {
-	_, err := mp4.ReadBoxStructure(r, func(h *mp4.ReadHandle) (interface{}, error) {
+	if m.lenient {
+		if m.query != "" {
+			return errors.New("query is not supported in lenient mode")
+		}
+		m.report = &mp4.ParseReport{}
+		_, err := mp4.ReadBoxStructureLenient(r, m.report, m.handle)
+		m.printErrors()
+		return err
+	}
+	if m.query != "" {
+		nodes, err := mp4.Query(r, m.query)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			m.matches = append(m.matches, &n.BoxInfo)
+		}
+	}
+	_, err := mp4.ReadBoxStructure(r, m.handle)
+	return err
+}
+
+func (m *mp4dump) handle(h *mp4.ReadHandle) (interface{}, error) {
+	m.printErrors()
+	if m.query == "" || m.inMatch {
+		return m.dumpBox(h)
+	}
+	for _, bi := range m.matches {
+		if bi.Offset == h.BoxInfo.Offset {
+			m.inMatch = true
+			m.baseDepth = len(h.Path) - 1
+			defer func() { m.inMatch = false }()
+			return m.dumpBox(h)
+		}
+	}
+	for _, bi := range m.matches {
+		if bi.Offset > h.BoxInfo.Offset && bi.Offset < h.BoxInfo.Offset+h.BoxInfo.Size {
+			// the box has the matched descendants
+			return h.Expand()
+		}
+	}
+	return nil, nil
+}
+
+// printErrors prints the errors which are found after the last box is printed.
+// The error of the box header is printed at the depth of the box, and the error of the box is printed under the box.
+func (m *mp4dump) printErrors() {
+	if m.report == nil {
+		return
+	}
+	for ; m.printed < len(m.report.Errors); m.printed++ {
+		e := m.report.Errors[m.printed]
 		line := bytes.NewBuffer(make([]byte, 0, terminalWidth))
+		printIndent(line, len(e.Path))
+		fmt.Fprintf(line, "(error) Offset="+m.sizeFormat()+" %v", e.Offset, e.Err)
+		fmt.Println(line.String())
+	}
+}
 
-		printIndent(line, len(h.Path)-1)
+func (m *mp4dump) sizeFormat() string {
+	if m.hex {
+		return "0x%x"
+	}
+	return "%d"
+}
+
+func (m *mp4dump) dumpBox(h *mp4.ReadHandle) (interface{}, error) {
+	line := bytes.NewBuffer(make([]byte, 0, terminalWidth))
+
+	printIndent(line, len(h.Path)-1-m.baseDepth)
 
-		fmt.Fprintf(line, "[%s]", h.BoxInfo.Type.String())
+	name := h.BoxInfo.Type.String()
+	if h.BoxInfo.Unparsed {
+		name = "unparsed"
+		fmt.Fprintf(line, "[unparsed]")
+	} else {
+		fmt.Fprintf(line, "[%s]", name)
 		if !h.BoxInfo.IsSupportedType() {
 			fmt.Fprintf(line, " (unsupported box type)")
 		}
-		sizeFormat := "%d"
-		if m.hex {
-			sizeFormat = "0x%x"
-		}
-		if m.offset {
-			fmt.Fprintf(line, " Offset="+sizeFormat, h.BoxInfo.Offset)
-		}
-		fmt.Fprintf(line, " Size="+sizeFormat, h.BoxInfo.Size)
-
-		_, full := m.full[h.BoxInfo.Type.String()]
-		if !full &&
-			(h.BoxInfo.Type == mp4.BoxTypeMdat() ||
-				h.BoxInfo.Type == mp4.BoxTypeFree() ||
-				h.BoxInfo.Type == mp4.BoxTypeSkip()) {
-			fmt.Fprintf(line, " Data=[...] (use \"-full %s\" to show all)", h.BoxInfo.Type)
+	}
+	sizeFormat := m.sizeFormat()
+	if m.offset {
+		fmt.Fprintf(line, " Offset="+sizeFormat, h.BoxInfo.Offset)
+	}
+	fmt.Fprintf(line, " Size="+sizeFormat, h.BoxInfo.Size)
+
+	_, full := m.full[name]
+	if !full &&
+		(h.BoxInfo.Type == mp4.BoxTypeMdat() ||
+			h.BoxInfo.Type == mp4.BoxTypeFree() ||
+			h.BoxInfo.Type == mp4.BoxTypeSkip()) {
+		fmt.Fprintf(line, " Data=[...] (use \"-full %s\" to show all)", h.BoxInfo.Type)
+		fmt.Println(line.String())
+		return nil, nil
+	}
+	full = full || m.showAll
+
+	// supported box type
+	if h.BoxInfo.IsSupportedType() {
+		if !full && h.BoxInfo.Size-h.BoxInfo.HeaderSize >= 64 &&
+			(h.BoxInfo.Type == mp4.BoxTypeEmsg() ||
+				h.BoxInfo.Type == mp4.BoxTypeEsds() ||
+				h.BoxInfo.Type == mp4.BoxTypeFtyp() ||
+				h.BoxInfo.Type == mp4.BoxTypePssh() ||
+				h.BoxInfo.Type == mp4.BoxTypeCtts() ||
+				h.BoxInfo.Type == mp4.BoxTypeCo64() ||
+				h.BoxInfo.Type == mp4.BoxTypeElst() ||
+				h.BoxInfo.Type == mp4.BoxTypeSbgp() ||
+				h.BoxInfo.Type == mp4.BoxTypeSdtp() ||
+				h.BoxInfo.Type == mp4.BoxTypeStco() ||
+				h.BoxInfo.Type == mp4.BoxTypeStsc() ||
+				h.BoxInfo.Type == mp4.BoxTypeStts() ||
+				h.BoxInfo.Type == mp4.BoxTypeStss() ||
+				h.BoxInfo.Type == mp4.BoxTypeStsz() ||
+				h.BoxInfo.Type == mp4.BoxTypeTfra() ||
+				h.BoxInfo.Type == mp4.BoxTypeTrun()) {
+			fmt.Fprintf(line, " ... (use \"-full %s\" to show all)", h.BoxInfo.Type)
 			fmt.Println(line.String())
 			return nil, nil
 		}
-		full = full || m.showAll
-
-		// supported box type
-		if h.BoxInfo.IsSupportedType() {
-			if !full && h.BoxInfo.Size-h.BoxInfo.HeaderSize >= 64 &&
-				(h.BoxInfo.Type == mp4.BoxTypeEmsg() ||
-					h.BoxInfo.Type == mp4.BoxTypeEsds() ||
-					h.BoxInfo.Type == mp4.BoxTypeFtyp() ||
-					h.BoxInfo.Type == mp4.BoxTypePssh() ||
-					h.BoxInfo.Type == mp4.BoxTypeCtts() ||
-					h.BoxInfo.Type == mp4.BoxTypeCo64() ||
-					h.BoxInfo.Type == mp4.BoxTypeElst() ||
-					h.BoxInfo.Type == mp4.BoxTypeSbgp() ||
-					h.BoxInfo.Type == mp4.BoxTypeSdtp() ||
-					h.BoxInfo.Type == mp4.BoxTypeStco() ||
-					h.BoxInfo.Type == mp4.BoxTypeStsc() ||
-					h.BoxInfo.Type == mp4.BoxTypeStts() ||
-					h.BoxInfo.Type == mp4.BoxTypeStss() ||
-					h.BoxInfo.Type == mp4.BoxTypeStsz() ||
-					h.BoxInfo.Type == mp4.BoxTypeTfra() ||
-					h.BoxInfo.Type == mp4.BoxTypeTrun()) {
-				fmt.Fprintf(line, " ... (use \"-full %s\" to show all)", h.BoxInfo.Type)
-				fmt.Println(line.String())
-				return nil, nil
-			}
 
-			box, _, err := h.ReadPayload()
-			if err != mp4.ErrUnsupportedBoxVersion {
-				if err != nil {
-					return nil, err
-				}
-
-				str, err := mp4.Stringify(box, h.BoxInfo.Context)
-				if err != nil {
-					return nil, err
-				}
-				if !full && line.Len()+len(str)+2 > terminalWidth {
-					fmt.Fprintf(line, " ... (use \"-full %s\" to show all)", h.BoxInfo.Type)
-				} else {
-					fmt.Fprintf(line, " %s", str)
-				}
-
-				fmt.Println(line.String())
-				_, err = h.Expand()
+		box, _, err := h.ReadPayload()
+		if err != mp4.ErrUnsupportedBoxVersion {
+			if err != nil {
 				return nil, err
 			}
-			fmt.Fprintf(line, " (unsupported box version)")
-		}
 
-		// unsupported box type
-		if full {
-			buf := bytes.NewBuffer(make([]byte, 0, h.BoxInfo.Size-h.BoxInfo.HeaderSize))
-			if _, err := h.ReadData(buf); err != nil {
+			str, err := mp4.Stringify(box, h.BoxInfo.Context)
+			if err != nil {
 				return nil, err
 			}
-			fmt.Fprintf(line, " Data=[")
-			for i, d := range buf.Bytes() {
-				if i != 0 {
-					fmt.Fprintf(line, " ")
-				}
-				fmt.Fprintf(line, "0x%02x", d)
+			if !full && line.Len()+len(str)+2 > terminalWidth {
+				fmt.Fprintf(line, " ... (use \"-full %s\" to show all)", h.BoxInfo.Type)
+			} else {
+				fmt.Fprintf(line, " %s", str)
 			}
-			fmt.Fprintf(line, "]")
-		} else {
-			fmt.Fprintf(line, " Data=[...] (use \"-full %s\" to show all)", h.BoxInfo.Type)
+
+			fmt.Println(line.String())
+			_, err = h.Expand()
+			return nil, err
 		}
-		fmt.Println(line.String())
-		return nil, nil
-	})
-	return err
+		fmt.Fprintf(line, " (unsupported box version)")
+	}
+
+	// unsupported box type
+	if full {
+		buf := bytes.NewBuffer(make([]byte, 0, h.BoxInfo.Size-h.BoxInfo.HeaderSize))
+		if _, err := h.ReadData(buf); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(line, " Data=[")
+		for i, d := range buf.Bytes() {
+			if i != 0 {
+				fmt.Fprintf(line, " ")
+			}
+			fmt.Fprintf(line, "0x%02x", d)
+		}
+		fmt.Fprintf(line, "]")
+	} else {
+		fmt.Fprintf(line, " Data=[...] (use \"-full %s\" to show all)", name)
+	}
+	fmt.Println(line.String())
+	return nil, nil
 }
 
 func printIndent(w io.Writer, depth int) {
@@ -0,0 +1,54 @@
+package extract
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("extract", flag.ExitOnError)
+	trackID := flagSet.Uint("track", 1, "track ID to extract")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool extract [OPTIONS] INPUT.mp4 OUTPUT\n")
+		fmt.Printf("  AVC/HEVC tracks are written as Annex B byte stream and AAC tracks are written as ADTS.\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPath := flagSet.Args()[0]
+	outputPath := flagSet.Args()[1]
+
+	err := extractFile(inputPath, outputPath, uint32(*trackID))
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func extractFile(inputPath, outputPath string, trackID uint32) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	r := bufseekio.NewReadSeeker(inputFile, 128*1024, 4)
+	w := bufio.NewWriter(outputFile)
+	if err := mp4.ExtractElementaryStream(r, trackID, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
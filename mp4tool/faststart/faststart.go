@@ -0,0 +1,52 @@
+package faststart
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("faststart", flag.ExitOnError)
+	removeFree := flagSet.Bool("remove-free", false, "remove top-level free and skip boxes")
+	padding := flagSet.Uint("padding", 0, "size of free box written after moov box")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool faststart [OPTIONS] INPUT.mp4 OUTPUT.mp4\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPath := flagSet.Args()[0]
+	outputPath := flagSet.Args()[1]
+
+	err := fastStartFile(inputPath, outputPath, &mp4.FastStartOptions{
+		RemoveFree: *removeFree,
+		Padding:    uint32(*padding),
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func fastStartFile(inputPath, outputPath string, opts *mp4.FastStartOptions) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	r := bufseekio.NewReadSeeker(inputFile, 128*1024, 4)
+	return mp4.FastStart(r, outputFile, opts)
+}
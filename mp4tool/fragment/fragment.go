@@ -0,0 +1,56 @@
+package fragment
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("fragment", flag.ExitOnError)
+	duration := flagSet.Duration("duration", mp4.DefaultFragmentDuration, "minimum duration of each fragment")
+	styp := flagSet.Bool("styp", false, "write styp box before each fragment")
+	sidx := flagSet.Bool("sidx", false, "write sidx box after the init segment")
+	mfra := flagSet.Bool("mfra", false, "write mfra box at the end of the file")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool fragment [OPTIONS] INPUT.mp4 OUTPUT.mp4\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPath := flagSet.Args()[0]
+	outputPath := flagSet.Args()[1]
+
+	err := fragmentFile(inputPath, outputPath, &mp4.FragmentOptions{
+		FragmentDuration: *duration,
+		WriteStyp:        *styp,
+		WriteSidx:        *sidx,
+		WriteMfra:        *mfra,
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func fragmentFile(inputPath, outputPath string, opts *mp4.FragmentOptions) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	r := bufseekio.NewReadSeeker(inputFile, 128*1024, 4)
+	return mp4.Fragment(r, outputFile, opts)
+}
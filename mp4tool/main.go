@@ -4,10 +4,18 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/abema/go-mp4/mp4tool/concat"
+	"github.com/abema/go-mp4/mp4tool/cut"
+	"github.com/abema/go-mp4/mp4tool/defragment"
 	"github.com/abema/go-mp4/mp4tool/divide"
 	"github.com/abema/go-mp4/mp4tool/dump"
 	"github.com/abema/go-mp4/mp4tool/edit"
+	"github.com/abema/go-mp4/mp4tool/extract"
+	"github.com/abema/go-mp4/mp4tool/faststart"
+	"github.com/abema/go-mp4/mp4tool/fragment"
 	"github.com/abema/go-mp4/mp4tool/psshdump"
+	"github.com/abema/go-mp4/mp4tool/remux"
+	"github.com/abema/go-mp4/mp4tool/sidx"
 )
 
 func main() {
@@ -23,6 +31,22 @@ func main() {
 		dump.Main(args[1:])
 	case "psshdump":
 		psshdump.Main(args[1:])
+	case "extract":
+		extract.Main(args[1:])
+	case "fragment":
+		fragment.Main(args[1:])
+	case "defragment":
+		defragment.Main(args[1:])
+	case "faststart":
+		faststart.Main(args[1:])
+	case "cut":
+		cut.Main(args[1:])
+	case "concat":
+		concat.Main(args[1:])
+	case "remux":
+		remux.Main(args[1:])
+	case "sidx":
+		sidx.Main(args[1:])
 	case "alpha":
 		alpha(args[1:])
 	default:
@@ -52,6 +76,14 @@ func printUsage() {
 	fmt.Println("COMMAND_NAME:")
 	fmt.Println("  dump")
 	fmt.Println("  psshdump")
+	fmt.Println("  extract")
+	fmt.Println("  fragment")
+	fmt.Println("  defragment")
+	fmt.Println("  faststart")
+	fmt.Println("  cut")
+	fmt.Println("  concat")
+	fmt.Println("  remux")
+	fmt.Println("  sidx")
 	fmt.Println("  alpha edit")
 	fmt.Println("  alpha divide")
 }
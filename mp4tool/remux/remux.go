@@ -0,0 +1,62 @@
+package remux
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("remux", flag.ExitOnError)
+	keep := flagSet.String("keep", "", "comma-separated track IDs to keep, in the output order (all tracks by default)")
+	renumber := flagSet.Bool("renumber", false, "renumber the kept tracks from 1")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool remux [OPTIONS] INPUT.mp4 OUTPUT.mp4\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPath := flagSet.Args()[0]
+	outputPath := flagSet.Args()[1]
+
+	opts := &mp4.RemuxOptions{RenumberTrackIDs: *renumber}
+	if *keep != "" {
+		for _, s := range strings.Split(*keep, ",") {
+			trackID, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+			if err != nil {
+				fmt.Println("Error: invalid track ID:", s)
+				os.Exit(1)
+			}
+			opts.TrackIDs = append(opts.TrackIDs, uint32(trackID))
+		}
+	}
+
+	if err := remuxFile(inputPath, outputPath, opts); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func remuxFile(inputPath, outputPath string, opts *mp4.RemuxOptions) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	r := bufseekio.NewReadSeeker(inputFile, 128*1024, 4)
+	return mp4.Remux(r, outputFile, opts)
+}
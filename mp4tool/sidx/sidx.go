@@ -0,0 +1,52 @@
+package sidx
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/abema/go-mp4"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+func Main(args []string) {
+	flagSet := flag.NewFlagSet("sidx", flag.ExitOnError)
+	trackID := flagSet.Uint("track", 0, "track ID to index (the first video track by default)")
+	maxReferences := flagSet.Int("max-references", 0, "maximum number of references of each sidx box; hierarchical sidx boxes are written when exceeded")
+	flagSet.Parse(args)
+
+	if len(flagSet.Args()) < 2 {
+		fmt.Printf("USAGE: mp4tool sidx [OPTIONS] INPUT.mp4 OUTPUT.mp4\n")
+		flagSet.PrintDefaults()
+		return
+	}
+
+	inputPath := flagSet.Args()[0]
+	outputPath := flagSet.Args()[1]
+
+	opts := &mp4.SidxOptions{
+		TrackID:       uint32(*trackID),
+		MaxReferences: *maxReferences,
+	}
+	if err := addSidx(inputPath, outputPath, opts); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func addSidx(inputPath, outputPath string, opts *mp4.SidxOptions) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	r := bufseekio.NewReadSeeker(inputFile, 128*1024, 4)
+	return mp4.AddSidx(r, outputFile, opts)
+}
@@ -0,0 +1,799 @@
+package mp4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/abema/go-mp4/aac"
+	"github.com/abema/go-mp4/avc"
+	"github.com/abema/go-mp4/nalu"
+)
+
+// MuxerMovieTimescale is the timescale of mvhd written by Muxer.
+const MuxerMovieTimescale = 1000
+
+// DefaultVideoTimescale is used for video tracks which are added with timescale 0.
+const DefaultVideoTimescale = 90000
+
+const muxerLengthSize = 4
+
+type muxerCodec int
+
+const (
+	muxerCodecAVC muxerCodec = iota
+	muxerCodecAAC
+)
+
+// Muxer builds a progressive MP4 file which consists of ftyp, mdat and moov.
+// Sample data is written into mdat as soon as WriteSample is called,
+// and moov is written when Close is called.
+// Consecutive samples of the same track are stored as a chunk,
+// so that the caller controls interleaving by the order of WriteSample calls.
+type Muxer struct {
+	w         *Writer
+	tracks    []*muxerTrack
+	offset    uint64
+	lastTrack *muxerTrack
+	buf       []byte
+	closed    bool
+}
+
+type muxerTrack struct {
+	trackID   uint32
+	codec     muxerCodec
+	timescale uint32
+	sps       []byte
+	pps       []byte
+	asc       *aac.AudioSpecificConfig
+	samples   []muxerSample
+	chunks    []muxerChunk
+}
+
+type muxerSample struct {
+	size   uint32
+	dts    int64
+	pts    int64
+	isSync bool
+}
+
+type muxerChunk struct {
+	offset                 uint64
+	sampleCount            uint32
+	sampleDescriptionIndex uint32
+}
+
+// NewMuxer writes ftyp box and the header of mdat box to w, and returns a new Muxer.
+func NewMuxer(w io.WriteSeeker) (*Muxer, error) {
+	m := &Muxer{w: NewWriter(w)}
+	ftyp := &Ftyp{
+		MajorBrand:   [4]byte{'i', 's', 'o', 'm'},
+		MinorVersion: 0x200,
+		CompatibleBrands: []CompatibleBrandElem{
+			{CompatibleBrand: [4]byte{'i', 's', 'o', 'm'}},
+			{CompatibleBrand: [4]byte{'i', 's', 'o', '2'}},
+			{CompatibleBrand: [4]byte{'a', 'v', 'c', '1'}},
+			{CompatibleBrand: [4]byte{'m', 'p', '4', '1'}},
+		},
+	}
+	if err := writeMuxBox(m.w, &muxBox{box: ftyp}); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if m.offset, err = startMdat(m.w); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddAVCTrack adds a H.264 video track and returns its track ID.
+// sps and pps are NAL units without start codes.
+// When they are nil, the first SPS and PPS found in the samples are used.
+// When timescale is 0, DefaultVideoTimescale is used.
+func (m *Muxer) AddAVCTrack(timescale uint32, sps, pps []byte) (uint32, error) {
+	if sps != nil {
+		if _, err := avc.ParseSPS(sps); err != nil {
+			return 0, err
+		}
+	}
+	if timescale == 0 {
+		timescale = DefaultVideoTimescale
+	}
+	return m.addTrack(&muxerTrack{
+		codec:     muxerCodecAVC,
+		timescale: timescale,
+		sps:       cloneBytes(sps),
+		pps:       cloneBytes(pps),
+	})
+}
+
+// AddAACTrack adds an AAC audio track and returns its track ID.
+// When asc is nil, it is derived from the first ADTS header found in the samples.
+// When timescale is 0, the sampling frequency is used.
+func (m *Muxer) AddAACTrack(timescale uint32, asc *aac.AudioSpecificConfig) (uint32, error) {
+	if timescale == 0 && asc != nil {
+		timescale = asc.SamplingFrequency
+	}
+	return m.addTrack(&muxerTrack{
+		codec:     muxerCodecAAC,
+		timescale: timescale,
+		asc:       asc,
+	})
+}
+
+func (m *Muxer) addTrack(track *muxerTrack) (uint32, error) {
+	if m.closed {
+		return 0, errors.New("muxer is already closed")
+	}
+	track.trackID = uint32(len(m.tracks) + 1)
+	m.tracks = append(m.tracks, track)
+	return track.trackID, nil
+}
+
+// WriteSample writes a sample to mdat.
+// dts and pts are represented in the timescale of the track,
+// and dts must not be less than the one of the previous sample of the same track.
+// For H.264 tracks, data is an access unit in Annex B byte stream format.
+// AUD and the parameter sets which are equal to the ones of the track are removed.
+// For AAC tracks, data is an ADTS frame or a raw AAC frame;
+// data which begins with the ADTS syncword is treated as an ADTS frame.
+func (m *Muxer) WriteSample(trackID uint32, data []byte, dts, pts int64, isSync bool) error {
+	if m.closed {
+		return errors.New("muxer is already closed")
+	}
+	if trackID == 0 || int(trackID) > len(m.tracks) {
+		return fmt.Errorf("track not found: trackID=%d", trackID)
+	}
+	track := m.tracks[trackID-1]
+	if n := len(track.samples); n != 0 && dts < track.samples[n-1].dts {
+		return fmt.Errorf("decoding timestamp goes backward: trackID=%d dts=%d", trackID, dts)
+	}
+
+	var payload []byte
+	var err error
+	switch track.codec {
+	case muxerCodecAVC:
+		payload, err = m.avcPayload(track, data)
+	case muxerCodecAAC:
+		payload, err = aacPayload(track, data)
+	}
+	if err != nil {
+		return err
+	}
+	if uint64(len(payload)) > math.MaxUint32 {
+		return fmt.Errorf("too large sample: size=%d", len(payload))
+	}
+
+	if _, err := m.w.Write(payload); err != nil {
+		return err
+	}
+	if m.lastTrack != track {
+		track.chunks = append(track.chunks, muxerChunk{offset: m.offset, sampleDescriptionIndex: 1})
+		m.lastTrack = track
+	}
+	track.chunks[len(track.chunks)-1].sampleCount++
+	track.samples = append(track.samples, muxerSample{
+		size:   uint32(len(payload)),
+		dts:    dts,
+		pts:    pts,
+		isSync: isSync,
+	})
+	m.offset += uint64(len(payload))
+	return nil
+}
+
+func (m *Muxer) avcPayload(track *muxerTrack, data []byte) ([]byte, error) {
+	nalus, err := nalu.SplitAnnexB(data)
+	if err != nil {
+		return nil, err
+	}
+	buf := m.buf[:0]
+	for _, n := range nalus {
+		if len(n) == 0 {
+			continue
+		}
+		switch nalu.CodecAVC.Type(n) {
+		case avc.NALUnitTypeAUD:
+			continue
+		case avc.NALUnitTypeSPS:
+			if track.sps == nil {
+				if _, err := avc.ParseSPS(n); err != nil {
+					return nil, err
+				}
+				track.sps = cloneBytes(n)
+			}
+			if bytes.Equal(track.sps, n) {
+				continue
+			}
+		case avc.NALUnitTypePPS:
+			if track.pps == nil {
+				track.pps = cloneBytes(n)
+			}
+			if bytes.Equal(track.pps, n) {
+				continue
+			}
+		}
+		if buf, err = nalu.AppendLengthPrefixed(buf, muxerLengthSize, n); err != nil {
+			return nil, err
+		}
+	}
+	m.buf = buf
+	return buf, nil
+}
+
+func aacPayload(track *muxerTrack, data []byte) ([]byte, error) {
+	if len(data) >= 2 && data[0] == 0xff && data[1]&0xf0 == 0xf0 {
+		header, err := aac.ParseADTSHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		if int(header.FrameLength) != len(data) {
+			return nil, fmt.Errorf("ADTS frame length mismatch: frameLength=%d size=%d", header.FrameLength, len(data))
+		}
+		if header.NumberOfRawDataBlocksInFrame != 0 {
+			return nil, errors.New("ADTS frame which has multiple raw data blocks is not supported")
+		}
+		if track.asc == nil {
+			track.asc = header.AudioSpecificConfig()
+			if track.timescale == 0 {
+				track.timescale = track.asc.SamplingFrequency
+			}
+		}
+		return data[header.HeaderSize():], nil
+	}
+	if track.asc == nil {
+		return nil, errors.New("AudioSpecificConfig is unknown")
+	}
+	return data, nil
+}
+
+// Close completes mdat box and writes moov box.
+// It does not close the underlying writer.
+func (m *Muxer) Close() error {
+	if m.closed {
+		return errors.New("muxer is already closed")
+	}
+	m.closed = true
+
+	moov, err := m.buildMoov()
+	if err != nil {
+		return err
+	}
+
+	if _, err := m.w.EndBox(); err != nil {
+		return err
+	}
+	return writeMuxBox(m.w, moov)
+}
+
+func (m *Muxer) buildMoov() (*muxBox, error) {
+	// presentation start time of the movie
+	var movieStart int64
+	var found bool
+	for _, track := range m.tracks {
+		if len(track.samples) == 0 {
+			continue
+		}
+		if start := track.presentationStart(); !found || start < movieStart {
+			movieStart = start
+			found = true
+		}
+	}
+
+	moov := &muxBox{box: &Moov{}}
+	var movieDuration uint64
+	for _, track := range m.tracks {
+		trak, duration, err := track.buildTrak(movieStart)
+		if err != nil {
+			return nil, err
+		}
+		moov.children = append(moov.children, trak)
+		if duration > movieDuration {
+			movieDuration = duration
+		}
+	}
+
+	mvhd := &Mvhd{
+		Timescale:   MuxerMovieTimescale,
+		Rate:        0x00010000,
+		Volume:      0x0100,
+		Matrix:      unityMatrix,
+		NextTrackID: uint32(len(m.tracks) + 1),
+	}
+	if movieDuration > math.MaxUint32 {
+		mvhd.SetVersion(1)
+		mvhd.DurationV1 = movieDuration
+	} else {
+		mvhd.DurationV0 = uint32(movieDuration)
+	}
+	moov.children = append([]*muxBox{{box: mvhd}}, moov.children...)
+	return moov, nil
+}
+
+var unityMatrix = [9]int32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// presentationStart returns the earliest presentation time in MuxerMovieTimescale.
+func (track *muxerTrack) presentationStart() int64 {
+	minPTS := track.samples[0].pts
+	for _, s := range track.samples {
+		if s.pts < minPTS {
+			minPTS = s.pts
+		}
+	}
+	return scaleTime(minPTS, track.timescale, MuxerMovieTimescale)
+}
+
+// durations returns the duration of each sample.
+// The last sample has the same duration as the previous one.
+func (track *muxerTrack) durations() []uint32 {
+	durations := make([]uint32, len(track.samples))
+	for i := 0; i+1 < len(track.samples); i++ {
+		durations[i] = uint32(track.samples[i+1].dts - track.samples[i].dts)
+	}
+	if n := len(durations); n >= 2 {
+		durations[n-1] = durations[n-2]
+	} else if n == 1 && track.codec == muxerCodecAAC {
+		// an AAC frame consists of 1024 samples
+		durations[0] = uint32(uint64(1024) * uint64(track.timescale) / uint64(track.asc.SamplingFrequency))
+	}
+	return durations
+}
+
+func (track *muxerTrack) buildTrak(movieStart int64) (*muxBox, uint64, error) {
+	if track.timescale == 0 {
+		return nil, 0, fmt.Errorf("timescale is unknown: trackID=%d", track.trackID)
+	}
+
+	var sampleEntry *muxBox
+	var err error
+	switch track.codec {
+	case muxerCodecAVC:
+		sampleEntry, err = track.buildAVCSampleEntry()
+	case muxerCodecAAC:
+		sampleEntry, err = track.buildAACSampleEntry()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	durations := track.durations()
+	var mediaDuration uint64
+	for _, d := range durations {
+		mediaDuration += uint64(d)
+	}
+
+	// edit list
+	var edits []ElstEntry
+	trackDuration := scaleUint(mediaDuration, track.timescale, MuxerMovieTimescale)
+	if len(track.samples) != 0 {
+		var mediaTime int64
+		minPTS := track.samples[0].pts
+		for _, s := range track.samples {
+			if s.pts < minPTS {
+				minPTS = s.pts
+			}
+		}
+		if minPTS > track.samples[0].dts {
+			mediaTime = minPTS - track.samples[0].dts
+		}
+		if delay := track.presentationStart() - movieStart; delay > 0 {
+			edits = append(edits, ElstEntry{
+				SegmentDurationV1: uint64(delay),
+				MediaTimeV1:       -1,
+				MediaRateInteger:  1,
+			})
+		}
+		if len(edits) != 0 || mediaTime != 0 {
+			segmentDuration := scaleUint(mediaDuration-uint64(mediaTime), track.timescale, MuxerMovieTimescale)
+			edits = append(edits, ElstEntry{
+				SegmentDurationV1: segmentDuration,
+				MediaTimeV1:       mediaTime,
+				MediaRateInteger:  1,
+			})
+			trackDuration = 0
+			for _, e := range edits {
+				trackDuration += e.SegmentDurationV1
+			}
+		}
+	}
+
+	tkhd := &Tkhd{
+		TrackID: track.trackID,
+		Matrix:  unityMatrix,
+	}
+	tkhd.SetFlags(0x000003) // track_enabled and track_in_movie
+	if trackDuration > math.MaxUint32 {
+		tkhd.SetVersion(1)
+		tkhd.DurationV1 = trackDuration
+	} else {
+		tkhd.DurationV0 = uint32(trackDuration)
+	}
+
+	mdhd := &Mdhd{
+		Timescale: track.timescale,
+		Language:  [3]byte{'u' - 0x60, 'n' - 0x60, 'd' - 0x60},
+	}
+	if mediaDuration > math.MaxUint32 {
+		mdhd.SetVersion(1)
+		mdhd.DurationV1 = mediaDuration
+	} else {
+		mdhd.DurationV0 = uint32(mediaDuration)
+	}
+
+	hdlr := &Hdlr{}
+	var mediaHeader *muxBox
+	switch track.codec {
+	case muxerCodecAVC:
+		sps, err := avc.ParseSPS(track.sps)
+		if err != nil {
+			return nil, 0, err
+		}
+		width := sps.Width()
+		if sarWidth, sarHeight := sps.SampleAspectRatio(); sarWidth != 0 && sarHeight != 0 {
+			width = width * uint32(sarWidth) / uint32(sarHeight)
+		}
+		tkhd.Width = width << 16
+		tkhd.Height = sps.Height() << 16
+		hdlr.HandlerType = [4]byte{'v', 'i', 'd', 'e'}
+		hdlr.Name = "VideoHandler"
+		vmhd := &Vmhd{}
+		vmhd.SetFlags(0x000001)
+		mediaHeader = &muxBox{box: vmhd}
+	case muxerCodecAAC:
+		tkhd.Volume = 0x0100
+		hdlr.HandlerType = [4]byte{'s', 'o', 'u', 'n'}
+		hdlr.Name = "SoundHandler"
+		mediaHeader = &muxBox{box: &Smhd{}}
+	}
+
+	url := &Url{}
+	url.SetFlags(UrlSelfContained)
+
+	trak := &muxBox{box: &Trak{}, children: []*muxBox{{box: tkhd}}}
+	if len(edits) != 0 {
+		trak.children = append(trak.children, &muxBox{box: &Edts{}, children: []*muxBox{{box: newElst(edits)}}})
+	}
+	trak.children = append(trak.children, &muxBox{box: &Mdia{}, children: []*muxBox{
+		{box: mdhd},
+		{box: hdlr},
+		{box: &Minf{}, children: []*muxBox{
+			mediaHeader,
+			{box: &Dinf{}, children: []*muxBox{
+				{box: &Dref{EntryCount: 1}, children: []*muxBox{{box: url}}},
+			}},
+			{box: &Stbl{}, children: append([]*muxBox{
+				{box: &Stsd{EntryCount: 1}, children: []*muxBox{sampleEntry}},
+			}, track.buildSampleTable(durations)...)},
+		}},
+	}})
+	return trak, trackDuration, nil
+}
+
+func (track *muxerTrack) buildAVCSampleEntry() (*muxBox, error) {
+	if track.sps == nil || track.pps == nil {
+		return nil, fmt.Errorf("SPS or PPS is not found: trackID=%d", track.trackID)
+	}
+	sps, err := avc.ParseSPS(track.sps)
+	if err != nil {
+		return nil, err
+	}
+
+	avcc := &AVCDecoderConfiguration{
+		AnyTypeBox:                 AnyTypeBox{Type: StrToBoxType("avcC")},
+		ConfigurationVersion:       1,
+		Profile:                    sps.ProfileIdc,
+		ProfileCompatibility:       track.sps[2],
+		Level:                      sps.LevelIdc,
+		Reserved:                   0x3f,
+		LengthSizeMinusOne:         muxerLengthSize - 1,
+		Reserved2:                  0x7,
+		NumOfSequenceParameterSets: 1,
+		SequenceParameterSets: []AVCParameterSet{
+			{Length: uint16(len(track.sps)), NALUnit: track.sps},
+		},
+		NumOfPictureParameterSets: 1,
+		PictureParameterSets: []AVCParameterSet{
+			{Length: uint16(len(track.pps)), NALUnit: track.pps},
+		},
+	}
+	switch sps.ProfileIdc {
+	case avc.ProfileHigh, avc.ProfileHigh10, avc.ProfileHigh422, 144:
+		avcc.HighProfileFieldsEnabled = true
+		avcc.Reserved3 = 0x3f
+		avcc.ChromaFormat = uint8(sps.ChromaFormatIdc)
+		avcc.Reserved4 = 0x1f
+		avcc.BitDepthLumaMinus8 = uint8(sps.BitDepthLumaMinus8)
+		avcc.Reserved5 = 0x1f
+		avcc.BitDepthChromaMinus8 = uint8(sps.BitDepthChromaMinus8)
+	}
+
+	return &muxBox{
+		box: &VisualSampleEntry{
+			SampleEntry: SampleEntry{
+				AnyTypeBox:         AnyTypeBox{Type: StrToBoxType("avc1")},
+				DataReferenceIndex: 1,
+			},
+			Width:           uint16(sps.Width()),
+			Height:          uint16(sps.Height()),
+			Horizresolution: 0x00480000,
+			Vertresolution:  0x00480000,
+			FrameCount:      1,
+			Depth:           0x0018,
+			PreDefined3:     -1,
+		},
+		children: []*muxBox{{box: avcc}},
+	}, nil
+}
+
+func (track *muxerTrack) buildAACSampleEntry() (*muxBox, error) {
+	if track.asc == nil {
+		return nil, fmt.Errorf("AudioSpecificConfig is unknown: trackID=%d", track.trackID)
+	}
+	asc, err := track.asc.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	var maxSize uint32
+	var totalSize uint64
+	bytesPerSecond := make(map[int64]uint64)
+	for _, s := range track.samples {
+		if s.size > maxSize {
+			maxSize = s.size
+		}
+		totalSize += uint64(s.size)
+		bytesPerSecond[s.dts/int64(track.timescale)] += uint64(s.size)
+	}
+	var avgBitrate, maxBitrate uint64
+	if n := len(track.samples); n >= 2 {
+		if duration := uint64(track.samples[n-1].dts - track.samples[0].dts); duration != 0 {
+			avgBitrate = totalSize * 8 * uint64(track.timescale) / duration
+		}
+	}
+	for _, b := range bytesPerSecond {
+		if b*8 > maxBitrate {
+			maxBitrate = b * 8
+		}
+	}
+	if maxBitrate < avgBitrate {
+		maxBitrate = avgBitrate
+	}
+
+	channelCount := uint16(track.asc.ChannelConfiguration)
+	switch track.asc.ChannelConfiguration {
+	case 0:
+		channelCount = 2
+	case 7:
+		channelCount = 8
+	}
+	var sampleRate uint32
+	if track.asc.SamplingFrequency <= 0xffff {
+		sampleRate = track.asc.SamplingFrequency << 16
+	}
+
+	decSpecificInfoSize := uint32(len(asc))
+	decoderConfigSize := 13 + 2 + decSpecificInfoSize
+	esSize := 3 + 2 + decoderConfigSize + 2 + 1
+	esds := &Esds{
+		Descriptors: []Descriptor{
+			{
+				Tag:          ESDescrTag,
+				Size:         esSize,
+				ESDescriptor: &ESDescriptor{ESID: uint16(track.trackID)},
+			},
+			{
+				Tag:  DecoderConfigDescrTag,
+				Size: decoderConfigSize,
+				DecoderConfigDescriptor: &DecoderConfigDescriptor{
+					ObjectTypeIndication: objectTypeMPEG4Audio,
+					StreamType:           0x05, // AudioStream
+					Reserved:             true,
+					BufferSizeDB:         maxSize,
+					MaxBitrate:           uint32(maxBitrate),
+					AvgBitrate:           uint32(avgBitrate),
+				},
+			},
+			{
+				Tag:  DecSpecificInfoTag,
+				Size: decSpecificInfoSize,
+				Data: asc,
+			},
+			{
+				Tag:  SLConfigDescrTag,
+				Size: 1,
+				Data: []byte{0x02}, // predefined: reserved for use in MP4 files
+			},
+		},
+	}
+
+	return &muxBox{
+		box: &AudioSampleEntry{
+			SampleEntry: SampleEntry{
+				AnyTypeBox:         AnyTypeBox{Type: StrToBoxType("mp4a")},
+				DataReferenceIndex: 1,
+			},
+			ChannelCount: channelCount,
+			SampleSize:   16,
+			SampleRate:   sampleRate,
+		},
+		children: []*muxBox{{box: esds}},
+	}, nil
+}
+
+func (track *muxerTrack) buildSampleTable(durations []uint32) []*muxBox {
+	// stts
+	stts := &Stts{}
+	for i, d := range durations {
+		if i != 0 && stts.Entries[len(stts.Entries)-1].SampleDelta == d {
+			stts.Entries[len(stts.Entries)-1].SampleCount++
+			continue
+		}
+		stts.Entries = append(stts.Entries, SttsEntry{SampleCount: 1, SampleDelta: d})
+	}
+	stts.EntryCount = uint32(len(stts.Entries))
+	boxes := []*muxBox{{box: stts}}
+
+	// ctts
+	var hasOffset, hasNegativeOffset bool
+	ctts := &Ctts{}
+	for i, s := range track.samples {
+		offset := s.pts - s.dts
+		if offset != 0 {
+			hasOffset = true
+		}
+		if offset < 0 {
+			hasNegativeOffset = true
+		}
+		if i != 0 && int64(ctts.Entries[len(ctts.Entries)-1].SampleOffsetV1) == offset {
+			ctts.Entries[len(ctts.Entries)-1].SampleCount++
+			continue
+		}
+		ctts.Entries = append(ctts.Entries, CttsEntry{
+			SampleCount:    1,
+			SampleOffsetV0: uint32(offset),
+			SampleOffsetV1: int32(offset),
+		})
+	}
+	ctts.EntryCount = uint32(len(ctts.Entries))
+	if hasNegativeOffset {
+		ctts.SetVersion(1)
+	}
+
+	// stss
+	stss := &Stss{}
+	for i, s := range track.samples {
+		if s.isSync {
+			stss.SampleNumber = append(stss.SampleNumber, uint32(i+1))
+		}
+	}
+	stss.EntryCount = uint32(len(stss.SampleNumber))
+
+	if len(stss.SampleNumber) != len(track.samples) {
+		boxes = append(boxes, &muxBox{box: stss})
+	}
+	if hasOffset {
+		boxes = append(boxes, &muxBox{box: ctts})
+	}
+
+	// stsc
+	stsc := &Stsc{}
+	for i, c := range track.chunks {
+		if n := len(stsc.Entries); n != 0 && stsc.Entries[n-1].SamplesPerChunk == c.sampleCount &&
+			stsc.Entries[n-1].SampleDescriptionIndex == c.sampleDescriptionIndex {
+			continue
+		}
+		stsc.Entries = append(stsc.Entries, StscEntry{
+			FirstChunk:             uint32(i + 1),
+			SamplesPerChunk:        c.sampleCount,
+			SampleDescriptionIndex: c.sampleDescriptionIndex,
+		})
+	}
+	stsc.EntryCount = uint32(len(stsc.Entries))
+	boxes = append(boxes, &muxBox{box: stsc})
+
+	// stsz
+	stsz := &Stsz{SampleCount: uint32(len(track.samples))}
+	constantSize := len(track.samples) != 0
+	for _, s := range track.samples {
+		if s.size != track.samples[0].size {
+			constantSize = false
+		}
+	}
+	if constantSize {
+		stsz.SampleSize = track.samples[0].size
+	} else {
+		for _, s := range track.samples {
+			stsz.EntrySize = append(stsz.EntrySize, s.size)
+		}
+	}
+	boxes = append(boxes, &muxBox{box: stsz})
+
+	// stco or co64
+	var large bool
+	for _, c := range track.chunks {
+		if c.offset > math.MaxUint32 {
+			large = true
+		}
+	}
+	if large {
+		co64 := &Co64{EntryCount: uint32(len(track.chunks))}
+		for _, c := range track.chunks {
+			co64.ChunkOffset = append(co64.ChunkOffset, c.offset)
+		}
+		boxes = append(boxes, &muxBox{box: co64})
+	} else {
+		stco := &Stco{EntryCount: uint32(len(track.chunks))}
+		for _, c := range track.chunks {
+			stco.ChunkOffset = append(stco.ChunkOffset, uint32(c.offset))
+		}
+		boxes = append(boxes, &muxBox{box: stco})
+	}
+	return boxes
+}
+
+// newElst returns elst box which has the specified edits.
+// Only SegmentDurationV1 and MediaTimeV1 of each edit have to be set,
+// and version 1 is used when they do not fit in 32 bits.
+func newElst(edits []ElstEntry) *Elst {
+	elst := &Elst{EntryCount: uint32(len(edits)), Entries: edits}
+	for i, e := range edits {
+		if e.SegmentDurationV1 > math.MaxUint32 || e.MediaTimeV1 > math.MaxInt32 || e.MediaTimeV1 < math.MinInt32 {
+			elst.SetVersion(1)
+		}
+		edits[i].SegmentDurationV0 = uint32(e.SegmentDurationV1)
+		edits[i].MediaTimeV0 = int32(e.MediaTimeV1)
+	}
+	return elst
+}
+
+// startMdat starts mdat box with the placeholder of the large size header.
+// It returns the offset of the mdat payload.
+func startMdat(w *Writer) (uint64, error) {
+	mdat, err := w.StartBoxWithPlaceholder(&BoxInfo{Type: BoxTypeMdat()})
+	if err != nil {
+		return 0, err
+	}
+	return mdat.Offset + mdat.HeaderSize, nil
+}
+
+// muxBox is a box to be written with its children.
+type muxBox struct {
+	box      IImmutableBox
+	children []*muxBox
+}
+
+func writeMuxBox(w *Writer, b *muxBox) error {
+	if _, err := w.StartBox(&BoxInfo{Type: b.box.GetType()}); err != nil {
+		return err
+	}
+	if _, err := Marshal(w, b.box, Context{}); err != nil {
+		return err
+	}
+	for _, child := range b.children {
+		if err := writeMuxBox(w, child); err != nil {
+			return err
+		}
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+// scaleTime converts t from a timescale to another.
+func scaleTime(t int64, from, to uint32) int64 {
+	return t * int64(to) / int64(from)
+}
+
+// scaleUint converts t from a timescale to another.
+func scaleUint(t uint64, from, to uint32) uint64 {
+	return t * uint64(to) / uint64(from)
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,248 @@
+package mp4
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/abema/go-mp4/aac"
+	"github.com/abema/go-mp4/nalu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func readSampleData(t *testing.T, r io.ReadSeeker, sample Sample) []byte {
+	data := make([]byte, sample.Size)
+	_, err := r.Seek(int64(sample.Offset), io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(r, data)
+	require.NoError(t, err)
+	return data
+}
+
+func TestMuxer(t *testing.T) {
+	src, err := os.Open("./_examples/sample.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+
+	videoSamples, err := ReadSamples(src, 1)
+	require.NoError(t, err)
+	audioSamples, err := ReadSamples(src, 2)
+	require.NoError(t, err)
+	bs, err := ExtractBoxWithPayload(src, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(),
+		BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("avc1"), StrToBoxType("avcC")})
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	parameterSets := bs[0].Payload.(*AVCDecoderConfiguration).ParameterSets()
+	asc := &aac.AudioSpecificConfig{
+		AudioObjectType:        aac.AudioObjectTypeAACLC,
+		SamplingFrequencyIndex: 4,
+		SamplingFrequency:      44100,
+		ChannelConfiguration:   2,
+	}
+
+	f, err := memfs.New().Create("muxed.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+
+	muxer, err := NewMuxer(f)
+	require.NoError(t, err)
+	videoTrackID, err := muxer.AddAVCTrack(10240, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), videoTrackID)
+	audioTrackID, err := muxer.AddAACTrack(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), audioTrackID)
+
+	// interleave samples by decoding time
+	aud := []byte{0x09, 0xf0}
+	var v, a int
+	for v < len(videoSamples) || a < len(audioSamples) {
+		if a >= len(audioSamples) || (v < len(videoSamples) &&
+			videoSamples[v].DecodeTime*44100 <= audioSamples[a].DecodeTime*10240) {
+			s := videoSamples[v]
+			data, err := nalu.ToAnnexB(readSampleData(t, src, s), 4)
+			require.NoError(t, err)
+			prefix := nalu.AppendAnnexB(nil, aud)
+			if s.IsSync {
+				prefix = nalu.AppendAnnexB(prefix, parameterSets...)
+			}
+			dts := int64(s.DecodeTime)
+			require.NoError(t, muxer.WriteSample(videoTrackID, append(prefix, data...), dts, dts+s.CompositionTimeOffset, s.IsSync))
+			v++
+		} else {
+			s := audioSamples[a]
+			data := readSampleData(t, src, s)
+			header, err := asc.ADTSHeader(len(data))
+			require.NoError(t, err)
+			dts := int64(s.DecodeTime)
+			require.NoError(t, muxer.WriteSample(audioTrackID, append(header.Marshal(), data...), dts, dts, true))
+			a++
+		}
+	}
+	require.NoError(t, muxer.Close())
+	assert.Error(t, muxer.Close())
+	assert.Error(t, muxer.WriteSample(videoTrackID, nil, 0, 0, true))
+
+	// samples
+	for _, tc := range []struct {
+		trackID  uint32
+		expected []Sample
+	}{
+		{trackID: videoTrackID, expected: videoSamples},
+		{trackID: audioTrackID, expected: audioSamples},
+	} {
+		samples, err := ReadSamples(f, tc.trackID)
+		require.NoError(t, err)
+		require.Len(t, samples, len(tc.expected))
+		for i := range samples {
+			assert.Equal(t, tc.expected[i].Size, samples[i].Size)
+			assert.Equal(t, tc.expected[i].DecodeTime, samples[i].DecodeTime)
+			assert.Equal(t, tc.expected[i].CompositionTimeOffset, samples[i].CompositionTimeOffset)
+			assert.Equal(t, tc.expected[i].IsSync, samples[i].IsSync)
+			assert.Equal(t, readSampleData(t, src, tc.expected[i]), readSampleData(t, f, samples[i]))
+		}
+	}
+
+	// boxes
+	bs, err = ExtractBoxesWithPayload(f, nil, []BoxPath{
+		{BoxTypeMoov(), BoxTypeMvhd()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeTkhd()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeEdts(), BoxTypeElst()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMdhd()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStss()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(),
+			StrToBoxType("mp4a"), BoxTypeEsds()},
+	})
+	require.NoError(t, err)
+	require.Len(t, bs, 8)
+	mvhd := bs[0].Payload.(*Mvhd)
+	assert.Equal(t, uint32(MuxerMovieTimescale), mvhd.Timescale)
+	// the last audio sample has the same duration as the previous one
+	audioDuration := audioSamples[43].DecodeTime + uint64(audioSamples[42].Duration)
+	assert.Equal(t, uint32(audioDuration*1000/44100), mvhd.DurationV0)
+	assert.Equal(t, uint32(3), mvhd.NextTrackID)
+
+	tkhd := bs[1].Payload.(*Tkhd)
+	assert.Equal(t, uint32(1), tkhd.TrackID)
+	assert.Equal(t, uint32(1000), tkhd.DurationV0)
+	assert.Equal(t, uint32(320<<16), tkhd.Width)
+	assert.Equal(t, uint32(180<<16), tkhd.Height)
+	elst := bs[2].Payload.(*Elst)
+	// presentation of the video track begins at 200ms
+	assert.Equal(t, []ElstEntry{
+		{SegmentDurationV0: 200, MediaTimeV0: -1, MediaRateInteger: 1},
+		{SegmentDurationV0: 800, MediaTimeV0: 2048, MediaRateInteger: 1},
+	}, elst.Entries)
+	mdhd := bs[3].Payload.(*Mdhd)
+	assert.Equal(t, uint32(10240), mdhd.Timescale)
+	assert.Equal(t, uint32(10240), mdhd.DurationV0)
+	assert.Equal(t, "und", string([]byte{mdhd.Language[0] + 0x60, mdhd.Language[1] + 0x60, mdhd.Language[2] + 0x60}))
+	stss := bs[4].Payload.(*Stss)
+	assert.Equal(t, []uint32{1}, stss.SampleNumber)
+
+	tkhd = bs[5].Payload.(*Tkhd)
+	assert.Equal(t, uint32(2), tkhd.TrackID)
+	assert.Equal(t, int16(0x0100), tkhd.Volume)
+	mdhd = bs[6].Payload.(*Mdhd)
+	assert.Equal(t, uint32(44100), mdhd.Timescale)
+	assert.Equal(t, uint32(audioDuration), mdhd.DurationV0)
+	assert.Equal(t, uint32(audioDuration*1000/44100), tkhd.DurationV0)
+	esds := bs[7].Payload.(*Esds)
+	config, err := audioSpecificConfigOf(esds)
+	require.NoError(t, err)
+	assert.Equal(t, asc, config)
+	require.Len(t, esds.Descriptors, 4)
+	assert.Equal(t, uint32(esds.Descriptors[1].Size+2+3+3), esds.Descriptors[0].Size)
+
+	// elementary stream round trip
+	info, err := ProbeFra(f)
+	require.NoError(t, err)
+	require.Len(t, info.Tracks, 2)
+	require.NotNil(t, info.Tracks[0].AVC)
+	assert.Equal(t, uint32(320), info.Tracks[0].AVC.Width)
+}
+
+func TestMuxerEmptyFirstTrack(t *testing.T) {
+	src, err := os.Open("./_examples/sample.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+
+	videoSamples, err := ReadSamples(src, 1)
+	require.NoError(t, err)
+	bs, err := ExtractBoxWithPayload(src, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(),
+		BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("avc1"), StrToBoxType("avcC")})
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	parameterSets := bs[0].Payload.(*AVCDecoderConfiguration).ParameterSets()
+
+	f, err := memfs.New().Create("muxed.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+
+	muxer, err := NewMuxer(f)
+	require.NoError(t, err)
+	// the audio track has no samples
+	_, err = muxer.AddAACTrack(0, &aac.AudioSpecificConfig{
+		AudioObjectType:        aac.AudioObjectTypeAACLC,
+		SamplingFrequencyIndex: 4,
+		SamplingFrequency:      44100,
+		ChannelConfiguration:   2,
+	})
+	require.NoError(t, err)
+	videoTrackID, err := muxer.AddAVCTrack(10240, nil, nil)
+	require.NoError(t, err)
+	for _, s := range videoSamples {
+		data, err := nalu.ToAnnexB(readSampleData(t, src, s), 4)
+		require.NoError(t, err)
+		var prefix []byte
+		if s.IsSync {
+			prefix = nalu.AppendAnnexB(prefix, parameterSets...)
+		}
+		dts := int64(s.DecodeTime)
+		require.NoError(t, muxer.WriteSample(videoTrackID, append(prefix, data...), dts, dts+s.CompositionTimeOffset, s.IsSync))
+	}
+	require.NoError(t, muxer.Close())
+
+	bs, err = ExtractBoxWithPayload(f, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeEdts(), BoxTypeElst()})
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	// the movie begins at the presentation start of the video track
+	assert.Equal(t, []ElstEntry{
+		{SegmentDurationV0: 800, MediaTimeV0: 2048, MediaRateInteger: 1},
+	}, bs[0].Payload.(*Elst).Entries)
+}
+
+func TestMuxerErrors(t *testing.T) {
+	f, err := memfs.New().Create("muxed.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+
+	muxer, err := NewMuxer(f)
+	require.NoError(t, err)
+	videoTrackID, err := muxer.AddAVCTrack(0, nil, nil)
+	require.NoError(t, err)
+	audioTrackID, err := muxer.AddAACTrack(0, nil)
+	require.NoError(t, err)
+
+	// unknown track
+	assert.Error(t, muxer.WriteSample(3, []byte{0x00, 0x00, 0x01, 0x09, 0xf0}, 0, 0, true))
+
+	// no start code
+	assert.Error(t, muxer.WriteSample(videoTrackID, []byte{0x09, 0xf0}, 0, 0, true))
+
+	// raw AAC frame without AudioSpecificConfig
+	assert.Error(t, muxer.WriteSample(audioTrackID, []byte{0x21, 0x00}, 0, 0, true))
+
+	// inconsistent ADTS frame length
+	assert.Error(t, muxer.WriteSample(audioTrackID, []byte{0xff, 0xf1, 0x50, 0x80, 0x0d, 0x7f, 0xfc, 0x21}, 0, 0, true))
+
+	// dts goes backward
+	require.NoError(t, muxer.WriteSample(audioTrackID, []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x1f, 0xfc, 0x21}, 1024, 1024, true))
+	assert.Error(t, muxer.WriteSample(audioTrackID, []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x1f, 0xfc, 0x21}, 0, 0, true))
+
+	// SPS and PPS are not found
+	require.NoError(t, muxer.WriteSample(videoTrackID, []byte{0x00, 0x00, 0x01, 0x65, 0x88}, 0, 0, true))
+	assert.Error(t, muxer.Close())
+}
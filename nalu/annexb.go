@@ -0,0 +1,119 @@
+package nalu
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var startCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+var ErrNoStartCode = errors.New("annex b byte stream does not begin with start code")
+
+// SplitAnnexB splits an Annex B byte stream into NAL units.
+// Start codes and trailing zero bytes are removed.
+// The returned slices refer to the underlying array of data.
+func SplitAnnexB(data []byte) ([][]byte, error) {
+	nalus := make([][]byte, 0, 4)
+	begin := -1
+	zeros := 0
+	for i, b := range data {
+		if b == 0x00 {
+			zeros++
+			continue
+		}
+		if b == 0x01 && zeros >= 2 {
+			if begin >= 0 && i-zeros > begin {
+				nalus = append(nalus, data[begin:i-zeros])
+			}
+			begin = i + 1
+		} else if begin < 0 {
+			return nil, ErrNoStartCode
+		}
+		zeros = 0
+	}
+	if begin >= 0 && len(data)-zeros > begin {
+		nalus = append(nalus, data[begin:len(data)-zeros])
+	}
+	return nalus, nil
+}
+
+// AppendAnnexB appends NAL units to dst with 4 bytes start codes.
+func AppendAnnexB(dst []byte, nalus ...[]byte) []byte {
+	for _, nalu := range nalus {
+		dst = append(dst, startCode...)
+		dst = append(dst, nalu...)
+	}
+	return dst
+}
+
+// AnnexBReader reads NAL units from an Annex B byte stream.
+type AnnexBReader struct {
+	r       *bufio.Reader
+	started bool
+}
+
+// NewAnnexBReader returns a new AnnexBReader.
+func NewAnnexBReader(r io.Reader) *AnnexBReader {
+	return &AnnexBReader{r: bufio.NewReader(r)}
+}
+
+// ReadNALUnit reads the next NAL unit.
+// It returns io.EOF when no more NAL unit is available.
+func (r *AnnexBReader) ReadNALUnit() ([]byte, error) {
+	var nalu []byte
+	zeros := 0
+	for {
+		b, err := r.r.ReadByte()
+		if err == io.EOF {
+			if len(nalu) == 0 {
+				return nil, io.EOF
+			}
+			return nalu, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if b == 0x00 {
+			zeros++
+			continue
+		}
+		if b == 0x01 && zeros >= 2 {
+			zeros = 0
+			if !r.started {
+				r.started = true
+				continue
+			}
+			if len(nalu) == 0 {
+				continue
+			}
+			return nalu, nil
+		}
+		if !r.started {
+			return nil, ErrNoStartCode
+		}
+		for ; zeros > 0; zeros-- {
+			nalu = append(nalu, 0x00)
+		}
+		nalu = append(nalu, b)
+	}
+}
+
+// AnnexBWriter writes NAL units as an Annex B byte stream.
+type AnnexBWriter struct {
+	w io.Writer
+}
+
+// NewAnnexBWriter returns a new AnnexBWriter.
+func NewAnnexBWriter(w io.Writer) *AnnexBWriter {
+	return &AnnexBWriter{w: w}
+}
+
+// WriteNALUnit writes a start code and the NAL unit.
+func (w *AnnexBWriter) WriteNALUnit(nalu []byte) error {
+	if _, err := w.w.Write(startCode); err != nil {
+		return err
+	}
+	_, err := w.w.Write(nalu)
+	return err
+}
@@ -0,0 +1,115 @@
+package nalu
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var annexBTestCases = []struct {
+	name     string
+	data     []byte
+	expected [][]byte
+	err      error
+}{
+	{
+		name:     "empty",
+		data:     []byte{},
+		expected: [][]byte{},
+	},
+	{
+		name: "4 bytes start codes",
+		data: []byte{
+			0x00, 0x00, 0x00, 0x01, 0x67, 0x64, 0x00, 0x1f,
+			0x00, 0x00, 0x00, 0x01, 0x68, 0xeb,
+		},
+		expected: [][]byte{{0x67, 0x64, 0x00, 0x1f}, {0x68, 0xeb}},
+	},
+	{
+		name: "3 bytes start codes and trailing zeros",
+		data: []byte{
+			0x00, 0x00, 0x01, 0x09, 0xf0,
+			0x00, 0x00, 0x01, 0x65, 0x88, 0x00, 0x01, 0x00, 0x00, 0x03, 0x01,
+			0x00, 0x00, 0x00, 0x00, 0x01, 0x06, 0x05,
+			0x00, 0x00,
+		},
+		expected: [][]byte{
+			{0x09, 0xf0},
+			{0x65, 0x88, 0x00, 0x01, 0x00, 0x00, 0x03, 0x01},
+			{0x06, 0x05},
+		},
+	},
+	{
+		name: "leading zeros and empty nal units",
+		data: []byte{
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			0x00, 0x00, 0x01, 0x41, 0x9a,
+		},
+		expected: [][]byte{{0x41, 0x9a}},
+	},
+	{
+		name: "no start code",
+		data: []byte{0x00, 0x67, 0x00, 0x00, 0x01, 0x68},
+		err:  ErrNoStartCode,
+	},
+	{
+		name:     "zeros only",
+		data:     []byte{0x00, 0x00, 0x00},
+		expected: [][]byte{},
+	},
+}
+
+func TestSplitAnnexB(t *testing.T) {
+	for _, tc := range annexBTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nalus, err := SplitAnnexB(tc.data)
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, nalus)
+		})
+	}
+}
+
+func TestAnnexBReader(t *testing.T) {
+	for _, tc := range annexBTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewAnnexBReader(bytes.NewReader(tc.data))
+			nalus := make([][]byte, 0)
+			for {
+				nalu, err := r.ReadNALUnit()
+				if err == io.EOF {
+					break
+				}
+				if tc.err != nil {
+					assert.Equal(t, tc.err, err)
+					return
+				}
+				require.NoError(t, err)
+				nalus = append(nalus, nalu)
+			}
+			assert.Equal(t, tc.expected, nalus)
+		})
+	}
+}
+
+func TestAnnexBWriter(t *testing.T) {
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x64,
+		0x00, 0x00, 0x00, 0x01, 0x68,
+	}, AppendAnnexB(nil, []byte{0x67, 0x64}, []byte{0x68}))
+
+	buf := bytes.NewBuffer(nil)
+	w := NewAnnexBWriter(buf)
+	require.NoError(t, w.WriteNALUnit([]byte{0x67, 0x64}))
+	require.NoError(t, w.WriteNALUnit([]byte{0x68}))
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x64,
+		0x00, 0x00, 0x00, 0x01, 0x68,
+	}, buf.Bytes())
+}
@@ -0,0 +1,73 @@
+package nalu
+
+// ToAnnexB converts a sample consisting of length-prefixed NAL units into an Annex B byte stream.
+func ToAnnexB(sample []byte, lengthSize int) ([]byte, error) {
+	nalus, err := SplitLengthPrefixed(sample, lengthSize)
+	if err != nil {
+		return nil, err
+	}
+	return AppendAnnexB(make([]byte, 0, len(sample)+len(nalus)*4), nalus...), nil
+}
+
+// ToLengthPrefixed converts an Annex B byte stream into length-prefixed NAL units.
+func ToLengthPrefixed(data []byte, lengthSize int) ([]byte, error) {
+	nalus, err := SplitAnnexB(data)
+	if err != nil {
+		return nil, err
+	}
+	return AppendLengthPrefixed(make([]byte, 0, len(data)+len(nalus)*lengthSize), lengthSize, nalus...)
+}
+
+// ConvertLengthSize re-prefixes length-prefixed NAL units with another length size.
+func ConvertLengthSize(sample []byte, from, to int) ([]byte, error) {
+	nalus, err := SplitLengthPrefixed(sample, from)
+	if err != nil {
+		return nil, err
+	}
+	return AppendLengthPrefixed(make([]byte, 0, len(sample)+len(nalus)*(to-from)), to, nalus...)
+}
+
+// AnnexBConverter converts samples in MP4 files into an Annex B byte stream
+// which can be decoded without the decoder configuration record.
+//
+// For AVC, ParameterSets can be obtained by mp4.AVCDecoderConfiguration.ParameterSets.
+// For HEVC, it can be obtained by mp4.HEVCDecoderConfiguration.ParameterSets.
+type AnnexBConverter struct {
+	Codec Codec
+
+	// LengthSize is LengthSizeMinusOne of the decoder configuration record plus one.
+	LengthSize int
+
+	// ParameterSets are inserted before every key frame which carries no parameter set.
+	ParameterSets [][]byte
+}
+
+// Convert appends the Annex B representation of the sample to dst.
+// Parameter sets are inserted after access unit delimiters.
+func (c *AnnexBConverter) Convert(dst []byte, sample []byte) ([]byte, error) {
+	nalus, err := SplitLengthPrefixed(sample, c.LengthSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyFrame, hasParameterSets bool
+	for _, nalu := range nalus {
+		t := c.Codec.Type(nalu)
+		if c.Codec.IsVCL(t) && c.Codec.IsKeyFrame(t) {
+			keyFrame = true
+		} else if c.Codec.IsParameterSet(t) {
+			hasParameterSets = true
+		}
+	}
+	if !keyFrame || hasParameterSets {
+		return AppendAnnexB(dst, nalus...), nil
+	}
+
+	i := 0
+	for i < len(nalus) && c.Codec.IsAUD(c.Codec.Type(nalus[i])) {
+		i++
+	}
+	dst = AppendAnnexB(dst, nalus[:i]...)
+	dst = AppendAnnexB(dst, c.ParameterSets...)
+	return AppendAnnexB(dst, nalus[i:]...), nil
+}
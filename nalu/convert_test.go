@@ -0,0 +1,144 @@
+package nalu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToAnnexB(t *testing.T) {
+	data, err := ToAnnexB([]byte{
+		0x00, 0x00, 0x00, 0x02, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x03, 0x65, 0x88, 0x84,
+	}, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84,
+	}, data)
+
+	_, err = ToAnnexB([]byte{0x00, 0x00, 0x00, 0x03, 0x09, 0xf0}, 4)
+	assert.Equal(t, ErrTruncated, err)
+}
+
+func TestToLengthPrefixed(t *testing.T) {
+	data, err := ToLengthPrefixed([]byte{
+		0x00, 0x00, 0x01, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84,
+	}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x00, 0x02, 0x09, 0xf0,
+		0x00, 0x03, 0x65, 0x88, 0x84,
+	}, data)
+
+	_, err = ToLengthPrefixed([]byte{0x09, 0xf0}, 2)
+	assert.Equal(t, ErrNoStartCode, err)
+}
+
+func TestConvertLengthSize(t *testing.T) {
+	data, err := ConvertLengthSize([]byte{
+		0x00, 0x02, 0x09, 0xf0,
+		0x00, 0x03, 0x65, 0x88, 0x84,
+	}, 2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x02, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x03, 0x65, 0x88, 0x84,
+	}, data)
+
+	data, err = ConvertLengthSize(data, 4, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x02, 0x09, 0xf0,
+		0x03, 0x65, 0x88, 0x84,
+	}, data)
+}
+
+func TestAnnexBConverter(t *testing.T) {
+	sps := []byte{0x67, 0x64, 0x00, 0x0c}
+	pps := []byte{0x68, 0xeb, 0xec}
+	c := &AnnexBConverter{
+		Codec:         CodecAVC,
+		LengthSize:    4,
+		ParameterSets: [][]byte{sps, pps},
+	}
+
+	testCases := []struct {
+		name     string
+		sample   []byte
+		expected []byte
+	}{
+		{
+			name: "IDR with AUD",
+			sample: []byte{
+				0x00, 0x00, 0x00, 0x02, 0x09, 0xf0,
+				0x00, 0x00, 0x00, 0x02, 0x65, 0x88,
+			},
+			expected: []byte{
+				0x00, 0x00, 0x00, 0x01, 0x09, 0xf0,
+				0x00, 0x00, 0x00, 0x01, 0x67, 0x64, 0x00, 0x0c,
+				0x00, 0x00, 0x00, 0x01, 0x68, 0xeb, 0xec,
+				0x00, 0x00, 0x00, 0x01, 0x65, 0x88,
+			},
+		},
+		{
+			name: "IDR with SEI",
+			sample: []byte{
+				0x00, 0x00, 0x00, 0x02, 0x06, 0x05,
+				0x00, 0x00, 0x00, 0x02, 0x65, 0x88,
+			},
+			expected: []byte{
+				0x00, 0x00, 0x00, 0x01, 0x67, 0x64, 0x00, 0x0c,
+				0x00, 0x00, 0x00, 0x01, 0x68, 0xeb, 0xec,
+				0x00, 0x00, 0x00, 0x01, 0x06, 0x05,
+				0x00, 0x00, 0x00, 0x01, 0x65, 0x88,
+			},
+		},
+		{
+			name: "IDR with in-band parameter sets",
+			sample: []byte{
+				0x00, 0x00, 0x00, 0x02, 0x67, 0x4d,
+				0x00, 0x00, 0x00, 0x02, 0x65, 0x88,
+			},
+			expected: []byte{
+				0x00, 0x00, 0x00, 0x01, 0x67, 0x4d,
+				0x00, 0x00, 0x00, 0x01, 0x65, 0x88,
+			},
+		},
+		{
+			name: "non-IDR",
+			sample: []byte{
+				0x00, 0x00, 0x00, 0x02, 0x41, 0x9a,
+			},
+			expected: []byte{
+				0x00, 0x00, 0x00, 0x01, 0x41, 0x9a,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst, err := c.Convert([]byte{0xff}, tc.sample)
+			require.NoError(t, err)
+			assert.Equal(t, append([]byte{0xff}, tc.expected...), dst)
+		})
+	}
+
+	hevcConverter := &AnnexBConverter{
+		Codec:         CodecHEVC,
+		LengthSize:    2,
+		ParameterSets: [][]byte{{0x40, 0x01}, {0x42, 0x01}, {0x44, 0x01}},
+	}
+	dst, err := hevcConverter.Convert(nil, []byte{0x00, 0x03, 0x2a, 0x01, 0xaf})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x40, 0x01,
+		0x00, 0x00, 0x00, 0x01, 0x42, 0x01,
+		0x00, 0x00, 0x00, 0x01, 0x44, 0x01,
+		0x00, 0x00, 0x00, 0x01, 0x2a, 0x01, 0xaf,
+	}, dst)
+
+	_, err = hevcConverter.Convert(nil, []byte{0x00, 0x03, 0x2a, 0x01})
+	assert.Equal(t, ErrTruncated, err)
+}
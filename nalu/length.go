@@ -0,0 +1,179 @@
+package nalu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrInvalidLengthSize = errors.New("invalid length size")
+	ErrTruncated         = errors.New("nal unit is truncated")
+)
+
+// Reader is the interface of NAL unit readers.
+type Reader interface {
+	ReadNALUnit() ([]byte, error)
+}
+
+// Writer is the interface of NAL unit writers.
+type Writer interface {
+	WriteNALUnit(nalu []byte) error
+}
+
+// Copy copies all NAL units from src to dst until src reaches io.EOF,
+// and returns the number of copied NAL units.
+func Copy(dst Writer, src Reader) (int, error) {
+	n := 0
+	for {
+		nalu, err := src.ReadNALUnit()
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		if err := dst.WriteNALUnit(nalu); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func checkLengthSize(lengthSize int) error {
+	switch lengthSize {
+	case 1, 2, 4:
+		return nil
+	}
+	return ErrInvalidLengthSize
+}
+
+func readLength(b []byte) uint32 {
+	var length uint32
+	for _, v := range b {
+		length = length<<8 | uint32(v)
+	}
+	return length
+}
+
+// SplitLengthPrefixed splits a sample which consists of length-prefixed NAL units.
+// lengthSize is LengthSizeMinusOne of avcC or hvcC plus one.
+// The returned slices refer to the underlying array of data.
+func SplitLengthPrefixed(data []byte, lengthSize int) ([][]byte, error) {
+	if err := checkLengthSize(lengthSize); err != nil {
+		return nil, err
+	}
+	nalus := make([][]byte, 0, 4)
+	for len(data) != 0 {
+		if len(data) < lengthSize {
+			return nil, ErrTruncated
+		}
+		length := readLength(data[:lengthSize])
+		data = data[lengthSize:]
+		if uint64(len(data)) < uint64(length) {
+			return nil, ErrTruncated
+		}
+		nalus = append(nalus, data[:length])
+		data = data[length:]
+	}
+	return nalus, nil
+}
+
+// AppendLengthPrefixed appends NAL units to dst with length prefixes.
+func AppendLengthPrefixed(dst []byte, lengthSize int, nalus ...[]byte) ([]byte, error) {
+	if err := checkLengthSize(lengthSize); err != nil {
+		return nil, err
+	}
+	for _, nalu := range nalus {
+		if err := checkNALUnitSize(nalu, lengthSize); err != nil {
+			return nil, err
+		}
+		dst = appendLength(dst, uint32(len(nalu)), lengthSize)
+		dst = append(dst, nalu...)
+	}
+	return dst, nil
+}
+
+func checkNALUnitSize(nalu []byte, lengthSize int) error {
+	if uint64(len(nalu)) >= uint64(1)<<(8*uint(lengthSize)) {
+		return fmt.Errorf("nal unit is too large for %d bytes length: size=%d", lengthSize, len(nalu))
+	}
+	return nil
+}
+
+func appendLength(dst []byte, length uint32, lengthSize int) []byte {
+	for i := lengthSize - 1; i >= 0; i-- {
+		dst = append(dst, byte(length>>(8*uint(i))))
+	}
+	return dst
+}
+
+// initialBufferSize is the capacity which is allocated before reading a NAL unit.
+const initialBufferSize = 64 * 1024
+
+// LengthPrefixedReader reads length-prefixed NAL units.
+type LengthPrefixedReader struct {
+	r          io.Reader
+	lengthSize int
+	buf        [4]byte
+}
+
+// NewLengthPrefixedReader returns a new LengthPrefixedReader.
+func NewLengthPrefixedReader(r io.Reader, lengthSize int) (*LengthPrefixedReader, error) {
+	if err := checkLengthSize(lengthSize); err != nil {
+		return nil, err
+	}
+	return &LengthPrefixedReader{r: r, lengthSize: lengthSize}, nil
+}
+
+// ReadNALUnit reads the next NAL unit.
+// It returns io.EOF when no more NAL unit is available.
+func (r *LengthPrefixedReader) ReadNALUnit() ([]byte, error) {
+	prefix := r.buf[:r.lengthSize]
+	if _, err := io.ReadFull(r.r, prefix); err == io.ErrUnexpectedEOF {
+		return nil, ErrTruncated
+	} else if err != nil {
+		return nil, err
+	}
+	// the buffer grows as the data arrives, so that a broken length does not allocate a large buffer
+	length := int64(readLength(prefix))
+	capacity := length
+	if capacity > initialBufferSize {
+		capacity = initialBufferSize
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, capacity))
+	if _, err := io.CopyN(buf, r.r, length); err == io.EOF {
+		return nil, ErrTruncated
+	} else if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LengthPrefixedWriter writes length-prefixed NAL units.
+type LengthPrefixedWriter struct {
+	w          io.Writer
+	lengthSize int
+	buf        [4]byte
+}
+
+// NewLengthPrefixedWriter returns a new LengthPrefixedWriter.
+func NewLengthPrefixedWriter(w io.Writer, lengthSize int) (*LengthPrefixedWriter, error) {
+	if err := checkLengthSize(lengthSize); err != nil {
+		return nil, err
+	}
+	return &LengthPrefixedWriter{w: w, lengthSize: lengthSize}, nil
+}
+
+// WriteNALUnit writes the length prefix and the NAL unit.
+func (w *LengthPrefixedWriter) WriteNALUnit(nalu []byte) error {
+	if err := checkNALUnitSize(nalu, w.lengthSize); err != nil {
+		return err
+	}
+	prefix := appendLength(w.buf[:0], uint32(len(nalu)), w.lengthSize)
+	if _, err := w.w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := w.w.Write(nalu)
+	return err
+}
@@ -0,0 +1,179 @@
+package nalu
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitLengthPrefixed(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       []byte
+		lengthSize int
+		expected   [][]byte
+		err        error
+	}{
+		{
+			name:       "1 byte",
+			data:       []byte{0x02, 0x09, 0xf0, 0x01, 0x65},
+			lengthSize: 1,
+			expected:   [][]byte{{0x09, 0xf0}, {0x65}},
+		},
+		{
+			name:       "2 bytes",
+			data:       []byte{0x00, 0x02, 0x09, 0xf0, 0x00, 0x00, 0x00, 0x01, 0x65},
+			lengthSize: 2,
+			expected:   [][]byte{{0x09, 0xf0}, {}, {0x65}},
+		},
+		{
+			name:       "4 bytes",
+			data:       []byte{0x00, 0x00, 0x00, 0x02, 0x09, 0xf0, 0x00, 0x00, 0x00, 0x01, 0x65},
+			lengthSize: 4,
+			expected:   [][]byte{{0x09, 0xf0}, {0x65}},
+		},
+		{
+			name:       "empty",
+			data:       []byte{},
+			lengthSize: 4,
+			expected:   [][]byte{},
+		},
+		{
+			name:       "truncated prefix",
+			data:       []byte{0x00, 0x00, 0x00, 0x02, 0x09, 0xf0, 0x00, 0x00},
+			lengthSize: 4,
+			err:        ErrTruncated,
+		},
+		{
+			name:       "truncated payload",
+			data:       []byte{0x00, 0x00, 0x00, 0x03, 0x09, 0xf0},
+			lengthSize: 4,
+			err:        ErrTruncated,
+		},
+		{
+			name:       "invalid length size",
+			data:       []byte{0x00, 0x00, 0x02, 0x09, 0xf0},
+			lengthSize: 3,
+			err:        ErrInvalidLengthSize,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nalus, err := SplitLengthPrefixed(tc.data, tc.lengthSize)
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, nalus)
+			}
+
+			r, err := NewLengthPrefixedReader(bytes.NewReader(tc.data), tc.lengthSize)
+			if tc.err == ErrInvalidLengthSize {
+				assert.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+			nalus = make([][]byte, 0)
+			for {
+				nalu, err := r.ReadNALUnit()
+				if err == io.EOF {
+					break
+				} else if tc.err != nil && err != nil {
+					assert.Equal(t, tc.err, err)
+					return
+				}
+				require.NoError(t, err)
+				nalus = append(nalus, nalu)
+			}
+			require.NoError(t, tc.err)
+			assert.Equal(t, tc.expected, nalus)
+		})
+	}
+}
+
+func TestAppendLengthPrefixed(t *testing.T) {
+	data, err := AppendLengthPrefixed([]byte{0xff}, 2, []byte{0x09, 0xf0}, []byte{0x65})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0x00, 0x02, 0x09, 0xf0, 0x00, 0x01, 0x65}, data)
+
+	_, err = AppendLengthPrefixed(nil, 1, make([]byte, 256))
+	assert.Error(t, err)
+
+	_, err = AppendLengthPrefixed(nil, 0, []byte{0x65})
+	assert.Equal(t, ErrInvalidLengthSize, err)
+}
+
+func TestLengthPrefixedWriter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w, err := NewLengthPrefixedWriter(buf, 4)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteNALUnit([]byte{0x09, 0xf0}))
+	require.NoError(t, w.WriteNALUnit([]byte{0x65}))
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x02, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x01, 0x65,
+	}, buf.Bytes())
+
+	w, err = NewLengthPrefixedWriter(buf, 1)
+	require.NoError(t, err)
+	assert.Error(t, w.WriteNALUnit(make([]byte, 256)))
+
+	_, err = NewLengthPrefixedWriter(buf, 8)
+	assert.Equal(t, ErrInvalidLengthSize, err)
+}
+
+func TestLengthPrefixedReader(t *testing.T) {
+	r, err := NewLengthPrefixedReader(bytes.NewReader([]byte{
+		0x00, 0x00, 0x00, 0x02, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x00,
+		0xff, 0xff, 0xff, 0xff, 0x65, 0x88, 0x84,
+	}), 4)
+	require.NoError(t, err)
+	nalu, err := r.ReadNALUnit()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x09, 0xf0}, nalu)
+	nalu, err = r.ReadNALUnit()
+	require.NoError(t, err)
+	assert.Empty(t, nalu)
+	// the broken length does not allocate 4 GiB
+	_, err = r.ReadNALUnit()
+	assert.Equal(t, ErrTruncated, err)
+
+	r, err = NewLengthPrefixedReader(bytes.NewReader([]byte{0x00}), 2)
+	require.NoError(t, err)
+	_, err = r.ReadNALUnit()
+	assert.Equal(t, ErrTruncated, err)
+}
+
+func TestCopy(t *testing.T) {
+	annexB := []byte{
+		0x00, 0x00, 0x00, 0x01, 0x09, 0xf0,
+		0x00, 0x00, 0x01, 0x65, 0x88, 0x84,
+	}
+	lengthPrefixed := []byte{
+		0x00, 0x02, 0x09, 0xf0,
+		0x00, 0x03, 0x65, 0x88, 0x84,
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w, err := NewLengthPrefixedWriter(buf, 2)
+	require.NoError(t, err)
+	n, err := Copy(w, NewAnnexBReader(bytes.NewReader(annexB)))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, lengthPrefixed, buf.Bytes())
+
+	r, err := NewLengthPrefixedReader(bytes.NewReader(lengthPrefixed), 2)
+	require.NoError(t, err)
+	buf = bytes.NewBuffer(nil)
+	n, err = Copy(NewAnnexBWriter(buf), r)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x09, 0xf0,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84,
+	}, buf.Bytes())
+}
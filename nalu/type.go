@@ -0,0 +1,121 @@
+package nalu
+
+import (
+	"fmt"
+
+	"github.com/abema/go-mp4/avc"
+	"github.com/abema/go-mp4/hevc"
+)
+
+// Codec specifies the syntax of NAL unit headers.
+type Codec int
+
+const (
+	CodecAVC Codec = iota
+	CodecHEVC
+)
+
+// Type returns nal_unit_type of the NAL unit.
+// It returns 0 for an empty NAL unit.
+func (c Codec) Type(nalu []byte) uint8 {
+	if len(nalu) == 0 {
+		return 0
+	}
+	if c == CodecHEVC {
+		return (nalu[0] >> 1) & 0x3f
+	}
+	return nalu[0] & 0x1f
+}
+
+// IsVCL returns whether the NAL unit type is a coded slice.
+func (c Codec) IsVCL(nalUnitType uint8) bool {
+	if c == CodecHEVC {
+		return nalUnitType < 32
+	}
+	return nalUnitType >= avc.NALUnitTypeNonIDR && nalUnitType <= avc.NALUnitTypeIDR
+}
+
+// IsKeyFrame returns whether the NAL unit type is a random access point:
+// IDR for AVC, and IRAP for HEVC.
+func (c Codec) IsKeyFrame(nalUnitType uint8) bool {
+	if c == CodecHEVC {
+		return hevc.IsIRAP(nalUnitType)
+	}
+	return nalUnitType == avc.NALUnitTypeIDR
+}
+
+// IsParameterSet returns whether the NAL unit type is VPS, SPS or PPS.
+func (c Codec) IsParameterSet(nalUnitType uint8) bool {
+	if c == CodecHEVC {
+		return nalUnitType == hevc.NALUnitTypeVPS ||
+			nalUnitType == hevc.NALUnitTypeSPS ||
+			nalUnitType == hevc.NALUnitTypePPS
+	}
+	return nalUnitType == avc.NALUnitTypeSPS ||
+		nalUnitType == avc.NALUnitTypePPS ||
+		nalUnitType == avc.NALUnitTypeSPSExt
+}
+
+// IsAUD returns whether the NAL unit type is access unit delimiter.
+func (c Codec) IsAUD(nalUnitType uint8) bool {
+	if c == CodecHEVC {
+		return nalUnitType == hevc.NALUnitTypeAUD
+	}
+	return nalUnitType == avc.NALUnitTypeAUD
+}
+
+var avcTypeNames = map[uint8]string{
+	avc.NALUnitTypeNonIDR:      "NonIDR",
+	2:                          "PartitionA",
+	3:                          "PartitionB",
+	4:                          "PartitionC",
+	avc.NALUnitTypeIDR:         "IDR",
+	avc.NALUnitTypeSEI:         "SEI",
+	avc.NALUnitTypeSPS:         "SPS",
+	avc.NALUnitTypePPS:         "PPS",
+	avc.NALUnitTypeAUD:         "AUD",
+	avc.NALUnitTypeEndOfSeq:    "EndOfSeq",
+	avc.NALUnitTypeEndOfStream: "EndOfStream",
+	avc.NALUnitTypeFillerData:  "FillerData",
+	avc.NALUnitTypeSPSExt:      "SPSExt",
+}
+
+var hevcTypeNames = map[uint8]string{
+	0:                         "TRAIL_N",
+	1:                         "TRAIL_R",
+	2:                         "TSA_N",
+	3:                         "TSA_R",
+	4:                         "STSA_N",
+	5:                         "STSA_R",
+	6:                         "RADL_N",
+	7:                         "RADL_R",
+	8:                         "RASL_N",
+	9:                         "RASL_R",
+	hevc.NALUnitTypeBLAWLP:    "BLA_W_LP",
+	hevc.NALUnitTypeBLAWRADL:  "BLA_W_RADL",
+	hevc.NALUnitTypeBLANLP:    "BLA_N_LP",
+	hevc.NALUnitTypeIDRWRADL:  "IDR_W_RADL",
+	hevc.NALUnitTypeIDRNLP:    "IDR_N_LP",
+	hevc.NALUnitTypeCRA:       "CRA_NUT",
+	hevc.NALUnitTypeVPS:       "VPS",
+	hevc.NALUnitTypeSPS:       "SPS",
+	hevc.NALUnitTypePPS:       "PPS",
+	hevc.NALUnitTypeAUD:       "AUD",
+	hevc.NALUnitTypeEOS:       "EOS",
+	hevc.NALUnitTypeEOB:       "EOB",
+	hevc.NALUnitTypeFD:        "FD",
+	hevc.NALUnitTypePrefixSEI: "PREFIX_SEI",
+	hevc.NALUnitTypeSuffixSEI: "SUFFIX_SEI",
+}
+
+// TypeName returns the name of the NAL unit type.
+func (c Codec) TypeName(nalUnitType uint8) string {
+	names := avcTypeNames
+	if c == CodecHEVC {
+		names = hevcTypeNames
+	}
+	if name, ok := names[nalUnitType]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%d)", nalUnitType)
+}
@@ -0,0 +1,73 @@
+package nalu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecAVC(t *testing.T) {
+	testCases := []struct {
+		nalu         []byte
+		typ          uint8
+		name         string
+		vcl          bool
+		keyFrame     bool
+		parameterSet bool
+		aud          bool
+	}{
+		{nalu: []byte{0x09, 0xf0}, typ: 9, name: "AUD", aud: true},
+		{nalu: []byte{0x67, 0x64}, typ: 7, name: "SPS", parameterSet: true},
+		{nalu: []byte{0x68, 0xeb}, typ: 8, name: "PPS", parameterSet: true},
+		{nalu: []byte{0x06, 0x05}, typ: 6, name: "SEI"},
+		{nalu: []byte{0x65, 0x88}, typ: 5, name: "IDR", vcl: true, keyFrame: true},
+		{nalu: []byte{0x41, 0x9a}, typ: 1, name: "NonIDR", vcl: true},
+		{nalu: []byte{0x18}, typ: 24, name: "Unknown(24)"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			typ := CodecAVC.Type(tc.nalu)
+			assert.Equal(t, tc.typ, typ)
+			assert.Equal(t, tc.name, CodecAVC.TypeName(typ))
+			assert.Equal(t, tc.vcl, CodecAVC.IsVCL(typ))
+			assert.Equal(t, tc.keyFrame, CodecAVC.IsKeyFrame(typ))
+			assert.Equal(t, tc.parameterSet, CodecAVC.IsParameterSet(typ))
+			assert.Equal(t, tc.aud, CodecAVC.IsAUD(typ))
+		})
+	}
+	assert.Equal(t, uint8(0), CodecAVC.Type(nil))
+}
+
+func TestCodecHEVC(t *testing.T) {
+	testCases := []struct {
+		nalu         []byte
+		typ          uint8
+		name         string
+		vcl          bool
+		keyFrame     bool
+		parameterSet bool
+		aud          bool
+	}{
+		{nalu: []byte{0x46, 0x01}, typ: 35, name: "AUD", aud: true},
+		{nalu: []byte{0x40, 0x01}, typ: 32, name: "VPS", parameterSet: true},
+		{nalu: []byte{0x42, 0x01}, typ: 33, name: "SPS", parameterSet: true},
+		{nalu: []byte{0x44, 0x01}, typ: 34, name: "PPS", parameterSet: true},
+		{nalu: []byte{0x4e, 0x01}, typ: 39, name: "PREFIX_SEI"},
+		{nalu: []byte{0x26, 0x01}, typ: 19, name: "IDR_W_RADL", vcl: true, keyFrame: true},
+		{nalu: []byte{0x2a, 0x01}, typ: 21, name: "CRA_NUT", vcl: true, keyFrame: true},
+		{nalu: []byte{0x02, 0x01}, typ: 1, name: "TRAIL_R", vcl: true},
+		{nalu: []byte{0x10, 0x01}, typ: 8, name: "RASL_N", vcl: true},
+		{nalu: []byte{0x60, 0x01}, typ: 48, name: "Unknown(48)"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			typ := CodecHEVC.Type(tc.nalu)
+			assert.Equal(t, tc.typ, typ)
+			assert.Equal(t, tc.name, CodecHEVC.TypeName(typ))
+			assert.Equal(t, tc.vcl, CodecHEVC.IsVCL(typ))
+			assert.Equal(t, tc.keyFrame, CodecHEVC.IsKeyFrame(typ))
+			assert.Equal(t, tc.parameterSet, CodecHEVC.IsParameterSet(typ))
+			assert.Equal(t, tc.aud, CodecHEVC.IsAUD(typ))
+		})
+	}
+}
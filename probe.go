@@ -1,10 +1,126 @@
 package mp4
 
-import "io"
+import (
+	"fmt"
+	"io"
+
+	"github.com/abema/go-mp4/aac"
+	"github.com/abema/go-mp4/avc"
+	"github.com/abema/go-mp4/hevc"
+)
 
 type TrackInfo struct {
 	TrackID   uint32
 	Timescale uint32
+
+	// Width and Height are the presentation size declared by tkhd.
+	Width  uint16
+	Height uint16
+
+	// StartTime is the presentation time of the first sample in the track timescale,
+	// to which the edit list is applied. See Timeline.StartTime.
+	StartTime int64
+
+	// AVC is set when the track has an avcC box.
+	AVC *AVCInfo
+
+	// HEVC is set when the track has an hvcC box.
+	HEVC *HEVCInfo
+
+	// AAC is set when the track has an esds box of AAC.
+	AAC *AACInfo
+
+	// Encryption is set when the sample entry is encv or enca.
+	Encryption *EncryptionInfo
+}
+
+// AVCInfo has the properties of an AVC track
+// which are derived from the sample entry and the SPS in avcC.
+type AVCInfo struct {
+	Profile uint8
+	Level   uint8
+
+	// Codecs is the codecs parameter defined at ISO/IEC 14496-15 Annex E.2, such as "avc1.64001F".
+	// The sample entry type of encrypted tracks is reported as "avc1".
+	Codecs string
+
+	// SampleEntryWidth and SampleEntryHeight are declared by the sample entry (avc1/avc3/encv).
+	SampleEntryWidth  uint16
+	SampleEntryHeight uint16
+
+	// Width and Height are the size of the decoded pictures after frame cropping.
+	// They are 0 when the SPS can not be parsed.
+	Width  uint32
+	Height uint32
+
+	// FrameRate is derived from VUI timing information, and 0 if it is absent.
+	FrameRate float64
+
+	// SPS is nil when the SPS can not be parsed.
+	SPS *avc.SPS
+}
+
+// HEVCInfo has the properties of an HEVC track
+// which are derived from the sample entry, hvcC and the parameter sets in hvcC.
+type HEVCInfo struct {
+	ProfileSpace uint8
+	Tier         bool
+	Profile      uint8
+	Level        uint8
+
+	// Codecs is the codecs parameter defined at ISO/IEC 14496-15 Annex E.3.
+	// The sample entry type of encrypted tracks is reported as "hvc1".
+	Codecs string
+
+	// SampleEntryWidth and SampleEntryHeight are declared by the sample entry (hvc1/hev1/encv).
+	SampleEntryWidth  uint16
+	SampleEntryHeight uint16
+
+	ChromaFormatIdc uint8
+	BitDepthLuma    uint8
+	BitDepthChroma  uint8
+
+	// Width and Height are the size of the decoded pictures after conformance window cropping.
+	// They are 0 when the SPS can not be parsed.
+	Width  uint32
+	Height uint32
+
+	// FrameRate is derived from VUI timing information, and 0 if it is absent.
+	FrameRate float64
+
+	// VPS and SPS are nil when they can not be parsed.
+	VPS *hevc.VPS
+	SPS *hevc.SPS
+}
+
+// AACInfo has the properties of an AAC track
+// which are derived from the sample entry (mp4a/enca) and esds.
+type AACInfo struct {
+	// Codecs is the codecs parameter defined at RFC 6381, such as "mp4a.40.2".
+	Codecs string
+
+	// ChannelCount and SampleRate are declared by the sample entry.
+	// SampleRate is derived from AudioSpecificConfig when the sample entry has 0.
+	ChannelCount uint16
+	SampleRate   uint32
+
+	ASC *aac.AudioSpecificConfig
+}
+
+// EncryptionInfo has the properties of an encrypted track
+// which are derived from sinf box defined at ISO/IEC 23001-7.
+type EncryptionInfo struct {
+	// OriginalFormat is the sample entry type before encryption declared by frma, such as "avc1".
+	OriginalFormat string
+
+	// Scheme is the protection scheme declared by schm, such as "cenc" or "cbcs".
+	Scheme string
+
+	DefaultIsProtected     bool
+	DefaultPerSampleIVSize uint8
+	DefaultKID             [16]byte
+	DefaultCryptByteBlock  uint8
+	DefaultSkipByteBlock   uint8
 }
 
 type SegmentInfo struct {
@@ -38,6 +154,7 @@ func ProbeFra(r io.ReadSeeker) (FraProbeInfo, error) {
 		return info, err
 	}
 
+	var traks []*BoxInfo
 	for bi := range boxes {
 		if boxes[bi].Type == BoxTypeTrak() {
 			track, err := probeTrak(r, boxes[bi])
@@ -45,6 +162,7 @@ func ProbeFra(r io.ReadSeeker) (FraProbeInfo, error) {
 				return info, err
 			}
 			info.Tracks = append(info.Tracks, track)
+			traks = append(traks, boxes[bi])
 		}
 	}
 
@@ -58,6 +176,14 @@ func ProbeFra(r io.ReadSeeker) (FraProbeInfo, error) {
 		}
 	}
 
+	for i := range info.Tracks {
+		startTime, err := probeStartTime(r, traks[i], info.Tracks[i].TrackID, info.Segments)
+		if err != nil {
+			return info, err
+		}
+		info.Tracks[i].StartTime = startTime
+	}
+
 	for bi := range boxes {
 		if boxes[bi].Type == BoxTypeTfra() {
 			err := probeTfra(r, boxes[bi], &info)
@@ -70,17 +196,80 @@ func ProbeFra(r io.ReadSeeker) (FraProbeInfo, error) {
 	return info, nil
 }
 
+// probeStartTime returns the start time of the track from the time ranges of the segments,
+// so that the samples are not read one by one.
+// The samples in the sample table are read only when the track has no segments.
+func probeStartTime(r io.ReadSeeker, trak *BoxInfo, trackID uint32, segments []SegmentInfo) (int64, error) {
+	var mediaStart, mediaEnd int64
+	var hasMedia bool
+	for _, segment := range segments {
+		if segment.TrackID != trackID || segment.SampleCount == 0 {
+			continue
+		}
+		start := int64(segment.BaseMediaDecodeTime) + int64(segment.CompositionTimeOffset)
+		end := int64(segment.BaseMediaDecodeTime) + int64(segment.Duration)
+		if !hasMedia || start < mediaStart {
+			mediaStart = start
+		}
+		if !hasMedia || end > mediaEnd {
+			mediaEnd = end
+		}
+		hasMedia = true
+	}
+	if !hasMedia {
+		timeline, err := readTimeline(r, trak, trackID)
+		if err != nil {
+			return 0, err
+		}
+		return timeline.StartTime, nil
+	}
+
+	elst, movieTimescale, mediaTimescale, err := readEdits(r, trak)
+	if err != nil {
+		return 0, err
+	}
+	timeline, err := newTimeline(elst, movieTimescale, mediaTimescale, mediaStart, mediaEnd, true)
+	if err != nil {
+		return 0, err
+	}
+	return timeline.StartTime, nil
+}
+
 func probeTrak(r io.ReadSeeker, bi *BoxInfo) (TrackInfo, error) {
 	track := TrackInfo{}
 
 	boxes, err := ExtractBoxes(r, bi, []BoxPath{
 		{BoxTypeTkhd()},
 		{BoxTypeMdia(), BoxTypeMdhd()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("avc1")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("avc3")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("encv")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("avc1"), StrToBoxType("avcC")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("avc3"), StrToBoxType("avcC")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("encv"), StrToBoxType("avcC")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("hvc1")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("hev1")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("hvc1"), StrToBoxType("hvcC")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("hev1"), StrToBoxType("hvcC")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("encv"), StrToBoxType("hvcC")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("mp4a")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("enca")},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("mp4a"), BoxTypeEsds()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("mp4a"), BoxTypeWave(), BoxTypeEsds()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("enca"), BoxTypeEsds()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("encv"), BoxTypeSinf(), BoxTypeFrma()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("enca"), BoxTypeSinf(), BoxTypeFrma()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("encv"), BoxTypeSinf(), BoxTypeSchm()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("enca"), BoxTypeSinf(), BoxTypeSchm()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("encv"), BoxTypeSinf(), BoxTypeSchi(), BoxTypeTenc()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsd(), StrToBoxType("enca"), BoxTypeSinf(), BoxTypeSchi(), BoxTypeTenc()},
 	})
 	if err != nil {
 		return track, err
 	}
 
+	var sampleEntry *BoxInfo
+
 	for bi := range boxes {
 		switch boxes[bi].Type {
 		case BoxTypeTkhd():
@@ -94,6 +283,34 @@ func probeTrak(r io.ReadSeeker, bi *BoxInfo) (TrackInfo, error) {
 			if err != nil {
 				return track, err
 			}
+
+		case StrToBoxType("avc1"), StrToBoxType("avc3"), StrToBoxType("encv"),
+			StrToBoxType("hvc1"), StrToBoxType("hev1"),
+			StrToBoxType("mp4a"), StrToBoxType("enca"):
+			sampleEntry = boxes[bi]
+			if sampleEntry.Type == StrToBoxType("encv") || sampleEntry.Type == StrToBoxType("enca") {
+				track.Encryption = &EncryptionInfo{}
+			}
+
+		case StrToBoxType("avcC"):
+			if err := probeAvcC(r, sampleEntry, boxes[bi], &track); err != nil {
+				return track, err
+			}
+
+		case StrToBoxType("hvcC"):
+			if err := probeHvcC(r, sampleEntry, boxes[bi], &track); err != nil {
+				return track, err
+			}
+
+		case BoxTypeEsds():
+			if err := probeEsds(r, sampleEntry, boxes[bi], &track); err != nil {
+				return track, err
+			}
+
+		case BoxTypeFrma(), BoxTypeSchm(), BoxTypeTenc():
+			if err := probeSinf(r, boxes[bi], track.Encryption); err != nil {
+				return track, err
+			}
 		}
 	}
 
@@ -111,6 +328,8 @@ func probeTkhd(r io.ReadSeeker, bi *BoxInfo, info *TrackInfo) error {
 		return err
 	}
 	info.TrackID = tkhd.TrackID
+	info.Width = tkhd.GetWidthInt()
+	info.Height = tkhd.GetHeightInt()
 	return nil
 }
 
@@ -129,6 +348,202 @@ func probeMdhd(r io.ReadSeeker, bi *BoxInfo, info *TrackInfo) error {
 	return nil
 }
 
+func probeAvcC(r io.ReadSeeker, sampleEntry *BoxInfo, bi *BoxInfo, info *TrackInfo) error {
+	avcInfo := &AVCInfo{}
+
+	sampleEntryType := "avc1"
+	if sampleEntry != nil {
+		vse, err := readVisualSampleEntry(r, sampleEntry)
+		if err != nil {
+			return err
+		}
+		avcInfo.SampleEntryWidth = vse.Width
+		avcInfo.SampleEntryHeight = vse.Height
+		if sampleEntry.Type != StrToBoxType("encv") {
+			sampleEntryType = sampleEntry.Type.String()
+		}
+	}
+
+	if _, err := bi.SeekToPayload(r); err != nil {
+		return err
+	}
+	box, _, err := UnmarshalAny(r, bi.Type, bi.Size-bi.HeaderSize, bi.Context)
+	if err != nil {
+		return err
+	}
+	avcc := box.(*AVCDecoderConfiguration)
+	avcInfo.Profile = avcc.Profile
+	avcInfo.Level = avcc.Level
+	avcInfo.Codecs = fmt.Sprintf("%s.%02X%02X%02X", sampleEntryType, avcc.Profile, avcc.ProfileCompatibility, avcc.Level)
+
+	// the SPS is optional information, and the properties derived from it are left empty if it is not parsed
+	if len(avcc.SequenceParameterSets) != 0 {
+		if sps, err := avc.ParseSPS(avcc.SequenceParameterSets[0].NALUnit); err == nil {
+			avcInfo.Width = sps.Width()
+			avcInfo.Height = sps.Height()
+			avcInfo.FrameRate = sps.FrameRate()
+			avcInfo.SPS = sps
+		}
+	}
+
+	info.AVC = avcInfo
+	return nil
+}
+
+func probeHvcC(r io.ReadSeeker, sampleEntry *BoxInfo, bi *BoxInfo, info *TrackInfo) error {
+	hevcInfo := &HEVCInfo{}
+
+	sampleEntryType := "hvc1"
+	if sampleEntry != nil {
+		vse, err := readVisualSampleEntry(r, sampleEntry)
+		if err != nil {
+			return err
+		}
+		hevcInfo.SampleEntryWidth = vse.Width
+		hevcInfo.SampleEntryHeight = vse.Height
+		if sampleEntry.Type != StrToBoxType("encv") {
+			sampleEntryType = sampleEntry.Type.String()
+		}
+	}
+
+	if _, err := bi.SeekToPayload(r); err != nil {
+		return err
+	}
+	box, _, err := UnmarshalAny(r, bi.Type, bi.Size-bi.HeaderSize, bi.Context)
+	if err != nil {
+		return err
+	}
+	hvcc := box.(*HEVCDecoderConfiguration)
+	hevcInfo.ProfileSpace = hvcc.GeneralProfileSpace
+	hevcInfo.Tier = hvcc.GeneralTierFlag
+	hevcInfo.Profile = hvcc.GeneralProfileIdc
+	hevcInfo.Level = hvcc.GeneralLevelIdc
+	hevcInfo.ChromaFormatIdc = hvcc.ChromaFormatIdc
+	hevcInfo.BitDepthLuma = hvcc.BitDepthLumaMinus8 + 8
+	hevcInfo.BitDepthChroma = hvcc.BitDepthChromaMinus8 + 8
+
+	ptl := &hevc.ProfileTierLevel{
+		GeneralProfileSpace:              hvcc.GeneralProfileSpace,
+		GeneralTierFlag:                  hvcc.GeneralTierFlag,
+		GeneralProfileIdc:                hvcc.GeneralProfileIdc,
+		GeneralProfileCompatibilityFlags: hvcc.GeneralProfileCompatibility,
+		GeneralLevelIdc:                  hvcc.GeneralLevelIdc,
+	}
+	for _, b := range hvcc.GeneralConstraintIndicator {
+		ptl.GeneralConstraintIndicatorFlags = ptl.GeneralConstraintIndicatorFlags<<8 | uint64(b)
+	}
+	hevcInfo.Codecs = ptl.Codecs(sampleEntryType)
+
+	// the parameter sets are optional information, and the properties derived from them are left empty if they are not parsed
+	for _, array := range hvcc.NaluArrays {
+		if len(array.Nalus) == 0 {
+			continue
+		}
+		switch array.NaluType {
+		case hevc.NALUnitTypeVPS:
+			if vps, err := hevc.ParseVPS(array.Nalus[0].NALUnit); err == nil {
+				hevcInfo.VPS = vps
+			}
+		case hevc.NALUnitTypeSPS:
+			if sps, err := hevc.ParseSPS(array.Nalus[0].NALUnit); err == nil {
+				hevcInfo.Width = sps.Width()
+				hevcInfo.Height = sps.Height()
+				hevcInfo.FrameRate = sps.FrameRate()
+				hevcInfo.SPS = sps
+			}
+		}
+	}
+
+	info.HEVC = hevcInfo
+	return nil
+}
+
+func probeEsds(r io.ReadSeeker, sampleEntry *BoxInfo, bi *BoxInfo, info *TrackInfo) error {
+	aacInfo := &AACInfo{}
+
+	if sampleEntry != nil {
+		if _, err := sampleEntry.SeekToPayload(r); err != nil {
+			return err
+		}
+		box, _, err := UnmarshalAny(r, sampleEntry.Type, sampleEntry.Size-sampleEntry.HeaderSize, sampleEntry.Context)
+		if err != nil {
+			return err
+		}
+		ase := box.(*AudioSampleEntry)
+		aacInfo.ChannelCount = ase.ChannelCount
+		aacInfo.SampleRate = ase.SampleRate >> 16
+	}
+
+	if _, err := bi.SeekToPayload(r); err != nil {
+		return err
+	}
+	esds := Esds{}
+	if _, err := Unmarshal(r, bi.Size-bi.HeaderSize, &esds, bi.Context); err != nil {
+		return err
+	}
+	asc, err := audioSpecificConfigOf(&esds)
+	if err != nil {
+		return err
+	} else if asc == nil {
+		return nil
+	}
+	aacInfo.ASC = asc
+	if aacInfo.SampleRate == 0 {
+		// the sample entry can not declare the sampling frequency above 65535 Hz
+		aacInfo.SampleRate = asc.SamplingFrequency
+		if asc.ExtensionSamplingFrequency != 0 {
+			aacInfo.SampleRate = asc.ExtensionSamplingFrequency
+		}
+	}
+
+	// the object type of the extension is signalled for HE-AAC and HE-AAC v2
+	aot := asc.AudioObjectType
+	if asc.ExtensionAudioObjectType != 0 {
+		aot = asc.ExtensionAudioObjectType
+	}
+	aacInfo.Codecs = fmt.Sprintf("mp4a.40.%d", aot)
+
+	info.AAC = aacInfo
+	return nil
+}
+
+func probeSinf(r io.ReadSeeker, bi *BoxInfo, info *EncryptionInfo) error {
+	if info == nil {
+		return nil
+	}
+	if _, err := bi.SeekToPayload(r); err != nil {
+		return err
+	}
+	box, _, err := UnmarshalAny(r, bi.Type, bi.Size-bi.HeaderSize, bi.Context)
+	if err != nil {
+		return err
+	}
+	switch box := box.(type) {
+	case *Frma:
+		info.OriginalFormat = string(box.DataFormat[:])
+	case *Schm:
+		info.Scheme = string(box.SchemeType[:])
+	case *Tenc:
+		info.DefaultIsProtected = box.DefaultIsProtected != 0
+		info.DefaultPerSampleIVSize = box.DefaultPerSampleIVSize
+		info.DefaultKID = box.DefaultKID
+		info.DefaultCryptByteBlock = box.DefaultCryptByteBlock
+		info.DefaultSkipByteBlock = box.DefaultSkipByteBlock
+	}
+	return nil
+}
+
+func readVisualSampleEntry(r io.ReadSeeker, bi *BoxInfo) (*VisualSampleEntry, error) {
+	if _, err := bi.SeekToPayload(r); err != nil {
+		return nil, err
+	}
+	box, _, err := UnmarshalAny(r, bi.Type, bi.Size-bi.HeaderSize, bi.Context)
+	if err != nil {
+		return nil, err
+	}
+	return box.(*VisualSampleEntry), nil
+}
+
 func probeMoof(r io.ReadSeeker, bi *BoxInfo) (SegmentInfo, error) {
 	segment := SegmentInfo{}
 
@@ -1,9 +1,12 @@
 package mp4
 
 import (
+	"io"
 	"os"
 	"testing"
 
+	"gopkg.in/src-d/go-billy.v4/memfs"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -22,8 +25,30 @@ func TestProbeFra(t *testing.T) {
 	assert.Equal(t, uint32(1), info.Tracks[0].TrackID)
 	assert.Equal(t, uint32(90000), info.Tracks[0].Timescale)
 
+	assert.Equal(t, int64(18000), info.Tracks[0].StartTime)
+	require.NotNil(t, info.Tracks[0].AVC)
+	assert.Equal(t, "avc1.4D401F", info.Tracks[0].AVC.Codecs)
+	assert.Nil(t, info.Tracks[0].AAC)
+	assert.Nil(t, info.Tracks[0].Encryption)
+
 	assert.Equal(t, uint32(2), info.Tracks[1].TrackID)
 	assert.Equal(t, uint32(44100), info.Tracks[1].Timescale)
+	assert.Equal(t, int64(0), info.Tracks[1].StartTime)
+	assert.Nil(t, info.Tracks[1].AVC)
+	require.NotNil(t, info.Tracks[1].AAC)
+	assert.Equal(t, "mp4a.40.2", info.Tracks[1].AAC.Codecs)
+
+	// the start times from the segments are the same as the timelines from all samples
+	for _, track := range info.Tracks {
+		timeline, err := ReadTimeline(f, track.TrackID)
+		require.NoError(t, err)
+		assert.Equal(t, timeline.StartTime, track.StartTime)
+	}
+	assert.Equal(t, uint16(2), info.Tracks[1].AAC.ChannelCount)
+	assert.Equal(t, uint32(44100), info.Tracks[1].AAC.SampleRate)
+	require.NotNil(t, info.Tracks[1].AAC.ASC)
+	assert.Equal(t, uint8(2), info.Tracks[1].AAC.ASC.AudioObjectType)
+	assert.Nil(t, info.Tracks[1].Encryption)
 
 	assert.Equal(t, uint32(1), info.Segments[0].TrackID)
 	assert.Equal(t, uint64(1227), info.Segments[0].MoofOffset)
@@ -57,3 +82,319 @@ func TestProbeFra(t *testing.T) {
 	assert.Equal(t, uint32(9216), info.Segments[3].Duration)
 	assert.Equal(t, int32(0), info.Segments[3].CompositionTimeOffset)
 }
+
+func TestProbeFraAVC(t *testing.T) {
+	f, err := os.Open("./_examples/sample.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+
+	info, err := ProbeFra(f)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(info.Tracks))
+
+	assert.Equal(t, uint16(320), info.Tracks[0].Width)
+	assert.Equal(t, uint16(180), info.Tracks[0].Height)
+	assert.Equal(t, int64(0), info.Tracks[0].StartTime)
+	require.NotNil(t, info.Tracks[0].AVC)
+	assert.Equal(t, AVCHighProfile, info.Tracks[0].AVC.Profile)
+	assert.Equal(t, uint8(12), info.Tracks[0].AVC.Level)
+	assert.Equal(t, "avc1.64000C", info.Tracks[0].AVC.Codecs)
+	assert.Equal(t, uint16(320), info.Tracks[0].AVC.SampleEntryWidth)
+	assert.Equal(t, uint16(180), info.Tracks[0].AVC.SampleEntryHeight)
+	assert.Equal(t, uint32(320), info.Tracks[0].AVC.Width)
+	assert.Equal(t, uint32(180), info.Tracks[0].AVC.Height)
+	assert.Equal(t, 10.0, info.Tracks[0].AVC.FrameRate)
+	require.NotNil(t, info.Tracks[0].AVC.SPS)
+
+	assert.Equal(t, uint16(0), info.Tracks[1].Width)
+	assert.Nil(t, info.Tracks[1].AVC)
+}
+
+func TestProbeFraHEVC(t *testing.T) {
+	sps := []byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03,
+		0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03,
+		0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16,
+		0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70,
+		0x80, 0x00, 0x01, 0xf4, 0x80, 0x00, 0x3a, 0x98,
+		0x04,
+	}
+	testCases := []struct {
+		name   string
+		sps    []byte
+		hasSPS bool
+	}{
+		{name: "valid SPS", sps: sps, hasSPS: true},
+		{name: "truncated SPS", sps: sps[:8], hasSPS: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := memfs.New().Create("hevc.mp4")
+			require.NoError(t, err)
+			defer f.Close()
+			w := NewWriter(f)
+
+			writeBox := func(box IImmutableBox, ctx Context) {
+				_, err := w.StartBox(&BoxInfo{Type: box.GetType()})
+				require.NoError(t, err)
+				_, err = Marshal(w, box, ctx)
+				require.NoError(t, err)
+			}
+			endBox := func() {
+				_, err := w.EndBox()
+				require.NoError(t, err)
+			}
+
+			writeBox(&Moov{}, Context{})
+			writeBox(&Trak{}, Context{})
+			tkhd := &Tkhd{TrackID: 1}
+			tkhd.Width = 1280 << 16
+			tkhd.Height = 720 << 16
+			writeBox(tkhd, Context{})
+			endBox()
+			writeBox(&Mdia{}, Context{})
+			writeBox(&Mdhd{Timescale: 90000}, Context{})
+			endBox()
+			writeBox(&Minf{}, Context{})
+			writeBox(&Stbl{}, Context{})
+			writeBox(&Stsd{EntryCount: 1}, Context{})
+			writeBox(&VisualSampleEntry{
+				SampleEntry: SampleEntry{
+					AnyTypeBox:         AnyTypeBox{Type: StrToBoxType("hev1")},
+					DataReferenceIndex: 1,
+				},
+				Width:  1280,
+				Height: 720,
+				Depth:  0x0018,
+			}, Context{})
+			writeBox(&HEVCDecoderConfiguration{
+				AnyTypeBox:                  AnyTypeBox{Type: StrToBoxType("hvcC")},
+				ConfigurationVersion:        1,
+				GeneralProfileIdc:           1,
+				GeneralProfileCompatibility: 0x60000000,
+				GeneralConstraintIndicator:  [6]uint8{0x90},
+				GeneralLevelIdc:             93,
+				Reserved:                    0xf,
+				Reserved2:                   0x3f,
+				Reserved3:                   0x3f,
+				ChromaFormatIdc:             1,
+				Reserved4:                   0x1f,
+				Reserved5:                   0x1f,
+				LengthSizeMinusOne:          3,
+				NumOfNaluArrays:             2,
+				NaluArrays: []HEVCNaluArray{
+					{
+						Completeness: true,
+						NaluType:     32,
+						NumNalus:     1,
+						Nalus: []HEVCNalu{{Length: 24, NALUnit: []byte{
+							0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60,
+							0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03,
+							0x00, 0x00, 0x03, 0x00, 0x5d, 0x95, 0x98, 0x09,
+						}}},
+					},
+					{
+						Completeness: true,
+						NaluType:     33,
+						NumNalus:     1,
+						Nalus:        []HEVCNalu{{Length: uint16(len(tc.sps)), NALUnit: tc.sps}},
+					},
+				},
+			}, Context{})
+			for i := 0; i < 8; i++ {
+				endBox() // hvcC, hev1, stsd, stbl, minf, mdia, trak, moov
+			}
+
+			_, err = f.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+			info, err := ProbeFra(f)
+			require.NoError(t, err)
+			require.Equal(t, 1, len(info.Tracks))
+			track := info.Tracks[0]
+			assert.Equal(t, uint32(1), track.TrackID)
+			assert.Equal(t, uint32(90000), track.Timescale)
+			assert.Nil(t, track.AVC)
+			require.NotNil(t, track.HEVC)
+			assert.Equal(t, uint8(1), track.HEVC.Profile)
+			assert.False(t, track.HEVC.Tier)
+			assert.Equal(t, uint8(93), track.HEVC.Level)
+			assert.Equal(t, "hev1.1.6.L93.90", track.HEVC.Codecs)
+			assert.Equal(t, uint16(1280), track.HEVC.SampleEntryWidth)
+			assert.Equal(t, uint16(720), track.HEVC.SampleEntryHeight)
+			assert.Equal(t, uint8(1), track.HEVC.ChromaFormatIdc)
+			assert.Equal(t, uint8(8), track.HEVC.BitDepthLuma)
+			assert.Equal(t, uint8(8), track.HEVC.BitDepthChroma)
+			require.NotNil(t, track.HEVC.VPS)
+			if !tc.hasSPS {
+				assert.Equal(t, uint32(0), track.HEVC.Width)
+				assert.Equal(t, uint32(0), track.HEVC.Height)
+				assert.Nil(t, track.HEVC.SPS)
+				return
+			}
+			assert.Equal(t, uint32(1280), track.HEVC.Width)
+			assert.Equal(t, uint32(720), track.HEVC.Height)
+			assert.InDelta(t, 29.97, track.HEVC.FrameRate, 0.001)
+			require.NotNil(t, track.HEVC.SPS)
+			assert.NoError(t, track.HEVC.SPS.CheckProfileConstraints())
+		})
+	}
+}
+
+func TestProbeFraEncryption(t *testing.T) {
+	f, err := memfs.New().Create("enca.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	w := NewWriter(f)
+
+	writeBox := func(box IImmutableBox, ctx Context) {
+		_, err := w.StartBox(&BoxInfo{Type: box.GetType()})
+		require.NoError(t, err)
+		_, err = Marshal(w, box, ctx)
+		require.NoError(t, err)
+	}
+	endBox := func() {
+		_, err := w.EndBox()
+		require.NoError(t, err)
+	}
+
+	writeBox(&Moov{}, Context{})
+	writeBox(&Trak{}, Context{})
+	writeBox(&Tkhd{TrackID: 1}, Context{})
+	endBox()
+	writeBox(&Mdia{}, Context{})
+	writeBox(&Mdhd{Timescale: 96000}, Context{})
+	endBox()
+	writeBox(&Minf{}, Context{})
+	writeBox(&Stbl{}, Context{})
+	writeBox(&Stsd{EntryCount: 1}, Context{})
+	writeBox(&AudioSampleEntry{
+		SampleEntry: SampleEntry{
+			AnyTypeBox:         AnyTypeBox{Type: StrToBoxType("enca")},
+			DataReferenceIndex: 1,
+		},
+		ChannelCount: 2,
+		SampleSize:   16,
+		SampleRate:   0, // 96000 Hz can not be declared
+	}, Context{})
+	writeBox(&Esds{
+		Descriptors: []Descriptor{
+			{Tag: ESDescrTag, Size: 25, ESDescriptor: &ESDescriptor{ESID: 1}},
+			{Tag: DecoderConfigDescrTag, Size: 17, DecoderConfigDescriptor: &DecoderConfigDescriptor{
+				ObjectTypeIndication: objectTypeMPEG4Audio,
+				StreamType:           0x05,
+				Reserved:             true,
+			}},
+			{Tag: DecSpecificInfoTag, Size: 2, Data: []byte{0x10, 0x10}}, // AAC-LC, 96000 Hz, stereo
+			{Tag: SLConfigDescrTag, Size: 1, Data: []byte{0x02}},
+		},
+	}, Context{})
+	endBox()
+	writeBox(&Sinf{}, Context{})
+	writeBox(&Frma{DataFormat: [4]byte{'m', 'p', '4', 'a'}}, Context{})
+	endBox()
+	writeBox(&Schm{SchemeType: [4]byte{'c', 'b', 'c', 's'}, SchemeVersion: 0x00010000}, Context{})
+	endBox()
+	writeBox(&Schi{}, Context{})
+	tenc := &Tenc{
+		DefaultCryptByteBlock: 1,
+		DefaultSkipByteBlock:  9,
+		DefaultIsProtected:    1,
+		DefaultKID:            [16]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+		DefaultConstantIVSize: 16,
+		DefaultConstantIV:     make([]byte, 16),
+	}
+	tenc.SetVersion(1)
+	writeBox(tenc, Context{})
+	for i := 0; i < 10; i++ {
+		endBox() // tenc, schi, sinf, enca, stsd, stbl, minf, mdia, trak, moov
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	info, err := ProbeFra(f)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(info.Tracks))
+	track := info.Tracks[0]
+	require.NotNil(t, track.AAC)
+	assert.Equal(t, "mp4a.40.2", track.AAC.Codecs)
+	assert.Equal(t, uint16(2), track.AAC.ChannelCount)
+	assert.Equal(t, uint32(96000), track.AAC.SampleRate)
+	require.NotNil(t, track.Encryption)
+	assert.Equal(t, "mp4a", track.Encryption.OriginalFormat)
+	assert.Equal(t, "cbcs", track.Encryption.Scheme)
+	assert.True(t, track.Encryption.DefaultIsProtected)
+	assert.Equal(t, uint8(0), track.Encryption.DefaultPerSampleIVSize)
+	assert.Equal(t, tenc.DefaultKID, track.Encryption.DefaultKID)
+	assert.Equal(t, uint8(1), track.Encryption.DefaultCryptByteBlock)
+	assert.Equal(t, uint8(9), track.Encryption.DefaultSkipByteBlock)
+}
+
+func TestProbeFraAVC3(t *testing.T) {
+	f, err := memfs.New().Create("avc3.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	w := NewWriter(f)
+
+	writeBox := func(box IImmutableBox, ctx Context) {
+		_, err := w.StartBox(&BoxInfo{Type: box.GetType()})
+		require.NoError(t, err)
+		_, err = Marshal(w, box, ctx)
+		require.NoError(t, err)
+	}
+	endBox := func() {
+		_, err := w.EndBox()
+		require.NoError(t, err)
+	}
+
+	writeBox(&Moov{}, Context{})
+	writeBox(&Trak{}, Context{})
+	writeBox(&Tkhd{TrackID: 1}, Context{})
+	endBox()
+	writeBox(&Mdia{}, Context{})
+	writeBox(&Mdhd{Timescale: 90000}, Context{})
+	endBox()
+	writeBox(&Minf{}, Context{})
+	writeBox(&Stbl{}, Context{})
+	writeBox(&Stsd{EntryCount: 1}, Context{})
+	writeBox(&VisualSampleEntry{
+		SampleEntry: SampleEntry{
+			AnyTypeBox:         AnyTypeBox{Type: StrToBoxType("avc3")},
+			DataReferenceIndex: 1,
+		},
+		Width:  640,
+		Height: 360,
+		Depth:  0x0018,
+	}, Context{})
+	writeBox(&AVCDecoderConfiguration{
+		AnyTypeBox:                 AnyTypeBox{Type: StrToBoxType("avcC")},
+		ConfigurationVersion:       1,
+		Profile:                    AVCBaselineProfile,
+		ProfileCompatibility:       0xc0,
+		Level:                      30,
+		Reserved:                   0x3f,
+		LengthSizeMinusOne:         3,
+		Reserved2:                  0x7,
+		NumOfSequenceParameterSets: 1,
+		// the SPS is truncated
+		SequenceParameterSets: []AVCParameterSet{{Length: 2, NALUnit: []byte{0x67, 0x42}}},
+	}, Context{})
+	for i := 0; i < 8; i++ {
+		endBox() // avcC, avc3, stsd, stbl, minf, mdia, trak, moov
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	info, err := ProbeFra(f)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(info.Tracks))
+	track := info.Tracks[0]
+	require.NotNil(t, track.AVC)
+	assert.Equal(t, AVCBaselineProfile, track.AVC.Profile)
+	assert.Equal(t, uint8(30), track.AVC.Level)
+	assert.Equal(t, "avc3.42C01E", track.AVC.Codecs)
+	assert.Equal(t, uint16(640), track.AVC.SampleEntryWidth)
+	assert.Equal(t, uint16(360), track.AVC.SampleEntryHeight)
+	assert.Equal(t, uint32(0), track.AVC.Width)
+	assert.Equal(t, uint32(0), track.AVC.Height)
+	assert.Nil(t, track.AVC.SPS)
+}
@@ -0,0 +1,792 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// progressiveBuilder builds a progressive MP4 file from the samples of a progressive or fragmented input.
+// moov box of the input is copied with the rebuilt sample tables, edit lists and durations.
+type progressiveBuilder struct {
+	ftyp           *Ftyp
+	fragmented     bool
+	movieTimescale uint32
+	tracks         []*progressiveTrack
+	chunks         []*progressiveChunk
+	movieDuration  uint64
+
+	// trackIDs maps the track IDs of the input to the output.
+	// It is nil when all tracks are kept as they are.
+	trackIDs map[uint32]uint32
+}
+
+type progressiveTrack struct {
+	trak            *BoxInfo
+	trackID         uint32
+	timescale       uint32
+	sampleEntryType BoxType
+	trex            *Trex
+	edits           []ElstEntry
+	samples         []Sample
+	sbgps           []*Sbgp
+	sgpds           []*Sgpd
+	auxInfo         *progressiveAuxInfo
+
+	// sampleEntries replaces the sample entries in stsd box when it is not nil.
+	sampleEntries [][]byte
+
+	muxerTrack    *muxerTrack
+	durations     []uint32
+	mediaDuration uint64
+	trackDuration uint64
+}
+
+// progressiveChunk is a range of the samples which are copied from src as a chunk.
+type progressiveChunk struct {
+	track                  *progressiveTrack
+	src                    io.ReadSeeker
+	begin                  int
+	end                    int
+	sampleDescriptionIndex uint32
+}
+
+// progressiveAuxInfo is the sample auxiliary information of a track which is referred by saiz and saio boxes.
+type progressiveAuxInfo struct {
+	saiz   *Saiz
+	sizes  []uint8
+	data   []byte
+	offset uint64
+}
+
+// readProgressiveInit reads moov box and the samples in the sample tables.
+// The samples in the track fragments are read by readSegment.
+func readProgressiveInit(r io.ReadSeeker) (*progressiveBuilder, error) {
+	bs, err := ExtractBoxesWithPayload(r, nil, []BoxPath{
+		{BoxTypeFtyp()},
+		{BoxTypeMoov(), BoxTypeMvhd()},
+		{BoxTypeMoov(), BoxTypeMvex(), BoxTypeTrex()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	b := &progressiveBuilder{}
+	trexs := make(map[uint32]*Trex)
+	for _, bi := range bs {
+		switch box := bi.Payload.(type) {
+		case *Ftyp:
+			b.ftyp = box
+		case *Mvhd:
+			b.movieTimescale = box.Timescale
+		case *Trex:
+			trexs[box.TrackID] = box
+		}
+	}
+	b.fragmented = len(trexs) != 0
+	if b.movieTimescale == 0 {
+		return nil, errors.New("invalid movie timescale")
+	}
+
+	traks, err := ExtractBox(r, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak()})
+	if err != nil {
+		return nil, err
+	}
+	for _, trak := range traks {
+		bs, err := ExtractBoxesWithPayload(r, trak, []BoxPath{
+			{BoxTypeTkhd()},
+			{BoxTypeEdts(), BoxTypeElst()},
+			{BoxTypeMdia(), BoxTypeMdhd()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSbgp()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSgpd()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSaiz()},
+			{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeSaio()},
+		})
+		if err != nil {
+			return nil, err
+		}
+		track := &progressiveTrack{trak: trak}
+		var saiz *Saiz
+		var saio *Saio
+		for _, bi := range bs {
+			switch box := bi.Payload.(type) {
+			case *Tkhd:
+				track.trackID = box.TrackID
+			case *Elst:
+				for _, e := range box.Entries {
+					if box.GetVersion() == 0 {
+						e.SegmentDurationV1 = uint64(e.SegmentDurationV0)
+						e.MediaTimeV1 = int64(e.MediaTimeV0)
+					}
+					track.edits = append(track.edits, e)
+				}
+			case *Mdhd:
+				track.timescale = box.Timescale
+			case *Sbgp:
+				track.sbgps = append(track.sbgps, box)
+			case *Sgpd:
+				track.sgpds = append(track.sgpds, box)
+			case *Saiz:
+				saiz = box
+			case *Saio:
+				saio = box
+			}
+		}
+		if track.timescale == 0 {
+			return nil, fmt.Errorf("invalid timescale: trackID=%d", track.trackID)
+		}
+		track.trex = trexs[track.trackID]
+		if b.fragmented && track.trex == nil {
+			return nil, fmt.Errorf("trex box is not found: trackID=%d", track.trackID)
+		}
+
+		entries, err := ExtractBox(r, trak, sampleEntryPath(BoxTypeAny()))
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 0 {
+			track.sampleEntryType = entries[0].Type
+		}
+
+		// samples in moov precede the samples in the fragments
+		if track.samples, err = readSampleTable(r, trak); err != nil {
+			return nil, err
+		}
+		if saiz != nil {
+			if track.auxInfo, err = readSampleTableAuxInfo(r, track.trackID, len(track.samples), saiz, saio); err != nil {
+				return nil, err
+			}
+		}
+		b.addChunks(track, r, 0)
+		b.tracks = append(b.tracks, track)
+	}
+	return b, nil
+}
+
+// readSampleTableAuxInfo reads the sample auxiliary information which is referred by saiz and saio boxes in stbl.
+func readSampleTableAuxInfo(r io.ReadSeeker, trackID uint32, sampleCount int, saiz *Saiz, saio *Saio) (*progressiveAuxInfo, error) {
+	if saio == nil {
+		return nil, fmt.Errorf("saio box is not found: trackID=%d", trackID)
+	}
+	if saio.EntryCount != 1 {
+		return nil, fmt.Errorf("unsupported saio entry count: trackID=%d entryCount=%d", trackID, saio.EntryCount)
+	}
+	if int(saiz.SampleCount) != sampleCount {
+		return nil, fmt.Errorf("inconsistent sample count: stsz=%d saiz=%d", sampleCount, saiz.SampleCount)
+	}
+	offset := saio.OffsetV1
+	if saio.GetVersion() == 0 {
+		offset = []uint64{uint64(saio.OffsetV0[0])}
+	}
+	auxInfo := &progressiveAuxInfo{saiz: saiz}
+	if err := auxInfo.read(r, saiz, offset, []int{int(saiz.SampleCount)}); err != nil {
+		return nil, err
+	}
+	return auxInfo, nil
+}
+
+// read appends the sizes and the data of the auxiliary information.
+// Each element of ranges is the number of the samples whose auxiliary information is stored at the offset.
+func (auxInfo *progressiveAuxInfo) read(r io.ReadSeeker, saiz *Saiz, offsets []uint64, ranges []int) error {
+	sizes := make([]uint8, saiz.SampleCount)
+	for i := range sizes {
+		if saiz.DefaultSampleInfoSize != 0 {
+			sizes[i] = saiz.DefaultSampleInfoSize
+		} else {
+			sizes[i] = saiz.SampleInfoSize[i]
+		}
+	}
+
+	var s int
+	for i, n := range ranges {
+		var size int
+		for _, sz := range sizes[s : s+n] {
+			size += int(sz)
+		}
+		s += n
+		data := make([]byte, size)
+		if _, err := r.Seek(int64(offsets[i]), io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		auxInfo.data = append(auxInfo.data, data...)
+	}
+	auxInfo.sizes = append(auxInfo.sizes, sizes...)
+	return nil
+}
+
+// readSegment reads the samples in moof boxes of a fragmented input.
+func (b *progressiveBuilder) readSegment(r io.ReadSeeker) error {
+	moofs, err := ExtractBox(r, nil, BoxPath{BoxTypeMoof()})
+	if err != nil {
+		return err
+	}
+	for _, moof := range moofs {
+		for _, track := range b.tracks {
+			if track.trex == nil {
+				return fmt.Errorf("trex box is not found: trackID=%d", track.trackID)
+			}
+			begin := len(track.samples)
+			if track.samples, err = readFragmentSamples(r, moof, track.trex, track.samples); err != nil {
+				return err
+			}
+			if err := track.readFragmentAuxInfo(r, moof, begin); err != nil {
+				return err
+			}
+			b.addChunks(track, r, begin)
+		}
+	}
+	return nil
+}
+
+// addChunks appends the samples after begin to the chunk list.
+// A new chunk begins when the sample description index changes.
+func (b *progressiveBuilder) addChunks(track *progressiveTrack, src io.ReadSeeker, begin int) {
+	var chunk *progressiveChunk
+	for i := begin; i < len(track.samples); i++ {
+		index := track.samples[i].SampleDescriptionIndex
+		if index == 0 {
+			index = 1
+		}
+		if chunk == nil || chunk.sampleDescriptionIndex != index {
+			chunk = &progressiveChunk{
+				track:                  track,
+				src:                    src,
+				begin:                  i,
+				sampleDescriptionIndex: index,
+			}
+			b.chunks = append(b.chunks, chunk)
+		}
+		chunk.end = i + 1
+	}
+}
+
+// readFragmentAuxInfo reads the sample auxiliary information of the samples after begin.
+func (track *progressiveTrack) readFragmentAuxInfo(r io.ReadSeeker, moof *BoxInfo, begin int) error {
+	bs, err := ExtractBoxesWithPayload(r, moof, []BoxPath{
+		{BoxTypeTraf(), BoxTypeTfhd()},
+		{BoxTypeTraf(), BoxTypeTrun()},
+		{BoxTypeTraf(), BoxTypeSaiz()},
+		{BoxTypeTraf(), BoxTypeSaio()},
+	})
+	if err != nil {
+		return err
+	}
+
+	index := begin
+	var traf struct {
+		tfhd  *Tfhd
+		truns []*Trun
+		saiz  *Saiz
+		saio  *Saio
+	}
+	flush := func() error {
+		if traf.tfhd == nil || traf.tfhd.TrackID != track.trackID {
+			return nil
+		}
+		var sampleCount int
+		for _, trun := range traf.truns {
+			sampleCount += int(trun.SampleCount)
+		}
+		index += sampleCount
+		if traf.saiz == nil {
+			return nil
+		}
+		if traf.saio == nil {
+			return fmt.Errorf("saio box is not found: trackID=%d", track.trackID)
+		}
+		if int(traf.saiz.SampleCount) != sampleCount {
+			return fmt.Errorf("inconsistent sample count: trun=%d saiz=%d", sampleCount, traf.saiz.SampleCount)
+		}
+
+		// offsets are relative to the base data offset, or the beginning of moof
+		base := moof.Offset
+		if traf.tfhd.GetFlags()&TfhdBaseDataOffsetPresent != 0 {
+			base = traf.tfhd.BaseDataOffset
+		}
+		offsets := make([]uint64, 0, traf.saio.EntryCount)
+		for i := uint32(0); i < traf.saio.EntryCount; i++ {
+			if traf.saio.GetVersion() == 0 {
+				offsets = append(offsets, base+uint64(traf.saio.OffsetV0[i]))
+			} else {
+				offsets = append(offsets, base+traf.saio.OffsetV1[i])
+			}
+		}
+
+		// ranges of the auxiliary information which are stored contiguously
+		var ranges []int
+		switch len(offsets) {
+		case 1:
+			ranges = []int{sampleCount}
+		case len(traf.truns):
+			for _, trun := range traf.truns {
+				ranges = append(ranges, int(trun.SampleCount))
+			}
+		default:
+			return fmt.Errorf("invalid saio entry count: trackID=%d entryCount=%d", track.trackID, len(offsets))
+		}
+
+		if track.auxInfo == nil {
+			track.auxInfo = &progressiveAuxInfo{saiz: traf.saiz}
+		} else if !sameAuxInfoType(track.auxInfo.saiz, traf.saiz) {
+			return fmt.Errorf("inconsistent auxiliary information type: trackID=%d", track.trackID)
+		}
+		// the samples without auxiliary information have size 0
+		for len(track.auxInfo.sizes) < index-sampleCount {
+			track.auxInfo.sizes = append(track.auxInfo.sizes, 0)
+		}
+		return track.auxInfo.read(r, traf.saiz, offsets, ranges)
+	}
+
+	for _, bi := range bs {
+		switch box := bi.Payload.(type) {
+		case *Tfhd:
+			if err := flush(); err != nil {
+				return err
+			}
+			traf.tfhd = box
+			traf.truns = nil
+			traf.saiz = nil
+			traf.saio = nil
+		case *Trun:
+			traf.truns = append(traf.truns, box)
+		case *Saiz:
+			if traf.saiz != nil {
+				return errors.New("multiple saiz boxes in a traf box are not supported")
+			}
+			traf.saiz = box
+		case *Saio:
+			traf.saio = box
+		}
+	}
+	return flush()
+}
+
+// sameAuxInfoType reports whether the saiz boxes have the same type of the auxiliary information.
+func sameAuxInfoType(a, b *Saiz) bool {
+	return a.GetFlags() == b.GetFlags() &&
+		a.AuxInfoType == b.AuxInfoType &&
+		a.AuxInfoTypeParameter == b.AuxInfoTypeParameter
+}
+
+// computeDurations computes the duration of each sample from the decoding times.
+func (track *progressiveTrack) computeDurations() error {
+	track.durations = make([]uint32, len(track.samples))
+	track.mediaDuration = 0
+	for i, s := range track.samples {
+		if i+1 < len(track.samples) {
+			next := track.samples[i+1].DecodeTime
+			if next < s.DecodeTime || next-s.DecodeTime > math.MaxUint32 {
+				return fmt.Errorf("discontinuous decoding time: trackID=%d decodeTime=%d", track.trackID, next)
+			}
+			// gaps between fragments are filled by the duration of the last sample of the fragment
+			track.durations[i] = uint32(next - s.DecodeTime)
+		} else {
+			track.durations[i] = s.Duration
+		}
+		track.mediaDuration += uint64(track.durations[i])
+	}
+	return nil
+}
+
+// splitEdits returns the duration of the leading empty edit and the following media edit.
+// Only an optional empty edit and a media edit at the normal rate are supported, and edit is nil when there is no media edit.
+func (track *progressiveTrack) splitEdits() (delay uint64, edit *ElstEntry, err error) {
+	edits := track.edits
+	if len(edits) != 0 && edits[0].MediaTimeV1 == -1 {
+		delay = edits[0].SegmentDurationV1
+		edits = edits[1:]
+	}
+	switch len(edits) {
+	case 0:
+		return delay, nil, nil
+	case 1:
+		if edits[0].MediaTimeV1 < 0 || edits[0].MediaRateInteger != 1 {
+			return 0, nil, fmt.Errorf("unsupported edit list: trackID=%d", track.trackID)
+		}
+		return delay, &edits[0], nil
+	default:
+		return 0, nil, fmt.Errorf("unsupported edit list: trackID=%d entryCount=%d", track.trackID, len(track.edits))
+	}
+}
+
+// trim removes the samples out of the range from the track and the chunk list.
+func (b *progressiveBuilder) trim(track *progressiveTrack, begin, end int) {
+	chunks := b.chunks[:0]
+	for _, c := range b.chunks {
+		if c.track == track {
+			if c.begin < begin {
+				c.begin = begin
+			}
+			if c.end > end {
+				c.end = end
+			}
+			if c.begin >= c.end {
+				continue
+			}
+			c.begin -= begin
+			c.end -= begin
+		}
+		chunks = append(chunks, c)
+	}
+	b.chunks = chunks
+
+	if track.auxInfo != nil {
+		for len(track.auxInfo.sizes) < len(track.samples) {
+			track.auxInfo.sizes = append(track.auxInfo.sizes, 0)
+		}
+		var dataBegin, dataEnd int
+		for i, size := range track.auxInfo.sizes[:end] {
+			if i < begin {
+				dataBegin += int(size)
+			}
+			dataEnd += int(size)
+		}
+		track.auxInfo.sizes = track.auxInfo.sizes[begin:end]
+		track.auxInfo.data = track.auxInfo.data[dataBegin:dataEnd]
+	}
+
+	for i, sbgp := range track.sbgps {
+		track.sbgps[i] = trimSbgp(sbgp, begin, end)
+	}
+	track.samples = track.samples[begin:end]
+}
+
+// trimSbgp returns sbgp box which has the entries of the samples in the range.
+func trimSbgp(sbgp *Sbgp, begin, end int) *Sbgp {
+	trimmed := &Sbgp{
+		FullBox:               sbgp.FullBox,
+		GroupingType:          sbgp.GroupingType,
+		GroupingTypeParameter: sbgp.GroupingTypeParameter,
+	}
+	var index int
+	for _, e := range sbgp.Entries {
+		first, last := index, index+int(e.SampleCount)
+		index = last
+		if first < begin {
+			first = begin
+		}
+		if last > end {
+			last = end
+		}
+		if first < last {
+			trimmed.Entries = append(trimmed.Entries, SbgpEntry{
+				SampleCount:           uint32(last - first),
+				GroupDescriptionIndex: e.GroupDescriptionIndex,
+			})
+		}
+	}
+	trimmed.EntryCount = uint32(len(trimmed.Entries))
+	return trimmed
+}
+
+// buildTracks builds the sample tables, and computes the durations of the tracks and the movie.
+// The decoding time of each track is rebased to begin at 0.
+func (b *progressiveBuilder) buildTracks() {
+	b.movieDuration = 0
+	for _, track := range b.tracks {
+		track.muxerTrack = &muxerTrack{trackID: track.trackID, timescale: track.timescale}
+		var base uint64
+		if len(track.samples) != 0 {
+			base = track.samples[0].DecodeTime
+		}
+		for _, s := range track.samples {
+			dts := int64(s.DecodeTime - base)
+			track.muxerTrack.samples = append(track.muxerTrack.samples, muxerSample{
+				size:   s.Size,
+				dts:    dts,
+				pts:    dts + s.CompositionTimeOffset,
+				isSync: s.IsSync,
+			})
+		}
+		if track.auxInfo != nil {
+			for len(track.auxInfo.sizes) < len(track.samples) {
+				track.auxInfo.sizes = append(track.auxInfo.sizes, 0)
+			}
+		}
+
+		track.trackDuration = 0
+		if len(track.edits) != 0 {
+			for _, e := range track.edits {
+				track.trackDuration += e.SegmentDurationV1
+			}
+		} else {
+			track.trackDuration = scaleUint(track.mediaDuration, track.timescale, b.movieTimescale)
+		}
+		if track.trackDuration > b.movieDuration {
+			b.movieDuration = track.trackDuration
+		}
+	}
+}
+
+// write writes ftyp, mdat and moov boxes.
+// moov box is copied from src.
+func (b *progressiveBuilder) write(src io.ReadSeeker, w io.WriteSeeker) error {
+	pw := NewWriter(w)
+	if err := writeMuxBox(pw, &muxBox{box: b.ftyp}); err != nil {
+		return err
+	}
+	offset, err := startMdat(pw)
+	if err != nil {
+		return err
+	}
+	for _, c := range b.chunks {
+		samples := c.track.samples[c.begin:c.end]
+		if err := copySamples(pw, c.src, samples); err != nil {
+			return err
+		}
+		c.track.muxerTrack.chunks = append(c.track.muxerTrack.chunks, muxerChunk{
+			offset:                 offset,
+			sampleCount:            uint32(len(samples)),
+			sampleDescriptionIndex: c.sampleDescriptionIndex,
+		})
+		for _, s := range samples {
+			offset += uint64(s.Size)
+		}
+	}
+	for _, track := range b.tracks {
+		if track.auxInfo == nil {
+			continue
+		}
+		if _, err := pw.Write(track.auxInfo.data); err != nil {
+			return err
+		}
+		track.auxInfo.offset = offset
+		offset += uint64(len(track.auxInfo.data))
+	}
+	if _, err := pw.EndBox(); err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return b.writeMoov(src, pw)
+}
+
+// copySamples copies the data of the samples from src to w.
+func copySamples(w io.Writer, src io.ReadSeeker, samples []Sample) error {
+	for i := 0; i < len(samples); {
+		begin := samples[i].Offset
+		end := begin + uint64(samples[i].Size)
+		for i++; i < len(samples) && samples[i].Offset == end; i++ {
+			end += uint64(samples[i].Size)
+		}
+		if _, err := src.Seek(int64(begin), io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, src, int64(end-begin)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMoov copies moov box of the input with the rebuilt sample tables, edit lists and durations.
+func (b *progressiveBuilder) writeMoov(r io.ReadSeeker, w *Writer) error {
+	var track *progressiveTrack
+	var edtsWritten bool
+	writeEdts := func() error {
+		if edtsWritten || len(track.edits) == 0 {
+			return nil
+		}
+		edtsWritten = true
+		return writeMuxBox(w, &muxBox{box: &Edts{}, children: []*muxBox{{box: newElst(track.edits)}}})
+	}
+
+	moovs, err := ExtractBox(r, nil, BoxPath{BoxTypeMoov()})
+	if err != nil {
+		return err
+	}
+	if len(moovs) == 0 {
+		return errors.New("moov box is not found")
+	}
+
+	var traksWritten bool
+	var handler ReadHandler
+	handler = func(h *ReadHandle) (interface{}, error) {
+		if h.BoxInfo.Type == BoxTypeTrak() && len(h.Path) == 2 {
+			// trak boxes are written in the order of the tracks
+			if !traksWritten {
+				traksWritten = true
+				for _, t := range b.tracks {
+					if _, err := ReadBoxStructureFromInternal(r, t.trak, handler); err != nil {
+						return nil, err
+					}
+				}
+			}
+			return nil, nil
+		}
+		if h.BoxInfo.Type == BoxTypeTrak() {
+			track = nil
+		}
+		if len(h.Path) >= 2 {
+			switch parent := h.Path[len(h.Path)-2]; {
+			case parent == BoxTypeMoov() && h.BoxInfo.Type == BoxTypeMvex():
+				return nil, nil
+			case parent == BoxTypeTrak() && h.BoxInfo.Type == StrToBoxType("tref") && b.trackIDs != nil:
+				return nil, writeTrackReferences(w, r, &h.BoxInfo, b.trackIDs)
+			case parent == BoxTypeTrak() && h.BoxInfo.Type == BoxTypeEdts():
+				return nil, writeEdts()
+			case parent == BoxTypeTrak() && h.BoxInfo.Type == BoxTypeMdia():
+				if track == nil {
+					return nil, errors.New("tkhd box is not found")
+				}
+				if err := writeEdts(); err != nil {
+					return nil, err
+				}
+			case parent == BoxTypeStbl() && h.BoxInfo.Type != BoxTypeStsd() && h.BoxInfo.Type != BoxTypeSgpd():
+				// the sample tables are written after stsd
+				return nil, nil
+			}
+		}
+
+		if !h.BoxInfo.IsSupportedType() {
+			return nil, w.CopyBox(r, &h.BoxInfo)
+		}
+
+		if _, err := w.StartBox(&h.BoxInfo); err != nil {
+			return nil, err
+		}
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		switch box := box.(type) {
+		case *Mvhd:
+			if box.GetVersion() == 0 && b.movieDuration > math.MaxUint32 {
+				box.SetVersion(1)
+				box.CreationTimeV1 = uint64(box.CreationTimeV0)
+				box.ModificationTimeV1 = uint64(box.ModificationTimeV0)
+			}
+			box.DurationV0 = uint32(b.movieDuration)
+			box.DurationV1 = b.movieDuration
+			if b.trackIDs != nil {
+				box.NextTrackID = nextTrackID(b.trackIDs)
+			}
+		case *Tkhd:
+			track = nil
+			for _, t := range b.tracks {
+				if t.trackID == box.TrackID {
+					track = t
+				}
+			}
+			if track == nil {
+				return nil, fmt.Errorf("track not found: trackID=%d", box.TrackID)
+			}
+			if b.trackIDs != nil {
+				box.TrackID = b.trackIDs[box.TrackID]
+			}
+			edtsWritten = false
+			if box.GetVersion() == 0 && track.trackDuration > math.MaxUint32 {
+				box.SetVersion(1)
+				box.CreationTimeV1 = uint64(box.CreationTimeV0)
+				box.ModificationTimeV1 = uint64(box.ModificationTimeV0)
+			}
+			box.DurationV0 = uint32(track.trackDuration)
+			box.DurationV1 = track.trackDuration
+		case *Mdhd:
+			if box.GetVersion() == 0 && track.mediaDuration > math.MaxUint32 {
+				box.SetVersion(1)
+				box.CreationTimeV1 = uint64(box.CreationTimeV0)
+				box.ModificationTimeV1 = uint64(box.ModificationTimeV0)
+			}
+			box.DurationV0 = uint32(track.mediaDuration)
+			box.DurationV1 = track.mediaDuration
+		}
+		if stsd, ok := box.(*Stsd); ok && track.sampleEntries != nil {
+			if err := writeSampleEntries(w, stsd, h.BoxInfo.Context, track.sampleEntries); err != nil {
+				return nil, err
+			}
+		} else {
+			if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+				return nil, err
+			}
+			if _, err := h.Expand(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := w.EndBox(); err != nil {
+			return nil, err
+		}
+
+		if h.BoxInfo.Type == BoxTypeStsd() {
+			for _, mb := range track.sampleTable() {
+				if err := writeMuxBox(w, mb); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	}
+	_, err = ReadBoxStructureFromInternal(r, moovs[0], handler)
+	return err
+}
+
+// writeSampleEntries writes the payload of stsd box which has the sample entries.
+func writeSampleEntries(w io.Writer, stsd *Stsd, ctx Context, entries [][]byte) error {
+	stsd.EntryCount = uint32(len(entries))
+	if _, err := Marshal(w, stsd, ctx); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := w.Write(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sampleTable returns the boxes which follow stsd.
+func (track *progressiveTrack) sampleTable() []*muxBox {
+	boxes := track.muxerTrack.buildSampleTable(track.durations)
+	for _, sbgp := range track.sbgps {
+		boxes = append(boxes, &muxBox{box: sbgp})
+	}
+	if track.auxInfo == nil {
+		return boxes
+	}
+
+	src := track.auxInfo.saiz
+	saiz := newSaiz(src, track.auxInfo.sizes)
+
+	saio := &Saio{
+		FullBox:              src.FullBox,
+		AuxInfoType:          src.AuxInfoType,
+		AuxInfoTypeParameter: src.AuxInfoTypeParameter,
+		EntryCount:           1,
+	}
+	if track.auxInfo.offset > math.MaxUint32 {
+		saio.SetVersion(1)
+		saio.OffsetV1 = []uint64{track.auxInfo.offset}
+	} else {
+		saio.SetVersion(0)
+		saio.OffsetV0 = []uint32{uint32(track.auxInfo.offset)}
+	}
+	return append(boxes, &muxBox{box: saiz}, &muxBox{box: saio})
+}
+
+// newSaiz returns saiz box which has the sizes and the auxiliary information type of src.
+func newSaiz(src *Saiz, sizes []uint8) *Saiz {
+	saiz := &Saiz{
+		FullBox:              src.FullBox,
+		AuxInfoType:          src.AuxInfoType,
+		AuxInfoTypeParameter: src.AuxInfoTypeParameter,
+		SampleCount:          uint32(len(sizes)),
+	}
+	saiz.SetVersion(0)
+	constantSize := len(sizes) != 0
+	for _, size := range sizes {
+		if size != sizes[0] {
+			constantSize = false
+		}
+	}
+	if constantSize && sizes[0] != 0 {
+		saiz.DefaultSampleInfoSize = sizes[0]
+	} else {
+		saiz.SampleInfoSize = sizes
+	}
+	return saiz
+}
@@ -0,0 +1,301 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Query returns the boxes which match the query expression in the order of the file.
+//
+// The expression is the steps separated by "/", and "//" selects the descendants at any depth instead of the children.
+// Each step is a box type or "*" followed by the predicates in brackets:
+//   - [N] selects the N-th box (1-origin) of the matched siblings.
+//   - [Field=value] or [Field!=value] compares the field of the decoded payload,
+//     where Field is the name of the struct field such as TrackID.
+//   - [path.Field=value] compares the field of the descendant box at the relative path such as tkhd or mdia/hdlr.
+//
+// The value is compared as a number for the integer fields, and as a UUID, a hex string with 0x prefix
+// or a string for the byte array fields. For example:
+//
+//	moov/trak[tkhd.TrackID=2]//stsd/*
+//	//pssh[SystemID=edef8ba9-79d6-4ace-a3c8-27dcd51d21ed]
+//	moof[3]/traf/trun
+func Query(r io.ReadSeeker, expr string) ([]*Node, error) {
+	q, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	root, err := ParseTree(r)
+	if err != nil {
+		return nil, err
+	}
+	return q.eval(root), nil
+}
+
+// Query returns the descendants which match the query expression relative to the node.
+// See Query for the syntax of the expression.
+func (n *Node) Query(expr string) ([]*Node, error) {
+	q, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return q.eval(n), nil
+}
+
+type query []*queryStep
+
+type queryStep struct {
+	descendant bool
+	boxType    BoxType
+	predicates []*queryPredicate
+}
+
+type queryPredicate struct {
+	// index is 1-origin position, and it is 0 for the field predicate.
+	index int
+
+	path    BoxPath
+	field   string
+	negated bool
+	value   string
+}
+
+func parseQuery(expr string) (query, error) {
+	var q query
+	s := expr
+	for i := 0; ; i++ {
+		step := &queryStep{}
+		if strings.HasPrefix(s, "//") {
+			step.descendant = true
+			s = s[2:]
+		} else if strings.HasPrefix(s, "/") {
+			s = s[1:]
+		} else if i != 0 {
+			return nil, fmt.Errorf("invalid query: %s", expr)
+		}
+
+		end := strings.IndexAny(s, "/[")
+		if end < 0 {
+			end = len(s)
+		}
+		boxType, err := parseQueryBoxType(s[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid query: %s: %v", expr, err)
+		}
+		step.boxType = boxType
+		s = s[end:]
+
+		for strings.HasPrefix(s, "[") {
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid query: unclosed bracket: %s", expr)
+			}
+			p, err := parseQueryPredicate(s[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid query: %s: %v", expr, err)
+			}
+			step.predicates = append(step.predicates, p)
+			s = s[end+1:]
+		}
+
+		q = append(q, step)
+		if s == "" {
+			return q, nil
+		}
+	}
+}
+
+func parseQueryBoxType(s string) (BoxType, error) {
+	if s == "*" {
+		return BoxTypeAny(), nil
+	}
+	b := strings.ReplaceAll(s, "(c)", string([]byte{0xa9}))
+	if len(b) != 4 {
+		return BoxType{}, fmt.Errorf("invalid box type: [%s]", s)
+	}
+	return StrToBoxType(b), nil
+}
+
+func parseQueryPredicate(s string) (*queryPredicate, error) {
+	if index, err := strconv.Atoi(s); err == nil {
+		if index < 1 {
+			return nil, fmt.Errorf("invalid index: %d", index)
+		}
+		return &queryPredicate{index: index}, nil
+	}
+
+	p := &queryPredicate{}
+	eq := strings.Index(s, "=")
+	if eq < 0 {
+		return nil, fmt.Errorf("invalid predicate: [%s]", s)
+	}
+	lhs := s[:eq]
+	if strings.HasSuffix(lhs, "!") {
+		p.negated = true
+		lhs = lhs[:len(lhs)-1]
+	}
+	p.value = strings.Trim(s[eq+1:], "\"")
+
+	if dot := strings.LastIndex(lhs, "."); dot >= 0 {
+		for _, t := range strings.Split(lhs[:dot], "/") {
+			boxType, err := parseQueryBoxType(t)
+			if err != nil {
+				return nil, err
+			}
+			p.path = append(p.path, boxType)
+		}
+		lhs = lhs[dot+1:]
+	}
+	if lhs == "" {
+		return nil, fmt.Errorf("invalid predicate: [%s]", s)
+	}
+	p.field = lhs
+	return p, nil
+}
+
+func (q query) eval(n *Node) []*Node {
+	nodes := []*Node{n}
+	for _, step := range q {
+		nodes = step.eval(nodes)
+	}
+
+	// the nodes are sorted in the order of the file
+	matched := make(map[*Node]struct{}, len(nodes))
+	for _, m := range nodes {
+		matched[m] = struct{}{}
+	}
+	var sorted []*Node
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if _, ok := matched[n]; ok {
+			sorted = append(sorted, n)
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sorted
+}
+
+func (step *queryStep) eval(nodes []*Node) []*Node {
+	var parents []*Node
+	visited := make(map[*Node]struct{})
+	var add func(n *Node)
+	add = func(n *Node) {
+		if _, ok := visited[n]; ok {
+			return
+		}
+		visited[n] = struct{}{}
+		parents = append(parents, n)
+		if step.descendant {
+			for _, c := range n.Children {
+				add(c)
+			}
+		}
+	}
+	for _, n := range nodes {
+		add(n)
+	}
+
+	var result []*Node
+	for _, parent := range parents {
+		var candidates []*Node
+		for _, c := range parent.Children {
+			if step.boxType.MatchWith(c.BoxInfo.Type) {
+				candidates = append(candidates, c)
+			}
+		}
+		for _, p := range step.predicates {
+			candidates = p.filter(candidates)
+		}
+		result = append(result, candidates...)
+	}
+	return result
+}
+
+func (p *queryPredicate) filter(nodes []*Node) []*Node {
+	if p.index != 0 {
+		if p.index > len(nodes) {
+			return nil
+		}
+		return nodes[p.index-1 : p.index]
+	}
+	var result []*Node
+	for _, n := range nodes {
+		if p.match(n) != p.negated {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+func (p *queryPredicate) match(n *Node) bool {
+	targets := []*Node{n}
+	if len(p.path) != 0 {
+		targets = n.Find(p.path)
+	}
+	for _, t := range targets {
+		// the payload is decoded without being retained, so that the tree is written as it is
+		box := t.payload
+		if box == nil {
+			if t.src == nil {
+				continue
+			}
+			var err error
+			if box, err = t.decodeCopy(); err != nil {
+				continue
+			}
+		}
+		v := reflect.ValueOf(box).Elem()
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		f := v.FieldByName(p.field)
+		if f.IsValid() && matchQueryValue(f, p.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchQueryValue(v reflect.Value, s string) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 0, 64)
+		return err == nil && v.Int() == i
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 0, 64)
+		return err == nil && v.Uint() == u
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		return err == nil && v.Bool() == b
+	case reflect.String:
+		return v.String() == s
+	case reflect.Array, reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		data := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(data), v)
+		if len(data) == 16 {
+			if u, err := uuid.Parse(s); err == nil {
+				return bytes.Equal(data, u[:])
+			}
+		}
+		if strings.HasPrefix(s, "0x") {
+			if b, err := hex.DecodeString(s[2:]); err == nil {
+				return bytes.Equal(data, b)
+			}
+		}
+		return string(data) == s
+	}
+	return fmt.Sprint(v.Interface()) == s
+}
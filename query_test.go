@@ -0,0 +1,101 @@
+package mp4
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	testCases := []struct {
+		name     string
+		file     string
+		expr     string
+		expected []BoxType
+		offsets  []uint64
+	}{
+		{name: "children", file: "./_examples/sample.mp4", expr: "moov/trak", expected: []BoxType{BoxTypeTrak(), BoxTypeTrak()}},
+		{name: "leading slash", file: "./_examples/sample.mp4", expr: "/moov/trak/tkhd", offsets: []uint64{6566, 7309}},
+		{name: "descendants", file: "./_examples/sample.mp4", expr: "//stco", expected: []BoxType{BoxTypeStco(), BoxTypeStco()}},
+		{name: "descendants of step", file: "./_examples/sample.mp4", expr: "moov/trak[tkhd.TrackID=2]//stsd/*", expected: []BoxType{StrToBoxType("mp4a")}},
+		{name: "any", file: "./_examples/sample.mp4", expr: "moov/*/tkhd", offsets: []uint64{6566, 7309}},
+		{name: "index", file: "./_examples/sample.mp4", expr: "moov/trak[2]/tkhd", offsets: []uint64{7309}},
+		{name: "out of index", file: "./_examples/sample.mp4", expr: "moov/trak[3]"},
+		{name: "string field", file: "./_examples/sample.mp4", expr: "//hdlr[HandlerType=soun]", expected: []BoxType{BoxTypeHdlr()}},
+		{name: "quoted string field", file: "./_examples/sample.mp4", expr: "//hdlr[Name=\"VideoHandle\"]", expected: []BoxType{BoxTypeHdlr()}},
+		{name: "negated field of path", file: "./_examples/sample.mp4", expr: "moov/trak[mdia/hdlr.HandlerType!=vide]/tkhd", offsets: []uint64{7309}},
+		{name: "hex field", file: "./_examples/sample.mp4", expr: "//vmhd[Flags=0x000001]", expected: []BoxType{BoxTypeVmhd()}},
+		{name: "unknown field", file: "./_examples/sample.mp4", expr: "moov/trak[Unknown=1]"},
+		{name: "nested descendants", file: "./_examples/sample.mp4", expr: "//minf//dref/*", expected: []BoxType{BoxTypeUrl(), BoxTypeUrl()}},
+		{name: "fragment", file: "./_examples/sample_fragmented.mp4", expr: "moof[3]/traf/trun", expected: []BoxType{BoxTypeTrun()}},
+		{name: "predicates in order", file: "./_examples/sample_fragmented.mp4", expr: "moof/traf[tfhd.TrackID=2][1]", expected: []BoxType{BoxTypeTraf(), BoxTypeTraf(), BoxTypeTraf(), BoxTypeTraf()}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Open(tc.file)
+			require.NoError(t, err)
+			defer f.Close()
+			nodes, err := Query(f, tc.expr)
+			require.NoError(t, err)
+			if tc.offsets != nil {
+				offsets := make([]uint64, 0, len(nodes))
+				for _, n := range nodes {
+					offsets = append(offsets, n.BoxInfo.Offset)
+				}
+				assert.Equal(t, tc.offsets, offsets)
+				return
+			}
+			types := make([]BoxType, 0, len(nodes))
+			for _, n := range nodes {
+				types = append(types, n.Type())
+			}
+			if tc.expected == nil {
+				tc.expected = []BoxType{}
+			}
+			assert.Equal(t, tc.expected, types)
+		})
+	}
+}
+
+func TestQueryUUID(t *testing.T) {
+	data, err := ioutil.ReadFile("./_examples/sample.mp4")
+	require.NoError(t, err)
+	root, err := ParseTree(bytes.NewReader(data))
+	require.NoError(t, err)
+	moov := root.Find(BoxPath{BoxTypeMoov()})
+	require.Len(t, moov, 1)
+	moov[0].Append(NewNode(&Pssh{SystemID: uuid.MustParse("1077efec-c0b2-4d02-ace3-3c1e52e2fb4b")}))
+	moov[0].Append(NewNode(&Pssh{SystemID: uuid.MustParse("edef8ba9-79d6-4ace-a3c8-27dcd51d21ed")}))
+
+	nodes, err := root.Query("//pssh[SystemID=edef8ba9-79d6-4ace-a3c8-27dcd51d21ed]")
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	assert.Equal(t, moov[0].Children[len(moov[0].Children)-1], nodes[0])
+
+	// the query is relative to the node
+	nodes, err = moov[0].Query("pssh")
+	require.NoError(t, err)
+	assert.Len(t, nodes, 2)
+}
+
+func TestQueryInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"moov/",
+		"moov/tra",
+		"moov[",
+		"moov[0]",
+		"moov[TrackID]",
+		"moov[=1]",
+		"moov/trak[tk.TrackID=1]",
+		"moov]",
+	} {
+		_, err := parseQuery(expr)
+		assert.Error(t, err, expr)
+	}
+}
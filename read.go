@@ -39,14 +39,15 @@ func ReadBoxStructure(r io.ReadSeeker, handler ReadHandler, params ...interface{
 	if _, err := r.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
-	return readBoxStructure(r, 0, true, nil, Context{}, handler, params)
+	return readBoxStructure(r, 0, true, nil, Context{}, handler, params, nil)
 }
 
 func ReadBoxStructureFromInternal(r io.ReadSeeker, bi *BoxInfo, handler ReadHandler, params ...interface{}) (interface{}, error) {
-	return readBoxStructureFromInternal(r, bi, nil, handler, params)
+	return readBoxStructureFromInternal(r, bi, nil, handler, params, nil)
 }
 
-func readBoxStructureFromInternal(r io.ReadSeeker, bi *BoxInfo, path BoxPath, handler ReadHandler, params []interface{}) (interface{}, error) {
+// readBoxStructureFromInternal reads the box, and the children are read in the lenient mode when report is not nil.
+func readBoxStructureFromInternal(r io.ReadSeeker, bi *BoxInfo, path BoxPath, handler ReadHandler, params []interface{}, report *ParseReport) (interface{}, error) {
 	if _, err := bi.SeekToPayload(r); err != nil {
 		return nil, err
 	}
@@ -65,23 +66,8 @@ func readBoxStructureFromInternal(r io.ReadSeeker, bi *BoxInfo, path BoxPath, ha
 		}
 	}
 
-	ctx := bi.Context
-	if bi.Type == BoxTypeWave() {
-		ctx.UnderWave = true
-	} else if bi.Type == BoxTypeIlst() {
-		ctx.UnderIlst = true
-	} else if bi.UnderIlst && !bi.UnderIlstMeta && IsIlstMetaBoxType(bi.Type) {
-		ctx.UnderIlstMeta = true
-		if bi.Type == StrToBoxType("----") {
-			ctx.UnderIlstFreeMeta = true
-		}
-	} else if bi.Type == BoxTypeUdta() {
-		ctx.UnderUdta = true
-	}
-
-	newPath := make(BoxPath, len(path)+1)
-	copy(newPath, path)
-	newPath[len(path)] = bi.Type
+	ctx := childContext(bi)
+	newPath := childPath(path, bi)
 
 	h := &ReadHandle{
 		Params:  params,
@@ -134,7 +120,7 @@ func readBoxStructureFromInternal(r io.ReadSeeker, bi *BoxInfo, path BoxPath, ha
 		}
 
 		childrenSize := bi.Offset + bi.Size - childrenOffset
-		return readBoxStructure(r, childrenSize, false, newPath, ctx, handler, params)
+		return readBoxStructure(r, childrenSize, false, newPath, ctx, handler, params, report)
 	}
 
 	if val, err := handler(h); err != nil {
@@ -146,7 +132,36 @@ func readBoxStructureFromInternal(r io.ReadSeeker, bi *BoxInfo, path BoxPath, ha
 	}
 }
 
-func readBoxStructure(r io.ReadSeeker, totalSize uint64, isRoot bool, path BoxPath, ctx Context, handler ReadHandler, params []interface{}) ([]interface{}, error) {
+// childContext returns the context of the children of the box.
+func childContext(bi *BoxInfo) Context {
+	ctx := bi.Context
+	if bi.Type == BoxTypeWave() {
+		ctx.UnderWave = true
+	} else if bi.Type == BoxTypeIlst() {
+		ctx.UnderIlst = true
+	} else if bi.UnderIlst && !bi.UnderIlstMeta && IsIlstMetaBoxType(bi.Type) {
+		ctx.UnderIlstMeta = true
+		if bi.Type == StrToBoxType("----") {
+			ctx.UnderIlstFreeMeta = true
+		}
+	} else if bi.Type == BoxTypeUdta() {
+		ctx.UnderUdta = true
+	}
+	return ctx
+}
+
+func childPath(path BoxPath, bi *BoxInfo) BoxPath {
+	newPath := make(BoxPath, len(path)+1)
+	copy(newPath, path)
+	newPath[len(path)] = bi.Type
+	return newPath
+}
+
+func readBoxStructure(r io.ReadSeeker, totalSize uint64, isRoot bool, path BoxPath, ctx Context, handler ReadHandler, params []interface{}, report *ParseReport) ([]interface{}, error) {
+	if report != nil {
+		return readBoxStructureLenient(r, totalSize, isRoot, path, ctx, handler, params, report)
+	}
+
 	vals := make([]interface{}, 0, 8)
 
 	for isRoot || totalSize != 0 {
@@ -164,7 +179,7 @@ func readBoxStructure(r io.ReadSeeker, totalSize uint64, isRoot bool, path BoxPa
 
 		bi.Context = ctx
 
-		val, err := readBoxStructureFromInternal(r, bi, path, handler, params)
+		val, err := readBoxStructureFromInternal(r, bi, path, handler, params, nil)
 		if err != nil {
 			return nil, err
 		}
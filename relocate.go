@@ -0,0 +1,381 @@
+package mp4
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// relocation maps the offsets in the source to the offsets in the output of Node.WriteTo.
+type relocation struct {
+	// starts and ends map the start and the end of the boxes in the source.
+	starts map[uint64]uint64
+	ends   map[uint64]uint64
+
+	// ranges are the payloads which are copied from the source as they are.
+	ranges []relocationRange
+
+	// end is the end of the last box in the source.
+	end uint64
+
+	// promoted is true when stco boxes are promoted to co64 boxes, and the layout must be computed again.
+	promoted bool
+}
+
+type relocationRange struct {
+	src  uint64
+	size uint64
+	out  uint64
+}
+
+func newRelocation() *relocation {
+	return &relocation{
+		starts: make(map[uint64]uint64),
+		ends:   make(map[uint64]uint64),
+	}
+}
+
+// offsetOf returns the offset in the output which corresponds to the offset in the source.
+// The offset must be the start of a box or in a payload which is copied from the source.
+func (rl *relocation) offsetOf(src uint64) (uint64, bool) {
+	if out, ok := rl.starts[src]; ok {
+		return out, true
+	}
+	i := sort.Search(len(rl.ranges), func(i int) bool {
+		return rl.ranges[i].src+rl.ranges[i].size > src
+	})
+	if i < len(rl.ranges) && rl.ranges[i].src <= src {
+		return rl.ranges[i].out + src - rl.ranges[i].src, true
+	}
+	// the data beyond the end of the source, such as the data of a truncated file, follows the last box
+	if out, ok := rl.ends[rl.end]; ok && src >= rl.end {
+		return out + src - rl.end, true
+	}
+	return 0, false
+}
+
+// boundaryOf returns the offset in the output which corresponds to the boundary between boxes in the source.
+// The end of the preceding box is preferred to the start of the following box when end is true.
+func (rl *relocation) boundaryOf(src uint64, end bool) (uint64, bool) {
+	if end {
+		if out, ok := rl.ends[src]; ok {
+			return out, true
+		}
+	}
+	if out, ok := rl.offsetOf(src); ok {
+		return out, true
+	}
+	out, ok := rl.ends[src]
+	return out, ok
+}
+
+// isRelocatable returns true if the box has offsets which should be relocated.
+func isRelocatable(boxType BoxType) bool {
+	switch boxType {
+	case BoxTypeStco(), BoxTypeCo64(), BoxTypeTfhd(), BoxTypeTrun(), BoxTypeSaio(), BoxTypeTfra(), BoxTypeSidx(), BoxTypeIloc():
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeCopy decodes the payload without retaining it, so that the payload is kept as the source.
+func (n *Node) decodeCopy() (IBox, error) {
+	if n.payload == nil {
+		if _, err := n.BoxInfo.SeekToPayload(n.src); err != nil {
+			return nil, err
+		}
+		box, _, err := UnmarshalAny(n.src, n.BoxInfo.Type, n.payloadSize, n.BoxInfo.Context)
+		return box, err
+	}
+	buf := bytes.NewBuffer(nil)
+	if _, err := Marshal(buf, n.payload, n.BoxInfo.Context); err != nil {
+		return nil, err
+	}
+	box, _, err := UnmarshalAny(bytes.NewReader(buf.Bytes()), n.BoxInfo.Type, uint64(buf.Len()), n.BoxInfo.Context)
+	return box, err
+}
+
+// place assigns the offsets in the output to the node and its descendants, and records them into rl.
+func (n *Node) place(offset uint64, rl *relocation) {
+	n.offset = offset
+	if !n.isRoot() {
+		if n.src != nil {
+			rl.starts[n.BoxInfo.Offset] = offset
+			// the end of the parent is preferred to the end of the last child
+			if _, ok := rl.ends[n.BoxInfo.Offset+n.BoxInfo.Size]; !ok {
+				rl.ends[n.BoxInfo.Offset+n.BoxInfo.Size] = offset + n.size
+			}
+			if n.BoxInfo.Offset+n.BoxInfo.Size > rl.end {
+				rl.end = n.BoxInfo.Offset + n.BoxInfo.Size
+			}
+		}
+		offset += n.headerSize
+		if n.isEncoded() {
+			offset += uint64(len(n.encoded))
+		} else {
+			if n.src != nil && n.payloadSize != 0 {
+				rl.ranges = append(rl.ranges, relocationRange{
+					src:  n.BoxInfo.Offset + n.BoxInfo.HeaderSize,
+					size: n.payloadSize,
+					out:  offset,
+				})
+			}
+			offset += n.payloadSize
+		}
+	}
+	for _, c := range n.Children {
+		c.place(offset, rl)
+		offset += c.size
+	}
+}
+
+// relocate rewrites the offsets in the boxes of the source to the offsets in the output.
+func (n *Node) relocate(rl *relocation) error {
+	switch box := n.fixed.(type) {
+	case *Stco:
+		for i, offset := range box.ChunkOffset {
+			out, err := n.offsetOf(rl, uint64(offset))
+			if err != nil {
+				return err
+			}
+			if out > math.MaxUint32 {
+				// the box is written as co64 box in the next layout
+				n.co64 = true
+				rl.promoted = true
+				break
+			}
+			box.ChunkOffset[i] = uint32(out)
+		}
+	case *Co64:
+		for i, offset := range box.ChunkOffset {
+			out, err := n.offsetOf(rl, offset)
+			if err != nil {
+				return err
+			}
+			box.ChunkOffset[i] = out
+		}
+	case *Saio:
+		// saio box in traf box is relocated with the base data offset
+		if n.Parent == nil || n.Parent.BoxInfo.Type != BoxTypeTraf() {
+			if err := n.relocateSaio(rl, box, 0, 0); err != nil {
+				return err
+			}
+		}
+	case *Tfra:
+		for i := range box.Entries {
+			e := &box.Entries[i]
+			offset := e.MoofOffsetV1
+			if box.GetVersion() == 0 {
+				offset = uint64(e.MoofOffsetV0)
+			}
+			out, err := n.offsetOf(rl, offset)
+			if err != nil {
+				return err
+			}
+			if box.GetVersion() == 0 {
+				if out > math.MaxUint32 {
+					return fmt.Errorf("too large moof offset for tfra box version 0: offset=%d", out)
+				}
+				e.MoofOffsetV0 = uint32(out)
+			} else {
+				e.MoofOffsetV1 = out
+			}
+		}
+	case *Sidx:
+		if err := n.relocateSidx(rl, box); err != nil {
+			return err
+		}
+	case *Iloc:
+		if err := n.relocateIloc(rl, box); err != nil {
+			return err
+		}
+	}
+
+	var trafs int
+	for _, c := range n.Children {
+		if n.BoxInfo.Type == BoxTypeMoof() && c.BoxInfo.Type == BoxTypeTraf() {
+			if err := c.relocateTraf(rl, n, trafs == 0); err != nil {
+				return err
+			}
+			trafs++
+		}
+		if err := c.relocate(rl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relocateTraf relocates the base data offset of tfhd box,
+// and the data offsets of trun boxes and the offsets of saio boxes which are relative to it.
+func (n *Node) relocateTraf(rl *relocation, moof *Node, first bool) error {
+	var tfhd *Tfhd
+	for _, c := range n.Children {
+		if b, ok := c.fixed.(*Tfhd); ok {
+			tfhd = b
+			break
+		}
+	}
+	if tfhd == nil {
+		return nil
+	}
+
+	var base, outBase uint64
+	switch {
+	case tfhd.CheckFlag(TfhdBaseDataOffsetPresent):
+		var err error
+		base = tfhd.BaseDataOffset
+		if outBase, err = n.offsetOf(rl, base); err != nil {
+			return err
+		}
+		tfhd.BaseDataOffset = outBase
+	case tfhd.CheckFlag(TfhdDefaultBaseIsMoof) || first:
+		if moof.src == nil {
+			return nil
+		}
+		base, outBase = moof.BoxInfo.Offset, moof.offset
+	default:
+		// the base is the end of the data of the preceding traf box
+		for _, c := range n.Children {
+			if trun, ok := c.fixed.(*Trun); (ok && trun.CheckFlag(TrunDataOffsetPresent)) || c.BoxInfo.Type == BoxTypeSaio() {
+				return fmt.Errorf("implicit base data offset is not supported for relocation: offset=%d", n.BoxInfo.Offset)
+			}
+		}
+		return nil
+	}
+
+	for _, c := range n.Children {
+		switch box := c.fixed.(type) {
+		case *Trun:
+			if !box.CheckFlag(TrunDataOffsetPresent) {
+				continue
+			}
+			out, err := c.offsetOf(rl, uint64(int64(base)+int64(box.DataOffset)))
+			if err != nil {
+				return err
+			}
+			offset := int64(out) - int64(outBase)
+			if offset < math.MinInt32 || offset > math.MaxInt32 {
+				return fmt.Errorf("too large data offset for trun box: offset=%d", offset)
+			}
+			box.DataOffset = int32(offset)
+		case *Saio:
+			if err := c.relocateSaio(rl, box, base, outBase); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Node) relocateSaio(rl *relocation, saio *Saio, base, outBase uint64) error {
+	for i := 0; i < int(saio.EntryCount); i++ {
+		out, err := n.offsetOf(rl, base+saioOffset(saio, i))
+		if err != nil {
+			return err
+		}
+		out -= outBase
+		if saio.GetVersion() == 0 {
+			if out > math.MaxUint32 {
+				return fmt.Errorf("too large offset for saio box version 0: offset=%d", out)
+			}
+			saio.OffsetV0[i] = uint32(out)
+		} else {
+			saio.OffsetV1[i] = out
+		}
+	}
+	return nil
+}
+
+// relocateSidx relocates the first offset and the referenced sizes,
+// so that the references have the ranges in the output which correspond to the ranges in the source.
+func (n *Node) relocateSidx(rl *relocation, sidx *Sidx) error {
+	if len(sidx.References) == 0 {
+		return nil
+	}
+	firstOffset := sidx.FirstOffsetV1
+	if sidx.GetVersion() == 0 {
+		firstOffset = uint64(sidx.FirstOffsetV0)
+	}
+	src := n.BoxInfo.Offset + n.BoxInfo.Size + firstOffset
+	start, ok := rl.boundaryOf(src, false)
+	if !ok || start < n.offset+n.size {
+		return fmt.Errorf("sidx box refers to data which is removed or moved: offset=%d", src)
+	}
+	if sidx.GetVersion() == 0 {
+		if start-n.offset-n.size > math.MaxUint32 {
+			return fmt.Errorf("too large first offset for sidx box version 0: offset=%d", start-n.offset-n.size)
+		}
+		sidx.FirstOffsetV0 = uint32(start - n.offset - n.size)
+	} else {
+		sidx.FirstOffsetV1 = start - n.offset - n.size
+	}
+	for i := range sidx.References {
+		ref := &sidx.References[i]
+		src += uint64(ref.ReferencedSize)
+		end, ok := rl.boundaryOf(src, i == len(sidx.References)-1)
+		if !ok || end <= start || end-start >= 1<<31 {
+			return fmt.Errorf("sidx box refers to data which is removed or moved: offset=%d", src)
+		}
+		ref.ReferencedSize = uint32(end - start)
+		start = end
+	}
+	return nil
+}
+
+// relocateIloc relocates the file offsets of the items which are in the same file.
+// The extent offsets are kept relative to the base offset when the base offset is used.
+func (n *Node) relocateIloc(rl *relocation, iloc *Iloc) error {
+	for i := range iloc.Items {
+		item := &iloc.Items[i]
+		if item.ConstructionMethod != IlocConstructionMethodFileOffset || item.DataReferenceIndex != 0 {
+			continue
+		}
+		var outBase uint64
+		if item.BaseOffset != 0 || iloc.OffsetSize == 0 {
+			var err error
+			if outBase, err = n.offsetOf(rl, item.BaseOffset); err != nil {
+				return err
+			}
+			if err := checkIlocSize(outBase, iloc.BaseOffsetSize); err != nil {
+				return err
+			}
+		}
+		if iloc.OffsetSize != 0 {
+			for j := range item.Extents {
+				e := &item.Extents[j]
+				out, err := n.offsetOf(rl, item.BaseOffset+e.ExtentOffset)
+				if err != nil {
+					return err
+				}
+				if out < outBase {
+					return fmt.Errorf("iloc box has extent which is moved before the base offset: offset=%d", item.BaseOffset+e.ExtentOffset)
+				}
+				if err := checkIlocSize(out-outBase, iloc.OffsetSize); err != nil {
+					return err
+				}
+				e.ExtentOffset = out - outBase
+			}
+		}
+		item.BaseOffset = outBase
+	}
+	return nil
+}
+
+func checkIlocSize(offset uint64, size uint8) error {
+	if size < 8 && offset>>(uint(size)*8) != 0 {
+		return fmt.Errorf("too large offset for iloc box: offset=%d, size=%d", offset, size)
+	}
+	return nil
+}
+
+// offsetOf returns the offset in the output which corresponds to the offset referred by the box.
+func (n *Node) offsetOf(rl *relocation, src uint64) (uint64, error) {
+	out, ok := rl.offsetOf(src)
+	if !ok {
+		return 0, fmt.Errorf("%s box refers to data which is removed or decoded: offset=%d", n.BoxInfo.Type.String(), src)
+	}
+	return out, nil
+}
@@ -0,0 +1,313 @@
+package mp4
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// assertSamples asserts that the samples of the tracks have the same data in both files.
+func assertSamples(t *testing.T, expected, actual io.ReadSeeker, trackIDs ...uint32) {
+	for _, trackID := range trackIDs {
+		expectedSamples, err := ReadSamples(expected, trackID)
+		require.NoError(t, err)
+		actualSamples, err := ReadSamples(actual, trackID)
+		require.NoError(t, err)
+		require.Len(t, actualSamples, len(expectedSamples))
+		for i := range expectedSamples {
+			assert.Equal(t, readSampleData(t, expected, expectedSamples[i]), readSampleData(t, actual, actualSamples[i]))
+		}
+	}
+}
+
+func TestRelocateProgressive(t *testing.T) {
+	data, err := ioutil.ReadFile("./_examples/sample.mp4")
+	require.NoError(t, err)
+	root, err := ParseTree(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// the free box before mdat moves the chunks
+	mdat := root.Find(BoxPath{BoxTypeMdat()})
+	require.Len(t, mdat, 1)
+	require.NoError(t, root.InsertBefore(NewNode(&Free{Data: []byte{0x00, 0x00, 0x00, 0x00}}), mdat[0]))
+	buf := bytes.NewBuffer(nil)
+	_, err = root.WriteTo(buf)
+	require.NoError(t, err)
+
+	bs, err := ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil, BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStco()})
+	require.NoError(t, err)
+	require.Len(t, bs, 2)
+	assert.Equal(t, []uint32{60, 3848, 4539, 4876, 5055, 5239, 5572, 5714, 6050}, bs[0].Payload.(*Stco).ChunkOffset)
+	assertSamples(t, bytes.NewReader(data), bytes.NewReader(buf.Bytes()), 1, 2)
+
+	// the payload which is decoded is not copied from the source
+	_, err = mdat[0].Payload()
+	require.NoError(t, err)
+	_, err = root.WriteTo(ioutil.Discard)
+	assert.Error(t, err)
+
+	// the removed data can not be referred
+	require.NoError(t, root.Remove(mdat[0]))
+	_, err = root.WriteTo(ioutil.Discard)
+	assert.Error(t, err)
+}
+
+func TestRelocateFragmented(t *testing.T) {
+	fs := memfs.New()
+	progressive := createProgressiveSample(t)
+	defer progressive.Close()
+	src, err := fs.Create("src.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+	require.NoError(t, Fragment(progressive, src, &FragmentOptions{
+		FragmentDuration: 200 * time.Millisecond,
+		WriteStyp:        true,
+		WriteSidx:        true,
+		WriteMfra:        true,
+	}))
+
+	root, err := ParseTree(src)
+	require.NoError(t, err)
+
+	// insert pssh into moov, free before the first moof, and free into the first traf
+	moov := root.Find(BoxPath{BoxTypeMoov()})
+	require.Len(t, moov, 1)
+	moov[0].Append(NewNode(&Pssh{SystemID: [16]byte{0x10, 0x77, 0xef, 0xec, 0xc0, 0xb2, 0x4d, 0x02, 0xac, 0xe3, 0x3c, 0x1e, 0x52, 0xe2, 0xfb, 0x4b}}))
+	moofs := root.Find(BoxPath{BoxTypeMoof()})
+	require.NotEmpty(t, moofs)
+	require.NoError(t, root.InsertBefore(NewNode(&Free{}), moofs[0]))
+	trafs := moofs[0].Find(BoxPath{BoxTypeTraf()})
+	require.NotEmpty(t, trafs)
+	trafs[0].Append(NewNode(&Free{Data: []byte{0x00, 0x00, 0x00, 0x00}}))
+
+	// the base data offset refers to moof box
+	tfhd, err := trafs[0].Find(BoxPath{BoxTypeTfhd()})[0].Payload()
+	require.NoError(t, err)
+	tfhd.(*Tfhd).SetFlags(tfhd.GetFlags()&^TfhdDefaultBaseIsMoof | TfhdBaseDataOffsetPresent)
+	tfhd.(*Tfhd).BaseDataOffset = moofs[0].BoxInfo.Offset
+
+	out, err := fs.Create("out.mp4")
+	require.NoError(t, err)
+	defer out.Close()
+	_, err = root.WriteTo(out)
+	require.NoError(t, err)
+
+	assertSamples(t, src, out, 1, 2)
+
+	bs, err := ExtractBoxesWithPayload(out, nil, []BoxPath{
+		{BoxTypeSidx()},
+		{BoxTypeMoof()},
+		{BoxTypeMoof(), BoxTypeTraf(), BoxTypeTfhd()},
+		{BoxTypeMfra(), BoxTypeTfra()},
+	})
+	require.NoError(t, err)
+	var sidx *BoxInfoWithPayload
+	var moofOffsets []uint64
+	var tfhds []*Tfhd
+	var tfras []*Tfra
+	for _, b := range bs {
+		switch box := b.Payload.(type) {
+		case *Sidx:
+			sidx = b
+		case *Moof:
+			moofOffsets = append(moofOffsets, b.Info.Offset)
+		case *Tfhd:
+			tfhds = append(tfhds, box)
+		case *Tfra:
+			tfras = append(tfras, box)
+		}
+	}
+	require.NotNil(t, sidx)
+	require.NotEmpty(t, moofOffsets)
+	assert.Equal(t, moofOffsets[0], tfhds[0].BaseDataOffset)
+
+	// the first subsegment has the inserted free box, and sidx still refers to styp boxes
+	checkSidx(t, out, sidx, BoxTypeStyp())
+	require.NotEmpty(t, tfras)
+	for _, tfra := range tfras {
+		for _, e := range tfra.Entries {
+			offset := e.MoofOffsetV1
+			if tfra.GetVersion() == 0 {
+				offset = uint64(e.MoofOffsetV0)
+			}
+			assert.Contains(t, moofOffsets, offset)
+		}
+	}
+}
+
+// writeTree writes the nodes as the top-level boxes.
+func writeTree(t *testing.T, w io.Writer, nodes ...*Node) {
+	root := &Node{}
+	for _, n := range nodes {
+		root.Append(n)
+	}
+	_, err := root.WriteTo(w)
+	require.NoError(t, err)
+}
+
+func newNodeWithChildren(box IBox, children ...*Node) *Node {
+	n := NewNode(box)
+	for _, c := range children {
+		n.Append(c)
+	}
+	return n
+}
+
+func TestRelocateIloc(t *testing.T) {
+	// mdat payload starts at 24
+	iloc := &Iloc{
+		FullBox:        FullBox{Version: 1},
+		OffsetSize:     4,
+		LengthSize:     4,
+		BaseOffsetSize: 4,
+		ItemCount:      4,
+		Items: []IlocItem{
+			{ItemID: 1, ExtentCount: 1, Extents: []IlocExtent{{ExtentOffset: 24, ExtentLength: 4}}},
+			{ItemID: 2, BaseOffset: 24, ExtentCount: 2, Extents: []IlocExtent{{ExtentOffset: 4, ExtentLength: 2}, {ExtentOffset: 6, ExtentLength: 2}}},
+			{ItemID: 3, ConstructionMethod: IlocConstructionMethodIdatOffset, ExtentCount: 1, Extents: []IlocExtent{{ExtentOffset: 0, ExtentLength: 4}}},
+			{ItemID: 4, DataReferenceIndex: 1, ExtentCount: 1, Extents: []IlocExtent{{ExtentOffset: 100, ExtentLength: 4}}},
+		},
+	}
+	src := bytes.NewBuffer(nil)
+	writeTree(t, src,
+		NewNode(&Ftyp{MajorBrand: [4]byte{'h', 'e', 'i', 'c'}}),
+		NewNode(&Mdat{Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}),
+		newNodeWithChildren(&Meta{}, NewNode(iloc)),
+	)
+
+	root, err := ParseTree(bytes.NewReader(src.Bytes()))
+	require.NoError(t, err)
+	mdat := root.Find(BoxPath{BoxTypeMdat()})
+	require.Len(t, mdat, 1)
+	require.NoError(t, root.InsertBefore(NewNode(&Free{Data: []byte{0x00, 0x00, 0x00, 0x00}}), mdat[0]))
+	buf := bytes.NewBuffer(nil)
+	_, err = root.WriteTo(buf)
+	require.NoError(t, err)
+
+	bs, err := ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil, BoxPath{BoxTypeMeta(), BoxTypeIloc()})
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	items := bs[0].Payload.(*Iloc).Items
+	// the file offsets are moved by the free box of 12 bytes
+	assert.Equal(t, uint64(36), items[0].Extents[0].ExtentOffset)
+	assert.Equal(t, uint64(36), items[1].BaseOffset)
+	assert.Equal(t, []IlocExtent{{ExtentOffset: 4, ExtentLength: 2}, {ExtentOffset: 6, ExtentLength: 2}}, items[1].Extents)
+	assert.Equal(t, iloc.Items[2:], items[2:])
+	assert.Equal(t, []byte{1, 2, 3, 4}, buf.Bytes()[36:40])
+}
+
+var sparseZeros = make([]byte, 32*1024)
+
+// sparseFile has zeros between head and tail, so that the large file is tested without the memory.
+type sparseFile struct {
+	head   []byte
+	zeros  int64
+	tail   []byte
+	offset int64
+}
+
+func (f *sparseFile) Read(p []byte) (int, error) {
+	head := int64(len(f.head))
+	var n int
+	switch {
+	case f.offset < head:
+		n = copy(p, f.head[f.offset:])
+	case f.offset < head+f.zeros:
+		if int64(len(p)) > head+f.zeros-f.offset {
+			p = p[:head+f.zeros-f.offset]
+		}
+		n = copy(p, sparseZeros)
+	case f.offset < head+f.zeros+int64(len(f.tail)):
+		n = copy(p, f.tail[f.offset-head-f.zeros:])
+	default:
+		return 0, io.EOF
+	}
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *sparseFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.head)) + f.zeros + int64(len(f.tail))
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// sparseWriter writes the data to sparseFile by skipping zeros after the head of the specified size.
+type sparseWriter struct {
+	sparseFile
+	headSize int
+	skip     int64
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if k := w.headSize - len(w.head); k > 0 {
+		if k > len(p) {
+			k = len(p)
+		}
+		w.head = append(w.head, p[:k]...)
+		p = p[k:]
+	}
+	if k := w.skip - w.zeros; k > 0 {
+		if k > int64(len(p)) {
+			k = int64(len(p))
+		}
+		w.zeros += k
+		p = p[k:]
+	}
+	w.tail = append(w.tail, p...)
+	return n, nil
+}
+
+func TestRelocateStcoToCo64(t *testing.T) {
+	// mdat payload at 24 is moved after the free box of 4GiB
+	const freeSize = 1 << 32
+	head := bytes.NewBuffer(nil)
+	writeTree(t, head,
+		NewNode(&Ftyp{MajorBrand: [4]byte{'i', 's', 'o', 'm'}}),
+		NewNode(&Mdat{Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}),
+	)
+	head.Write(marshalBoxHeader(&BoxInfo{Type: BoxTypeFree(), Size: LargeHeaderSize + freeSize, HeaderSize: LargeHeaderSize}))
+	tail := bytes.NewBuffer(nil)
+	writeTree(t, tail, newNodeWithChildren(&Moov{}, newNodeWithChildren(&Trak{},
+		newNodeWithChildren(&Mdia{}, newNodeWithChildren(&Minf{}, newNodeWithChildren(&Stbl{},
+			NewNode(&Stco{EntryCount: 2, ChunkOffset: []uint32{24, 28}}),
+		))),
+	)))
+	src := &sparseFile{head: head.Bytes(), zeros: freeSize, tail: tail.Bytes()}
+
+	root, err := ParseTree(src)
+	require.NoError(t, err)
+	mdat := root.Find(BoxPath{BoxTypeMdat()})
+	require.Len(t, mdat, 1)
+	root.Append(mdat[0])
+	out := &sparseWriter{headSize: 16 + LargeHeaderSize, skip: freeSize}
+	_, err = root.WriteTo(out)
+	require.NoError(t, err)
+
+	bs, err := ExtractBoxesWithPayload(&out.sparseFile, nil, []BoxPath{
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStco()},
+		{BoxTypeMoov(), BoxTypeTrak(), BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeCo64()},
+		{BoxTypeMdat()},
+	})
+	require.NoError(t, err)
+	require.Len(t, bs, 2)
+	co64, ok := bs[0].Payload.(*Co64)
+	require.True(t, ok)
+	mdatOffset := bs[1].Info.Offset + bs[1].Info.HeaderSize
+	assert.Greater(t, mdatOffset, uint64(math.MaxUint32))
+	assert.Equal(t, []uint64{mdatOffset, mdatOffset + 4}, co64.ChunkOffset)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, bs[1].Payload.(*Mdat).Data)
+}
@@ -0,0 +1,702 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/orcaman/writerseeker"
+)
+
+// RemuxOptions is the options of Remux.
+type RemuxOptions struct {
+	// TrackIDs are the IDs of the tracks which are kept, in the output order.
+	// All tracks are kept in the original order when it is empty.
+	TrackIDs []uint32
+
+	// RenumberTrackIDs renumbers the kept tracks from 1 in the output order.
+	RenumberTrackIDs bool
+}
+
+// Remux keeps the specified tracks of the input and drops the others.
+// The track IDs in tkhd, tref, trex, tfhd and tfra boxes are rewritten consistently,
+// and the next track ID of mvhd box follows the largest track ID.
+//
+// A progressive input is rebuilt as ftyp, mdat and moov boxes which have only the samples of the kept tracks.
+// For a fragmented input, traf boxes of the dropped tracks are removed from each moof box,
+// and the mdat box following each moof box only has the samples of the kept tracks,
+// which are referred by the data offsets relative to moof box.
+// mfra box is rewritten, and sidx and ssix boxes, which can not be kept consistent, are dropped.
+func Remux(r io.ReadSeeker, w io.WriteSeeker, opts *RemuxOptions) error {
+	if opts == nil {
+		opts = &RemuxOptions{}
+	}
+	b, err := readProgressiveInit(r)
+	if err != nil {
+		return err
+	}
+	tracks, trackIDs, err := selectTracks(b.tracks, opts)
+	if err != nil {
+		return err
+	}
+
+	if b.fragmented {
+		for _, track := range tracks {
+			if len(track.samples) != 0 {
+				return errors.New("fragmented input which has samples in moov box is not supported")
+			}
+		}
+		rm := &remuxer{
+			r:           r,
+			allTracks:   b.tracks,
+			tracks:      tracks,
+			trackIDs:    trackIDs,
+			moofs:       make(map[uint64]uint64),
+			trafNumbers: make(map[uint64][]uint32),
+		}
+		return rm.write(w)
+	}
+
+	kept := make(map[*progressiveTrack]bool, len(tracks))
+	for _, track := range tracks {
+		kept[track] = true
+	}
+	chunks := b.chunks[:0]
+	for _, c := range b.chunks {
+		if kept[c.track] {
+			chunks = append(chunks, c)
+		}
+	}
+	b.chunks = chunks
+	b.tracks = tracks
+	b.trackIDs = trackIDs
+	for _, track := range b.tracks {
+		if err := track.computeDurations(); err != nil {
+			return err
+		}
+	}
+	b.buildTracks()
+	return b.write(r, w)
+}
+
+// selectTracks returns the kept tracks in the output order, and the map of the track IDs from the input to the output.
+func selectTracks(tracks []*progressiveTrack, opts *RemuxOptions) ([]*progressiveTrack, map[uint32]uint32, error) {
+	selected := tracks
+	if len(opts.TrackIDs) != 0 {
+		selected = make([]*progressiveTrack, 0, len(opts.TrackIDs))
+		for i, trackID := range opts.TrackIDs {
+			for _, id := range opts.TrackIDs[:i] {
+				if id == trackID {
+					return nil, nil, fmt.Errorf("duplicated track ID: %d", trackID)
+				}
+			}
+			var track *progressiveTrack
+			for _, t := range tracks {
+				if t.trackID == trackID {
+					track = t
+				}
+			}
+			if track == nil {
+				return nil, nil, fmt.Errorf("track is not found: trackID=%d", trackID)
+			}
+			selected = append(selected, track)
+		}
+	}
+
+	trackIDs := make(map[uint32]uint32, len(selected))
+	for i, track := range selected {
+		trackIDs[track.trackID] = track.trackID
+		if opts.RenumberTrackIDs {
+			trackIDs[track.trackID] = uint32(i + 1)
+		}
+	}
+	return selected, trackIDs, nil
+}
+
+// nextTrackID returns the track ID which follows the largest track ID in the output.
+func nextTrackID(trackIDs map[uint32]uint32) uint32 {
+	var max uint32
+	for _, id := range trackIDs {
+		if id > max {
+			max = id
+		}
+	}
+	return max + 1
+}
+
+// writeTrackReferences writes tref box whose track IDs are mapped by trackIDs.
+// The references to the dropped tracks are removed, and tref box is not written when no reference remains.
+func writeTrackReferences(w *Writer, r io.ReadSeeker, tref *BoxInfo, trackIDs map[uint32]uint32) error {
+	if _, err := tref.SeekToPayload(r); err != nil {
+		return err
+	}
+	data := make([]byte, tref.Size-tref.HeaderSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	// tref box has the boxes of the reference types which have the arrays of track IDs
+	var refs []byte
+	for len(data) != 0 {
+		if len(data) < SmallHeaderSize {
+			return errors.New("invalid tref box")
+		}
+		size := binary.BigEndian.Uint32(data)
+		if size < SmallHeaderSize || uint64(size) > uint64(len(data)) {
+			return fmt.Errorf("invalid track reference type box size: %d", size)
+		}
+		ref := append([]byte(nil), data[:SmallHeaderSize]...)
+		for i := SmallHeaderSize; i+4 <= int(size); i += 4 {
+			if id, ok := trackIDs[binary.BigEndian.Uint32(data[i:])]; ok {
+				ref = append(ref, 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(ref[len(ref)-4:], id)
+			}
+		}
+		if len(ref) != SmallHeaderSize {
+			binary.BigEndian.PutUint32(ref, uint32(len(ref)))
+			refs = append(refs, ref...)
+		}
+		data = data[size:]
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	if _, err := w.StartBox(&BoxInfo{Type: tref.Type}); err != nil {
+		return err
+	}
+	if _, err := w.Write(refs); err != nil {
+		return err
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+type remuxer struct {
+	r         io.ReadSeeker
+	allTracks []*progressiveTrack
+	tracks    []*progressiveTrack
+	trackIDs  map[uint32]uint32
+
+	// moofs maps the offsets of moof boxes from the input to the output.
+	moofs map[uint64]uint64
+
+	// trafNumbers has the traf numbers in the output for each moof box of the input, and 0 means the dropped traf box.
+	trafNumbers map[uint64][]uint32
+}
+
+// remuxTraf is a traf box which is kept in the output.
+type remuxTraf struct {
+	trackID uint32
+
+	// base is the base offset of the auxiliary information in the input.
+	base uint64
+
+	// runs are the data of trun boxes.
+	runs []*remuxRun
+
+	// auxInfo is the auxiliary information referred by each entry of saio box,
+	// which is nil when it is stored in moof box.
+	auxInfo []*remuxRun
+}
+
+// remuxRun is a range of the data which is copied into mdat box.
+type remuxRun struct {
+	offset uint64
+	size   uint64
+
+	// output is the offset relative to the payload of mdat box in the output.
+	output uint64
+}
+
+// remuxPosition is the offset of a box which is copied into moof box.
+type remuxPosition struct {
+	offset uint64
+	size   uint64
+	output uint64
+}
+
+func (rm *remuxer) write(w io.WriteSeeker) error {
+	var boxes []*BoxInfo
+	if _, err := ReadBoxStructure(rm.r, func(h *ReadHandle) (interface{}, error) {
+		bi := h.BoxInfo
+		boxes = append(boxes, &bi)
+		return nil, nil
+	}); err != nil {
+		return err
+	}
+
+	pw := NewWriter(w)
+	for _, bi := range boxes {
+		var err error
+		switch bi.Type {
+		case BoxTypeMoov():
+			err = rm.writeMoov(pw, bi)
+		case BoxTypeMoof():
+			err = rm.writeMoof(pw, bi)
+		case BoxTypeMfra():
+			err = rm.writeMfra(pw, bi)
+		case BoxTypeMdat(), BoxTypeSidx(), StrToBoxType("ssix"):
+			// mdat boxes are rewritten with moof boxes
+		default:
+			err = pw.CopyBox(rm.r, bi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMoov copies moov box which has only trak and trex boxes of the kept tracks.
+func (rm *remuxer) writeMoov(w *Writer, moov *BoxInfo) error {
+	var traksWritten, trexsWritten bool
+	trexs := make(map[uint32]*BoxInfo)
+	var handler ReadHandler
+	handler = func(h *ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case BoxTypeMvex():
+			bs, err := ExtractBoxWithPayload(rm.r, &h.BoxInfo, BoxPath{BoxTypeTrex()})
+			if err != nil {
+				return nil, err
+			}
+			for _, b := range bs {
+				bi := b.Info
+				trexs[b.Payload.(*Trex).TrackID] = &bi
+			}
+		case BoxTypeTrex():
+			if len(h.Path) != 3 {
+				break
+			}
+			// trex boxes are also written in the order of the tracks
+			if !trexsWritten {
+				trexsWritten = true
+				for _, track := range rm.tracks {
+					if bi, ok := trexs[track.trackID]; ok {
+						if _, err := ReadBoxStructureFromInternal(rm.r, bi, handler); err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+			return nil, nil
+		case BoxTypeTrak():
+			if len(h.Path) != 2 {
+				break
+			}
+			// trak boxes are written in the order of the tracks
+			if !traksWritten {
+				traksWritten = true
+				for _, track := range rm.tracks {
+					if _, err := ReadBoxStructureFromInternal(rm.r, track.trak, handler); err != nil {
+						return nil, err
+					}
+				}
+			}
+			return nil, nil
+		case StrToBoxType("tref"):
+			return nil, writeTrackReferences(w, rm.r, &h.BoxInfo, rm.trackIDs)
+		case BoxTypeMoov(), BoxTypeMvhd(), BoxTypeTkhd():
+		default:
+			return nil, w.CopyBox(rm.r, &h.BoxInfo)
+		}
+
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		switch b := box.(type) {
+		case *Mvhd:
+			b.NextTrackID = nextTrackID(rm.trackIDs)
+		case *Tkhd:
+			b.TrackID = rm.trackIDs[b.TrackID]
+		case *Trex:
+			b.TrackID = rm.trackIDs[b.TrackID]
+		}
+		if _, err := w.StartBox(&h.BoxInfo); err != nil {
+			return nil, err
+		}
+		if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+			return nil, err
+		}
+		if _, err := h.Expand(); err != nil {
+			return nil, err
+		}
+		_, err = w.EndBox()
+		return nil, err
+	}
+	_, err := ReadBoxStructureFromInternal(rm.r, moov, handler)
+	return err
+}
+
+// writeMoof writes moof box which has only traf boxes of the kept tracks, and mdat box which has their data.
+// moof box is not written when it has no traf boxes of the kept tracks.
+func (rm *remuxer) writeMoof(w *Writer, moof *BoxInfo) error {
+	trafs, order, err := rm.planMoof(moof)
+	if err != nil {
+		return err
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	// the samples and the auxiliary information out of moof box are stored in mdat box in the order of traf boxes
+	var runs []*remuxRun
+	var dataSize uint64
+	for _, traf := range order {
+		for _, run := range append(append([]*remuxRun{}, traf.runs...), traf.auxInfo...) {
+			if run == nil {
+				continue
+			}
+			run.output = dataSize
+			dataSize += run.size
+			runs = append(runs, run)
+		}
+	}
+	mdat := &BoxInfo{Type: BoxTypeMdat(), HeaderSize: SmallHeaderSize}
+	if dataSize+SmallHeaderSize > math.MaxUint32 {
+		mdat.HeaderSize = LargeHeaderSize
+	}
+	mdat.Size = mdat.HeaderSize + dataSize
+
+	// the size of moof box does not depend on the data offsets
+	data, positions, err := rm.buildMoof(moof, trafs, 0, nil)
+	if err != nil {
+		return err
+	}
+	if data, _, err = rm.buildMoof(moof, trafs, uint64(len(data))+mdat.HeaderSize, positions); err != nil {
+		return err
+	}
+
+	offset, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	rm.moofs[moof.Offset] = uint64(offset)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if _, err := WriteBoxInfo(w, mdat); err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if _, err := rm.r.Seek(int64(run.offset), io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, rm.r, int64(run.size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planMoof returns the kept traf boxes by their offsets and in the order.
+func (rm *remuxer) planMoof(moof *BoxInfo) (map[uint64]*remuxTraf, []*remuxTraf, error) {
+	samples := make(map[uint32][]Sample, len(rm.allTracks))
+	for _, track := range rm.allTracks {
+		s, err := readFragmentSamples(rm.r, moof, track.trex, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		samples[track.trackID] = s
+	}
+
+	bs, err := ExtractBoxesWithPayload(rm.r, moof, []BoxPath{
+		{BoxTypeTraf()},
+		{BoxTypeTraf(), BoxTypeTfhd()},
+		{BoxTypeTraf(), BoxTypeTrun()},
+		{BoxTypeTraf(), BoxTypeSaiz()},
+		{BoxTypeTraf(), BoxTypeSaio()},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trafs := make(map[uint64]*remuxTraf)
+	var order []*remuxTraf
+	var numbers []uint32
+	var traf *remuxTraf
+	var trafInfo *BoxInfo
+	var saiz *Saiz
+	var saio *Saio
+	var sampleCounts []uint32
+	flush := func() error {
+		if traf == nil || saiz == nil || saio == nil {
+			return nil
+		}
+		var err error
+		traf.auxInfo, err = remuxAuxInfo(moof, traf.base, saiz, saio, sampleCounts)
+		return err
+	}
+	dataEnd := moof.Offset
+	for _, bi := range bs {
+		switch box := bi.Payload.(type) {
+		case *Traf:
+			if err := flush(); err != nil {
+				return nil, nil, err
+			}
+			trafInfo = &bi.Info
+			traf = nil
+			saiz = nil
+			saio = nil
+			sampleCounts = nil
+		case *Tfhd:
+			if _, ok := samples[box.TrackID]; !ok {
+				return nil, nil, fmt.Errorf("track is not found: trackID=%d", box.TrackID)
+			}
+			traf = &remuxTraf{trackID: box.TrackID}
+			switch {
+			case box.GetFlags()&TfhdBaseDataOffsetPresent != 0:
+				traf.base = box.BaseDataOffset
+			case box.GetFlags()&TfhdDefaultBaseIsMoof != 0 || len(numbers) == 0:
+				traf.base = moof.Offset
+			default:
+				traf.base = dataEnd
+			}
+			var number uint32
+			if _, ok := rm.trackIDs[box.TrackID]; ok {
+				trafs[trafInfo.Offset] = traf
+				order = append(order, traf)
+				number = uint32(len(order))
+			}
+			numbers = append(numbers, number)
+		case *Trun:
+			if traf == nil {
+				return nil, nil, errors.New("tfhd box is not found")
+			}
+			s := samples[traf.trackID]
+			if int(box.SampleCount) > len(s) {
+				return nil, nil, fmt.Errorf("inconsistent sample count: trackID=%d", traf.trackID)
+			}
+			run := &remuxRun{}
+			if box.SampleCount != 0 {
+				run.offset = s[0].Offset
+				for _, sample := range s[:box.SampleCount] {
+					run.size += uint64(sample.Size)
+				}
+				dataEnd = run.offset + run.size
+			}
+			traf.runs = append(traf.runs, run)
+			sampleCounts = append(sampleCounts, box.SampleCount)
+			samples[traf.trackID] = s[box.SampleCount:]
+		case *Saiz:
+			saiz = box
+		case *Saio:
+			saio = box
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, nil, err
+	}
+	rm.trafNumbers[moof.Offset] = numbers
+	return trafs, order, nil
+}
+
+// remuxAuxInfo returns the ranges of the auxiliary information referred by the entries of saio box.
+// The entries which refer to the inside of moof box are nil.
+func remuxAuxInfo(moof *BoxInfo, base uint64, saiz *Saiz, saio *Saio, sampleCounts []uint32) ([]*remuxRun, error) {
+	var total uint32
+	for _, n := range sampleCounts {
+		total += n
+	}
+	if saiz.SampleCount != total {
+		return nil, fmt.Errorf("inconsistent sample count: trun=%d saiz=%d", total, saiz.SampleCount)
+	}
+	// each entry of saio box refers to the auxiliary information of all samples or the samples of each trun box
+	switch int(saio.EntryCount) {
+	case 1:
+		sampleCounts = []uint32{total}
+	case len(sampleCounts):
+	default:
+		return nil, fmt.Errorf("invalid saio entry count: %d", saio.EntryCount)
+	}
+
+	auxInfo := make([]*remuxRun, saio.EntryCount)
+	var index uint32
+	for i, n := range sampleCounts {
+		offset := base + saioOffset(saio, i)
+		run := &remuxRun{offset: offset}
+		for j := index; j < index+n; j++ {
+			if saiz.DefaultSampleInfoSize != 0 {
+				run.size += uint64(saiz.DefaultSampleInfoSize)
+			} else {
+				run.size += uint64(saiz.SampleInfoSize[j])
+			}
+		}
+		index += n
+		if offset < moof.Offset || offset >= moof.Offset+moof.Size {
+			auxInfo[i] = run
+		}
+	}
+	return auxInfo, nil
+}
+
+// buildMoof returns moof box in which the data of the kept traf boxes begin at dataOffset relative to moof box.
+// The offsets of saio boxes which refer to the inside of moof box are mapped by the positions of the copied boxes,
+// which are also returned.
+func (rm *remuxer) buildMoof(moof *BoxInfo, trafs map[uint64]*remuxTraf, dataOffset uint64, positions []remuxPosition) ([]byte, []remuxPosition, error) {
+	ws := &writerseeker.WriterSeeker{}
+	w := NewWriter(ws)
+	var copied []remuxPosition
+	var traf *remuxTraf
+	var runIndex int
+	if _, err := ReadBoxStructureFromInternal(rm.r, moof, func(h *ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case BoxTypeMoof(), BoxTypeTfhd(), BoxTypeTrun(), BoxTypeSaio():
+		case BoxTypeTraf():
+			if traf = trafs[h.BoxInfo.Offset]; traf == nil {
+				return nil, nil
+			}
+			runIndex = 0
+		default:
+			offset, err := w.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			copied = append(copied, remuxPosition{offset: h.BoxInfo.Offset, size: h.BoxInfo.Size, output: uint64(offset)})
+			return nil, w.CopyBox(rm.r, &h.BoxInfo)
+		}
+
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		switch b := box.(type) {
+		case *Tfhd:
+			b.TrackID = rm.trackIDs[b.TrackID]
+			b.SetFlags(b.GetFlags()&^TfhdBaseDataOffsetPresent | TfhdDefaultBaseIsMoof)
+			b.BaseDataOffset = 0
+		case *Trun:
+			offset := dataOffset + traf.runs[runIndex].output
+			if offset > math.MaxInt32 {
+				return nil, fmt.Errorf("too large data offset: %d", offset)
+			}
+			b.AddFlag(TrunDataOffsetPresent)
+			b.DataOffset = int32(offset)
+			runIndex++
+		case *Saio:
+			if traf.auxInfo == nil {
+				break
+			}
+			for i, run := range traf.auxInfo {
+				var offset uint64
+				if run != nil {
+					offset = dataOffset + run.output
+				} else if positions != nil {
+					if offset, err = remuxPositionOf(positions, traf.base+saioOffset(b, i)); err != nil {
+						return nil, err
+					}
+				}
+				if b.GetVersion() == 0 {
+					b.OffsetV0[i] = uint32(offset)
+				} else {
+					b.OffsetV1[i] = offset
+				}
+			}
+		}
+		if _, err := w.StartBox(&h.BoxInfo); err != nil {
+			return nil, err
+		}
+		if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+			return nil, err
+		}
+		if _, err := h.Expand(); err != nil {
+			return nil, err
+		}
+		_, err = w.EndBox()
+		return nil, err
+	}); err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(ws.Reader())
+	return data, copied, err
+}
+
+// saioOffset returns the i-th offset of saio box.
+func saioOffset(saio *Saio, i int) uint64 {
+	if saio.GetVersion() == 0 {
+		return uint64(saio.OffsetV0[i])
+	}
+	return saio.OffsetV1[i]
+}
+
+// remuxPositionOf returns the offset in the output moof box which corresponds to the offset in the input.
+func remuxPositionOf(positions []remuxPosition, offset uint64) (uint64, error) {
+	for _, p := range positions {
+		if offset >= p.offset && offset < p.offset+p.size {
+			return p.output + offset - p.offset, nil
+		}
+	}
+	return 0, fmt.Errorf("auxiliary information out of moof box is not supported: offset=%d", offset)
+}
+
+// writeMfra writes mfra box which has tfra boxes of the kept tracks with the offsets of moof boxes in the output.
+func (rm *remuxer) writeMfra(w *Writer, mfra *BoxInfo) error {
+	bs, err := ExtractBoxWithPayload(rm.r, mfra, BoxPath{BoxTypeTfra()})
+	if err != nil {
+		return err
+	}
+	tfras := make(map[uint32]*Tfra, len(bs))
+	for _, bi := range bs {
+		tfra := bi.Payload.(*Tfra)
+		tfras[tfra.TrackID] = tfra
+	}
+
+	// tfra boxes are written in the order of the tracks
+	mb := &muxBox{box: &Mfra{}}
+	size := uint64(SmallHeaderSize)
+	for _, track := range rm.tracks {
+		tfra, ok := tfras[track.trackID]
+		if !ok {
+			continue
+		}
+		tfra.TrackID = rm.trackIDs[track.trackID]
+
+		entries := tfra.Entries[:0]
+		var large bool
+		for _, e := range tfra.Entries {
+			moofOffset := e.MoofOffsetV1
+			if tfra.GetVersion() == 0 {
+				moofOffset = uint64(e.MoofOffsetV0)
+			}
+			offset, ok := rm.moofs[moofOffset]
+			numbers := rm.trafNumbers[moofOffset]
+			if !ok || e.TrafNumber == 0 || int(e.TrafNumber) > len(numbers) || numbers[e.TrafNumber-1] == 0 {
+				continue
+			}
+			e.TrafNumber = numbers[e.TrafNumber-1]
+			e.MoofOffsetV0 = uint32(offset)
+			e.MoofOffsetV1 = offset
+			large = large || offset > math.MaxUint32
+			entries = append(entries, e)
+		}
+		if tfra.GetVersion() == 0 && large {
+			tfra.SetVersion(1)
+			for i := range entries {
+				entries[i].TimeV1 = uint64(entries[i].TimeV0)
+			}
+		}
+		tfra.Entries = entries
+		tfra.NumberOfEntry = uint32(len(entries))
+
+		s, err := boxSize(tfra)
+		if err != nil {
+			return err
+		}
+		size += s
+		mb.children = append(mb.children, &muxBox{box: tfra})
+	}
+
+	// mfro box has the size of mfra box
+	mfro := &Mfro{}
+	s, err := boxSize(mfro)
+	if err != nil {
+		return err
+	}
+	mfro.Size = uint32(size + s)
+	mb.children = append(mb.children, &muxBox{box: mfro})
+	return writeMuxBox(w, mb)
+}
@@ -0,0 +1,341 @@
+package mp4
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/orcaman/writerseeker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+func TestRemux(t *testing.T) {
+	progressive := createProgressiveSample(t)
+	defer progressive.Close()
+	fragmented, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer fragmented.Close()
+	require.NoError(t, Fragment(progressive, fragmented, &FragmentOptions{
+		FragmentDuration: 200 * time.Millisecond,
+		WriteSidx:        true,
+		WriteMfra:        true,
+	}))
+
+	testCases := []struct {
+		name        string
+		opts        *RemuxOptions
+		trackIDs    []uint32
+		outputIDs   []uint32
+		nextTrackID uint32
+	}{
+		{
+			name:        "all tracks",
+			opts:        nil,
+			trackIDs:    []uint32{1, 2},
+			outputIDs:   []uint32{1, 2},
+			nextTrackID: 3,
+		},
+		{
+			name:        "drop video",
+			opts:        &RemuxOptions{TrackIDs: []uint32{2}},
+			trackIDs:    []uint32{2},
+			outputIDs:   []uint32{2},
+			nextTrackID: 3,
+		},
+		{
+			name:        "drop video and renumber",
+			opts:        &RemuxOptions{TrackIDs: []uint32{2}, RenumberTrackIDs: true},
+			trackIDs:    []uint32{2},
+			outputIDs:   []uint32{1},
+			nextTrackID: 2,
+		},
+		{
+			name:        "drop audio",
+			opts:        &RemuxOptions{TrackIDs: []uint32{1}},
+			trackIDs:    []uint32{1},
+			outputIDs:   []uint32{1},
+			nextTrackID: 2,
+		},
+		{
+			name:        "reorder and renumber",
+			opts:        &RemuxOptions{TrackIDs: []uint32{2, 1}, RenumberTrackIDs: true},
+			trackIDs:    []uint32{2, 1},
+			outputIDs:   []uint32{1, 2},
+			nextTrackID: 3,
+		},
+	}
+
+	for _, input := range []struct {
+		name       string
+		r          io.ReadSeeker
+		fragmented bool
+	}{
+		{name: "progressive", r: progressive},
+		{name: "fragmented", r: fragmented, fragmented: true},
+	} {
+		for _, tc := range testCases {
+			t.Run(input.name+"/"+tc.name, func(t *testing.T) {
+				f, err := memfs.New().Create("remuxed.mp4")
+				require.NoError(t, err)
+				defer f.Close()
+				require.NoError(t, Remux(input.r, f, tc.opts))
+
+				var size uint64
+				for i, trackID := range tc.trackIDs {
+					expected, err := ReadSamples(input.r, trackID)
+					require.NoError(t, err)
+					samples, err := ReadSamples(f, tc.outputIDs[i])
+					require.NoError(t, err)
+					require.Len(t, samples, len(expected))
+					for j := range samples {
+						assert.Equal(t, expected[j].Size, samples[j].Size)
+						assert.Equal(t, expected[j].DecodeTime, samples[j].DecodeTime)
+						assert.Equal(t, expected[j].CompositionTimeOffset, samples[j].CompositionTimeOffset)
+						assert.Equal(t, expected[j].IsSync, samples[j].IsSync)
+						assert.Equal(t, readSampleData(t, input.r, expected[j]), readSampleData(t, f, samples[j]))
+						size += uint64(expected[j].Size)
+					}
+				}
+
+				bs, err := ExtractBoxesWithPayload(f, nil, []BoxPath{
+					{BoxTypeMoov(), BoxTypeMvhd()},
+					{BoxTypeMoov(), BoxTypeTrak(), BoxTypeTkhd()},
+					{BoxTypeMoov(), BoxTypeMvex(), BoxTypeTrex()},
+					{BoxTypeMoof(), BoxTypeTraf(), BoxTypeTfhd()},
+					{BoxTypeMdat()},
+					{BoxTypeSidx()},
+				})
+				require.NoError(t, err)
+				var tkhds, trexs []uint32
+				var mdatSize uint64
+				var sidxs int
+				for _, b := range bs {
+					switch box := b.Payload.(type) {
+					case *Mvhd:
+						assert.Equal(t, tc.nextTrackID, box.NextTrackID)
+					case *Tkhd:
+						tkhds = append(tkhds, box.TrackID)
+					case *Trex:
+						trexs = append(trexs, box.TrackID)
+					case *Tfhd:
+						assert.Contains(t, tc.outputIDs, box.TrackID)
+					case *Mdat:
+						mdatSize += b.Info.Size - b.Info.HeaderSize
+					case *Sidx:
+						sidxs++
+					}
+				}
+				assert.Equal(t, tc.outputIDs, tkhds)
+				if input.fragmented {
+					assert.Equal(t, tc.outputIDs, trexs)
+				}
+				// the samples of the dropped tracks are removed
+				assert.Equal(t, size, mdatSize)
+				assert.Zero(t, sidxs)
+
+				if !input.fragmented {
+					return
+				}
+				// tfra boxes refer to the moof boxes of the output
+				moofs, err := ExtractBox(f, nil, BoxPath{BoxTypeMoof()})
+				require.NoError(t, err)
+				moofOffsets := make(map[uint64]bool, len(moofs))
+				for _, moof := range moofs {
+					moofOffsets[moof.Offset] = true
+				}
+				bs, err = ExtractBoxesWithPayload(f, nil, []BoxPath{
+					{BoxTypeMfra()},
+					{BoxTypeMfra(), BoxTypeTfra()},
+					{BoxTypeMfra(), BoxTypeMfro()},
+				})
+				require.NoError(t, err)
+				require.Len(t, bs, len(tc.outputIDs)+2)
+				assert.Equal(t, uint32(bs[0].Info.Size), bs[len(bs)-1].Payload.(*Mfro).Size)
+				for i, b := range bs[1 : len(bs)-1] {
+					tfra := b.Payload.(*Tfra)
+					assert.Equal(t, tc.outputIDs[i], tfra.TrackID)
+					require.NotZero(t, tfra.NumberOfEntry)
+					for _, entry := range tfra.Entries {
+						offset := uint64(entry.MoofOffsetV0)
+						if tfra.GetVersion() == 1 {
+							offset = entry.MoofOffsetV1
+						}
+						assert.True(t, moofOffsets[offset])
+					}
+				}
+			})
+		}
+	}
+
+	f, err := memfs.New().Create("remuxed.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, Remux(progressive, f, &RemuxOptions{TrackIDs: []uint32{3}}))
+	assert.Error(t, Remux(progressive, f, &RemuxOptions{TrackIDs: []uint32{1, 1}}))
+}
+
+func TestRemuxAuxInfo(t *testing.T) {
+	videoData := []byte{0x01, 0x02, 0x03, 0x04, 0x05}
+	audioData := []byte{0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17}
+	auxData := []byte{0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38}
+	url := &Url{}
+	url.SetFlags(UrlSelfContained)
+	trak := func(trackID uint32, handlerType [4]byte) *muxBox {
+		return &muxBox{box: &Trak{}, children: []*muxBox{
+			{box: &Tkhd{TrackID: trackID}},
+			{box: &Mdia{}, children: []*muxBox{
+				{box: &Mdhd{Timescale: 48000}},
+				{box: &Hdlr{HandlerType: handlerType}},
+				{box: &Minf{}, children: []*muxBox{
+					{box: &Dinf{}, children: []*muxBox{
+						{box: &Dref{EntryCount: 1}, children: []*muxBox{{box: url}}},
+					}},
+					{box: &Stbl{}, children: []*muxBox{
+						{box: &Stsd{}},
+						{box: &Stts{}},
+						{box: &Stsc{}},
+						{box: &Stsz{}},
+						{box: &Stco{}},
+					}},
+				}},
+			}},
+		}}
+	}
+	init := &muxBox{box: &Moov{}, children: []*muxBox{
+		{box: &Mvhd{Timescale: 1000, NextTrackID: 3}},
+		trak(1, [4]byte{'v', 'i', 'd', 'e'}),
+		trak(2, [4]byte{'s', 'o', 'u', 'n'}),
+		{box: &Mvex{}, children: []*muxBox{
+			{box: &Trex{TrackID: 1, DefaultSampleDescriptionIndex: 1, DefaultSampleDuration: 1024}},
+			{box: &Trex{TrackID: 2, DefaultSampleDescriptionIndex: 1, DefaultSampleDuration: 1024}},
+		}},
+	}}
+
+	// mdat has the video samples, the audio samples, and 8 bytes of auxiliary information for each audio sample
+	// which are referred by the offsets relative to moof box
+	buildMoof := func(moofSize uint32) *muxBox {
+		videoTfhd := &Tfhd{TrackID: 1}
+		videoTrun := &Trun{
+			SampleCount: 1,
+			DataOffset:  int32(moofSize + 8),
+			Entries:     []TrunEntry{{SampleSize: uint32(len(videoData))}},
+		}
+		videoTrun.SetFlags(TrunDataOffsetPresent | TrunSampleSizePresent)
+		audioTfhd := &Tfhd{TrackID: 2}
+		audioTfhd.SetFlags(TfhdDefaultBaseIsMoof)
+		audioTrun := &Trun{
+			SampleCount: 2,
+			DataOffset:  int32(moofSize + 8 + uint32(len(videoData))),
+			Entries:     []TrunEntry{{SampleSize: 3}, {SampleSize: 4}},
+		}
+		audioTrun.SetFlags(TrunDataOffsetPresent | TrunSampleSizePresent)
+		saiz := &Saiz{DefaultSampleInfoSize: 8, SampleCount: 2}
+		saio := &Saio{EntryCount: 1, OffsetV0: []uint32{moofSize + 8 + uint32(len(videoData)+len(audioData))}}
+		return &muxBox{box: &Moof{}, children: []*muxBox{
+			{box: &Mfhd{SequenceNumber: 1}},
+			{box: &Traf{}, children: []*muxBox{
+				{box: videoTfhd},
+				{box: &Tfdt{}},
+				{box: videoTrun},
+			}},
+			{box: &Traf{}, children: []*muxBox{
+				{box: audioTfhd},
+				{box: &Tfdt{}},
+				{box: saiz},
+				{box: saio},
+				{box: audioTrun},
+			}},
+		}}
+	}
+	scratch := &writerseeker.WriterSeeker{}
+	require.NoError(t, writeMuxBox(NewWriter(scratch), buildMoof(0)))
+	moofSize, err := scratch.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+
+	src, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+	w := NewWriter(src)
+	require.NoError(t, writeMuxBox(w, init))
+	require.NoError(t, writeMuxBox(w, buildMoof(uint32(moofSize))))
+	data := append(append(append([]byte{}, videoData...), audioData...), auxData...)
+	_, err = WriteBoxInfo(w, &BoxInfo{Type: BoxTypeMdat(), Size: SmallHeaderSize + uint64(len(data))})
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+
+	f, err := memfs.New().Create("remuxed.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, Remux(src, f, &RemuxOptions{TrackIDs: []uint32{2}, RenumberTrackIDs: true}))
+
+	samples, err := ReadSamples(f, 1)
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, audioData[:3], readSampleData(t, f, samples[0]))
+	assert.Equal(t, audioData[3:], readSampleData(t, f, samples[1]))
+
+	bs, err := ExtractBoxesWithPayload(f, nil, []BoxPath{
+		{BoxTypeMoof()},
+		{BoxTypeMoof(), BoxTypeTraf(), BoxTypeSaio()},
+		{BoxTypeMdat()},
+	})
+	require.NoError(t, err)
+	require.Len(t, bs, 3)
+	saio := bs[1].Payload.(*Saio)
+	require.Equal(t, uint32(1), saio.EntryCount)
+	// the video samples are removed from mdat
+	assert.Equal(t, uint64(len(audioData)+len(auxData)), bs[2].Info.Size-bs[2].Info.HeaderSize)
+	aux := make([]byte, len(auxData))
+	_, err = f.Seek(int64(bs[0].Info.Offset+saioOffset(saio, 0)), io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(f, aux)
+	require.NoError(t, err)
+	assert.Equal(t, auxData, aux)
+}
+
+func TestWriteTrackReferences(t *testing.T) {
+	// chap box refers to the tracks 2 and 3, and hint box refers to the track 3
+	tref := []byte{
+		0x00, 0x00, 0x00, 0x20, 't', 'r', 'e', 'f',
+		0x00, 0x00, 0x00, 0x0c, 'h', 'i', 'n', 't',
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x10, 'c', 'h', 'a', 'p',
+		0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03,
+	}
+	bi := &BoxInfo{Type: StrToBoxType("tref"), Size: uint64(len(tref)), HeaderSize: SmallHeaderSize}
+
+	testCases := []struct {
+		name     string
+		trackIDs map[uint32]uint32
+		expected []byte
+	}{
+		{
+			name:     "remapped",
+			trackIDs: map[uint32]uint32{1: 2, 2: 1},
+			expected: []byte{
+				0x00, 0x00, 0x00, 0x14, 't', 'r', 'e', 'f',
+				0x00, 0x00, 0x00, 0x0c, 'c', 'h', 'a', 'p',
+				0x00, 0x00, 0x00, 0x01,
+			},
+		},
+		{
+			name:     "all dropped",
+			trackIDs: map[uint32]uint32{1: 1},
+			expected: []byte{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &writerseeker.WriterSeeker{}
+			require.NoError(t, writeTrackReferences(NewWriter(buf), bytes.NewReader(tref), bi, tc.trackIDs))
+			data, err := ioutil.ReadAll(buf.Reader())
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, data)
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+)
+
+// sample_is_non_sync_sample bit of sample flags defined at ISO/IEC 14496-12 8.8.3.1
+const SampleFlagsIsNonSyncSample = 0x00010000
+
+// Sample is a media sample which is referred by the sample table of moov or track fragments of moof.
+type Sample struct {
+	// Offset is the absolute position of the sample data in the file.
+	Offset uint64
+	Size   uint32
+
+	// DecodeTime is the decoding timestamp in the media timescale.
+	DecodeTime            uint64
+	Duration              uint32
+	CompositionTimeOffset int64
+
+	IsSync                 bool
+	SampleDescriptionIndex uint32
+}
+
+// ReadSamples returns the samples of the specified track in decoding order.
+// Both of the sample table in moov and the track fragments in moof boxes are scanned.
+func ReadSamples(r io.ReadSeeker, trackID uint32) ([]Sample, error) {
+	trak, err := findTrak(r, trackID)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := readSampleTable(r, trak)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := ExtractBoxWithPayload(r, nil, BoxPath{BoxTypeMoov(), BoxTypeMvex(), BoxTypeTrex()})
+	if err != nil {
+		return nil, err
+	}
+	var trex *Trex
+	for _, b := range bs {
+		if t := b.Payload.(*Trex); t.TrackID == trackID {
+			trex = t
+		}
+	}
+	if trex == nil {
+		return samples, nil
+	}
+
+	moofs, err := ExtractBox(r, nil, BoxPath{BoxTypeMoof()})
+	if err != nil {
+		return nil, err
+	}
+	for _, moof := range moofs {
+		if samples, err = readFragmentSamples(r, moof, trex, samples); err != nil {
+			return nil, err
+		}
+	}
+	return samples, nil
+}
+
+func findTrak(r io.ReadSeeker, trackID uint32) (*BoxInfo, error) {
+	traks, err := ExtractBox(r, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak()})
+	if err != nil {
+		return nil, err
+	}
+	for _, trak := range traks {
+		bs, err := ExtractBoxWithPayload(r, trak, BoxPath{BoxTypeTkhd()})
+		if err != nil {
+			return nil, err
+		}
+		if len(bs) != 0 && bs[0].Payload.(*Tkhd).TrackID == trackID {
+			return trak, nil
+		}
+	}
+	return nil, fmt.Errorf("track not found: trackID=%d", trackID)
+}
+
+func readSampleTable(r io.ReadSeeker, trak *BoxInfo) ([]Sample, error) {
+	bs, err := ExtractBoxesWithPayload(r, trak, []BoxPath{
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStts()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeCtts()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStss()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsc()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStsz()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeStco()},
+		{BoxTypeMdia(), BoxTypeMinf(), BoxTypeStbl(), BoxTypeCo64()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stts *Stts
+	var ctts *Ctts
+	var stss *Stss
+	var stsc *Stsc
+	var stsz *Stsz
+	var chunkOffsets []uint64
+	for _, b := range bs {
+		switch box := b.Payload.(type) {
+		case *Stts:
+			stts = box
+		case *Ctts:
+			ctts = box
+		case *Stss:
+			stss = box
+		case *Stsc:
+			stsc = box
+		case *Stsz:
+			stsz = box
+		case *Stco:
+			for _, offset := range box.ChunkOffset {
+				chunkOffsets = append(chunkOffsets, uint64(offset))
+			}
+		case *Co64:
+			chunkOffsets = append(chunkOffsets, box.ChunkOffset...)
+		}
+	}
+	if stsz == nil || stsz.SampleCount == 0 {
+		return nil, nil
+	}
+	if stts == nil || stsc == nil {
+		return nil, fmt.Errorf("stts or stsc box is not found")
+	}
+
+	samples := make([]Sample, stsz.SampleCount)
+	for i := range samples {
+		if stsz.SampleSize != 0 {
+			samples[i].Size = stsz.SampleSize
+		} else {
+			samples[i].Size = stsz.EntrySize[i]
+		}
+		samples[i].IsSync = stss == nil
+	}
+
+	// stts
+	var index int
+	var decodeTime uint64
+	for _, entry := range stts.Entries {
+		for j := uint32(0); j < entry.SampleCount && index < len(samples); j++ {
+			samples[index].DecodeTime = decodeTime
+			samples[index].Duration = entry.SampleDelta
+			decodeTime += uint64(entry.SampleDelta)
+			index++
+		}
+	}
+	if index != len(samples) {
+		return nil, fmt.Errorf("inconsistent sample count: stsz=%d stts=%d", len(samples), index)
+	}
+
+	// ctts
+	if ctts != nil {
+		index = 0
+		for _, entry := range ctts.Entries {
+			offset := int64(entry.SampleOffsetV1)
+			if ctts.GetVersion() == 0 {
+				// some muxers write negative offsets into version 0
+				offset = int64(int32(entry.SampleOffsetV0))
+			}
+			for j := uint32(0); j < entry.SampleCount && index < len(samples); j++ {
+				samples[index].CompositionTimeOffset = offset
+				index++
+			}
+		}
+	}
+
+	// stss
+	if stss != nil {
+		for _, number := range stss.SampleNumber {
+			if number >= 1 && int(number) <= len(samples) {
+				samples[number-1].IsSync = true
+			}
+		}
+	}
+
+	// stsc, stco and co64
+	index = 0
+	for i, entry := range stsc.Entries {
+		lastChunk := uint32(len(chunkOffsets))
+		if i+1 < len(stsc.Entries) {
+			lastChunk = stsc.Entries[i+1].FirstChunk - 1
+		}
+		if entry.FirstChunk == 0 || lastChunk > uint32(len(chunkOffsets)) {
+			return nil, fmt.Errorf("invalid chunk number: firstChunk=%d chunks=%d", entry.FirstChunk, len(chunkOffsets))
+		}
+		for chunk := entry.FirstChunk; chunk <= lastChunk; chunk++ {
+			offset := chunkOffsets[chunk-1]
+			for j := uint32(0); j < entry.SamplesPerChunk && index < len(samples); j++ {
+				samples[index].Offset = offset
+				samples[index].SampleDescriptionIndex = entry.SampleDescriptionIndex
+				offset += uint64(samples[index].Size)
+				index++
+			}
+		}
+	}
+	if index != len(samples) {
+		return nil, fmt.Errorf("inconsistent sample count: stsz=%d stsc=%d", len(samples), index)
+	}
+
+	return samples, nil
+}
+
+func readFragmentSamples(r io.ReadSeeker, moof *BoxInfo, trex *Trex, samples []Sample) ([]Sample, error) {
+	bs, err := ExtractBoxesWithPayload(r, moof, []BoxPath{
+		{BoxTypeTraf(), BoxTypeTfhd()},
+		{BoxTypeTraf(), BoxTypeTfdt()},
+		{BoxTypeTraf(), BoxTypeTrun()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var decodeTime uint64
+	if len(samples) != 0 {
+		last := samples[len(samples)-1]
+		decodeTime = last.DecodeTime + uint64(last.Duration)
+	}
+
+	// data of the first traf begins at the moof box
+	// when neither base-data-offset-present nor default-base-is-moof is set.
+	dataEnd := moof.Offset
+	var tfhd *Tfhd
+	var baseDataOffset uint64
+	for _, b := range bs {
+		switch box := b.Payload.(type) {
+		case *Tfhd:
+			tfhd = box
+			flags := tfhd.GetFlags()
+			if flags&TfhdBaseDataOffsetPresent != 0 {
+				baseDataOffset = tfhd.BaseDataOffset
+			} else if flags&TfhdDefaultBaseIsMoof != 0 {
+				baseDataOffset = moof.Offset
+			} else {
+				baseDataOffset = dataEnd
+			}
+			dataEnd = baseDataOffset
+
+		case *Tfdt:
+			if tfhd == nil || tfhd.TrackID != trex.TrackID {
+				continue
+			}
+			if box.GetVersion() == 0 {
+				decodeTime = uint64(box.BaseMediaDecodeTimeV0)
+			} else {
+				decodeTime = box.BaseMediaDecodeTimeV1
+			}
+
+		case *Trun:
+			if tfhd == nil {
+				return nil, fmt.Errorf("tfhd box is not found")
+			}
+			trunFlags := box.GetFlags()
+			tfhdFlags := tfhd.GetFlags()
+
+			offset := dataEnd
+			if trunFlags&TrunDataOffsetPresent != 0 {
+				offset = uint64(int64(baseDataOffset) + int64(box.DataOffset))
+			}
+
+			for i, entry := range box.Entries {
+				var sample Sample
+				sample.Offset = offset
+
+				if trunFlags&TrunSampleSizePresent != 0 {
+					sample.Size = entry.SampleSize
+				} else if tfhdFlags&TfhdDefaultSampleSizePresent != 0 {
+					sample.Size = tfhd.DefaultSampleSize
+				} else {
+					sample.Size = trex.DefaultSampleSize
+				}
+				offset += uint64(sample.Size)
+
+				if tfhd.TrackID != trex.TrackID {
+					continue
+				}
+
+				if trunFlags&TrunSampleDurationPresent != 0 {
+					sample.Duration = entry.SampleDuration
+				} else if tfhdFlags&TfhdDefaultSampleDurationPresent != 0 {
+					sample.Duration = tfhd.DefaultSampleDuration
+				} else {
+					sample.Duration = trex.DefaultSampleDuration
+				}
+
+				var sampleFlags uint32
+				if i == 0 && trunFlags&TrunFirstSampleFlagsPresent != 0 {
+					sampleFlags = box.FirstSampleFlags
+				} else if trunFlags&TrunSampleFlagsPresent != 0 {
+					sampleFlags = entry.SampleFlags
+				} else if tfhdFlags&TfhdDefaultSampleFlagsPresent != 0 {
+					sampleFlags = tfhd.DefaultSampleFlags
+				} else {
+					sampleFlags = trex.DefaultSampleFlags
+				}
+				sample.IsSync = sampleFlags&SampleFlagsIsNonSyncSample == 0
+
+				if trunFlags&TrunSampleCompositionTimeOffsetPresent != 0 {
+					if box.GetVersion() == 0 {
+						sample.CompositionTimeOffset = int64(int32(entry.SampleCompositionTimeOffsetV0))
+					} else {
+						sample.CompositionTimeOffset = int64(entry.SampleCompositionTimeOffsetV1)
+					}
+				}
+
+				if tfhdFlags&TfhdSampleDescriptionIndexPresent != 0 {
+					sample.SampleDescriptionIndex = tfhd.SampleDescriptionIndex
+				} else {
+					sample.SampleDescriptionIndex = trex.DefaultSampleDescriptionIndex
+				}
+
+				sample.DecodeTime = decodeTime
+				decodeTime += uint64(sample.Duration)
+				samples = append(samples, sample)
+			}
+			dataEnd = offset
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,107 @@
+package mp4
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSamples(t *testing.T) {
+	f, err := os.Open("./_examples/sample.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+
+	// video
+	samples, err := ReadSamples(f, 1)
+	require.NoError(t, err)
+	require.Equal(t, 10, len(samples))
+	assert.Equal(t, Sample{
+		Offset:                 48,
+		Size:                   3679,
+		DecodeTime:             0,
+		Duration:               1024,
+		CompositionTimeOffset:  2048,
+		IsSync:                 true,
+		SampleDescriptionIndex: 1,
+	}, samples[0])
+	assert.Equal(t, Sample{
+		Offset:                 6038,
+		Size:                   15,
+		DecodeTime:             9216,
+		Duration:               1024,
+		CompositionTimeOffset:  1024,
+		IsSync:                 false,
+		SampleDescriptionIndex: 1,
+	}, samples[9])
+	for i := 1; i < len(samples); i++ {
+		assert.False(t, samples[i].IsSync)
+		assert.Equal(t, samples[i-1].DecodeTime+uint64(samples[i-1].Duration), samples[i].DecodeTime)
+	}
+
+	// audio
+	samples, err = ReadSamples(f, 2)
+	require.NoError(t, err)
+	require.Equal(t, 44, len(samples))
+	assert.Equal(t, uint64(3813), samples[0].Offset)
+	assert.Equal(t, uint32(23), samples[0].Size)
+	for _, s := range samples {
+		assert.True(t, s.IsSync)
+	}
+
+	_, err = ReadSamples(f, 3)
+	assert.Error(t, err)
+}
+
+func TestReadSamplesFragmented(t *testing.T) {
+	f, err := os.Open("./_examples/sample_fragmented.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+
+	// video
+	samples, err := ReadSamples(f, 1)
+	require.NoError(t, err)
+	require.Equal(t, 10, len(samples))
+	assert.Equal(t, Sample{
+		Offset:                 1363,
+		Size:                   974,
+		DecodeTime:             0,
+		Duration:               9000,
+		CompositionTimeOffset:  18000,
+		IsSync:                 true,
+		SampleDescriptionIndex: 1,
+	}, samples[0])
+	assert.Equal(t, Sample{
+		Offset:                 2337,
+		Size:                   40,
+		DecodeTime:             9000,
+		Duration:               9000,
+		CompositionTimeOffset:  27000,
+		IsSync:                 false,
+		SampleDescriptionIndex: 1,
+	}, samples[1])
+	assert.Equal(t, uint64(81000), samples[9].DecodeTime)
+	var syncs int
+	for _, s := range samples {
+		if s.IsSync {
+			syncs++
+		}
+	}
+	assert.Equal(t, 4, syncs)
+
+	// audio
+	samples, err = ReadSamples(f, 2)
+	require.NoError(t, err)
+	require.Equal(t, 44, len(samples))
+	assert.Equal(t, Sample{
+		Offset:                 2565,
+		Size:                   44,
+		DecodeTime:             0,
+		Duration:               8830,
+		IsSync:                 true,
+		SampleDescriptionIndex: 1,
+	}, samples[0])
+	assert.Equal(t, uint64(8830), samples[1].DecodeTime)
+	assert.Equal(t, uint32(29), samples[1].Size)
+}
@@ -0,0 +1,336 @@
+package mp4
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// SeekPoint is the sync sample from which the decoding begins to present a specified time.
+type SeekPoint struct {
+	Sample Sample
+
+	// PresentationTime is the presentation time of the sample in the media timescale, to which the edit list is applied.
+	PresentationTime int64
+
+	// Fragment is the movie fragment which has the sample, and nil for the samples in moov box.
+	Fragment *FragmentRange
+}
+
+// FragmentRange is the byte range of a movie fragment, which consists of moof box and the following mdat boxes.
+type FragmentRange struct {
+	Offset uint64
+	Size   uint64
+}
+
+// SeekToTime returns the nearest sync sample of the track which is presented at or before t.
+// When no sync sample precedes t, the first sync sample is returned.
+// t is the presentation time, to which the edit list of the track is applied.
+//
+// The sample table in moov box is used for progressive files.
+// For fragmented files, the search begins at the fragment which is found by tfra box or sidx box,
+// or at the first fragment when neither exists, and the following fragments are scanned until t,
+// so that the sync samples which are not listed in a sparse tfra box are also found.
+func SeekToTime(r io.ReadSeeker, trackID uint32, t time.Duration) (*SeekPoint, error) {
+	trak, err := findTrak(r, trackID)
+	if err != nil {
+		return nil, err
+	}
+	elst, movieTimescale, mediaTimescale, err := readEdits(r, trak)
+	if err != nil {
+		return nil, err
+	}
+	timeline, err := NewTimeline(elst, movieTimescale, mediaTimescale, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &seeker{
+		r:         r,
+		trackID:   trackID,
+		timescale: mediaTimescale,
+		target:    seekMediaTime(timeline, int64(t)*int64(mediaTimescale)/int64(time.Second)),
+	}
+	samples, err := readSampleTable(r, trak)
+	if err != nil {
+		return nil, err
+	}
+	s.find(samples, nil)
+
+	bs, err := ExtractBoxWithPayload(r, nil, BoxPath{BoxTypeMoov(), BoxTypeMvex(), BoxTypeTrex()})
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bs {
+		if trex := b.Payload.(*Trex); trex.TrackID == trackID {
+			s.trex = trex
+		}
+	}
+	if s.trex != nil {
+		if len(samples) != 0 {
+			s.last = samples[len(samples)-1:]
+		}
+		if err := s.seekFragments(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.point == nil {
+		return nil, errors.New("no sync sample")
+	}
+	s.point.PresentationTime = seekPresentationTime(timeline, compositionTime(&s.point.Sample))
+	return s.point, nil
+}
+
+type seeker struct {
+	r         io.ReadSeeker
+	trackID   uint32
+	timescale uint32
+	trex      *Trex
+
+	// target is the composition time to seek in the media timescale.
+	target int64
+
+	// point is the nearest sync sample which is found so far.
+	point *SeekPoint
+
+	// last is the last sample in moov box, which precedes the samples of the first fragment.
+	last []Sample
+}
+
+// find updates the seek point by the sync samples.
+func (s *seeker) find(samples []Sample, fragment *FragmentRange) {
+	for _, sample := range samples {
+		if !sample.IsSync {
+			continue
+		}
+		ct := compositionTime(&sample)
+		if s.point != nil {
+			current := compositionTime(&s.point.Sample)
+			if current <= s.target && (ct > s.target || ct <= current) {
+				continue
+			}
+			if current > s.target && ct >= current {
+				continue
+			}
+		}
+		s.point = &SeekPoint{Sample: sample, Fragment: fragment}
+	}
+}
+
+// found returns true when a sync sample at or before the target is found.
+func (s *seeker) found() bool {
+	return s.point != nil && compositionTime(&s.point.Sample) <= s.target
+}
+
+func (s *seeker) seekFragments() error {
+	var boxes []*BoxInfo
+	if _, err := ReadBoxStructure(s.r, func(h *ReadHandle) (interface{}, error) {
+		bi := h.BoxInfo
+		boxes = append(boxes, &bi)
+		return nil, nil
+	}); err != nil {
+		return err
+	}
+	var moofs []int
+	for i, bi := range boxes {
+		if bi.Type == BoxTypeMoof() {
+			moofs = append(moofs, i)
+		}
+	}
+
+	start, err := s.indexByTfra(boxes, moofs)
+	if err != nil {
+		return err
+	}
+	if start == -1 {
+		if start, err = s.indexBySidx(boxes, moofs); err != nil {
+			return err
+		}
+	}
+	if start > 0 {
+		// the decode time can not be known without tfdt box unless the preceding fragments are scanned
+		bs, err := ExtractBoxesWithPayload(s.r, boxes[moofs[start]], []BoxPath{
+			{BoxTypeTraf(), BoxTypeTfhd()},
+			{BoxTypeTraf(), BoxTypeTfdt()},
+		})
+		if err != nil {
+			return err
+		}
+		var tfdt bool
+		var tfhd *Tfhd
+		for _, b := range bs {
+			switch box := b.Payload.(type) {
+			case *Tfhd:
+				tfhd = box
+			case *Tfdt:
+				tfdt = tfdt || (tfhd != nil && tfhd.TrackID == s.trackID)
+			}
+		}
+		if !tfdt {
+			start = 0
+		}
+	}
+
+	if err := s.scan(boxes, moofs, start); err != nil {
+		return err
+	}
+	if !s.found() && start > 0 {
+		// the index does not point to the fragment before the target
+		return s.scan(boxes, moofs, 0)
+	}
+	return nil
+}
+
+// scan finds the sync samples from the specified fragment until the fragment which begins after the target.
+func (s *seeker) scan(boxes []*BoxInfo, moofs []int, start int) error {
+	var last []Sample
+	if start == 0 {
+		last = s.last
+	}
+	for _, i := range moofs[start:] {
+		samples, err := readFragmentSamples(s.r, boxes[i], s.trex, last)
+		if err != nil {
+			return err
+		}
+		samples = samples[len(last):]
+		if len(samples) == 0 {
+			continue
+		}
+		last = samples[len(samples)-1:]
+
+		fragment := &FragmentRange{Offset: boxes[i].Offset, Size: boxes[i].Size}
+		for _, bi := range boxes[i+1:] {
+			if bi.Type != BoxTypeMdat() {
+				break
+			}
+			fragment.Size += bi.Size
+		}
+		s.find(samples, fragment)
+
+		begin := compositionTime(&samples[0])
+		for j := range samples {
+			if ct := compositionTime(&samples[j]); ct < begin {
+				begin = ct
+			}
+		}
+		if begin > s.target {
+			break
+		}
+	}
+	return nil
+}
+
+// indexByTfra returns the index of the fragment which has the last random access point at or before the target,
+// and -1 when tfra box of the track is not found.
+func (s *seeker) indexByTfra(boxes []*BoxInfo, moofs []int) (int, error) {
+	bs, err := ExtractBoxWithPayload(s.r, nil, BoxPath{BoxTypeMfra(), BoxTypeTfra()})
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range bs {
+		tfra := b.Payload.(*Tfra)
+		if tfra.TrackID != s.trackID {
+			continue
+		}
+		var moofOffset uint64
+		var found bool
+		for _, e := range tfra.Entries {
+			entryTime, offset := int64(e.TimeV1), e.MoofOffsetV1
+			if tfra.GetVersion() == 0 {
+				entryTime, offset = int64(e.TimeV0), uint64(e.MoofOffsetV0)
+			}
+			if entryTime <= s.target {
+				moofOffset, found = offset, true
+			}
+		}
+		if !found {
+			return 0, nil
+		}
+		for i, index := range moofs {
+			if boxes[index].Offset == moofOffset {
+				return i, nil
+			}
+		}
+		return -1, nil
+	}
+	return -1, nil
+}
+
+// indexBySidx returns the index of the first fragment in the last subsegment which begins at or before the target,
+// and 0 when sidx box of the track is not found.
+func (s *seeker) indexBySidx(boxes []*BoxInfo, moofs []int) (int, error) {
+	bs, err := ExtractBoxWithPayload(s.r, nil, BoxPath{BoxTypeSidx()})
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range bs {
+		sidx := b.Payload.(*Sidx)
+		if sidx.ReferenceID != s.trackID || sidx.Timescale == 0 {
+			continue
+		}
+		// the references begin at the end of sidx box
+		ept, offset := sidx.EarliestPresentationTimeV1, b.Info.Offset+b.Info.Size+sidx.FirstOffsetV1
+		if sidx.GetVersion() == 0 {
+			ept, offset = uint64(sidx.EarliestPresentationTimeV0), b.Info.Offset+b.Info.Size+uint64(sidx.FirstOffsetV0)
+		}
+		var subsegment uint64
+		for _, ref := range sidx.References {
+			if int64(scaleUint(ept, sidx.Timescale, s.timescale)) > s.target {
+				break
+			}
+			subsegment = offset
+			ept += uint64(ref.SubsegmentDuration)
+			offset += uint64(ref.ReferencedSize)
+		}
+		for i, index := range moofs {
+			if boxes[index].Offset >= subsegment {
+				return i, nil
+			}
+		}
+		return 0, nil
+	}
+	return 0, nil
+}
+
+// seekMediaTime returns the media time which is presented at the presentation time.
+// The presentation time in an empty edit is mapped to the beginning of the next edit,
+// and the presentation time after the timeline is mapped by the last edit.
+func seekMediaTime(t *Timeline, presentationTime int64) int64 {
+	var last *EditSegment
+	for i := range t.Segments {
+		s := &t.Segments[i]
+		if s.IsEmpty() {
+			continue
+		}
+		if presentationTime < s.PresentationTime {
+			return s.MediaTime
+		}
+		last = s
+		if presentationTime < s.PresentationTime+s.Duration {
+			break
+		}
+	}
+	if last == nil {
+		return 0
+	}
+	return last.MediaTime + scaleByRate(presentationTime-last.PresentationTime, last.Rate)
+}
+
+// seekPresentationTime returns the presentation time of the media time.
+// The media time which is not presented is mapped by the nearest edit.
+func seekPresentationTime(t *Timeline, mediaTime int64) int64 {
+	if presentationTime, ok := t.PresentationTime(mediaTime); ok {
+		return presentationTime
+	}
+	for _, s := range t.Segments {
+		if !s.IsEmpty() && s.Rate != 0 && (mediaTime < s.MediaTime+s.mediaDuration() || s.Duration == 0) {
+			return s.PresentationTime + scaleByRate(mediaTime-s.MediaTime, 1/s.Rate)
+		}
+	}
+	return mediaTime
+}
+
+func compositionTime(s *Sample) int64 {
+	return int64(s.DecodeTime) + s.CompositionTimeOffset
+}
@@ -0,0 +1,152 @@
+package mp4
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// sparseTfra returns a copy of the input whose tfra boxes have only the first entries.
+func sparseTfra(t *testing.T, fs billy.Filesystem, r io.ReadSeeker) billy.File {
+	f, err := fs.Create("sparse.mp4")
+	require.NoError(t, err)
+	w := NewWriter(f)
+	_, err = ReadBoxStructure(r, func(h *ReadHandle) (interface{}, error) {
+		if h.BoxInfo.Type == BoxTypeMfra() {
+			return nil, nil
+		}
+		return nil, w.CopyBox(r, &h.BoxInfo)
+	})
+	require.NoError(t, err)
+
+	bs, err := ExtractBoxWithPayload(r, nil, BoxPath{BoxTypeMfra(), BoxTypeTfra()})
+	require.NoError(t, err)
+	require.NotEmpty(t, bs)
+	mfra := &muxBox{box: &Mfra{}}
+	for _, b := range bs {
+		tfra := b.Payload.(*Tfra)
+		tfra.Entries = tfra.Entries[:1]
+		tfra.NumberOfEntry = 1
+		mfra.children = append(mfra.children, &muxBox{box: tfra})
+	}
+	mfra.children = append(mfra.children, &muxBox{box: &Mfro{}})
+	require.NoError(t, writeMuxBox(w, mfra))
+	return f
+}
+
+func TestSeekToTime(t *testing.T) {
+	fs := memfs.New()
+	progressive := createProgressiveSample(t)
+	defer progressive.Close()
+	fragment := func(name string, opts *FragmentOptions) billy.File {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		opts.FragmentDuration = 200 * time.Millisecond
+		require.NoError(t, Fragment(progressive, f, opts))
+		return f
+	}
+	fragmented := fragment("fragmented.mp4", &FragmentOptions{})
+	defer fragmented.Close()
+	withSidx := fragment("sidx.mp4", &FragmentOptions{WriteSidx: true})
+	defer withSidx.Close()
+	withMfra := fragment("mfra.mp4", &FragmentOptions{WriteMfra: true})
+	defer withMfra.Close()
+	sparse := sparseTfra(t, fs, withMfra)
+	defer sparse.Close()
+
+	videoSamples, err := ReadSamples(progressive, 1)
+	require.NoError(t, err)
+	audioSamples, err := ReadSamples(progressive, 2)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name             string
+		trackID          uint32
+		t                time.Duration
+		expected         Sample
+		presentationTime int64
+	}{
+		{
+			// the beginning of the video track is delayed by the empty edit
+			name:             "video in empty edit",
+			trackID:          1,
+			t:                0,
+			expected:         videoSamples[0],
+			presentationTime: 18000,
+		},
+		{
+			name:             "video between sync samples",
+			trackID:          1,
+			t:                600 * time.Millisecond,
+			expected:         videoSamples[3],
+			presentationTime: 45000,
+		},
+		{
+			name:             "video at sync sample",
+			trackID:          1,
+			t:                700 * time.Millisecond,
+			expected:         videoSamples[5],
+			presentationTime: 63000,
+		},
+		{
+			name:             "video after the end",
+			trackID:          1,
+			t:                10 * time.Second,
+			expected:         videoSamples[8],
+			presentationTime: 90000,
+		},
+		{
+			name:             "audio",
+			trackID:          2,
+			t:                300 * time.Millisecond,
+			expected:         audioSamples[4],
+			presentationTime: 11902,
+		},
+	}
+
+	for _, input := range []struct {
+		name       string
+		r          io.ReadSeeker
+		fragmented bool
+	}{
+		{name: "progressive", r: progressive},
+		{name: "fragmented", r: fragmented, fragmented: true},
+		{name: "sidx", r: withSidx, fragmented: true},
+		{name: "mfra", r: withMfra, fragmented: true},
+		{name: "sparse mfra", r: sparse, fragmented: true},
+	} {
+		for _, tc := range testCases {
+			t.Run(input.name+"/"+tc.name, func(t *testing.T) {
+				point, err := SeekToTime(input.r, tc.trackID, tc.t)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected.DecodeTime, point.Sample.DecodeTime)
+				assert.Equal(t, tc.expected.CompositionTimeOffset, point.Sample.CompositionTimeOffset)
+				assert.True(t, point.Sample.IsSync)
+				assert.Equal(t, tc.presentationTime, point.PresentationTime)
+				assert.Equal(t, readSampleData(t, progressive, tc.expected), readSampleData(t, input.r, point.Sample))
+				if !input.fragmented {
+					assert.Nil(t, point.Fragment)
+					return
+				}
+				require.NotNil(t, point.Fragment)
+				assert.LessOrEqual(t, point.Fragment.Offset, point.Sample.Offset)
+				assert.Less(t, point.Sample.Offset, point.Fragment.Offset+point.Fragment.Size)
+				moofs, err := ExtractBox(input.r, nil, BoxPath{BoxTypeMoof()})
+				require.NoError(t, err)
+				var found bool
+				for _, moof := range moofs {
+					found = found || moof.Offset == point.Fragment.Offset
+				}
+				assert.True(t, found)
+			})
+		}
+	}
+
+	_, err = SeekToTime(progressive, 3, 0)
+	assert.Error(t, err)
+}
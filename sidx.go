@@ -0,0 +1,484 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// SidxOptions is the options of AddSidx.
+type SidxOptions struct {
+	// TrackID is the ID of the reference track which is indexed.
+	// The first video track, or the first track when there is no video track, is used when it is 0.
+	TrackID uint32
+
+	// MaxReferences is the maximum number of references of each sidx box, which is 65535 when it is 0.
+	// When the subsegments exceed it, a top-level sidx box refers to the sidx boxes,
+	// each of which precedes and indexes up to MaxReferences subsegments.
+	MaxReferences int
+}
+
+// AddSidx writes the fragmented MP4 file with sidx box which indexes the subsegments of the reference track.
+// A subsegment begins at each moof box which has the samples of the reference track,
+// including the preceding styp, emsg and prft boxes.
+//
+// sidx box is placed between the init segment and the first fragment, and the existing sidx and ssix boxes are dropped.
+// The absolute offsets of tfhd and tfra boxes are shifted consistently.
+func AddSidx(r io.ReadSeeker, w io.WriteSeeker, opts *SidxOptions) error {
+	if opts == nil {
+		opts = &SidxOptions{}
+	}
+	maxReferences := opts.MaxReferences
+	if maxReferences <= 0 || maxReferences > math.MaxUint16 {
+		maxReferences = math.MaxUint16
+	}
+
+	trackID, timescale, trex, err := readReferenceTrack(r, opts.TrackID)
+	if err != nil {
+		return err
+	}
+
+	var boxes []*BoxInfo
+	if _, err := ReadBoxStructure(r, func(h *ReadHandle) (interface{}, error) {
+		bi := h.BoxInfo
+		boxes = append(boxes, &bi)
+		return nil, nil
+	}); err != nil {
+		return err
+	}
+
+	subsegments, first, end, err := planSubsegments(r, boxes, trex)
+	if err != nil {
+		return err
+	}
+	if len(subsegments) == 0 {
+		return errors.New("no fragments")
+	}
+	for _, s := range subsegments {
+		if len(s.samples) == 0 {
+			return fmt.Errorf("no samples of the reference track: trackID=%d", trackID)
+		}
+	}
+
+	// subsegments are grouped when they exceed the maximum number of references
+	var groups [][]*subsegment
+	for len(subsegments) != 0 {
+		n := maxReferences
+		if n > len(subsegments) {
+			n = len(subsegments)
+		}
+		groups = append(groups, subsegments[:n])
+		subsegments = subsegments[n:]
+	}
+	if len(groups) > maxReferences {
+		return fmt.Errorf("too many subsegments for sidx: %d", len(groups)*maxReferences)
+	}
+	sidxs := make([]*Sidx, len(groups))
+	sidxSizes := make([]uint64, len(groups))
+	for i, group := range groups {
+		if sidxs[i], err = newSidx(trackID, timescale, group); err != nil {
+			return err
+		}
+		if sidxSizes[i], err = boxSize(sidxs[i]); err != nil {
+			return err
+		}
+	}
+	var top *Sidx
+	var topSize uint64
+	if len(groups) > 1 {
+		if top, err = newTopSidx(sidxs, sidxSizes, groups); err != nil {
+			return err
+		}
+		if topSize, err = boxSize(top); err != nil {
+			return err
+		}
+	}
+
+	// offsets of the boxes in the output
+	offsets := make(map[*BoxInfo]uint64, len(boxes))
+	var offset uint64
+	for _, bi := range boxes[:first] {
+		if !isDroppedIndex(bi) {
+			offsets[bi] = offset
+			offset += bi.Size
+		}
+	}
+	offset += topSize
+	for i, group := range groups {
+		offset += sidxSizes[i]
+		for _, s := range group {
+			for _, bi := range s.boxes {
+				offsets[bi] = offset
+				offset += bi.Size
+			}
+		}
+	}
+	for _, bi := range boxes[end:] {
+		if !isDroppedIndex(bi) {
+			offsets[bi] = offset
+			offset += bi.Size
+		}
+	}
+	rebase := func(offset uint64) (uint64, error) {
+		for _, bi := range boxes {
+			if o, ok := offsets[bi]; ok && bi.Offset <= offset && offset < bi.Offset+bi.Size {
+				return o + offset - bi.Offset, nil
+			}
+		}
+		return 0, fmt.Errorf("invalid offset: %d", offset)
+	}
+
+	bw := NewWriter(w)
+	writeBox := func(bi *BoxInfo) error {
+		switch bi.Type {
+		case BoxTypeMoof():
+			return writeRebasedMoof(bw, r, bi, rebase)
+		case BoxTypeMfra():
+			return writeRebasedMfra(bw, r, bi, rebase)
+		default:
+			return bw.CopyBox(r, bi)
+		}
+	}
+	for _, bi := range boxes[:first] {
+		if !isDroppedIndex(bi) {
+			if err := writeBox(bi); err != nil {
+				return err
+			}
+		}
+	}
+	if top != nil {
+		if err := writeMuxBox(bw, &muxBox{box: top}); err != nil {
+			return err
+		}
+	}
+	for i, group := range groups {
+		if err := writeMuxBox(bw, &muxBox{box: sidxs[i]}); err != nil {
+			return err
+		}
+		for _, s := range group {
+			for _, bi := range s.boxes {
+				if err := writeBox(bi); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, bi := range boxes[end:] {
+		if !isDroppedIndex(bi) {
+			if err := writeBox(bi); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subsegment is a range of top-level boxes which is referred by an entry of sidx box.
+type subsegment struct {
+	boxes   []*BoxInfo
+	size    uint64
+	samples []Sample
+}
+
+// readReferenceTrack returns the track ID, the timescale and trex box of the reference track.
+func readReferenceTrack(r io.ReadSeeker, trackID uint32) (uint32, uint32, *Trex, error) {
+	traks, err := ExtractBox(r, nil, BoxPath{BoxTypeMoov(), BoxTypeTrak()})
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	type track struct {
+		trackID   uint32
+		timescale uint32
+		isVideo   bool
+	}
+	var ref *track
+	for _, trak := range traks {
+		bs, err := ExtractBoxesWithPayload(r, trak, []BoxPath{
+			{BoxTypeTkhd()},
+			{BoxTypeMdia(), BoxTypeMdhd()},
+			{BoxTypeMdia(), BoxTypeHdlr()},
+		})
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		t := &track{}
+		for _, b := range bs {
+			switch box := b.Payload.(type) {
+			case *Tkhd:
+				t.trackID = box.TrackID
+			case *Mdhd:
+				t.timescale = box.Timescale
+			case *Hdlr:
+				t.isVideo = box.HandlerType == [4]byte{'v', 'i', 'd', 'e'}
+			}
+		}
+		if trackID != 0 {
+			if t.trackID == trackID {
+				ref = t
+			}
+		} else if ref == nil || (t.isVideo && !ref.isVideo) {
+			ref = t
+		}
+	}
+	if ref == nil {
+		return 0, 0, nil, fmt.Errorf("track is not found: trackID=%d", trackID)
+	}
+
+	bs, err := ExtractBoxWithPayload(r, nil, BoxPath{BoxTypeMoov(), BoxTypeMvex(), BoxTypeTrex()})
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for _, b := range bs {
+		if trex := b.Payload.(*Trex); trex.TrackID == ref.trackID {
+			return ref.trackID, ref.timescale, trex, nil
+		}
+	}
+	return 0, 0, nil, fmt.Errorf("trex box is not found: trackID=%d", ref.trackID)
+}
+
+// isDroppedIndex returns true for sidx and ssix boxes of the input, which are replaced by the new sidx boxes.
+func isDroppedIndex(bi *BoxInfo) bool {
+	return bi.Type == BoxTypeSidx() || bi.Type == StrToBoxType("ssix")
+}
+
+// isFragmentPrefix returns true for the boxes which may precede moof box in a fragment.
+func isFragmentPrefix(bi *BoxInfo) bool {
+	switch bi.Type {
+	case BoxTypeStyp(), BoxTypeEmsg(), StrToBoxType("prft"):
+		return true
+	}
+	return false
+}
+
+// planSubsegments splits the fragments into the subsegments.
+// It also returns the range of the fragments in the top-level boxes.
+func planSubsegments(r io.ReadSeeker, boxes []*BoxInfo, trex *Trex) ([]*subsegment, int, int, error) {
+	first, end := -1, 0
+	for i, bi := range boxes {
+		switch bi.Type {
+		case BoxTypeMoof():
+			if first == -1 {
+				first = i
+			}
+			end = i + 1
+		case BoxTypeMdat():
+			if first != -1 {
+				end = i + 1
+			}
+		}
+	}
+	if first == -1 {
+		return nil, 0, 0, nil
+	}
+	for first > 0 && isFragmentPrefix(boxes[first-1]) {
+		first--
+	}
+
+	var subsegments []*subsegment
+	current := &subsegment{}
+	var prefix []*BoxInfo
+	var last []Sample
+	for _, bi := range boxes[first:end] {
+		if isDroppedIndex(bi) {
+			continue
+		}
+		if isFragmentPrefix(bi) {
+			prefix = append(prefix, bi)
+			continue
+		}
+		if bi.Type == BoxTypeMoof() {
+			samples, err := readFragmentSamples(r, bi, trex, last)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			samples = samples[len(last):]
+			if len(samples) != 0 {
+				last = samples[len(samples)-1:]
+				// a new subsegment begins at the fragment which has the samples of the reference track
+				if len(current.samples) != 0 {
+					subsegments = append(subsegments, current)
+					current = &subsegment{}
+				}
+				current.samples = samples
+			}
+		}
+		for _, b := range append(prefix, bi) {
+			current.boxes = append(current.boxes, b)
+			current.size += b.Size
+		}
+		prefix = nil
+	}
+	for _, b := range prefix {
+		current.boxes = append(current.boxes, b)
+		current.size += b.Size
+	}
+	subsegments = append(subsegments, current)
+	return subsegments, first, end, nil
+}
+
+// newSidx returns sidx box which refers to the subsegments.
+func newSidx(trackID, timescale uint32, subsegments []*subsegment) (*Sidx, error) {
+	ept := earliestPresentationTime(subsegments[0].samples)
+	sidx := &Sidx{
+		ReferenceID:                trackID,
+		Timescale:                  timescale,
+		EarliestPresentationTimeV0: uint32(ept),
+		EarliestPresentationTimeV1: ept,
+		ReferenceCount:             uint16(len(subsegments)),
+		References:                 make([]SidxReference, len(subsegments)),
+	}
+	if ept > math.MaxUint32 {
+		sidx.SetVersion(1)
+	}
+	for i, s := range subsegments {
+		ref, err := sidxReference(s.samples, s.size)
+		if err != nil {
+			return nil, err
+		}
+		sidx.References[i] = ref
+	}
+	return sidx, nil
+}
+
+// newTopSidx returns sidx box which refers to the sidx boxes, each of which precedes the subsegments it refers to.
+func newTopSidx(sidxs []*Sidx, sidxSizes []uint64, groups [][]*subsegment) (*Sidx, error) {
+	top := &Sidx{
+		ReferenceID:                sidxs[0].ReferenceID,
+		Timescale:                  sidxs[0].Timescale,
+		EarliestPresentationTimeV0: sidxs[0].EarliestPresentationTimeV0,
+		EarliestPresentationTimeV1: sidxs[0].EarliestPresentationTimeV1,
+		ReferenceCount:             uint16(len(sidxs)),
+		References:                 make([]SidxReference, len(sidxs)),
+	}
+	top.SetVersion(sidxs[0].GetVersion())
+	for i, sidx := range sidxs {
+		size := sidxSizes[i]
+		var duration uint64
+		ref := SidxReference{ReferenceType: true}
+		for j, r := range sidx.References {
+			// the first SAP of the referred subsegments
+			if ref.SAPType == 0 && r.SAPType != 0 {
+				ref.StartsWithSAP = j == 0 && r.StartsWithSAP
+				ref.SAPType = r.SAPType
+				ref.SAPDeltaTime = uint32(duration) + r.SAPDeltaTime
+			}
+			size += groups[i][j].size
+			duration += uint64(r.SubsegmentDuration)
+		}
+		if size >= 1<<31 || duration > math.MaxUint32 {
+			return nil, errors.New("too large subsegments for sidx")
+		}
+		ref.ReferencedSize = uint32(size)
+		ref.SubsegmentDuration = uint32(duration)
+		top.References[i] = ref
+	}
+	return top, nil
+}
+
+// sidxReference returns the entry of sidx box which refers to the subsegment of the samples.
+// The SAP type is 1 when no sample following the first sync sample is presented before it, and 3 otherwise.
+func sidxReference(samples []Sample, size uint64) (SidxReference, error) {
+	var duration uint64
+	for _, s := range samples {
+		duration += uint64(s.Duration)
+	}
+	if size >= 1<<31 || duration > math.MaxUint32 {
+		return SidxReference{}, errors.New("too large subsegment for sidx")
+	}
+	ref := SidxReference{
+		ReferencedSize:     uint32(size),
+		SubsegmentDuration: uint32(duration),
+	}
+	ept := int64(earliestPresentationTime(samples))
+	for i := range samples {
+		if !samples[i].IsSync {
+			continue
+		}
+		sap := compositionTime(&samples[i])
+		ref.StartsWithSAP = i == 0
+		ref.SAPType = 1
+		for j := range samples[i+1:] {
+			if compositionTime(&samples[i+1+j]) < sap {
+				ref.SAPType = 3
+				break
+			}
+		}
+		if sap > ept {
+			ref.SAPDeltaTime = uint32(sap - ept)
+		}
+		break
+	}
+	return ref, nil
+}
+
+// writeRebasedMoof writes moof box whose base data offsets of tfhd boxes are shifted by rebase.
+func writeRebasedMoof(w *Writer, r io.ReadSeeker, moof *BoxInfo, rebase func(uint64) (uint64, error)) error {
+	bs, err := ExtractBoxWithPayload(r, moof, BoxPath{BoxTypeTraf(), BoxTypeTfhd()})
+	if err != nil {
+		return err
+	}
+	var rebased bool
+	for _, b := range bs {
+		rebased = rebased || b.Payload.(*Tfhd).CheckFlag(TfhdBaseDataOffsetPresent)
+	}
+	if !rebased {
+		return w.CopyBox(r, moof)
+	}
+
+	_, err = ReadBoxStructureFromInternal(r, moof, func(h *ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case BoxTypeMoof(), BoxTypeTraf(), BoxTypeTfhd():
+		default:
+			return nil, w.CopyBox(r, &h.BoxInfo)
+		}
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		if tfhd, ok := box.(*Tfhd); ok && tfhd.CheckFlag(TfhdBaseDataOffsetPresent) {
+			if tfhd.BaseDataOffset, err = rebase(tfhd.BaseDataOffset); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := w.StartBox(&h.BoxInfo); err != nil {
+			return nil, err
+		}
+		if _, err := Marshal(w, box, h.BoxInfo.Context); err != nil {
+			return nil, err
+		}
+		if _, err := h.Expand(); err != nil {
+			return nil, err
+		}
+		_, err = w.EndBox()
+		return nil, err
+	})
+	return err
+}
+
+// writeRebasedMfra writes mfra box whose moof offsets of tfra boxes are shifted by rebase.
+func writeRebasedMfra(w *Writer, r io.ReadSeeker, mfra *BoxInfo, rebase func(uint64) (uint64, error)) error {
+	bs, err := ExtractBoxWithPayload(r, mfra, BoxPath{BoxTypeTfra()})
+	if err != nil {
+		return err
+	}
+	tfras := make([]*Tfra, 0, len(bs))
+	for _, b := range bs {
+		tfra := b.Payload.(*Tfra)
+		for i := range tfra.Entries {
+			e := &tfra.Entries[i]
+			if tfra.GetVersion() == 0 {
+				e.TimeV1 = uint64(e.TimeV0)
+				e.MoofOffsetV1 = uint64(e.MoofOffsetV0)
+			}
+			offset, err := rebase(e.MoofOffsetV1)
+			if err != nil {
+				return err
+			}
+			e.MoofOffsetV0 = uint32(offset)
+			e.MoofOffsetV1 = offset
+		}
+		tfras = append(tfras, tfra)
+	}
+	return writeMfra(w, tfras)
+}
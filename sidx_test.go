@@ -0,0 +1,291 @@
+package mp4
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/orcaman/writerseeker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+// checkSidx checks that the references of sidx box point to the boxes of the expected types,
+// and returns the offsets of the referred items.
+func checkSidx(t *testing.T, r io.ReadSeeker, sidx *BoxInfoWithPayload, types ...BoxType) []uint64 {
+	box := sidx.Payload.(*Sidx)
+	offset := sidx.Info.Offset + sidx.Info.Size + uint64(box.FirstOffsetV0)
+	if box.GetVersion() != 0 {
+		offset = sidx.Info.Offset + sidx.Info.Size + box.FirstOffsetV1
+	}
+	var offsets []uint64
+	for _, ref := range box.References {
+		_, err := r.Seek(int64(offset), io.SeekStart)
+		require.NoError(t, err)
+		bi, err := ReadBoxInfo(r)
+		require.NoError(t, err)
+		assert.Contains(t, types, bi.Type)
+		offsets = append(offsets, offset)
+		offset += uint64(ref.ReferencedSize)
+	}
+	return offsets
+}
+
+func TestAddSidx(t *testing.T) {
+	fs := memfs.New()
+	progressive := createProgressiveSample(t)
+	defer progressive.Close()
+
+	// the sidx box written by Fragment is the reference
+	expected, err := fs.Create("expected.mp4")
+	require.NoError(t, err)
+	defer expected.Close()
+	require.NoError(t, Fragment(progressive, expected, &FragmentOptions{
+		FragmentDuration: 200 * time.Millisecond,
+		WriteStyp:        true,
+		WriteSidx:        true,
+	}))
+	bs, err := ExtractBoxWithPayload(expected, nil, BoxPath{BoxTypeSidx()})
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	expectedSidx := bs[0].Payload.(*Sidx)
+
+	input, err := fs.Create("input.mp4")
+	require.NoError(t, err)
+	defer input.Close()
+	require.NoError(t, Fragment(progressive, input, &FragmentOptions{
+		FragmentDuration: 200 * time.Millisecond,
+		WriteStyp:        true,
+		WriteMfra:        true,
+	}))
+
+	for _, tc := range []struct {
+		name  string
+		input io.ReadSeeker
+	}{
+		{name: "without sidx", input: input},
+		{name: "with sidx", input: expected},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := memfs.New().Create("sidx.mp4")
+			require.NoError(t, err)
+			defer f.Close()
+			require.NoError(t, AddSidx(tc.input, f, nil))
+
+			// sidx box follows the init segment
+			var types []BoxType
+			_, err = ReadBoxStructure(f, func(h *ReadHandle) (interface{}, error) {
+				types = append(types, h.BoxInfo.Type)
+				return nil, nil
+			})
+			require.NoError(t, err)
+			require.True(t, len(types) > 3)
+			assert.Equal(t, []BoxType{BoxTypeFtyp(), BoxTypeMoov(), BoxTypeSidx(), BoxTypeStyp()}, types[:4])
+
+			bs, err := ExtractBoxWithPayload(f, nil, BoxPath{BoxTypeSidx()})
+			require.NoError(t, err)
+			require.Len(t, bs, 1)
+			assert.Equal(t, expectedSidx, bs[0].Payload.(*Sidx))
+			checkSidx(t, f, bs[0], BoxTypeStyp())
+
+			for trackID := uint32(1); trackID <= 2; trackID++ {
+				expectedSamples, err := ReadSamples(tc.input, trackID)
+				require.NoError(t, err)
+				samples, err := ReadSamples(f, trackID)
+				require.NoError(t, err)
+				require.Len(t, samples, len(expectedSamples))
+				for i := range samples {
+					assert.Equal(t, readSampleData(t, tc.input, expectedSamples[i]), readSampleData(t, f, samples[i]))
+				}
+			}
+		})
+	}
+
+	t.Run("tfra", func(t *testing.T) {
+		f, err := memfs.New().Create("sidx.mp4")
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, AddSidx(input, f, nil))
+		moofs, err := ExtractBox(f, nil, BoxPath{BoxTypeMoof()})
+		require.NoError(t, err)
+		bs, err := ExtractBoxWithPayload(f, nil, BoxPath{BoxTypeMfra(), BoxTypeTfra()})
+		require.NoError(t, err)
+		require.Len(t, bs, 2)
+		for _, b := range bs {
+			for _, e := range b.Payload.(*Tfra).Entries {
+				var found bool
+				for _, moof := range moofs {
+					found = found || moof.Offset == uint64(e.MoofOffsetV0)
+				}
+				assert.True(t, found)
+			}
+		}
+	})
+
+	t.Run("hierarchical", func(t *testing.T) {
+		f, err := memfs.New().Create("sidx.mp4")
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, AddSidx(input, f, &SidxOptions{MaxReferences: 2}))
+
+		bs, err := ExtractBoxWithPayload(f, nil, BoxPath{BoxTypeSidx()})
+		require.NoError(t, err)
+		n := len(expectedSidx.References)
+		require.Len(t, bs, 1+(n+1)/2)
+		top := bs[0].Payload.(*Sidx)
+		require.Len(t, top.References, (n+1)/2)
+		offsets := checkSidx(t, f, bs[0], BoxTypeSidx())
+		for i, ref := range top.References {
+			assert.True(t, ref.ReferenceType)
+			assert.Equal(t, offsets[i], bs[i+1].Info.Offset)
+			child := bs[i+1].Payload.(*Sidx)
+			var duration uint32
+			var size uint64 = bs[i+1].Info.Size
+			for j, r := range child.References {
+				assert.False(t, r.ReferenceType)
+				assert.Equal(t, expectedSidx.References[2*i+j], r)
+				duration += r.SubsegmentDuration
+				size += uint64(r.ReferencedSize)
+			}
+			assert.Equal(t, duration, ref.SubsegmentDuration)
+			assert.Equal(t, uint32(size), ref.ReferencedSize)
+			checkSidx(t, f, bs[i+1], BoxTypeStyp())
+		}
+		samples, err := ReadSamples(f, 1)
+		require.NoError(t, err)
+		expectedSamples, err := ReadSamples(input, 1)
+		require.NoError(t, err)
+		require.Len(t, samples, len(expectedSamples))
+		for i := range samples {
+			assert.Equal(t, readSampleData(t, input, expectedSamples[i]), readSampleData(t, f, samples[i]))
+		}
+	})
+
+	t.Run("separated tracks", func(t *testing.T) {
+		// each fragment has a track, and a subsegment has a video fragment and the following audio fragment
+		src, err := os.Open("./_examples/sample_fragmented.mp4")
+		require.NoError(t, err)
+		defer src.Close()
+		f, err := memfs.New().Create("sidx.mp4")
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, AddSidx(src, f, nil))
+
+		bs, err := ExtractBoxWithPayload(f, nil, BoxPath{BoxTypeSidx()})
+		require.NoError(t, err)
+		require.Len(t, bs, 1)
+		sidx := bs[0].Payload.(*Sidx)
+		assert.Equal(t, uint32(1), sidx.ReferenceID)
+		assert.Equal(t, uint32(90000), sidx.Timescale)
+		assert.Equal(t, uint32(18000), sidx.EarliestPresentationTimeV0)
+		require.Len(t, sidx.References, 4)
+		var duration uint32
+		for _, ref := range sidx.References {
+			duration += ref.SubsegmentDuration
+		}
+		assert.Equal(t, uint32(90000), duration)
+		checkSidx(t, f, bs[0], BoxTypeMoof())
+
+		// audio track is indexed
+		f2, err := memfs.New().Create("sidx_audio.mp4")
+		require.NoError(t, err)
+		defer f2.Close()
+		require.NoError(t, AddSidx(src, f2, &SidxOptions{TrackID: 2}))
+		bs, err = ExtractBoxWithPayload(f2, nil, BoxPath{BoxTypeSidx()})
+		require.NoError(t, err)
+		require.Len(t, bs, 1)
+		assert.Equal(t, uint32(2), bs[0].Payload.(*Sidx).ReferenceID)
+		assert.Equal(t, uint32(44100), bs[0].Payload.(*Sidx).Timescale)
+		checkSidx(t, f2, bs[0], BoxTypeMoof())
+	})
+
+	f, err := memfs.New().Create("sidx.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.Error(t, AddSidx(input, f, &SidxOptions{TrackID: 3}))
+	assert.Error(t, AddSidx(progressive, f, nil))
+}
+
+func TestAddSidxBaseDataOffset(t *testing.T) {
+	sampleData := [][]byte{{0x01, 0x02, 0x03}, {0x04, 0x05, 0x06, 0x07}}
+	url := &Url{}
+	url.SetFlags(UrlSelfContained)
+	init := &muxBox{box: &Moov{}, children: []*muxBox{
+		{box: &Mvhd{Timescale: 1000, NextTrackID: 2}},
+		{box: &Trak{}, children: []*muxBox{
+			{box: &Tkhd{TrackID: 1}},
+			{box: &Mdia{}, children: []*muxBox{
+				{box: &Mdhd{Timescale: 48000}},
+				{box: &Hdlr{HandlerType: [4]byte{'s', 'o', 'u', 'n'}}},
+				{box: &Minf{}, children: []*muxBox{
+					{box: &Dinf{}, children: []*muxBox{
+						{box: &Dref{EntryCount: 1}, children: []*muxBox{{box: url}}},
+					}},
+					{box: &Stbl{}, children: []*muxBox{
+						{box: &Stsd{}},
+						{box: &Stts{}},
+						{box: &Stsc{}},
+						{box: &Stsz{}},
+						{box: &Stco{}},
+					}},
+				}},
+			}},
+		}},
+		{box: &Mvex{}, children: []*muxBox{
+			{box: &Trex{TrackID: 1, DefaultSampleDescriptionIndex: 1, DefaultSampleDuration: 1024}},
+		}},
+	}}
+
+	// fragment whose data is referred by the absolute offset
+	buildMoof := func(sequenceNumber uint32, baseDataOffset uint64, size int) *muxBox {
+		tfhd := &Tfhd{TrackID: 1, BaseDataOffset: baseDataOffset}
+		tfhd.SetFlags(TfhdBaseDataOffsetPresent)
+		trun := &Trun{SampleCount: 1, Entries: []TrunEntry{{SampleSize: uint32(size)}}}
+		trun.SetFlags(TrunSampleSizePresent)
+		return &muxBox{box: &Moof{}, children: []*muxBox{
+			{box: &Mfhd{SequenceNumber: sequenceNumber}},
+			{box: &Traf{}, children: []*muxBox{
+				{box: tfhd},
+				{box: &Tfdt{BaseMediaDecodeTimeV0: 1024 * (sequenceNumber - 1)}},
+				{box: trun},
+			}},
+		}}
+	}
+	scratch := &writerseeker.WriterSeeker{}
+	require.NoError(t, writeMuxBox(NewWriter(scratch), buildMoof(1, 0, 0)))
+	moofSize, err := scratch.Seek(0, io.SeekCurrent)
+	require.NoError(t, err)
+
+	src, err := memfs.New().Create("fragmented.mp4")
+	require.NoError(t, err)
+	defer src.Close()
+	w := NewWriter(src)
+	require.NoError(t, writeMuxBox(w, init))
+	for i, data := range sampleData {
+		offset, err := w.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		require.NoError(t, writeMuxBox(w, buildMoof(uint32(i+1), uint64(offset+moofSize+8), len(data))))
+		_, err = WriteBoxInfo(w, &BoxInfo{Type: BoxTypeMdat(), Size: SmallHeaderSize + uint64(len(data))})
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+
+	f, err := memfs.New().Create("sidx.mp4")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, AddSidx(src, f, nil))
+
+	samples, err := ReadSamples(f, 1)
+	require.NoError(t, err)
+	require.Len(t, samples, len(sampleData))
+	for i := range samples {
+		assert.Equal(t, sampleData[i], readSampleData(t, f, samples[i]))
+	}
+	bs, err := ExtractBoxWithPayload(f, nil, BoxPath{BoxTypeSidx()})
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	checkSidx(t, f, bs[0], BoxTypeMoof())
+}
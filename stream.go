@@ -0,0 +1,313 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+var errStreamConsumed = errors.New("the data has already been consumed from the stream")
+
+// ReadBoxStructureStream reads the box structure from r which can not seek, such as a pipe, stdin or a network stream.
+// The handler is called in the order of the boxes as ReadBoxStructure, while ReadHandle has the following restrictions:
+//   - ReadPayload can be called repeatedly until ReadData or Expand is called,
+//     excepting mdat, free and skip boxes whose payload is not retained and can be read only once.
+//   - ReadData streams the payload without buffering it, and it can be called after ReadPayload.
+//   - ReadData and Expand consume the payload, and none of the functions can be called after them.
+//   - The boxes which are not expanded are discarded by reading past them.
+//   - ExtractBox and the other functions which seek the reader can not be called in the handler.
+//
+// A box which extends to the end of the stream has ExtendToEOF set,
+// and its Size covers only the header since the size is unknown until the end of the stream.
+// ReadPayload, ReadData and Expand of the box read until the end of the stream.
+func ReadBoxStructureStream(r io.Reader, handler ReadHandler, params ...interface{}) ([]interface{}, error) {
+	s := &streamSeeker{r: r}
+	return readStreamStructure(s, 0, true, nil, Context{}, handler, params)
+}
+
+func readStreamStructure(s *streamSeeker, totalSize uint64, untilEOF bool, path BoxPath, ctx Context, handler ReadHandler, params []interface{}) ([]interface{}, error) {
+	vals := make([]interface{}, 0, 8)
+
+	for untilEOF || totalSize != 0 {
+		bi, err := readStreamBoxInfo(s)
+		if untilEOF && err == io.EOF {
+			return vals, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !untilEOF && (bi.ExtendToEOF || bi.Size > totalSize) {
+			return nil, fmt.Errorf("too large box size: type=%s, size=%d, actualBufSize=%d", bi.Type.String(), bi.Size, totalSize)
+		}
+		totalSize -= bi.Size
+
+		bi.Context = ctx
+
+		val, err := readStreamStructureFromInternal(s, bi, path, handler, params)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+
+		if bi.IsQuickTimeCompatible {
+			ctx.IsQuickTimeCompatible = true
+		}
+		if bi.ExtendToEOF {
+			return vals, nil
+		}
+	}
+
+	return vals, nil
+}
+
+func readStreamStructureFromInternal(s *streamSeeker, bi *BoxInfo, path BoxPath, handler ReadHandler, params []interface{}) (interface{}, error) {
+	payloadOffset := int64(bi.Offset + bi.HeaderSize)
+
+	// check comatible-brands
+	if len(path) == 0 && bi.Type == BoxTypeFtyp() && !bi.ExtendToEOF {
+		s.mark()
+		var ftyp Ftyp
+		if _, err := Unmarshal(s, bi.Size-bi.HeaderSize, &ftyp, bi.Context); err != nil {
+			return nil, err
+		}
+		if ftyp.HasCompatibleBrand(BrandQT()) {
+			bi.IsQuickTimeCompatible = true
+		}
+		if _, err := s.Seek(payloadOffset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := childContext(bi)
+	newPath := childPath(path, bi)
+
+	h := &ReadHandle{
+		Params:  params,
+		BoxInfo: *bi,
+		Path:    newPath,
+	}
+
+	// the payload is retained until the handler returns or expands the children,
+	// so that the payload can be read again after it is decoded.
+	// The media data and the padding are not retained because they can be large.
+	if retainsStreamPayload(bi) {
+		s.mark()
+	}
+	defer s.unmark()
+
+	var consumed bool
+	var childrenOffset int64
+
+	h.ReadPayload = func() (IBox, uint64, error) {
+		if consumed {
+			return nil, 0, errStreamConsumed
+		}
+		if _, err := s.Seek(payloadOffset, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+
+		var box IBox
+		var n uint64
+		var err error
+		if bi.ExtendToEOF {
+			var data []byte
+			if data, err = ioutil.ReadAll(s); err != nil {
+				return nil, 0, err
+			}
+			box, n, err = UnmarshalAny(bytes.NewReader(data), bi.Type, uint64(len(data)), bi.Context)
+		} else {
+			box, n, err = UnmarshalAny(s, bi.Type, bi.Size-bi.HeaderSize, bi.Context)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		childrenOffset = payloadOffset + int64(n)
+		return box, n, nil
+	}
+
+	h.ReadData = func(w io.Writer) (uint64, error) {
+		if consumed {
+			return 0, errStreamConsumed
+		}
+		if _, err := s.Seek(payloadOffset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		// the data is streamed without being retained
+		consumed = true
+		s.unmark()
+
+		if bi.ExtendToEOF {
+			n, err := io.Copy(w, s)
+			return uint64(n), err
+		}
+		size := bi.Size - bi.HeaderSize
+		if _, err := io.CopyN(w, s, int64(size)); err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+
+	h.Expand = func(params ...interface{}) ([]interface{}, error) {
+		if consumed {
+			return nil, errStreamConsumed
+		}
+		if childrenOffset == 0 {
+			if _, err := s.Seek(payloadOffset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			payloadSize := bi.Size - bi.HeaderSize
+			if bi.ExtendToEOF {
+				// the fields of the container box precede the children
+				payloadSize = ^uint64(0) - bi.Offset - bi.HeaderSize
+			}
+			_, n, err := UnmarshalAny(s, bi.Type, payloadSize, bi.Context)
+			if err != nil {
+				return nil, err
+			}
+			childrenOffset = payloadOffset + int64(n)
+		}
+		if _, err := s.Seek(childrenOffset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		consumed = true
+		s.unmark()
+
+		if bi.ExtendToEOF {
+			return readStreamStructure(s, 0, true, newPath, ctx, handler, params)
+		}
+		childrenSize := bi.Offset + bi.Size - uint64(childrenOffset)
+		return readStreamStructure(s, childrenSize, false, newPath, ctx, handler, params)
+	}
+
+	val, err := handler(h)
+	if err != nil {
+		return nil, err
+	}
+	s.unmark()
+	if bi.ExtendToEOF {
+		if _, err := io.Copy(ioutil.Discard, s); err != nil {
+			return nil, err
+		}
+	} else if _, err := s.Seek(int64(bi.Offset+bi.Size), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// retainsStreamPayload returns true if the payload of the box is retained to be read repeatedly.
+func retainsStreamPayload(bi *BoxInfo) bool {
+	switch bi.Type {
+	case BoxTypeMdat(), BoxTypeFree(), BoxTypeSkip():
+		return false
+	default:
+		return bi.IsSupportedType()
+	}
+}
+
+// readStreamBoxInfo reads the header of the box from the stream.
+// The size of a box which extends to the end of the stream covers only the header.
+func readStreamBoxInfo(s *streamSeeker) (*BoxInfo, error) {
+	offset := s.offset
+	data := make([]byte, LargeHeaderSize)
+	if _, err := io.ReadFull(s, data[:SmallHeaderSize]); err != nil {
+		return nil, err
+	}
+	bi := &BoxInfo{
+		Offset:     uint64(offset),
+		HeaderSize: SmallHeaderSize,
+		Size:       uint64(binary.BigEndian.Uint32(data)),
+		Type:       BoxType{data[4], data[5], data[6], data[7]},
+	}
+	if bi.Size == 0 {
+		bi.ExtendToEOF = true
+		bi.Size = bi.HeaderSize
+	} else if bi.Size == 1 {
+		if _, err := io.ReadFull(s, data[SmallHeaderSize:]); err != nil {
+			return nil, err
+		}
+		bi.HeaderSize = LargeHeaderSize
+		bi.Size = binary.BigEndian.Uint64(data[SmallHeaderSize:])
+	}
+	if !bi.ExtendToEOF && bi.Size < bi.HeaderSize {
+		return nil, fmt.Errorf("too small box size: type=%s, size=%d", bi.Type.String(), bi.Size)
+	}
+	return bi, nil
+}
+
+// streamSeeker is io.ReadSeeker on io.Reader which can not seek.
+// It seeks forward by discarding the data,
+// and seeks backward only within the data which is retained after mark is called.
+type streamSeeker struct {
+	r io.Reader
+
+	// offset is the current offset, and head is the offset of the data which is read from r next.
+	offset int64
+	head   int64
+
+	// buf has the data from head-len(buf) to head.
+	buf    []byte
+	marked bool
+}
+
+// mark starts to retain the data from the current offset.
+func (s *streamSeeker) mark() {
+	if s.offset == s.head {
+		s.buf = s.buf[:0]
+	} else {
+		// the data after the current offset has already been retained
+		s.buf = s.buf[len(s.buf)-int(s.head-s.offset):]
+	}
+	s.marked = true
+}
+
+// unmark stops to retain the data.
+// The retained data can be read until the current offset passes it.
+func (s *streamSeeker) unmark() {
+	s.marked = false
+}
+
+func (s *streamSeeker) Read(p []byte) (int, error) {
+	if s.offset < s.head {
+		n := copy(p, s.buf[len(s.buf)-int(s.head-s.offset):])
+		s.offset += int64(n)
+		return n, nil
+	}
+	if !s.marked {
+		s.buf = s.buf[:0]
+	}
+	n, err := s.r.Read(p)
+	if s.marked {
+		s.buf = append(s.buf, p[:n]...)
+	}
+	s.offset += int64(n)
+	s.head += int64(n)
+	return n, err
+}
+
+func (s *streamSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	default:
+		return 0, errors.New("can not seek from the end of the stream")
+	}
+	if offset < s.head-int64(len(s.buf)) {
+		return 0, errStreamConsumed
+	}
+	if offset <= s.head {
+		s.offset = offset
+		return offset, nil
+	}
+	s.offset = s.head
+	if _, err := io.CopyN(ioutil.Discard, s, offset-s.head); err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return offset, nil
+}
@@ -0,0 +1,202 @@
+package mp4
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBoxStructureStream(t *testing.T) {
+	type entry struct {
+		path    BoxPath
+		info    BoxInfo
+		payload string
+		data    []byte
+	}
+
+	// dump decodes the supported boxes and reads the data of the others as mp4tool dump does
+	dump := func(entries *[]entry) ReadHandler {
+		return func(h *ReadHandle) (interface{}, error) {
+			e := entry{path: h.Path, info: h.BoxInfo}
+			if h.BoxInfo.IsSupportedType() && h.BoxInfo.Type != BoxTypeMdat() {
+				box, _, err := h.ReadPayload()
+				if err == nil {
+					if e.payload, err = Stringify(box, h.BoxInfo.Context); err != nil {
+						return nil, err
+					}
+					*entries = append(*entries, e)
+					_, err := h.Expand()
+					return nil, err
+				} else if err != ErrUnsupportedBoxVersion {
+					return nil, err
+				}
+			}
+			buf := bytes.NewBuffer(nil)
+			if _, err := h.ReadData(buf); err != nil {
+				return nil, err
+			}
+			e.data = buf.Bytes()
+			*entries = append(*entries, e)
+			return nil, nil
+		}
+	}
+
+	for _, file := range []string{
+		"./_examples/sample.mp4",
+		"./_examples/sample_fragmented.mp4",
+		"./_examples/sample_qt.mp4",
+	} {
+		t.Run(file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+
+			var expected, actual []entry
+			_, err = ReadBoxStructure(bytes.NewReader(data), dump(&expected))
+			require.NoError(t, err)
+			_, err = ReadBoxStructureStream(iotest.OneByteReader(bytes.NewReader(data)), dump(&actual))
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestReadBoxStructureStreamSkip(t *testing.T) {
+	data, err := ioutil.ReadFile("./_examples/sample_fragmented.mp4")
+	require.NoError(t, err)
+
+	// the children which are not expanded are discarded
+	var types []BoxType
+	_, err = ReadBoxStructureStream(bytes.NewReader(data), func(h *ReadHandle) (interface{}, error) {
+		types = append(types, h.BoxInfo.Type)
+		if h.BoxInfo.Type == BoxTypeMoof() {
+			return h.Expand()
+		}
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []BoxType{
+		BoxTypeFtyp(), BoxTypeMoov(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMoof(), BoxTypeMfhd(), BoxTypeTraf(), BoxTypeMdat(),
+		BoxTypeMfra(),
+	}, types)
+}
+
+func TestReadBoxStructureStreamExtendToEOF(t *testing.T) {
+	data := []byte{
+		0x00, 0x00, 0x00, 0x10, 'f', 'r', 'e', 'e', 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x00, 0x00, 0x00, 0x00, 'm', 'd', 'a', 't', 0x11, 0x12, 0x13, 0x14, 0x15,
+	}
+
+	t.Run("read data", func(t *testing.T) {
+		var payloads [][]byte
+		_, err := ReadBoxStructureStream(iotest.OneByteReader(bytes.NewReader(data)), func(h *ReadHandle) (interface{}, error) {
+			buf := bytes.NewBuffer(nil)
+			n, err := h.ReadData(buf)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(buf.Len()), n)
+			payloads = append(payloads, buf.Bytes())
+			if h.BoxInfo.Type == BoxTypeMdat() {
+				assert.True(t, h.BoxInfo.ExtendToEOF)
+				assert.Equal(t, uint64(16), h.BoxInfo.Offset)
+				assert.Equal(t, uint64(8), h.BoxInfo.Size)
+			}
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{
+			{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			{0x11, 0x12, 0x13, 0x14, 0x15},
+		}, payloads)
+	})
+
+	t.Run("read payload", func(t *testing.T) {
+		var mdat *Mdat
+		_, err := ReadBoxStructureStream(bytes.NewReader(data), func(h *ReadHandle) (interface{}, error) {
+			if h.BoxInfo.Type == BoxTypeMdat() {
+				box, n, err := h.ReadPayload()
+				require.NoError(t, err)
+				assert.Equal(t, uint64(5), n)
+				mdat = box.(*Mdat)
+			}
+			return nil, nil
+		})
+		require.NoError(t, err)
+		require.NotNil(t, mdat)
+		assert.Equal(t, []byte{0x11, 0x12, 0x13, 0x14, 0x15}, mdat.Data)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		var n int
+		_, err := ReadBoxStructureStream(bytes.NewReader(data), func(h *ReadHandle) (interface{}, error) {
+			n++
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+}
+
+func TestReadBoxStructureStreamConsumed(t *testing.T) {
+	data, err := ioutil.ReadFile("./_examples/sample.mp4")
+	require.NoError(t, err)
+
+	_, err = ReadBoxStructureStream(bytes.NewReader(data), func(h *ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case BoxTypeFtyp():
+			// the payload can be read repeatedly until it is streamed
+			_, _, err := h.ReadPayload()
+			require.NoError(t, err)
+			_, _, err = h.ReadPayload()
+			require.NoError(t, err)
+			_, err = h.ReadData(ioutil.Discard)
+			require.NoError(t, err)
+			_, _, err = h.ReadPayload()
+			assert.Error(t, err)
+		case BoxTypeMdat():
+			// the media data is not retained
+			_, _, err := h.ReadPayload()
+			require.NoError(t, err)
+			_, _, err = h.ReadPayload()
+			assert.Equal(t, errStreamConsumed, err)
+			_, err = h.ReadData(ioutil.Discard)
+			assert.Equal(t, errStreamConsumed, err)
+		case BoxTypeMoov():
+			_, err := h.Expand()
+			require.NoError(t, err)
+			_, _, err = h.ReadPayload()
+			assert.Error(t, err)
+			_, err = h.ReadData(ioutil.Discard)
+			assert.Error(t, err)
+		}
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	// the size of the child exceeds the parent
+	_, err = ReadBoxStructureStream(bytes.NewReader([]byte{
+		0x00, 0x00, 0x00, 0x10, 'm', 'o', 'o', 'v',
+		0x00, 0x00, 0x00, 0x10, 'u', 'd', 't', 'a',
+	}), func(h *ReadHandle) (interface{}, error) {
+		return h.Expand()
+	})
+	assert.Error(t, err)
+
+	// the stream ends in the box
+	_, err = ReadBoxStructureStream(bytes.NewReader([]byte{
+		0x00, 0x00, 0x00, 0x10, 'f', 'r', 'e', 'e', 0x00,
+	}), func(h *ReadHandle) (interface{}, error) {
+		return nil, nil
+	})
+	assert.Error(t, err)
+}
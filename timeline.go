@@ -0,0 +1,240 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EditSegment is a segment of the presentation timeline which is defined by an entry of elst box.
+// All times are in the media timescale.
+type EditSegment struct {
+	// PresentationTime is the start of the segment on the presentation timeline.
+	PresentationTime int64
+	Duration         int64
+
+	// MediaTime is the start of the segment on the media timeline, and -1 means an empty edit.
+	MediaTime int64
+
+	// Rate is the media rate, and 0 means a dwell which holds the media at MediaTime for Duration.
+	Rate float64
+}
+
+// IsEmpty returns true when the segment is an empty edit, which presents no media.
+func (s *EditSegment) IsEmpty() bool {
+	return s.MediaTime == -1
+}
+
+// mediaDuration returns the duration of the media which is presented in the segment.
+func (s *EditSegment) mediaDuration() int64 {
+	return scaleByRate(s.Duration, s.Rate)
+}
+
+// Timeline is the presentation timeline of a track which is derived from the edit list.
+// All times are in the media timescale, and the presentation time 0 is the beginning of the movie.
+type Timeline struct {
+	Timescale uint32
+	Segments  []EditSegment
+
+	// StartTime is the presentation time at which the first sample is presented.
+	// It differs from the composition time of the first sample
+	// when the edit list skips the delay of B-frames or the encoder delay of audio.
+	StartTime int64
+}
+
+// NewTimeline returns the presentation timeline of the track whose edit list is elst.
+// When elst is nil, the media is presented as it is from the presentation time 0.
+// samples are the samples of the track, which are used to compute the media duration and the start time.
+func NewTimeline(elst *Elst, movieTimescale, mediaTimescale uint32, samples []Sample) (*Timeline, error) {
+	// range of the composition times of the samples
+	var mediaStart, mediaEnd int64
+	for i, s := range samples {
+		ct := int64(s.DecodeTime) + s.CompositionTimeOffset
+		if i == 0 || ct < mediaStart {
+			mediaStart = ct
+		}
+		if i == 0 || ct+int64(s.Duration) > mediaEnd {
+			mediaEnd = ct + int64(s.Duration)
+		}
+	}
+	return newTimeline(elst, movieTimescale, mediaTimescale, mediaStart, mediaEnd, len(samples) != 0)
+}
+
+// newTimeline returns the presentation timeline of the media whose composition times range from mediaStart to mediaEnd.
+// StartTime is computed only when hasMedia is true.
+func newTimeline(elst *Elst, movieTimescale, mediaTimescale uint32, mediaStart, mediaEnd int64, hasMedia bool) (*Timeline, error) {
+	if mediaTimescale == 0 {
+		return nil, errors.New("media timescale is 0")
+	}
+
+	t := &Timeline{Timescale: mediaTimescale}
+	if elst == nil || len(elst.Entries) == 0 {
+		t.Segments = []EditSegment{{Duration: mediaEnd, Rate: 1}}
+	} else {
+		if movieTimescale == 0 {
+			return nil, errors.New("movie timescale is 0")
+		}
+		var presentationTime int64
+		for i, e := range elst.Entries {
+			segment := EditSegment{
+				PresentationTime: presentationTime,
+				Duration:         int64(scaleUint(uint64(e.SegmentDurationV0), movieTimescale, mediaTimescale)),
+				MediaTime:        int64(e.MediaTimeV0),
+				Rate:             float64(e.MediaRateInteger) + float64(e.MediaRateFraction)/0x10000,
+			}
+			if elst.GetVersion() == 1 {
+				segment.Duration = int64(scaleUint(e.SegmentDurationV1, movieTimescale, mediaTimescale))
+				segment.MediaTime = e.MediaTimeV1
+			}
+			if segment.MediaTime < -1 || segment.Rate < 0 {
+				return nil, fmt.Errorf("invalid edit list entry: mediaTime=%d rate=%g", segment.MediaTime, segment.Rate)
+			}
+			// the last segment whose duration is 0 lasts until the end of the media
+			if segment.Duration == 0 && i == len(elst.Entries)-1 && !segment.IsEmpty() &&
+				segment.Rate != 0 && mediaEnd > segment.MediaTime {
+				segment.Duration = int64(float64(mediaEnd-segment.MediaTime) / segment.Rate)
+			}
+			t.Segments = append(t.Segments, segment)
+			presentationTime += segment.Duration
+		}
+	}
+
+	if hasMedia {
+		t.StartTime = t.startTime(mediaStart, mediaEnd)
+	}
+	return t, nil
+}
+
+// startTime returns the presentation time at which the media between mediaStart and mediaEnd is presented first.
+func (t *Timeline) startTime(mediaStart, mediaEnd int64) int64 {
+	for _, s := range t.Segments {
+		if s.IsEmpty() || s.Duration == 0 {
+			continue
+		}
+		if s.Rate == 0 {
+			if mediaStart <= s.MediaTime && s.MediaTime < mediaEnd {
+				return s.PresentationTime
+			}
+			continue
+		}
+		start := s.MediaTime
+		if mediaStart > start {
+			start = mediaStart
+		}
+		end := s.MediaTime + s.mediaDuration()
+		if mediaEnd < end {
+			end = mediaEnd
+		}
+		if start < end {
+			return s.PresentationTime + scaleByRate(start-s.MediaTime, 1/s.Rate)
+		}
+	}
+	return 0
+}
+
+// Duration returns the duration of the presentation.
+func (t *Timeline) Duration() int64 {
+	if len(t.Segments) == 0 {
+		return 0
+	}
+	last := t.Segments[len(t.Segments)-1]
+	return last.PresentationTime + last.Duration
+}
+
+// MediaTime returns the media time which is presented at the specified presentation time.
+// It returns false when the presentation time is out of the timeline or in an empty edit.
+func (t *Timeline) MediaTime(presentationTime int64) (int64, bool) {
+	for _, s := range t.Segments {
+		if presentationTime < s.PresentationTime || presentationTime >= s.PresentationTime+s.Duration {
+			continue
+		}
+		if s.IsEmpty() {
+			return 0, false
+		}
+		return s.MediaTime + scaleByRate(presentationTime-s.PresentationTime, s.Rate), true
+	}
+	return 0, false
+}
+
+// PresentationTime returns the presentation time at which the specified media time is presented first.
+// It returns false when the media time is not presented.
+func (t *Timeline) PresentationTime(mediaTime int64) (int64, bool) {
+	for _, s := range t.Segments {
+		if s.IsEmpty() || s.Duration == 0 {
+			continue
+		}
+		if s.Rate == 0 {
+			if mediaTime == s.MediaTime {
+				return s.PresentationTime, true
+			}
+			continue
+		}
+		if mediaTime >= s.MediaTime && mediaTime < s.MediaTime+s.mediaDuration() {
+			return s.PresentationTime + scaleByRate(mediaTime-s.MediaTime, 1/s.Rate), true
+		}
+	}
+	return 0, false
+}
+
+// ReadTimeline returns the presentation timeline of the specified track.
+func ReadTimeline(r io.ReadSeeker, trackID uint32) (*Timeline, error) {
+	trak, err := findTrak(r, trackID)
+	if err != nil {
+		return nil, err
+	}
+	return readTimeline(r, trak, trackID)
+}
+
+func readTimeline(r io.ReadSeeker, trak *BoxInfo, trackID uint32) (*Timeline, error) {
+	elst, movieTimescale, mediaTimescale, err := readEdits(r, trak)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := ReadSamples(r, trackID)
+	if err != nil {
+		return nil, err
+	}
+	return NewTimeline(elst, movieTimescale, mediaTimescale, samples)
+}
+
+// readEdits returns the edit list of the track, the movie timescale and the media timescale.
+// The movie timescale is 0 when the track has no edit list.
+func readEdits(r io.ReadSeeker, trak *BoxInfo) (*Elst, uint32, uint32, error) {
+	bs, err := ExtractBoxesWithPayload(r, trak, []BoxPath{
+		{BoxTypeEdts(), BoxTypeElst()},
+		{BoxTypeMdia(), BoxTypeMdhd()},
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var elst *Elst
+	var mediaTimescale uint32
+	for _, b := range bs {
+		switch box := b.Payload.(type) {
+		case *Elst:
+			elst = box
+		case *Mdhd:
+			mediaTimescale = box.Timescale
+		}
+	}
+	if elst == nil {
+		return nil, 0, mediaTimescale, nil
+	}
+
+	// the movie timescale is required only for the segment durations of the edit list
+	bs, err = ExtractBoxWithPayload(r, nil, BoxPath{BoxTypeMoov(), BoxTypeMvhd()})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(bs) == 0 {
+		return nil, 0, 0, errors.New("mvhd box is not found")
+	}
+	return elst, bs[0].Payload.(*Mvhd).Timescale, mediaTimescale, nil
+}
+
+func scaleByRate(t int64, rate float64) int64 {
+	if rate == 1 {
+		return t
+	}
+	return int64(float64(t) * rate)
+}
@@ -0,0 +1,212 @@
+package mp4
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTimeline(t *testing.T) {
+	// I, P, B, B frames whose composition times are 3000, 12000, 6000 and 9000
+	samples := []Sample{
+		{DecodeTime: 0, Duration: 3000, CompositionTimeOffset: 3000, IsSync: true},
+		{DecodeTime: 3000, Duration: 3000, CompositionTimeOffset: 9000},
+		{DecodeTime: 6000, Duration: 3000, CompositionTimeOffset: 0},
+		{DecodeTime: 9000, Duration: 3000, CompositionTimeOffset: 0},
+	}
+	elst := func(version uint8, entries ...ElstEntry) *Elst {
+		elst := &Elst{EntryCount: uint32(len(entries)), Entries: entries}
+		elst.SetVersion(version)
+		return elst
+	}
+
+	type mapping struct {
+		presentationTime int64
+		mediaTime        int64
+		ok               bool
+	}
+	testCases := []struct {
+		name              string
+		elst              *Elst
+		segments          []EditSegment
+		startTime         int64
+		mediaTimes        []mapping
+		presentationTimes []mapping
+	}{
+		{
+			name:      "no edit list",
+			segments:  []EditSegment{{Duration: 15000, Rate: 1}},
+			startTime: 3000,
+			mediaTimes: []mapping{
+				{presentationTime: 4000, mediaTime: 4000, ok: true},
+				{presentationTime: 15000},
+			},
+			presentationTimes: []mapping{
+				{mediaTime: 3000, presentationTime: 3000, ok: true},
+			},
+		},
+		{
+			name: "B-frame delay",
+			elst: elst(0, ElstEntry{SegmentDurationV0: 100, MediaTimeV0: 3000, MediaRateInteger: 1}),
+			segments: []EditSegment{
+				{Duration: 9000, MediaTime: 3000, Rate: 1},
+			},
+			startTime: 0,
+			mediaTimes: []mapping{
+				{presentationTime: 0, mediaTime: 3000, ok: true},
+				{presentationTime: 9000},
+			},
+			presentationTimes: []mapping{
+				{mediaTime: 3000, presentationTime: 0, ok: true},
+				{mediaTime: 0},
+			},
+		},
+		{
+			name: "empty edit",
+			elst: elst(0,
+				ElstEntry{SegmentDurationV0: 50, MediaTimeV0: -1, MediaRateInteger: 1},
+				ElstEntry{SegmentDurationV0: 100, MediaTimeV0: 3000, MediaRateInteger: 1},
+			),
+			segments: []EditSegment{
+				{Duration: 4500, MediaTime: -1, Rate: 1},
+				{PresentationTime: 4500, Duration: 9000, MediaTime: 3000, Rate: 1},
+			},
+			startTime: 4500,
+			mediaTimes: []mapping{
+				{presentationTime: 1000},
+				{presentationTime: 4500, mediaTime: 3000, ok: true},
+			},
+			presentationTimes: []mapping{
+				{mediaTime: 6000, presentationTime: 7500, ok: true},
+			},
+		},
+		{
+			name: "dwell",
+			elst: elst(0,
+				ElstEntry{SegmentDurationV0: 10, MediaTimeV0: 3000, MediaRateInteger: 0},
+				ElstEntry{SegmentDurationV0: 100, MediaTimeV0: 3000, MediaRateInteger: 1},
+			),
+			segments: []EditSegment{
+				{Duration: 900, MediaTime: 3000, Rate: 0},
+				{PresentationTime: 900, Duration: 9000, MediaTime: 3000, Rate: 1},
+			},
+			startTime: 0,
+			mediaTimes: []mapping{
+				{presentationTime: 500, mediaTime: 3000, ok: true},
+				{presentationTime: 1000, mediaTime: 3100, ok: true},
+			},
+			presentationTimes: []mapping{
+				{mediaTime: 3000, presentationTime: 0, ok: true},
+				{mediaTime: 3100, presentationTime: 1000, ok: true},
+			},
+		},
+		{
+			name: "double rate",
+			elst: elst(0, ElstEntry{SegmentDurationV0: 50, MediaTimeV0: 3000, MediaRateInteger: 2}),
+			segments: []EditSegment{
+				{Duration: 4500, MediaTime: 3000, Rate: 2},
+			},
+			startTime: 0,
+			mediaTimes: []mapping{
+				{presentationTime: 1000, mediaTime: 5000, ok: true},
+			},
+			presentationTimes: []mapping{
+				{mediaTime: 7000, presentationTime: 2000, ok: true},
+				{mediaTime: 12000},
+			},
+		},
+		{
+			name: "edit before the first sample",
+			elst: elst(0, ElstEntry{SegmentDurationV0: 100, MediaTimeV0: 0, MediaRateInteger: 1}),
+			segments: []EditSegment{
+				{Duration: 9000, MediaTime: 0, Rate: 1},
+			},
+			startTime: 3000,
+		},
+		{
+			// the duration 0 of fragmented files means the end of the media
+			name: "zero duration",
+			elst: elst(1, ElstEntry{SegmentDurationV1: 0, MediaTimeV1: 3000, MediaRateInteger: 1}),
+			segments: []EditSegment{
+				{Duration: 12000, MediaTime: 3000, Rate: 1},
+			},
+			startTime: 0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			timeline, err := NewTimeline(tc.elst, 1000, 90000, samples)
+			require.NoError(t, err)
+			assert.Equal(t, uint32(90000), timeline.Timescale)
+			assert.Equal(t, tc.segments, timeline.Segments)
+			assert.Equal(t, tc.startTime, timeline.StartTime)
+			last := tc.segments[len(tc.segments)-1]
+			assert.Equal(t, last.PresentationTime+last.Duration, timeline.Duration())
+			for _, m := range tc.mediaTimes {
+				mediaTime, ok := timeline.MediaTime(m.presentationTime)
+				assert.Equal(t, m.ok, ok, "presentationTime=%d", m.presentationTime)
+				assert.Equal(t, m.mediaTime, mediaTime, "presentationTime=%d", m.presentationTime)
+			}
+			for _, m := range tc.presentationTimes {
+				presentationTime, ok := timeline.PresentationTime(m.mediaTime)
+				assert.Equal(t, m.ok, ok, "mediaTime=%d", m.mediaTime)
+				assert.Equal(t, m.presentationTime, presentationTime, "mediaTime=%d", m.mediaTime)
+			}
+		})
+	}
+
+	_, err := NewTimeline(elst(0, ElstEntry{SegmentDurationV0: 100, MediaTimeV0: -2, MediaRateInteger: 1}), 1000, 90000, samples)
+	assert.Error(t, err)
+	_, err = NewTimeline(elst(0, ElstEntry{SegmentDurationV0: 100, MediaTimeV0: 0, MediaRateInteger: -1}), 1000, 90000, samples)
+	assert.Error(t, err)
+	_, err = NewTimeline(nil, 1000, 0, samples)
+	assert.Error(t, err)
+}
+
+func TestReadTimeline(t *testing.T) {
+	testCases := []struct {
+		name      string
+		file      string
+		trackID   uint32
+		segments  []EditSegment
+		startTime int64
+	}{
+		{
+			// the edit list skips the delay of B-frames
+			name:      "video with edit list",
+			file:      "./_examples/sample.mp4",
+			trackID:   1,
+			segments:  []EditSegment{{Duration: 10240, MediaTime: 2048, Rate: 1}},
+			startTime: 0,
+		},
+		{
+			// the edit list skips the priming samples
+			name:      "audio with edit list",
+			file:      "./_examples/sample.mp4",
+			trackID:   2,
+			segments:  []EditSegment{{Duration: 44100, MediaTime: 1024, Rate: 1}},
+			startTime: 0,
+		},
+		{
+			// the first frame is presented after the delay of B-frames
+			name:      "video without edit list",
+			file:      "./_examples/sample_fragmented.mp4",
+			trackID:   1,
+			segments:  []EditSegment{{Duration: 108000, Rate: 1}},
+			startTime: 18000,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := os.Open(tc.file)
+			require.NoError(t, err)
+			defer f.Close()
+			timeline, err := ReadTimeline(f, tc.trackID)
+			require.NoError(t, err)
+			assert.Equal(t, tc.segments, timeline.Segments)
+			assert.Equal(t, tc.startTime, timeline.StartTime)
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package mp4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+var errNotChild = errors.New("the node is not a child of the parent")
+
+// Node is a box in the tree which is built by ParseTree.
+// The payload is decoded on demand by Payload,
+// and the payload which has never been decoded is copied from the source as it is when the tree is written.
+// The offsets in stco, co64, tfhd, trun, saio, tfra, sidx and iloc boxes of the source are regarded as the offsets in the source,
+// and they are relocated to the offsets in the output when the tree is written.
+// stco box is written as co64 box when the relocated offsets exceed 32 bits.
+type Node struct {
+	// BoxInfo is the box in the source, and it is not updated by the mutations.
+	BoxInfo  BoxInfo
+	Parent   *Node
+	Children []*Node
+
+	src io.ReadSeeker
+
+	// payloadSize is the size of the payload in the source excluding the children.
+	payloadSize uint64
+	payload     IBox
+
+	// size, headerSize, encoded and fixed are computed by layout before the tree is written,
+	// and offset is the offset in the output which is computed by place.
+	size       uint64
+	headerSize uint64
+	encoded    []byte
+	fixed      IBox
+	offset     uint64
+
+	// co64 is true when stco box is written as co64 box, and it is kept until the tree is written.
+	co64 bool
+}
+
+// ParseTree reads the box structure from r and returns the root node which has the top-level boxes as its children.
+// The root node represents the file itself and has no box.
+// The children of the boxes of the supported types are parsed excepting mdat, free and skip,
+// and the other boxes are treated as raw data.
+// r must be available until the tree is written because the payloads are read lazily.
+func ParseTree(r io.ReadSeeker) (*Node, error) {
+	root := &Node{}
+	vals, err := ReadBoxStructure(r, func(h *ReadHandle) (interface{}, error) {
+		n := &Node{
+			BoxInfo:     h.BoxInfo,
+			src:         r,
+			payloadSize: h.BoxInfo.Size - h.BoxInfo.HeaderSize,
+		}
+		if h.BoxInfo.IsQuickTimeCompatible {
+			root.BoxInfo.IsQuickTimeCompatible = true
+		}
+		if !h.BoxInfo.IsSupportedType() ||
+			h.BoxInfo.Type == BoxTypeMdat() ||
+			h.BoxInfo.Type == BoxTypeFree() ||
+			h.BoxInfo.Type == BoxTypeSkip() {
+			return n, nil
+		}
+		vals, err := h.Expand()
+		if err == ErrUnsupportedBoxVersion {
+			return n, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, val := range vals {
+			c := val.(*Node)
+			c.Parent = n
+			n.Children = append(n.Children, c)
+		}
+		if len(n.Children) != 0 {
+			n.payloadSize = n.Children[0].BoxInfo.Offset - n.BoxInfo.Offset - n.BoxInfo.HeaderSize
+		}
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, val := range vals {
+		c := val.(*Node)
+		c.Parent = root
+		root.Children = append(root.Children, c)
+	}
+	return root, nil
+}
+
+// NewNode returns the node of the box which is not in the source.
+func NewNode(box IBox) *Node {
+	return &Node{
+		BoxInfo: BoxInfo{Type: box.GetType()},
+		payload: box,
+	}
+}
+
+// Type returns the box type of the node.
+func (n *Node) Type() BoxType {
+	return n.BoxInfo.Type
+}
+
+// Payload returns the decoded payload excluding the children.
+// The returned box is retained by the node, and its modification is reflected when the tree is written.
+func (n *Node) Payload() (IBox, error) {
+	if n.payload != nil {
+		return n.payload, nil
+	}
+	if n.src == nil {
+		return nil, errors.New("the root node has no payload")
+	}
+	if _, err := n.BoxInfo.SeekToPayload(n.src); err != nil {
+		return nil, err
+	}
+	box, _, err := UnmarshalAny(n.src, n.BoxInfo.Type, n.payloadSize, n.BoxInfo.Context)
+	if err != nil {
+		return nil, err
+	}
+	n.payload = box
+	return box, nil
+}
+
+// Find returns the descendants which match the path relative to the node.
+func (n *Node) Find(path BoxPath) []*Node {
+	if len(path) == 0 {
+		return nil
+	}
+	var nodes []*Node
+	for _, c := range n.Children {
+		if !path[0].MatchWith(c.BoxInfo.Type) {
+			continue
+		}
+		if len(path) == 1 {
+			nodes = append(nodes, c)
+		} else {
+			nodes = append(nodes, c.Find(path[1:])...)
+		}
+	}
+	return nodes
+}
+
+// Append adds the node to the end of the children.
+// The node is removed from its current parent if it has.
+func (n *Node) Append(child *Node) {
+	n.insert(len(n.Children), child)
+}
+
+// InsertBefore inserts the node before ref which is one of the children.
+// The node is appended when ref is nil.
+func (n *Node) InsertBefore(child, ref *Node) error {
+	if ref == nil {
+		n.Append(child)
+		return nil
+	}
+	if ref.Parent != n {
+		return errNotChild
+	}
+	if child == ref {
+		return nil
+	}
+	if child.Parent != nil {
+		child.Parent.remove(child)
+	}
+	n.insert(n.indexOf(ref), child)
+	return nil
+}
+
+// Remove removes the node from the children.
+func (n *Node) Remove(child *Node) error {
+	if child.Parent != n {
+		return errNotChild
+	}
+	n.remove(child)
+	return nil
+}
+
+// Replace replaces old which is one of the children with the node.
+func (n *Node) Replace(child, old *Node) error {
+	if old.Parent != n {
+		return errNotChild
+	}
+	if child == old {
+		return nil
+	}
+	if err := n.InsertBefore(child, old); err != nil {
+		return err
+	}
+	n.remove(old)
+	return nil
+}
+
+func (n *Node) indexOf(child *Node) int {
+	for i, c := range n.Children {
+		if c == child {
+			return i
+		}
+	}
+	return -1
+}
+
+func (n *Node) insert(i int, child *Node) {
+	if child.Parent != nil {
+		child.Parent.remove(child)
+		if i > len(n.Children) {
+			i = len(n.Children)
+		}
+	}
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = child
+	child.Parent = n
+	child.setContext(childContext(&n.BoxInfo))
+}
+
+func (n *Node) remove(child *Node) {
+	i := n.indexOf(child)
+	n.Children = append(n.Children[:i], n.Children[i+1:]...)
+	child.Parent = nil
+}
+
+func (n *Node) setContext(ctx Context) {
+	n.BoxInfo.Context = ctx
+	for _, c := range n.Children {
+		c.setContext(childContext(&n.BoxInfo))
+	}
+}
+
+// WriteTo writes the node and its descendants to w, and the sizes of all boxes are recomputed.
+// The root node writes only its children.
+// It fails if a relocated offset refers to the data which is removed or decoded.
+func (n *Node) WriteTo(w io.Writer) (int64, error) {
+	defer n.clearLayout()
+	if err := n.layout(); err != nil {
+		return 0, err
+	}
+	for {
+		rl := newRelocation()
+		n.place(0, rl)
+		sort.Slice(rl.ranges, func(i, j int) bool {
+			return rl.ranges[i].src < rl.ranges[j].src
+		})
+		if err := n.relocate(rl); err != nil {
+			return 0, err
+		}
+		if !rl.promoted {
+			break
+		}
+		// the promoted boxes are larger, so that the offsets are computed again
+		if err := n.layout(); err != nil {
+			return 0, err
+		}
+	}
+	if err := n.encodeFixed(); err != nil {
+		return 0, err
+	}
+	if err := n.write(w); err != nil {
+		return 0, err
+	}
+	return int64(n.size), nil
+}
+
+func (n *Node) isRoot() bool {
+	return n.src == nil && n.payload == nil
+}
+
+// isEncoded returns true if the payload is written from the encoded box instead of the source.
+func (n *Node) isEncoded() bool {
+	return n.payload != nil || n.fixed != nil
+}
+
+// layout computes the sizes of the node and its descendants bottom-up.
+func (n *Node) layout() error {
+	var size uint64
+	if !n.isRoot() {
+		box := n.payload
+		if n.src != nil && isRelocatable(n.BoxInfo.Type) {
+			var err error
+			if n.fixed, err = n.decodeCopy(); err != nil {
+				return err
+			}
+			if stco, ok := n.fixed.(*Stco); ok && n.co64 {
+				co64 := &Co64{FullBox: stco.FullBox, EntryCount: stco.EntryCount, ChunkOffset: make([]uint64, len(stco.ChunkOffset))}
+				for i, offset := range stco.ChunkOffset {
+					co64.ChunkOffset[i] = uint64(offset)
+				}
+				n.fixed = co64
+			}
+			box = n.fixed
+		}
+		if box != nil {
+			buf := bytes.NewBuffer(nil)
+			if _, err := Marshal(buf, box, n.BoxInfo.Context); err != nil {
+				return err
+			}
+			n.encoded = buf.Bytes()
+			size = uint64(len(n.encoded))
+		} else {
+			size = n.payloadSize
+		}
+	}
+	for _, c := range n.Children {
+		if err := c.layout(); err != nil {
+			return err
+		}
+		size += c.size
+	}
+	if n.isRoot() {
+		n.size = size
+		return nil
+	}
+	n.headerSize = SmallHeaderSize
+	if n.BoxInfo.HeaderSize == LargeHeaderSize || size+SmallHeaderSize > math.MaxUint32 {
+		n.headerSize = LargeHeaderSize
+	}
+	n.size = n.headerSize + size
+	return nil
+}
+
+// encodeFixed encodes the relocated payloads, which must not change the sizes.
+func (n *Node) encodeFixed() error {
+	if n.fixed != nil {
+		buf := bytes.NewBuffer(make([]byte, 0, len(n.encoded)))
+		if _, err := Marshal(buf, n.fixed, n.BoxInfo.Context); err != nil {
+			return err
+		}
+		if buf.Len() != len(n.encoded) {
+			return fmt.Errorf("payload size is changed by relocation: type=%s", n.BoxInfo.Type.String())
+		}
+		n.encoded = buf.Bytes()
+	}
+	for _, c := range n.Children {
+		if err := c.encodeFixed(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Node) clearLayout() {
+	n.encoded = nil
+	n.fixed = nil
+	n.co64 = false
+	for _, c := range n.Children {
+		c.clearLayout()
+	}
+}
+
+func (n *Node) write(w io.Writer) error {
+	if !n.isRoot() {
+		boxType := n.BoxInfo.Type
+		if n.co64 {
+			boxType = BoxTypeCo64()
+		}
+		header := marshalBoxHeader(&BoxInfo{Type: boxType, Size: n.size, HeaderSize: n.headerSize})
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if n.isEncoded() {
+			if _, err := w.Write(n.encoded); err != nil {
+				return err
+			}
+		} else {
+			if _, err := n.BoxInfo.SeekToPayload(n.src); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, n.src, int64(n.payloadSize)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range n.Children {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package mp4
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTree(t *testing.T) {
+	for _, file := range []string{
+		"./_examples/sample.mp4",
+		"./_examples/sample_fragmented.mp4",
+		"./_examples/sample_qt.mp4",
+	} {
+		t.Run(file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+
+			// untouched payloads are copied from the source
+			root, err := ParseTree(bytes.NewReader(data))
+			require.NoError(t, err)
+			buf := bytes.NewBuffer(nil)
+			n, err := root.WriteTo(buf)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), n)
+			assert.Equal(t, data, buf.Bytes())
+
+			// decoded payloads are marshaled
+			for _, n := range root.Find(BoxPath{BoxTypeMoov(), BoxTypeAny()}) {
+				_, err := n.Payload()
+				require.NoError(t, err)
+			}
+			buf.Reset()
+			_, err = root.WriteTo(buf)
+			require.NoError(t, err)
+			assert.Equal(t, data, buf.Bytes())
+		})
+	}
+}
+
+func TestNodeMutation(t *testing.T) {
+	data, err := ioutil.ReadFile("./_examples/sample.mp4")
+	require.NoError(t, err)
+	root, err := ParseTree(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	moov := root.Find(BoxPath{BoxTypeMoov()})
+	require.Len(t, moov, 1)
+	traks := moov[0].Find(BoxPath{BoxTypeTrak()})
+	require.Len(t, traks, 2)
+	udta := moov[0].Find(BoxPath{BoxTypeUdta()})
+	require.Len(t, udta, 1)
+	assert.Len(t, root.Find(BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeTkhd()}), 2)
+	assert.Len(t, root.Find(BoxPath{BoxTypeMoov(), BoxTypeAny(), BoxTypeTkhd()}), 2)
+
+	// insert pssh before the first trak
+	pssh := NewNode(&Pssh{
+		SystemID: [16]byte{0x10, 0x77, 0xef, 0xec, 0xc0, 0xb2, 0x4d, 0x02, 0xac, 0xe3, 0x3c, 0x1e, 0x52, 0xe2, 0xfb, 0x4b},
+	})
+	require.NoError(t, moov[0].InsertBefore(pssh, traks[0]))
+	// move udta to the top level
+	root.Append(udta[0])
+	// replace tkhd of the second track
+	tkhd := traks[1].Find(BoxPath{BoxTypeTkhd()})
+	require.Len(t, tkhd, 1)
+	require.NoError(t, traks[1].Replace(NewNode(&Tkhd{TrackID: 100}), tkhd[0]))
+	// remove edts of the first track
+	edts := traks[0].Find(BoxPath{BoxTypeEdts()})
+	require.Len(t, edts, 1)
+	require.NoError(t, traks[0].Remove(edts[0]))
+
+	// the nodes which are not children can not be referred
+	assert.Error(t, moov[0].InsertBefore(NewNode(&Free{}), edts[0]))
+	assert.Error(t, moov[0].Remove(udta[0]))
+	assert.Error(t, moov[0].Replace(NewNode(&Free{}), tkhd[0]))
+
+	buf := bytes.NewBuffer(nil)
+	_, err = root.WriteTo(buf)
+	require.NoError(t, err)
+
+	var types []BoxType
+	var sizes []uint64
+	_, err = ReadBoxStructure(bytes.NewReader(buf.Bytes()), func(h *ReadHandle) (interface{}, error) {
+		if len(h.Path) <= 2 {
+			types = append(types, h.BoxInfo.Type)
+			sizes = append(sizes, h.BoxInfo.Size)
+		}
+		if h.BoxInfo.Type == BoxTypeMoov() || h.BoxInfo.Type == BoxTypeTrak() {
+			return h.Expand()
+		}
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []BoxType{
+		BoxTypeFtyp(), BoxTypeFree(), BoxTypeMdat(),
+		BoxTypeMoov(), BoxTypeMvhd(), BoxTypePssh(), BoxTypeTrak(), BoxTypeTrak(),
+		BoxTypeUdta(),
+	}, types)
+	// pssh is 32 bytes and edts of 36 bytes is removed, and udta of 133 bytes is moved out of moov
+	assert.Equal(t, []uint64{32, 8, 6402, 1836 + 32 - 36 - 133, 108, 32, 743 - 36, 844, 133}, sizes)
+
+	bs, err := ExtractBoxWithPayload(bytes.NewReader(buf.Bytes()), nil, BoxPath{BoxTypeMoov(), BoxTypeTrak(), BoxTypeTkhd()})
+	require.NoError(t, err)
+	require.Len(t, bs, 2)
+	assert.Equal(t, uint32(1), bs[0].Payload.(*Tkhd).TrackID)
+	assert.Equal(t, uint32(100), bs[1].Payload.(*Tkhd).TrackID)
+}
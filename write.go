@@ -1,13 +1,23 @@
 package mp4
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 )
 
 type Writer struct {
 	writer  io.WriteSeeker
-	biStack []*BoxInfo
+	biStack []*writerBox
+}
+
+type writerBox struct {
+	bi *BoxInfo
+
+	// placeholder is true when the box is preceded by a free box which is replaced with the large size header.
+	placeholder bool
 }
 
 func NewWriter(w io.WriteSeeker) *Writer {
@@ -29,18 +39,46 @@ func (w *Writer) StartBox(bi *BoxInfo) (*BoxInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	w.biStack = append(w.biStack, bi)
+	w.biStack = append(w.biStack, &writerBox{bi: bi})
 	return bi, nil
 }
 
+// StartBoxWithPlaceholder starts the box whose size may exceed 32 bits, such as mdat.
+// It writes an empty free box before the box header as QuickTime does with wide atom.
+// When the size of the box exceeds 32 bits, EndBox replaces the free box and the header with the large size header,
+// and the BoxInfo returned by EndBox has the offset of the free box.
+// The returned BoxInfo is a copy, and it is not updated by EndBox.
+func (w *Writer) StartBoxWithPlaceholder(bi *BoxInfo) (*BoxInfo, error) {
+	if _, err := WriteBoxInfo(w.writer, &BoxInfo{Type: BoxTypeFree(), Size: SmallHeaderSize}); err != nil {
+		return nil, err
+	}
+	bi, err := WriteBoxInfo(w.writer, &BoxInfo{Type: bi.Type, Size: SmallHeaderSize})
+	if err != nil {
+		return nil, err
+	}
+	w.biStack = append(w.biStack, &writerBox{bi: bi, placeholder: true})
+	ret := *bi
+	return &ret, nil
+}
+
 func (w *Writer) EndBox() (*BoxInfo, error) {
-	bi := w.biStack[len(w.biStack)-1]
+	wb := w.biStack[len(w.biStack)-1]
 	w.biStack = w.biStack[:len(w.biStack)-1]
+	bi := wb.bi
 	end, err := w.writer.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return nil, err
 	}
 	bi.Size = uint64(end) - bi.Offset
+	if bi.HeaderSize == SmallHeaderSize && bi.Size > math.MaxUint32 {
+		if !wb.placeholder {
+			return nil, fmt.Errorf("box size exceeds 32 bits: type=%s, size=%d: "+
+				"use StartBoxWithPlaceholder or LargeHeaderSize for the large box", bi.Type.String(), bi.Size)
+		}
+		bi.Offset -= SmallHeaderSize
+		bi.Size += SmallHeaderSize
+		bi.HeaderSize = LargeHeaderSize
+	}
 	if _, err = bi.SeekToStart(w.writer); err != nil {
 		return nil, err
 	}
@@ -66,3 +104,143 @@ func (w *Writer) CopyBox(r io.ReadSeeker, bi *BoxInfo) error {
 	}
 	return nil
 }
+
+// StreamWriter writes boxes to io.Writer which can not seek, such as a HTTP response.
+// A box started by StartBox is buffered in memory until EndBox computes its size,
+// so it should be used for small boxes such as moov and moof.
+// A box started by StartBoxWithPayloadSize is streamed with the payload size declared up front,
+// so it should be used for large boxes such as mdat.
+type StreamWriter struct {
+	writer io.Writer
+	offset uint64
+	stack  []*streamBox
+}
+
+type streamBox struct {
+	bi *BoxInfo
+
+	// buf is the payload of the buffered box, and it is nil for the streamed box.
+	buf *bytes.Buffer
+
+	// written is the size of the payload which is written to the streamed box.
+	written uint64
+}
+
+func NewStreamWriter(w io.Writer) *StreamWriter {
+	return &StreamWriter{
+		writer: w,
+	}
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	if err := w.emit(len(w.stack), p); err != nil {
+		return 0, err
+	}
+	w.offset += uint64(len(p))
+	return len(p), nil
+}
+
+// emit writes p to the payload of the n-th box of the stack, or to the underlying writer when n is 0.
+func (w *StreamWriter) emit(n int, p []byte) error {
+	// the declared sizes are checked before anything is written
+	for i := n - 1; i >= 0 && w.stack[i].buf == nil; i-- {
+		sb := w.stack[i]
+		if sb.written+uint64(len(p)) > sb.bi.Size-sb.bi.HeaderSize {
+			return fmt.Errorf("box payload exceeds declared size: type=%s, declaredSize=%d, writtenSize=%d",
+				sb.bi.Type.String(), sb.bi.Size-sb.bi.HeaderSize, sb.written+uint64(len(p)))
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		sb := w.stack[i]
+		if sb.buf != nil {
+			_, err := sb.buf.Write(p)
+			return err
+		}
+		sb.written += uint64(len(p))
+	}
+	_, err := w.writer.Write(p)
+	return err
+}
+
+// StartBox starts the box which is buffered until EndBox is called.
+func (w *StreamWriter) StartBox(bi *BoxInfo) (*BoxInfo, error) {
+	headerSize := uint64(SmallHeaderSize)
+	if bi.HeaderSize == LargeHeaderSize {
+		headerSize = LargeHeaderSize
+	}
+	bi = &BoxInfo{
+		Offset:     w.offset,
+		Size:       headerSize,
+		HeaderSize: headerSize,
+		Type:       bi.Type,
+	}
+	w.stack = append(w.stack, &streamBox{bi: bi, buf: bytes.NewBuffer(nil)})
+	w.offset += headerSize
+	return bi, nil
+}
+
+// StartBoxWithPayloadSize starts the box which has the payload of the declared size.
+// The header is written immediately, and the payload is streamed without being buffered.
+// EndBox fails if the size of the written payload differs from the declared size.
+func (w *StreamWriter) StartBoxWithPayloadSize(bi *BoxInfo, payloadSize uint64) (*BoxInfo, error) {
+	headerSize := uint64(SmallHeaderSize)
+	if bi.HeaderSize == LargeHeaderSize || payloadSize+SmallHeaderSize > math.MaxUint32 {
+		headerSize = LargeHeaderSize
+	}
+	bi = &BoxInfo{
+		Offset:     w.offset,
+		Size:       headerSize + payloadSize,
+		HeaderSize: headerSize,
+		Type:       bi.Type,
+	}
+	if err := w.emit(len(w.stack), marshalBoxHeader(bi)); err != nil {
+		return nil, err
+	}
+	w.stack = append(w.stack, &streamBox{bi: bi})
+	w.offset += headerSize
+	return bi, nil
+}
+
+// EndBox ends the box started last.
+// The buffered box is written with its size, which is computed from the buffered payload.
+func (w *StreamWriter) EndBox() (*BoxInfo, error) {
+	if len(w.stack) == 0 {
+		return nil, errors.New("no box to end")
+	}
+	sb := w.stack[len(w.stack)-1]
+	bi := sb.bi
+
+	if sb.buf == nil {
+		if sb.written != bi.Size-bi.HeaderSize {
+			return nil, fmt.Errorf("box payload is shorter than declared size: type=%s, declaredSize=%d, writtenSize=%d",
+				bi.Type.String(), bi.Size-bi.HeaderSize, sb.written)
+		}
+		w.stack = w.stack[:len(w.stack)-1]
+		return bi, nil
+	}
+
+	bi.Size = bi.HeaderSize + uint64(sb.buf.Len())
+	if bi.HeaderSize == SmallHeaderSize && bi.Size > math.MaxUint32 {
+		return nil, fmt.Errorf("too large buffered box: type=%s, size=%d", bi.Type.String(), bi.Size)
+	}
+	w.stack = w.stack[:len(w.stack)-1]
+	if err := w.emit(len(w.stack), marshalBoxHeader(bi)); err != nil {
+		return nil, err
+	}
+	if err := w.emit(len(w.stack), sb.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return bi, nil
+}
+
+func (w *StreamWriter) CopyBox(r io.ReadSeeker, bi *BoxInfo) error {
+	if _, err := bi.SeekToStart(r); err != nil {
+		return err
+	}
+	if n, err := io.CopyN(w, r, int64(bi.Size)); err != nil {
+		return err
+	} else if n != int64(bi.Size) {
+		return errors.New("failed to copy box")
+	}
+	return nil
+}
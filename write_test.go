@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"math"
 	"testing"
 
 	"gopkg.in/src-d/go-billy.v4/memfs"
@@ -153,3 +154,265 @@ func TestWriter(t *testing.T) {
 		0x00, 0x00, 0x00, 0x09, // height
 	}, bin)
 }
+
+func TestStreamWriter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewStreamWriter(buf)
+
+	// ftyp
+	bi, err := w.StartBox(&BoxInfo{Type: BoxTypeFtyp()})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), bi.Offset)
+	assert.Equal(t, uint64(8), bi.Size)
+	_, err = Marshal(w, &Ftyp{
+		MajorBrand:   [4]byte{'a', 'b', 'e', 'm'},
+		MinorVersion: 0x12345678,
+	}, Context{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, buf.Len())
+	bi, err = w.EndBox()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), bi.Offset)
+	assert.Equal(t, uint64(16), bi.Size)
+	assert.Equal(t, 16, buf.Len())
+
+	// moov and udta are buffered until moov ends
+	bi, err = w.StartBox(&BoxInfo{Type: BoxTypeMoov()})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16), bi.Offset)
+	bi, err = w.StartBox(&BoxInfo{Type: BoxTypeUdta()})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(24), bi.Offset)
+	_, err = w.Write([]byte{0x01, 0x02, 0x03})
+	require.NoError(t, err)
+	bi, err = w.EndBox()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(24), bi.Offset)
+	assert.Equal(t, uint64(11), bi.Size)
+	assert.Equal(t, 16, buf.Len())
+	bi, err = w.EndBox()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16), bi.Offset)
+	assert.Equal(t, uint64(19), bi.Size)
+	assert.Equal(t, 35, buf.Len())
+
+	// mdat is streamed with the declared size
+	bi, err = w.StartBoxWithPayloadSize(&BoxInfo{Type: BoxTypeMdat()}, 4)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(35), bi.Offset)
+	assert.Equal(t, uint64(12), bi.Size)
+	assert.Equal(t, 43, buf.Len())
+	_, err = w.Write([]byte{0x11, 0x12})
+	require.NoError(t, err)
+	assert.Equal(t, 45, buf.Len())
+	_, err = w.Write([]byte{0x13, 0x14})
+	require.NoError(t, err)
+	bi, err = w.EndBox()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(35), bi.Offset)
+	assert.Equal(t, uint64(12), bi.Size)
+
+	assert.Equal(t, []byte{
+		// ftyp
+		0x00, 0x00, 0x00, 0x10, 'f', 't', 'y', 'p',
+		'a', 'b', 'e', 'm', 0x12, 0x34, 0x56, 0x78,
+		// moov
+		0x00, 0x00, 0x00, 0x13, 'm', 'o', 'o', 'v',
+		// udta
+		0x00, 0x00, 0x00, 0x0b, 'u', 'd', 't', 'a', 0x01, 0x02, 0x03,
+		// mdat
+		0x00, 0x00, 0x00, 0x0c, 'm', 'd', 'a', 't', 0x11, 0x12, 0x13, 0x14,
+	}, buf.Bytes())
+}
+
+func TestStreamWriterNested(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w := NewStreamWriter(buf)
+
+	// the buffered child is flushed into the streamed parent
+	_, err := w.StartBoxWithPayloadSize(&BoxInfo{Type: BoxTypeMoof()}, 11)
+	require.NoError(t, err)
+	bi, err := w.StartBox(&BoxInfo{Type: BoxTypeTraf()})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), bi.Offset)
+	_, err = w.Write([]byte{0x01, 0x02, 0x03})
+	require.NoError(t, err)
+	assert.Equal(t, 8, buf.Len())
+	_, err = w.EndBox()
+	require.NoError(t, err)
+	assert.Equal(t, 19, buf.Len())
+	_, err = w.EndBox()
+	require.NoError(t, err)
+
+	// the large header is used for the payload which does not fit in 32 bits
+	bi, err = w.StartBoxWithPayloadSize(&BoxInfo{Type: BoxTypeMdat()}, math.MaxUint32)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(19), bi.Offset)
+	assert.Equal(t, uint64(LargeHeaderSize), bi.HeaderSize)
+	assert.Equal(t, uint64(LargeHeaderSize+math.MaxUint32), bi.Size)
+
+	assert.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x13, 'm', 'o', 'o', 'f',
+		0x00, 0x00, 0x00, 0x0b, 't', 'r', 'a', 'f', 0x01, 0x02, 0x03,
+		0x00, 0x00, 0x00, 0x01, 'm', 'd', 'a', 't',
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0f,
+	}, buf.Bytes())
+}
+
+func TestStreamWriterSizeMismatch(t *testing.T) {
+	testCases := []struct {
+		name  string
+		write func(w *StreamWriter) error
+		err   string
+	}{
+		{
+			name: "exceeds declared size",
+			write: func(w *StreamWriter) error {
+				if _, err := w.StartBoxWithPayloadSize(&BoxInfo{Type: BoxTypeMdat()}, 4); err != nil {
+					return err
+				}
+				_, err := w.Write([]byte{0x01, 0x02, 0x03, 0x04, 0x05})
+				return err
+			},
+			err: "box payload exceeds declared size: type=mdat, declaredSize=4, writtenSize=5",
+		},
+		{
+			name: "shorter than declared size",
+			write: func(w *StreamWriter) error {
+				if _, err := w.StartBoxWithPayloadSize(&BoxInfo{Type: BoxTypeMdat()}, 4); err != nil {
+					return err
+				}
+				if _, err := w.Write([]byte{0x01, 0x02, 0x03}); err != nil {
+					return err
+				}
+				_, err := w.EndBox()
+				return err
+			},
+			err: "box payload is shorter than declared size: type=mdat, declaredSize=4, writtenSize=3",
+		},
+		{
+			name: "buffered child exceeds declared size",
+			write: func(w *StreamWriter) error {
+				if _, err := w.StartBoxWithPayloadSize(&BoxInfo{Type: BoxTypeMoof()}, 8); err != nil {
+					return err
+				}
+				if _, err := w.StartBox(&BoxInfo{Type: BoxTypeTraf()}); err != nil {
+					return err
+				}
+				if _, err := w.Write([]byte{0x01}); err != nil {
+					return err
+				}
+				_, err := w.EndBox()
+				return err
+			},
+			err: "box payload exceeds declared size: type=moof, declaredSize=8, writtenSize=9",
+		},
+		{
+			name: "no box to end",
+			write: func(w *StreamWriter) error {
+				_, err := w.EndBox()
+				return err
+			},
+			err: "no box to end",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.write(NewStreamWriter(ioutil.Discard))
+			require.Error(t, err)
+			assert.Equal(t, tc.err, err.Error())
+		})
+	}
+}
+
+// headWriteSeeker keeps only the head of the written data, so that a box larger than 4 GiB can be written by seeking.
+type headWriteSeeker struct {
+	head   []byte
+	offset int64
+	size   int64
+}
+
+func (w *headWriteSeeker) Write(p []byte) (int, error) {
+	for i := range p {
+		if off := w.offset + int64(i); off < int64(len(w.head)) {
+			w.head[off] = p[i]
+		}
+	}
+	w.offset += int64(len(p))
+	if w.offset > w.size {
+		w.size = w.offset
+	}
+	return len(p), nil
+}
+
+func (w *headWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += w.offset
+	case io.SeekEnd:
+		offset += w.size
+	}
+	w.offset = offset
+	return offset, nil
+}
+
+func TestWriterPlaceholder(t *testing.T) {
+	testCases := []struct {
+		name        string
+		payloadSize int64
+		expectedBox BoxInfo
+		head        []byte
+	}{
+		{
+			name:        "small",
+			payloadSize: 4,
+			expectedBox: BoxInfo{Offset: 16, Size: 12, HeaderSize: SmallHeaderSize, Type: BoxTypeMdat()},
+			head: []byte{
+				0x00, 0x00, 0x00, 0x08, 'f', 'r', 'e', 'e',
+				0x00, 0x00, 0x00, 0x0c, 'm', 'd', 'a', 't',
+			},
+		},
+		{
+			name:        "large",
+			payloadSize: math.MaxUint32,
+			expectedBox: BoxInfo{Offset: 8, Size: LargeHeaderSize + math.MaxUint32, HeaderSize: LargeHeaderSize, Type: BoxTypeMdat()},
+			head: []byte{
+				0x00, 0x00, 0x00, 0x01, 'm', 'd', 'a', 't',
+				0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0f,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := &headWriteSeeker{head: make([]byte, 24)}
+			w := NewWriter(ws)
+			_, err := w.StartBox(&BoxInfo{Type: BoxTypeFtyp()})
+			require.NoError(t, err)
+			_, err = w.EndBox()
+			require.NoError(t, err)
+
+			started, err := w.StartBoxWithPlaceholder(&BoxInfo{Type: BoxTypeMdat()})
+			require.NoError(t, err)
+			assert.Equal(t, uint64(16), started.Offset)
+			_, err = w.Seek(tc.payloadSize, io.SeekCurrent)
+			require.NoError(t, err)
+			bi, err := w.EndBox()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBox, *bi)
+			// the BoxInfo returned by StartBoxWithPlaceholder is not updated
+			assert.Equal(t, uint64(16), started.Offset)
+			assert.Equal(t, uint64(SmallHeaderSize), started.HeaderSize)
+			assert.Equal(t, tc.head, ws.head[8:])
+			assert.Equal(t, 24+tc.payloadSize, ws.offset)
+		})
+	}
+
+	// the box without the placeholder can not be promoted
+	w := NewWriter(&headWriteSeeker{})
+	_, err := w.StartBox(&BoxInfo{Type: BoxTypeMdat()})
+	require.NoError(t, err)
+	_, err = w.Seek(math.MaxUint32, io.SeekCurrent)
+	require.NoError(t, err)
+	_, err = w.EndBox()
+	assert.Error(t, err)
+}